@@ -123,8 +123,12 @@ public interface ApiService {
         public int sample_interval;
         public int upload_interval;
         public int max_cache_size;
+        public int batch_size;
         public boolean auto_upload;
         public int compression_level;
+        public int version;
+        public int applied_version;
+        public String applied_at;
     }
 
     // Updated collector status response
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"power-collector/pkg/config"
+	"power-collector/pkg/modbus"
+	"power-collector/pkg/pzem"
+)
+
+// command represents a collector subcommand
+type command struct {
+	name        string
+	args        string
+	description string
+	run         func(ctx *commandContext, args []string) error
+}
+
+// commandContext carries the loaded configuration to subcommands
+type commandContext struct {
+	cfg        *config.Config
+	configFile string
+}
+
+var commands = []command{
+	{
+		name:        "reset-energy",
+		description: "Reset the energy counter of a PZEM-004T to zero",
+		run:         runResetEnergy,
+	},
+	{
+		name:        "get-alarm",
+		description: "Show the power alarm threshold of a PZEM-004T",
+		run:         runGetAlarm,
+	},
+	{
+		name:        "set-alarm",
+		args:        "<watts>",
+		description: "Set the power alarm threshold of a PZEM-004T",
+		run:         runSetAlarm,
+	},
+	{
+		name:        "set-address",
+		args:        "<new-address>",
+		description: "Change the slave address of a PZEM-004T and update the configuration",
+		run:         runSetAddress,
+	},
+	{
+		name:        "scan",
+		description: "Probe the slave addresses of the meter bus and list the meters that respond",
+		run:         runScan,
+	},
+	{
+		name:        "read",
+		description: "Take a reading of a meter, with -raw dumping the Modbus frames in hex",
+		run:         runRead,
+	},
+	{
+		name:        "cache",
+		args:        "<action>",
+		description: "Inspect the offline cache: stats, list, export or purge",
+		run:         runCache,
+	},
+	{
+		name:        "register",
+		description: "Register the channels without a token with their registration code",
+		run:         runRegister,
+	},
+	{
+		name:        "ping-server",
+		description: "Test the connection to the server of every channel with timing details",
+		run:         runPingServer,
+	},
+}
+
+// printCommands lists the subcommands for the usage message
+func printCommands() {
+	fmt.Fprintf(flag.CommandLine.Output(), "\nCommands:\n")
+	for _, cmd := range commands {
+		usage := cmd.name
+		if cmd.args != "" {
+			usage += " " + cmd.args
+		}
+		fmt.Fprintf(flag.CommandLine.Output(), "  %-28s %s\n", usage, cmd.description)
+	}
+	fmt.Fprintf(flag.CommandLine.Output(), "\nRun '%s <command> -h' for the options of a command.\n", os.Args[0])
+}
+
+// runCommand runs the subcommand named by the first argument
+func runCommand(ctx *commandContext, args []string) error {
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd.run(ctx, args[1:])
+		}
+	}
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+// deviceFlags selects the meter a device command talks to
+type deviceFlags struct {
+	channel string
+	address int
+}
+
+// newDeviceFlagSet returns the flag set of a device command with the common
+// -channel and -address options
+func newDeviceFlagSet(name, args string) (*flag.FlagSet, *deviceFlags) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	df := &deviceFlags{}
+	fs.StringVar(&df.channel, "channel", "", "Channel key of the meter (default: the first channel)")
+	fs.IntVar(&df.address, "address", 0, "Slave address of the meter, overrides -channel")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [-config file] %s [options] %s\n", os.Args[0], name, args)
+		fs.PrintDefaults()
+	}
+	return fs, df
+}
+
+// resolveChannel returns the channel selected by the device flags
+func (df *deviceFlags) resolveChannel(cfg *config.Config) (config.ChannelConfig, error) {
+	channels := cfg.MeterChannels()
+
+	if df.address != 0 {
+		if df.address < 1 || df.address > pzem.MaxAddress {
+			return config.ChannelConfig{}, fmt.Errorf("invalid slave address %d", df.address)
+		}
+		for _, ch := range channels {
+			if ch.Address == df.address {
+				return ch, nil
+			}
+		}
+		return config.ChannelConfig{Driver: cfg.Serial.Driver, Address: df.address}, nil
+	}
+
+	if df.channel == "" {
+		return channels[0], nil
+	}
+	for _, ch := range channels {
+		if ch.Key == df.channel {
+			return ch, nil
+		}
+	}
+	return config.ChannelConfig{}, fmt.Errorf("unknown channel %q", df.channel)
+}
+
+// openPZEM opens the serial bus and returns the PZEM-004T selected by the
+// device flags. The caller closes the returned bus.
+func openPZEM(cfg *config.Config, df *deviceFlags) (*pzem.PZEM004T, *modbus.Client, config.ChannelConfig, error) {
+	ch, err := df.resolveChannel(cfg)
+	if err != nil {
+		return nil, nil, ch, err
+	}
+	if ch.Driver != "pzem004t" {
+		return nil, nil, ch, fmt.Errorf("channel %s uses driver %s, this command only supports pzem004t", ch.Key, ch.Driver)
+	}
+
+	bus, err := openBus(cfg, 0, nil)
+	if err != nil {
+		return nil, nil, ch, err
+	}
+
+	return pzem.New(bus, uint8(ch.Address)), bus, ch, nil
+}
+
+// openBus opens the meter bus of the configuration. A timeout of 0 uses the
+// configured response timeout; trace, if set, receives the raw frames.
+func openBus(cfg *config.Config, timeout time.Duration, trace modbus.TraceFunc) (*modbus.Client, error) {
+	if cfg.Serial.Port == "" {
+		return nil, fmt.Errorf("serial port is required")
+	}
+	if timeout == 0 {
+		timeout = cfg.Serial.Timeout * time.Second
+	}
+
+	bus, err := modbus.Open(modbus.SerialConfig{
+		Port:     cfg.Serial.Port,
+		BaudRate: cfg.Serial.BaudRate,
+		StopBits: cfg.Serial.StopBits,
+		Timeout:  timeout,
+		Trace:    trace,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open meter bus: %w", err)
+	}
+	return bus, nil
+}
+
+// runResetEnergy resets the energy counter of a meter
+func runResetEnergy(ctx *commandContext, args []string) error {
+	fs, df := newDeviceFlagSet("reset-energy", "")
+	fs.Parse(args)
+
+	device, bus, ch, err := openPZEM(ctx.cfg, df)
+	if err != nil {
+		return err
+	}
+	defer bus.Close()
+
+	if err := device.ResetEnergy(); err != nil {
+		return err
+	}
+
+	log.Printf("Energy counter of the meter at address %d reset", ch.Address)
+	return nil
+}
+
+// runGetAlarm prints the power alarm threshold of a meter
+func runGetAlarm(ctx *commandContext, args []string) error {
+	fs, df := newDeviceFlagSet("get-alarm", "")
+	fs.Parse(args)
+
+	device, bus, ch, err := openPZEM(ctx.cfg, df)
+	if err != nil {
+		return err
+	}
+	defer bus.Close()
+
+	threshold, err := device.AlarmThreshold()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Power alarm threshold of the meter at address %d: %d W\n", ch.Address, threshold)
+	return nil
+}
+
+// runSetAlarm sets the power alarm threshold of a meter
+func runSetAlarm(ctx *commandContext, args []string) error {
+	fs, df := newDeviceFlagSet("set-alarm", "<watts>")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("set-alarm requires the threshold in watts")
+	}
+	watts, err := strconv.ParseUint(fs.Arg(0), 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid alarm threshold %q: %w", fs.Arg(0), err)
+	}
+
+	device, bus, ch, err := openPZEM(ctx.cfg, df)
+	if err != nil {
+		return err
+	}
+	defer bus.Close()
+
+	if err := device.SetAlarmThreshold(uint16(watts)); err != nil {
+		return err
+	}
+
+	log.Printf("Power alarm threshold of the meter at address %d set to %d W", ch.Address, watts)
+	return nil
+}
+
+// runSetAddress changes the slave address of a meter and stores the new
+// address in the configuration of its channel
+func runSetAddress(ctx *commandContext, args []string) error {
+	fs, df := newDeviceFlagSet("set-address", "<new-address>")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("set-address requires the new slave address")
+	}
+	newAddress, err := strconv.Atoi(fs.Arg(0))
+	if err != nil || newAddress < 1 || newAddress > pzem.MaxAddress {
+		return fmt.Errorf("invalid slave address %q: must be between 1 and %d", fs.Arg(0), pzem.MaxAddress)
+	}
+
+	device, bus, ch, err := openPZEM(ctx.cfg, df)
+	if err != nil {
+		return err
+	}
+	defer bus.Close()
+
+	if err := device.SetAddress(uint8(newAddress)); err != nil {
+		return err
+	}
+	log.Printf("Slave address of the meter changed from %d to %d", ch.Address, newAddress)
+
+	// Devices selected by a bare -address are not part of the configuration
+	if ch.Key == "" {
+		return nil
+	}
+
+	ch.Address = newAddress
+	ctx.cfg.UpdateChannel(ch)
+	if err := config.SaveConfig(ctx.cfg, ctx.configFile); err != nil {
+		return fmt.Errorf("failed to save updated configuration: %w", err)
+	}
+	log.Printf("Address of channel %s updated in %s", ch.Key, ctx.configFile)
+	return nil
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"power-collector/pkg/client"
+	"power-collector/pkg/config"
+	"power-collector/pkg/database"
+	"power-collector/pkg/meter"
+	"power-collector/pkg/modbus"
+	"power-collector/pkg/pzem"
+)
+
+// newFlagSet returns the flag set of a command that does not select a meter
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [-config file] %s [options] %s\n", os.Args[0], name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// selectChannels returns the channel with the given key, or every channel if
+// key is empty
+func selectChannels(cfg *config.Config, key string) ([]config.ChannelConfig, error) {
+	channels := cfg.MeterChannels()
+	if key == "" {
+		return channels, nil
+	}
+	for _, ch := range channels {
+		if ch.Key == key {
+			return []config.ChannelConfig{ch}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown channel %q", key)
+}
+
+// simulationOptions returns the settings of the drivers without meter
+// hardware
+func simulationOptions(cfg *config.Config) meter.SimulationOptions {
+	return meter.SimulationOptions{
+		Seed:             cfg.Simulator.Seed,
+		BaseLoad:         cfg.Simulator.BaseLoad,
+		NominalVoltage:   cfg.Simulator.NominalVoltage,
+		NominalFrequency: cfg.Simulator.NominalFrequency,
+		Source:           cfg.Simulator.Source,
+		Speed:            cfg.Simulator.Speed,
+		Loop:             cfg.Simulator.Loop,
+	}
+}
+
+// runScan probes a range of slave addresses and lists the meters that
+// respond
+func runScan(ctx *commandContext, args []string) error {
+	fs := newFlagSet("scan", "")
+	driverName := fs.String("driver", ctx.cfg.Serial.Driver, "Meter driver to probe with")
+	from := fs.Int("from", 1, "First slave address to probe")
+	to := fs.Int("to", pzem.MaxAddress, "Last slave address to probe")
+	timeout := fs.Duration("timeout", 200*time.Millisecond, "Response timeout per address")
+	fs.Parse(args)
+
+	if *from < 1 || *to > pzem.MaxAddress || *from > *to {
+		return fmt.Errorf("invalid address range %d-%d: must be within 1-%d", *from, *to, pzem.MaxAddress)
+	}
+	driver, ok := meter.Lookup(*driverName)
+	if !ok {
+		return fmt.Errorf("unknown meter driver %q (available: %v)", *driverName, meter.Drivers())
+	}
+	if driver.Virtual {
+		return fmt.Errorf("driver %s does not use the meter bus", *driverName)
+	}
+
+	bus, err := openBus(ctx.cfg, *timeout, nil)
+	if err != nil {
+		return err
+	}
+	defer bus.Close()
+
+	configured := make(map[int]string)
+	for _, ch := range ctx.cfg.MeterChannels() {
+		configured[ch.Address] = ch.Key
+	}
+
+	log.Printf("Scanning addresses %d-%d on %s with driver %s...", *from, *to, ctx.cfg.Serial.Port, *driverName)
+	found := 0
+	for address := *from; address <= *to; address++ {
+		device, err := driver.Open(meter.Options{Bus: bus, Address: uint8(address)})
+		if err != nil {
+			return fmt.Errorf("failed to open meter at address %d: %w", address, err)
+		}
+
+		// Exceptions and corrupt frames are answers too, except for the
+		// exceptions a gateway sends for slaves that do not answer it
+		var result string
+		data, err := device.ReadData()
+		var exception *modbus.ExceptionError
+		switch {
+		case err == nil:
+			result = data.String()
+		case errors.As(err, &exception) && exception.Code != modbus.ExceptionGatewayPathUnavailable &&
+			exception.Code != modbus.ExceptionGatewayTargetFailed:
+			result = "responds with " + exception.Error()
+		case errors.Is(err, modbus.ErrCRC):
+			result = "responds with a corrupt frame, check wiring and for duplicate addresses"
+		default:
+			continue
+		}
+		found++
+
+		channel := configured[address]
+		if channel == "" {
+			channel = "-"
+		}
+		fmt.Printf("%3d  %-10s  %-10s  %s\n", address, device.Capabilities().Model, channel, result)
+	}
+
+	log.Printf("Scan complete, %d meter(s) found", found)
+	return nil
+}
+
+// runRead takes readings of a meter, optionally dumping the frames exchanged
+// with it
+func runRead(ctx *commandContext, args []string) error {
+	fs, df := newDeviceFlagSet("read", "")
+	raw := fs.Bool("raw", false, "Dump the request and response frames in hex")
+	count := fs.Int("count", 1, "Number of readings to take")
+	interval := fs.Duration("interval", time.Second, "Time between readings")
+	fs.Parse(args)
+
+	ch, err := df.resolveChannel(ctx.cfg)
+	if err != nil {
+		return err
+	}
+	driver, ok := meter.Lookup(ch.Driver)
+	if !ok {
+		return fmt.Errorf("unknown meter driver %q (available: %v)", ch.Driver, meter.Drivers())
+	}
+
+	var bus *modbus.Client
+	if !driver.Virtual {
+		var trace modbus.TraceFunc
+		if *raw {
+			trace = func(direction string, frame []byte) {
+				fmt.Printf("%s %s % X\n", time.Now().Format("15:04:05.000"), direction, frame)
+			}
+		}
+		if bus, err = openBus(ctx.cfg, 0, trace); err != nil {
+			return err
+		}
+		defer bus.Close()
+	} else if *raw {
+		log.Printf("Driver %s does not use the meter bus, there are no frames to dump", ch.Driver)
+	}
+
+	device, err := driver.Open(meter.Options{
+		Bus:         bus,
+		Address:     uint8(ch.Address),
+		CollectorID: ch.ID,
+		Simulation:  simulationOptions(ctx.cfg),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to open meter: %w", err)
+	}
+	defer device.Close()
+
+	for i := 0; i < *count; i++ {
+		if i > 0 {
+			time.Sleep(*interval)
+		}
+
+		start := time.Now()
+		data, err := device.ReadData()
+		if err != nil {
+			return fmt.Errorf("failed to read meter at address %d: %w", ch.Address, err)
+		}
+		fmt.Printf("%s (%s, %v)\n", data, device.Capabilities().Model, time.Since(start).Round(time.Microsecond))
+	}
+
+	if bus != nil {
+		stats := bus.Stats(uint8(ch.Address))
+		log.Printf("Requests: %d, CRC errors: %d, timeouts: %d, exceptions: %d",
+			stats.Requests, stats.CRCErrors, stats.Timeouts, stats.Exceptions)
+	}
+	return nil
+}
+
+// runCache inspects, exports or purges the offline cache
+func runCache(ctx *commandContext, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("cache requires an action: stats, list, export or purge")
+	}
+	action := args[0]
+
+	fs := newFlagSet("cache "+action, "")
+	channel := fs.String("channel", "", "Channel key to limit to (default: every channel)")
+	var (
+		limit      *int
+		unuploaded *bool
+		output     *string
+		all        *bool
+	)
+	switch action {
+	case "stats":
+	case "list":
+		limit = fs.Int("n", 20, "Number of readings to list, 0 for all")
+		unuploaded = fs.Bool("unuploaded", false, "List only readings not uploaded yet")
+	case "export":
+		unuploaded = fs.Bool("unuploaded", false, "Export only readings not uploaded yet")
+		output = fs.String("o", "", "CSV file to write (default: stdout)")
+	case "purge":
+		all = fs.Bool("all", false, "Also delete readings not uploaded yet, which are lost")
+	default:
+		return fmt.Errorf("unknown cache action %q: must be stats, list, export or purge", action)
+	}
+	fs.Parse(args[1:])
+
+	// Rows are stored by collector ID
+	var collectorID string
+	if *channel != "" {
+		channels, err := selectChannels(ctx.cfg, *channel)
+		if err != nil {
+			return err
+		}
+		if collectorID = channels[0].ID; collectorID == "" {
+			return fmt.Errorf("channel %s has no collector ID", *channel)
+		}
+	}
+
+	cacheDB, err := database.NewCacheDB(ctx.cfg.Data.CacheDB)
+	if err != nil {
+		return err
+	}
+	defer cacheDB.Close()
+
+	switch action {
+	case "stats":
+		return printCacheStats(ctx.cfg, cacheDB)
+	case "list":
+		return listCache(ctx.cfg, cacheDB, collectorID, *unuploaded, *limit)
+	case "export":
+		return exportCache(cacheDB, collectorID, *unuploaded, *output)
+	default:
+		purged, err := cacheDB.Purge(collectorID, !*all)
+		if err != nil {
+			return err
+		}
+		log.Printf("%d cached reading(s) deleted", purged)
+		return nil
+	}
+}
+
+// printCacheStats prints the counters of the offline cache
+func printCacheStats(cfg *config.Config, cacheDB *database.CacheDB) error {
+	stats, err := cacheDB.GetCacheStats()
+	if err != nil {
+		return err
+	}
+
+	oldest := "-"
+	if stats["oldest_unuploaded"] != 0 {
+		oldest = time.Unix(stats["oldest_unuploaded"], 0).Format("2006-01-02 15:04:05")
+	}
+
+	fmt.Printf("Cache database:    %s\n", cfg.Data.CacheDB)
+	fmt.Printf("Readings:          %d (%d not uploaded, %d uploaded)\n", stats["total"], stats["unuploaded"], stats["uploaded"])
+	fmt.Printf("Capacity:          %d (policy %s)\n", cfg.Data.MaxCacheSize, cfg.Data.CachePolicy)
+	fmt.Printf("Oldest unuploaded: %s\n", oldest)
+	fmt.Printf("Lost to the limit: %d dropped, %d downsampled\n", stats[database.LossDropped], stats[database.LossDownsampled])
+	return nil
+}
+
+// listCache prints the newest cached readings
+func listCache(cfg *config.Config, cacheDB *database.CacheDB, collectorID string, unuploaded bool, limit int) error {
+	rows, err := cacheDB.ListData(collectorID, unuploaded, limit)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		fmt.Println("No cached readings found")
+		return nil
+	}
+
+	channels := make(map[string]string)
+	for _, ch := range cfg.MeterChannels() {
+		channels[ch.ID] = ch.Key
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCHANNEL\tTIMESTAMP\tVOLTAGE\tCURRENT\tPOWER\tENERGY\tSAMPLES\tUPLOADED")
+	for _, row := range rows {
+		channel := channels[row.CollectorID]
+		if channel == "" {
+			channel = row.CollectorID
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%.1f V\t%.3f A\t%.1f W\t%.0f Wh\t%d\t%t\n",
+			row.ID,
+			channel,
+			row.Timestamp.Local().Format("2006-01-02 15:04:05"),
+			row.Voltage,
+			row.Current,
+			row.Power,
+			row.Energy,
+			row.Samples,
+			row.Uploaded)
+	}
+	return w.Flush()
+}
+
+// exportCache writes cached readings as CSV in chronological order. The
+// file can be replayed by the replay driver.
+func exportCache(cacheDB *database.CacheDB, collectorID string, unuploaded bool, output string) error {
+	rows, err := cacheDB.ListData(collectorID, unuploaded, 0)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			return fmt.Errorf("failed to create export file: %w", err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	formatFloat := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	w := csv.NewWriter(out)
+	w.Write([]string{"timestamp", "voltage", "current", "power", "energy", "frequency", "power_factor", "collector_id", "seq", "uploaded"})
+	for i := len(rows) - 1; i >= 0; i-- {
+		row := rows[i]
+		w.Write([]string{
+			row.Timestamp.Format(time.RFC3339),
+			formatFloat(row.Voltage),
+			formatFloat(row.Current),
+			formatFloat(row.Power),
+			formatFloat(row.Energy),
+			formatFloat(row.Frequency),
+			formatFloat(row.PowerFactor),
+			row.CollectorID,
+			strconv.FormatUint(row.Seq, 10),
+			strconv.FormatBool(row.Uploaded),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+
+	if output != "" {
+		log.Printf("%d cached reading(s) exported to %s", len(rows), output)
+	}
+	return nil
+}
+
+// runRegister registers the channels that have no token with their
+// registration code and saves the configuration
+func runRegister(ctx *commandContext, args []string) error {
+	fs := newFlagSet("register", "")
+	channel := fs.String("channel", "", "Channel key to register (default: every channel without a token)")
+	code := fs.String("code", "", "Registration code to use instead of the configured one")
+	fs.Parse(args)
+
+	channels, err := selectChannels(ctx.cfg, *channel)
+	if err != nil {
+		return err
+	}
+
+	registered := 0
+	for _, ch := range channels {
+		if ch.Token != "" {
+			log.Printf("Channel %s already has a token, remove it from the configuration to register again", ch.Key)
+			continue
+		}
+		if *code != "" {
+			ch.RegistrationCode = *code
+		}
+		if ch.RegistrationCode == "" {
+			log.Printf("Channel %s has no registration code, skipping", ch.Key)
+			continue
+		}
+
+		log.Printf("Registering channel %s with %s...", ch.Key, ctx.cfg.Server.BaseURL)
+		if err := registerChannel(ctx.cfg, ch); err != nil {
+			return fmt.Errorf("failed to register collector for channel %s: %w", ch.Key, err)
+		}
+		registered++
+	}
+
+	if registered == 0 {
+		log.Println("No channel was registered")
+		return nil
+	}
+
+	if err := config.SaveConfig(ctx.cfg, ctx.configFile); err != nil {
+		return fmt.Errorf("failed to save updated configuration: %w", err)
+	}
+	log.Printf("Configuration updated and saved to %s", ctx.configFile)
+	return nil
+}
+
+// runPingServer tests the connection of channels to the server and prints
+// how long each phase of the request took
+func runPingServer(ctx *commandContext, args []string) error {
+	fs := newFlagSet("ping-server", "")
+	channel := fs.String("channel", "", "Channel key to test (default: every channel)")
+	fs.Parse(args)
+
+	channels, err := selectChannels(ctx.cfg, *channel)
+	if err != nil {
+		return err
+	}
+
+	cfg := ctx.cfg
+	fmt.Printf("Server: %s%s\n", cfg.Server.BaseURL, cfg.Server.APIPrefix)
+
+	failed := 0
+	for _, ch := range channels {
+		apiClient := client.NewAPIClient(cfg.Server.BaseURL, cfg.Server.APIPrefix, cfg.Server.Timeout*time.Second)
+
+		// Authenticate the way the service does
+		authentication := "none"
+		certFile, keyFile := ch.ClientCert, ch.ClientKey
+		if _, err := os.Stat(certFile); certFile != "" && os.IsNotExist(err) && ch.Token != "" {
+			certFile, keyFile = "", ""
+		}
+		switch {
+		case certFile != "":
+			authentication = "client certificate"
+		case ch.Token != "":
+			authentication = "token"
+		}
+		if ch.SigningSecret != "" {
+			authentication += ", signed"
+		}
+		if err := apiClient.SetTLS(certFile, keyFile, cfg.Server.CACert); err != nil {
+			return fmt.Errorf("failed to configure TLS of channel %s: %w", ch.Key, err)
+		}
+		apiClient.SetSigningSecret(ch.SigningSecret)
+		apiClient.SetToken(ch.Token, ch.ID)
+
+		fmt.Printf("\nChannel %s (collector ID %s, authentication: %s)\n", ch.Key, ch.ID, authentication)
+		timing, err := apiClient.TestConnectionTiming()
+		if timing != nil {
+			round := func(d time.Duration) time.Duration { return d.Round(time.Microsecond) }
+			fmt.Printf("  Remote address: %s\n", timing.RemoteAddr)
+			if timing.ConnReused {
+				fmt.Printf("  Connection:     reused\n")
+			} else {
+				fmt.Printf("  DNS lookup:     %v\n", round(timing.DNSLookup))
+				fmt.Printf("  TCP connect:    %v\n", round(timing.Connect))
+				if timing.TLSHandshake > 0 {
+					fmt.Printf("  TLS handshake:  %v\n", round(timing.TLSHandshake))
+				}
+			}
+			fmt.Printf("  Server time:    %v\n", round(timing.ServerTime))
+			fmt.Printf("  Total:          %v (%d attempt(s))\n", round(timing.Total), timing.Attempts)
+			if timing.StatusCode != 0 {
+				fmt.Printf("  Status:         %d\n", timing.StatusCode)
+			}
+		}
+		if err != nil {
+			fmt.Printf("  Result:         FAILED, %v\n", err)
+			failed++
+			continue
+		}
+		fmt.Printf("  Result:         OK\n")
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d channel(s) could not connect", failed, len(channels))
+	}
+	return nil
+}
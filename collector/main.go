@@ -4,6 +4,7 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
@@ -13,6 +14,7 @@ import (
 	"power-collector/pkg/client"
 	"power-collector/pkg/collector"
 	"power-collector/pkg/config"
+	"power-collector/pkg/systemd"
 
 	"github.com/google/uuid"
 )
@@ -30,6 +32,11 @@ func main() {
 		showVersion = flag.Bool("version", false, "Show version information")
 		testMode    = flag.Bool("test", false, "Run in test mode to collect data once and print")
 	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] [command]\n\nOptions:\n", os.Args[0])
+		flag.PrintDefaults()
+		printCommands()
+	}
 	flag.Parse()
 
 	// Show version information
@@ -46,10 +53,29 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	// Write the log to the configured file as well
+	if cfg.Logging.File != "" {
+		logFile, err := os.OpenFile(cfg.Logging.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatalf("Failed to open log file: %v", err)
+		}
+		defer logFile.Close()
+		log.SetOutput(io.MultiWriter(os.Stderr, logFile))
+	}
+
+	// Run a subcommand instead of the service
+	if flag.NArg() > 0 {
+		ctx := &commandContext{cfg: cfg, configFile: *configFile}
+		if err := runCommand(ctx, flag.Args()); err != nil {
+			log.Fatalf("%s failed: %v", flag.Arg(0), err)
+		}
+		return
+	}
+
 	// If test mode is enabled, run a single collection and exit
 	if *testMode {
 		log.Println("Running in test mode...")
-		service, err := collector.NewCollectorService(cfg, version)
+		service, err := collector.NewCollectorService(cfg, *configFile, version)
 		if err != nil {
 			log.Fatalf("Failed to create collector service for testing: %v", err)
 		}
@@ -59,50 +85,21 @@ func main() {
 		return
 	}
 
-	// If no token, but has registration code, register with server
-	if cfg.Auth.Token == "" && cfg.Auth.RegistrationCode != "" {
-		log.Println("No token found, attempting to register with server using registration code...")
-
-		// Generate collector ID if not provided
-		if cfg.Collector.ID == "" {
-			cfg.Collector.ID = uuid.New().String()
-			log.Printf("Generated new collector ID: %s", cfg.Collector.ID)
+	// Register every channel that has no token, but has a registration code
+	registered := false
+	for _, ch := range cfg.MeterChannels() {
+		if ch.Token != "" || ch.RegistrationCode == "" {
+			continue
 		}
-
-		apiClient := client.NewAPIClient(cfg.Server.BaseURL, cfg.Server.APIPrefix, cfg.Server.Timeout*time.Second)
-		req := client.RegisterRequest{
-			RegistrationCode: cfg.Auth.RegistrationCode,
-			CollectorID:      cfg.Collector.ID,
-			Name:             cfg.Collector.Name,
-			Description:      cfg.Collector.Description,
-			Location:         cfg.Collector.Location,
-			Version:          version,
-		}
-
-		resp, err := apiClient.Register(req)
-		if err != nil {
-			log.Fatalf("Failed to register collector: %v", err)
+		log.Printf("No token found for channel %s, attempting to register with server using registration code...", ch.Key)
+		if err := registerChannel(cfg, ch); err != nil {
+			log.Fatalf("Failed to register collector for channel %s: %v", ch.Key, err)
 		}
+		registered = true
+	}
 
-		log.Println("Collector registered successfully.")
-
-		// Update config with data from server
-		cfg.Auth.Token = resp.Data.Token
-		if resp.Data.Config.CollectorID != "" {
-			cfg.Collector.ID = resp.Data.Config.CollectorID
-		}
-		if resp.Data.Config.SampleInterval > 0 {
-			cfg.Serial.SampleInterval = time.Duration(resp.Data.Config.SampleInterval)
-		}
-		if resp.Data.Config.UploadInterval > 0 {
-			cfg.Data.UploadInterval = time.Duration(resp.Data.Config.UploadInterval)
-		}
-		if resp.Data.Config.MaxCacheSize > 0 {
-			cfg.Data.MaxCacheSize = resp.Data.Config.MaxCacheSize
-		}
-		cfg.Data.AutoUpload = resp.Data.Config.AutoUpload
-
-		// Save updated config
+	// Save updated config
+	if registered {
 		if err := config.SaveConfig(cfg, *configFile); err != nil {
 			log.Fatalf("Failed to save updated configuration: %v", err)
 		}
@@ -110,29 +107,44 @@ func main() {
 	}
 
 	log.Printf("Starting Power Collector v%s", version)
-	log.Printf("Collector ID: %s", cfg.Collector.ID)
 	log.Printf("Collector Name: %s", cfg.Collector.Name)
+	for _, ch := range cfg.MeterChannels() {
+		log.Printf("Channel %s: collector ID %s, address %d", ch.Key, ch.ID, ch.Address)
+	}
 
 	// Create collector service
-	service, err := collector.NewCollectorService(cfg, version)
+	service, err := collector.NewCollectorService(cfg, *configFile, version)
 	if err != nil {
 		log.Fatalf("Failed to create collector service: %v", err)
 	}
 
+	// Ping the systemd watchdog while the collection and upload loops make
+	// progress. Starting up is bounded by the start timeout instead.
+	watchdogStop := make(chan struct{})
+	go systemd.Watchdog(watchdogStop, service.Stalled)
+
 	// Start the service
 	if err := service.Start(); err != nil {
 		log.Fatalf("Failed to start collector service: %v", err)
 	}
+	systemd.Ready(fmt.Sprintf("Collecting from %d channels", len(cfg.MeterChannels())))
 
 	// Setup signal handling for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-	// Wait for shutdown signal
+	// Wait for shutdown signal or a restart requested by the server
 	log.Println("Collector is running. Press Ctrl+C to stop.")
-	sig := <-sigChan
-
-	log.Printf("Received signal %v, stopping collector...", sig)
+	restart := false
+	select {
+	case sig := <-sigChan:
+		log.Printf("Received signal %v, stopping collector...", sig)
+		systemd.Stopping()
+	case <-service.RestartRequested():
+		log.Println("Restart requested by the server, stopping collector...")
+		systemd.Reloading()
+		restart = true
+	}
 
 	// Stop the service gracefully with a timeout
 	stopCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -152,4 +164,101 @@ func main() {
 	case <-stopCtx.Done():
 		log.Println("Graceful shutdown timed out after 10 seconds. Forcing exit.")
 	}
+	close(watchdogStop)
+
+	if restart {
+		restartProcess()
+	}
+}
+
+// registerChannel registers the collector of a channel with its registration
+// code and stores the token, signing secret and settings assigned by the
+// server in cfg. A client certificate is requested if one is configured but
+// its file does not exist yet.
+func registerChannel(cfg *config.Config, ch config.ChannelConfig) error {
+	// Generate collector ID if not provided
+	if ch.ID == "" {
+		ch.ID = uuid.New().String()
+		log.Printf("Generated new collector ID: %s", ch.ID)
+	}
+
+	apiClient := client.NewAPIClient(cfg.Server.BaseURL, cfg.Server.APIPrefix, cfg.Server.Timeout*time.Second)
+	if err := apiClient.SetTLS("", "", cfg.Server.CACert); err != nil {
+		return fmt.Errorf("failed to configure TLS: %w", err)
+	}
+	req := client.RegisterRequest{
+		RegistrationCode: ch.RegistrationCode,
+		CollectorID:      ch.ID,
+		Name:             ch.Name,
+		Description:      ch.Description,
+		Location:         ch.Location,
+		Version:          version,
+	}
+
+	// Request a client certificate if one is configured but missing
+	requestCert := false
+	if ch.ClientCert != "" {
+		if _, err := os.Stat(ch.ClientCert); os.IsNotExist(err) {
+			csr, err := client.GenerateCSR(ch.ID, ch.ClientKey)
+			if err != nil {
+				return fmt.Errorf("failed to create client certificate request: %w", err)
+			}
+			req.CSR = string(csr)
+			requestCert = true
+		}
+	}
+
+	resp, err := apiClient.Register(req)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Collector for channel %s registered successfully.", ch.Key)
+
+	// The token is saved either way, so the registration code is not
+	// wasted if no certificate was issued
+	if requestCert {
+		if resp.Data.Certificate == "" {
+			log.Printf("Server did not issue a client certificate for channel %s, client certificates may be disabled on the server", ch.Key)
+		} else if err := os.WriteFile(ch.ClientCert, []byte(resp.Data.Certificate), 0644); err != nil {
+			log.Printf("Failed to save client certificate of channel %s: %v", ch.Key, err)
+		} else {
+			log.Printf("Client certificate of channel %s saved to %s", ch.Key, ch.ClientCert)
+		}
+	}
+
+	// Update config with data from server
+	ch.Token = resp.Data.Token
+	ch.SigningSecret = resp.Data.SigningSecret
+	if resp.Data.Config.CollectorID != "" {
+		ch.ID = resp.Data.Config.CollectorID
+	}
+	cfg.UpdateChannel(ch)
+	if resp.Data.Config.SampleInterval > 0 {
+		cfg.Serial.SampleInterval = time.Duration(resp.Data.Config.SampleInterval)
+	}
+	if resp.Data.Config.UploadInterval > 0 {
+		cfg.Data.UploadInterval = time.Duration(resp.Data.Config.UploadInterval)
+	}
+	if resp.Data.Config.MaxCacheSize > 0 {
+		cfg.Data.MaxCacheSize = resp.Data.Config.MaxCacheSize
+	}
+	if resp.Data.Config.BatchSize > 0 {
+		cfg.Data.BatchSize = resp.Data.Config.BatchSize
+	}
+	cfg.Data.AutoUpload = resp.Data.Config.AutoUpload
+	return nil
+}
+
+// restartProcess replaces the process with a fresh instance of the
+// collector. If that fails, it exits with an error so that the service
+// manager restarts it.
+func restartProcess() {
+	executable, err := os.Executable()
+	if err == nil {
+		log.Println("Restarting collector...")
+		err = syscall.Exec(executable, os.Args, os.Environ())
+	}
+	log.Printf("Failed to restart collector: %v, exiting for the service manager to restart it", err)
+	os.Exit(1)
 }
@@ -1,23 +1,51 @@
 package client
 
 import (
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"sync"
 	"time"
 
+	"power-collector/pkg/clock"
+
 	"github.com/go-resty/resty/v2"
 )
 
+// TokenExpiresHeader carries the expiry of the token a request was
+// authenticated with
+const TokenExpiresHeader = "X-Token-Expires-At"
+
 // APIClient represents the HTTP client for server communication
 type APIClient struct {
-	client      *resty.Client
-	baseURL     string
-	prefix      string
-	token       string
-	collectorID string
+	client  *resty.Client
+	baseURL string
+	prefix  string
+
+	// Credentials, replaced when the token is rotated
+	tokenMu        sync.RWMutex
+	token          string
+	tokenExpiresAt time.Time
+	collectorID    string
+	signingSecret  string
+
+	// TLS configuration shared with the command channel, nil for defaults
+	tlsConfig *tls.Config
+
+	// Compression of batch uploads, disabled when algorithm is empty
+	compressionMu    sync.RWMutex
+	compression      string
+	compressionLevel int
+
+	// clock is synchronized with the server time of the responses, nil if
+	// it is not tracked
+	clock *clock.Clock
 }
 
 // PowerDataRequest represents a single power data measurement for API
 type PowerDataRequest struct {
+	Seq         uint64    `json:"seq"` // per-collector sequence number the server deduplicates on
 	Timestamp   time.Time `json:"timestamp"`
 	Voltage     float64   `json:"voltage"`
 	Current     float64   `json:"current"`
@@ -25,6 +53,29 @@ type PowerDataRequest struct {
 	Energy      float64   `json:"energy"`
 	Frequency   float64   `json:"frequency"`
 	PowerFactor float64   `json:"power_factor"`
+	// TimeUnreliable is set if the reading was taken before the clock was
+	// synchronized with the server
+	TimeUnreliable bool `json:"time_unreliable,omitempty"`
+	// Quality is set if the collector rejected the reading as implausible
+	Quality string `json:"quality,omitempty"`
+	// Aggregate is set if the reading summarizes the samples of a window
+	Aggregate *PowerAggregate `json:"aggregate,omitempty"`
+}
+
+// PowerAggregate represents the extremes and the energy consumption of the
+// samples summarized by a reading
+type PowerAggregate struct {
+	Window         int     `json:"window"` // seconds
+	Samples        int     `json:"samples"`
+	VoltageMin     float64 `json:"voltage_min"`
+	VoltageMax     float64 `json:"voltage_max"`
+	CurrentMin     float64 `json:"current_min"`
+	CurrentMax     float64 `json:"current_max"`
+	PowerMin       float64 `json:"power_min"`
+	PowerMax       float64 `json:"power_max"`
+	PowerFactorMin float64 `json:"power_factor_min"`
+	PowerFactorMax float64 `json:"power_factor_max"`
+	EnergyDelta    float64 `json:"energy_delta"` // Wh
 }
 
 // PowerDataUploadRequest represents bulk power data upload
@@ -33,6 +84,51 @@ type PowerDataUploadRequest struct {
 	Data        []PowerDataRequest `json:"data"`
 }
 
+// DataLossReport represents cached readings the collector dropped or
+// downsampled while its cache was full
+type DataLossReport struct {
+	Policy  string    `json:"policy"`
+	Records int       `json:"records"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+}
+
+// DataLossRequest represents the data loss report request
+type DataLossRequest struct {
+	Losses []DataLossReport `json:"losses"`
+}
+
+// AlarmEvent represents a local alarm rule being raised or cleared
+type AlarmEvent struct {
+	Rule      string    `json:"rule"`
+	Metric    string    `json:"metric"`
+	State     string    `json:"state"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// AlarmEventRequest represents the alarm event report request
+type AlarmEventRequest struct {
+	Events []AlarmEvent `json:"events"`
+}
+
+// BatchUploadResponse represents the response of a batch upload
+type BatchUploadResponse struct {
+	Success bool               `json:"success"`
+	Message string             `json:"message"`
+	Count   int                `json:"count"`
+	Data    *BatchUploadResult `json:"data"`
+}
+
+// BatchUploadResult lists the sequence numbers of a batch the server stored
+// and those it had stored before. It is nil for servers that do not
+// deduplicate.
+type BatchUploadResult struct {
+	Accepted   []uint64 `json:"accepted"`
+	Duplicates []uint64 `json:"duplicates"`
+}
+
 // RegisterRequest represents collector registration request
 type RegisterRequest struct {
 	RegistrationCode string `json:"registration_code"`
@@ -41,6 +137,8 @@ type RegisterRequest struct {
 	Description      string `json:"description"`
 	Location         string `json:"location"`
 	Version          string `json:"version"`
+	// CSR requests a client certificate for the collector
+	CSR string `json:"csr,omitempty"`
 }
 
 // RegisterResponse represents collector registration response
@@ -48,23 +146,69 @@ type RegisterResponse struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
 	Data    struct {
-		Token        string `json:"token"`
-		TokenExpires string `json:"token_expires"`
-		Config       struct {
-			CollectorID      string `json:"collector_id"`
-			SampleInterval   int    `json:"sample_interval"`
-			UploadInterval   int    `json:"upload_interval"`
-			MaxCacheSize     int    `json:"max_cache_size"`
-			AutoUpload       bool   `json:"auto_upload"`
-			CompressionLevel int    `json:"compression_level"`
-		} `json:"config"`
+		Token        string       `json:"token"`
+		TokenExpires time.Time    `json:"token_expires"`
+		Config       RemoteConfig `json:"config"`
+		Certificate  string       `json:"certificate"` // signed from the CSR, if any
+		// SigningSecret is the key requests may be signed with
+		SigningSecret string `json:"signing_secret"`
 	} `json:"data"`
 }
 
+// TokenResponse represents the response of a token rotation
+type TokenResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Data    struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	} `json:"data"`
+}
+
+// RemoteConfig represents the collector configuration managed on the server
+type RemoteConfig struct {
+	CollectorID      string `json:"collector_id"`
+	SampleInterval   int    `json:"sample_interval"` // seconds
+	UploadInterval   int    `json:"upload_interval"` // seconds
+	MaxCacheSize     int    `json:"max_cache_size"`
+	BatchSize        int    `json:"batch_size"`
+	AutoUpload       bool   `json:"auto_upload"`
+	CompressionLevel int    `json:"compression_level"`
+	Version          int    `json:"version"`
+	// Validation settings replacing those of the collector, nil where the
+	// server does not set them
+	VoltageMin     *float64 `json:"voltage_min"`
+	VoltageMax     *float64 `json:"voltage_max"`
+	CurrentMax     *float64 `json:"current_max"`
+	PowerMax       *float64 `json:"power_max"`
+	FrequencyMin   *float64 `json:"frequency_min"`
+	FrequencyMax   *float64 `json:"frequency_max"`
+	SpikeFilter    *bool    `json:"spike_filter"`
+	SpikeThreshold *float64 `json:"spike_threshold"`
+	UploadRejected *bool    `json:"upload_rejected"`
+}
+
+// ConfigResponse represents the response of the config endpoint
+type ConfigResponse struct {
+	Success bool         `json:"success"`
+	Message string       `json:"message"`
+	Data    RemoteConfig `json:"data"`
+}
+
+// ConfigAppliedRequest reports the config version the collector runs with
+type ConfigAppliedRequest struct {
+	Version int `json:"version"`
+}
+
 // HeartbeatRequest represents heartbeat request
 type HeartbeatRequest struct {
 	Status  string `json:"status"`
 	Version string `json:"version"`
+	// Timestamp is the local time the heartbeat is sent, uncorrected, so
+	// that the server can record the offset of the collector clock
+	Timestamp time.Time `json:"timestamp"`
+	// Diagnostics describes the state of the collector
+	Diagnostics interface{} `json:"diagnostics,omitempty"`
 }
 
 // APIResponse represents standard API response
@@ -89,18 +233,105 @@ func NewAPIClient(baseURL, apiPrefix string, timeout time.Duration) *APIClient {
 		return r.StatusCode() >= 500 || err != nil
 	})
 
-	return &APIClient{
+	a := &APIClient{
 		client:  client,
 		baseURL: baseURL,
 		prefix:  apiPrefix,
 	}
+
+	// Authenticate with the current token and keep track of its expiry
+	client.OnBeforeRequest(func(_ *resty.Client, r *resty.Request) error {
+		if token := a.currentToken(); token != "" {
+			r.SetHeader("Authorization", "Bearer "+token)
+		}
+		return nil
+	})
+	client.SetPreRequestHook(func(_ *resty.Client, r *http.Request) error {
+		return a.signRequest(r)
+	})
+	client.OnAfterResponse(func(_ *resty.Client, r *resty.Response) error {
+		if expiresAt, err := time.Parse(time.RFC3339, r.Header().Get(TokenExpiresHeader)); err == nil {
+			a.tokenMu.Lock()
+			a.tokenExpiresAt = expiresAt
+			a.tokenMu.Unlock()
+		}
+		a.observeServerTime(r)
+		return nil
+	})
+
+	return a
 }
 
 // SetToken sets the token for API requests
 func (a *APIClient) SetToken(token, collectorID string) {
+	a.tokenMu.Lock()
+	defer a.tokenMu.Unlock()
+
 	a.token = token
 	a.collectorID = collectorID
-	a.client.SetHeader("Authorization", "Bearer "+token)
+}
+
+// currentToken returns the token for API requests
+func (a *APIClient) currentToken() string {
+	a.tokenMu.RLock()
+	defer a.tokenMu.RUnlock()
+
+	return a.token
+}
+
+// TokenExpiresAt returns when the token expires as last reported by the
+// server, zero if unknown
+func (a *APIClient) TokenExpiresAt() time.Time {
+	a.tokenMu.RLock()
+	defer a.tokenMu.RUnlock()
+
+	return a.tokenExpiresAt
+}
+
+// SetClock synchronizes a clock with the server time returned in the
+// responses, and signs requests with its time
+func (a *APIClient) SetClock(c *clock.Clock) {
+	a.clock = c
+}
+
+// now returns the time by the server clock if it is tracked
+func (a *APIClient) now() time.Time {
+	if a.clock == nil {
+		return time.Now()
+	}
+	return a.clock.Now()
+}
+
+// observeServerTime synchronizes the clock with the server time of a
+// response, returned by the heartbeat and upload endpoints
+func (a *APIClient) observeServerTime(r *resty.Response) {
+	if a.clock == nil {
+		return
+	}
+
+	var body struct {
+		ServerTime time.Time `json:"server_time"`
+	}
+	if err := json.Unmarshal(r.Body(), &body); err != nil || body.ServerTime.IsZero() {
+		return
+	}
+	a.clock.Observe(body.ServerTime, r.Request.Time, r.ReceivedAt())
+}
+
+// SetCompression compresses batch uploads with the algorithm (gzip or zstd)
+// at a level from 1 (fastest) to 9 (smallest). An empty algorithm sends
+// plain JSON.
+func (a *APIClient) SetCompression(algorithm string, level int) error {
+	if algorithm != "" && algorithm != CompressionGzip && algorithm != CompressionZstd {
+		return fmt.Errorf("unsupported compression: %s", algorithm)
+	}
+
+	a.compressionMu.Lock()
+	defer a.compressionMu.Unlock()
+
+	a.compression = algorithm
+	a.compressionLevel = level
+	return nil
 }
 
 // Register registers the collector with the server using registration code
@@ -152,9 +383,9 @@ func (a *APIClient) UploadData(data PowerDataRequest) error {
 }
 
 // UploadBatchData uploads multiple power data measurements
-func (a *APIClient) UploadBatchData(data []PowerDataRequest) error {
+func (a *APIClient) UploadBatchData(data []PowerDataRequest) (*BatchUploadResult, error) {
 	if len(data) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	request := PowerDataUploadRequest{
@@ -162,33 +393,109 @@ func (a *APIClient) UploadBatchData(data []PowerDataRequest) error {
 		Data:        data,
 	}
 
+	var response BatchUploadResponse
+
+	req := a.client.R().SetResult(&response)
+
+	a.compressionMu.RLock()
+	algorithm, level := a.compression, a.compressionLevel
+	a.compressionMu.RUnlock()
+
+	if algorithm != "" {
+		body, err := json.Marshal(request)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode batch data: %w", err)
+		}
+		compressed, err := compress(algorithm, level, body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress batch data: %w", err)
+		}
+		req.SetHeader("Content-Encoding", algorithm).SetBody(compressed)
+	} else {
+		req.SetBody(request)
+	}
+
+	resp, err := req.Post(a.buildURL("/collector/data/batch"))
+
+	if err != nil {
+		return nil, fmt.Errorf("batch upload request failed: %w", err)
+	}
+
+	if resp.StatusCode() != 200 {
+		return nil, fmt.Errorf("batch upload failed with status %d: %s", resp.StatusCode(), resp.String())
+	}
+
+	if !response.Success {
+		return nil, fmt.Errorf("batch upload failed: %s", response.Message)
+	}
+
+	return response.Data, nil
+}
+
+// ReportDataLoss reports readings lost to the cache size limit
+func (a *APIClient) ReportDataLoss(losses []DataLossReport) error {
+	if len(losses) == 0 {
+		return nil
+	}
+
 	var response APIResponse
 
 	resp, err := a.client.R().
-		SetBody(request).
+		SetBody(DataLossRequest{Losses: losses}).
 		SetResult(&response).
-		Post(a.buildURL("/collector/data/batch"))
+		Post(a.buildURL("/collector/data/loss"))
 
 	if err != nil {
-		return fmt.Errorf("batch upload request failed: %w", err)
+		return fmt.Errorf("data loss report request failed: %w", err)
 	}
 
 	if resp.StatusCode() != 200 {
-		return fmt.Errorf("batch upload failed with status %d: %s", resp.StatusCode(), resp.String())
+		return fmt.Errorf("data loss report failed with status %d: %s", resp.StatusCode(), resp.String())
 	}
 
 	if !response.Success {
-		return fmt.Errorf("batch upload failed: %s", response.Message)
+		return fmt.Errorf("data loss report failed: %s", response.Message)
 	}
 
 	return nil
 }
 
-// SendHeartbeat sends heartbeat to maintain connection
-func (a *APIClient) SendHeartbeat(status, version string) error {
+// ReportAlarmEvents reports local alarms that were raised or cleared
+func (a *APIClient) ReportAlarmEvents(events []AlarmEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	var response APIResponse
+
+	resp, err := a.client.R().
+		SetBody(AlarmEventRequest{Events: events}).
+		SetResult(&response).
+		Post(a.buildURL("/collector/alarms"))
+
+	if err != nil {
+		return fmt.Errorf("alarm event report request failed: %w", err)
+	}
+
+	if resp.StatusCode() != 200 {
+		return fmt.Errorf("alarm event report failed with status %d: %s", resp.StatusCode(), resp.String())
+	}
+
+	if !response.Success {
+		return fmt.Errorf("alarm event report failed: %s", response.Message)
+	}
+
+	return nil
+}
+
+// SendHeartbeat sends heartbeat to maintain connection. diagnostics is
+// encoded as JSON and stored by the server, nil to send none.
+func (a *APIClient) SendHeartbeat(status, version string, diagnostics interface{}) error {
 	request := HeartbeatRequest{
-		Status:  status,
-		Version: version,
+		Status:      status,
+		Version:     version,
+		Timestamp:   time.Now(),
+		Diagnostics: diagnostics,
 	}
 
 	var response APIResponse
@@ -213,9 +520,38 @@ func (a *APIClient) SendHeartbeat(status, version string) error {
 	return nil
 }
 
+// RotateToken asks the server for a new token and uses it for all further
+// requests. The old token stays valid on the server for a grace period.
+func (a *APIClient) RotateToken() (*TokenResponse, error) {
+	var response TokenResponse
+
+	resp, err := a.client.R().
+		SetResult(&response).
+		Post(a.buildURL("/collector/token/rotate"))
+
+	if err != nil {
+		return nil, fmt.Errorf("token rotation request failed: %w", err)
+	}
+
+	if resp.StatusCode() != 200 {
+		return nil, fmt.Errorf("token rotation failed with status %d: %s", resp.StatusCode(), resp.String())
+	}
+
+	if !response.Success || response.Data.Token == "" {
+		return nil, fmt.Errorf("token rotation failed: %s", response.Message)
+	}
+
+	a.tokenMu.Lock()
+	a.token = response.Data.Token
+	a.tokenExpiresAt = response.Data.ExpiresAt
+	a.tokenMu.Unlock()
+
+	return &response, nil
+}
+
 // GetConfig retrieves collector configuration from server
-func (a *APIClient) GetConfig() (map[string]interface{}, error) {
-	var response APIResponse
+func (a *APIClient) GetConfig() (*RemoteConfig, error) {
+	var response ConfigResponse
 
 	resp, err := a.client.R().
 		SetResult(&response).
@@ -233,27 +569,87 @@ func (a *APIClient) GetConfig() (map[string]interface{}, error) {
 		return nil, fmt.Errorf("get config failed: %s", response.Message)
 	}
 
-	if config, ok := response.Data.(map[string]interface{}); ok {
-		return config, nil
+	return &response.Data, nil
+}
+
+// ReportConfigApplied tells the server which config version is in effect
+func (a *APIClient) ReportConfigApplied(version int) error {
+	var response APIResponse
+
+	resp, err := a.client.R().
+		SetBody(ConfigAppliedRequest{Version: version}).
+		SetResult(&response).
+		Post(a.buildURL("/collector/config/applied"))
+
+	if err != nil {
+		return fmt.Errorf("config applied request failed: %w", err)
+	}
+
+	if resp.StatusCode() != 200 {
+		return fmt.Errorf("config applied failed with status %d: %s", resp.StatusCode(), resp.String())
 	}
 
-	return nil, fmt.Errorf("get config failed: invalid data format")
+	if !response.Success {
+		return fmt.Errorf("config applied failed: %s", response.Message)
+	}
+
+	return nil
 }
 
 // TestConnection tests the connection to the server
 func (a *APIClient) TestConnection() error {
+	_, err := a.TestConnectionTiming()
+	return err
+}
+
+// ConnectionTiming breaks down the last attempt of a connection test
+type ConnectionTiming struct {
+	RemoteAddr   string
+	StatusCode   int
+	Attempts     int
+	ConnReused   bool
+	DNSLookup    time.Duration
+	Connect      time.Duration // TCP connect
+	TLSHandshake time.Duration
+	ServerTime   time.Duration // from the request being sent to the first response byte
+	Total        time.Duration
+}
+
+// TestConnectionTiming tests the connection like TestConnection and reports
+// how long each phase of the request took. The timing is returned as far as
+// the request got, also if it failed.
+func (a *APIClient) TestConnectionTiming() (*ConnectionTiming, error) {
 	resp, err := a.client.R().
+		EnableTrace().
 		Get(a.buildURL("/collector/config"))
 
+	var timing *ConnectionTiming
+	if resp != nil && resp.Request != nil {
+		trace := resp.Request.TraceInfo()
+		timing = &ConnectionTiming{
+			StatusCode:   resp.StatusCode(),
+			Attempts:     trace.RequestAttempt,
+			ConnReused:   trace.IsConnReused,
+			DNSLookup:    trace.DNSLookup,
+			Connect:      trace.TCPConnTime,
+			TLSHandshake: trace.TLSHandshake,
+			ServerTime:   trace.ServerTime,
+			Total:        trace.TotalTime,
+		}
+		if trace.RemoteAddr != nil {
+			timing.RemoteAddr = trace.RemoteAddr.String()
+		}
+	}
+
 	if err != nil {
-		return fmt.Errorf("test connection request failed: %w", err)
+		return timing, fmt.Errorf("test connection request failed: %w", err)
 	}
 
 	if resp.StatusCode() >= 400 {
-		return fmt.Errorf("connection test failed with status %d", resp.StatusCode())
+		return timing, fmt.Errorf("connection test failed with status %d", resp.StatusCode())
 	}
 
-	return nil
+	return timing, nil
 }
 
 // buildURL constructs the full API URL
@@ -0,0 +1,129 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Command channel message types
+const (
+	CommandMessageCommand = "command" // server to collector: run a command
+	CommandMessageAck     = "ack"     // collector to server: command received
+	CommandMessageResult  = "result"  // collector to server: command finished
+)
+
+// commandReadTimeout is how long the channel may stay silent. The server
+// pings every 54 seconds.
+const commandReadTimeout = 90 * time.Second
+
+// CommandMessage represents a message on the command channel
+type CommandMessage struct {
+	Type    string            `json:"type"`
+	ID      uint              `json:"id"`
+	Command string            `json:"command,omitempty"`
+	Args    map[string]string `json:"args,omitempty"`
+	Success bool              `json:"success,omitempty"`
+	Result  string            `json:"result,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// CommandConn represents the command channel WebSocket to the server
+type CommandConn struct {
+	conn *websocket.Conn
+	mu   sync.Mutex // serializes writes
+}
+
+// DialCommands opens the command channel of the collector
+func (a *APIClient) DialCommands() (*CommandConn, error) {
+	url := a.buildURL("/collector/ws")
+	url = strings.Replace(url, "http", "ws", 1)
+
+	dialer := websocket.Dialer{
+		HandshakeTimeout: a.client.GetClient().Timeout,
+		TLSClientConfig:  a.tlsConfig,
+	}
+	header := http.Header{}
+	if token := a.currentToken(); token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	if secret := a.signingKey(); secret != "" {
+		u, err := neturl.Parse(url)
+		if err != nil {
+			return nil, fmt.Errorf("invalid command channel URL: %w", err)
+		}
+		for name, value := range signatureHeaders(secret, http.MethodGet, u.RequestURI(), nil, a.now()) {
+			header.Set(name, value)
+		}
+	}
+
+	conn, resp, err := dialer.Dial(url, header)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("command channel connection failed with status %d: %w", resp.StatusCode, err)
+		}
+		return nil, fmt.Errorf("command channel connection failed: %w", err)
+	}
+
+	cc := &CommandConn{conn: conn}
+	conn.SetReadDeadline(time.Now().Add(commandReadTimeout))
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(commandReadTimeout))
+		cc.mu.Lock()
+		defer cc.mu.Unlock()
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(10*time.Second))
+	})
+
+	return cc, nil
+}
+
+// Receive waits for the next command from the server
+func (cc *CommandConn) Receive() (*CommandMessage, error) {
+	for {
+		var msg CommandMessage
+		if err := cc.conn.ReadJSON(&msg); err != nil {
+			return nil, err
+		}
+		if msg.Type == CommandMessageCommand {
+			return &msg, nil
+		}
+	}
+}
+
+// Ack confirms that a command was received
+func (cc *CommandConn) Ack(id uint) error {
+	return cc.write(CommandMessage{Type: CommandMessageAck, ID: id})
+}
+
+// SendResult reports the outcome of a command
+func (cc *CommandConn) SendResult(id uint, result string, err error) error {
+	msg := CommandMessage{
+		Type:    CommandMessageResult,
+		ID:      id,
+		Success: err == nil,
+		Result:  result,
+	}
+	if err != nil {
+		msg.Error = err.Error()
+	}
+	return cc.write(msg)
+}
+
+// write sends a message to the server
+func (cc *CommandConn) write(msg CommandMessage) error {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	cc.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return cc.conn.WriteJSON(msg)
+}
+
+// Close closes the command channel
+func (cc *CommandConn) Close() error {
+	return cc.conn.Close()
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression algorithms of batch uploads, sent as the Content-Encoding
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// compress encodes data with the algorithm at a level from 1 (fastest) to 9
+// (smallest)
+func compress(algorithm string, level int, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	switch algorithm {
+	case CompressionGzip:
+		w, err := gzip.NewWriterLevel(&buf, level)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	case CompressionZstd:
+		w, err := zstd.NewWriter(&buf, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported compression: %s", algorithm)
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,263 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"power-collector/pkg/clock"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mqttQoS is the quality of service of all messages: delivered at least once,
+// with the server skipping readings it has stored before
+const mqttQoS = 1
+
+// mqttMaxReconnectInterval bounds the backoff between reconnection attempts,
+// so that the collector is back soon after the broker
+const mqttMaxReconnectInterval = time.Minute
+
+// mqttSignatureMethod takes the place of the request method in the
+// signature of a message
+const mqttSignatureMethod = "PUBLISH"
+
+// Online status of a collector published to its status topic
+const (
+	StatusOnline  = "online"
+	StatusOffline = "offline"
+)
+
+// MQTTOptions represents the connection of a collector to an MQTT broker
+type MQTTOptions struct {
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+	// CACert holds the CA certificates the broker certificate is verified
+	// against, the system pool is used if empty
+	CACert    string
+	KeepAlive time.Duration
+	// Timeout is how long to wait for the broker to acknowledge a message
+	Timeout time.Duration
+	// Topics of the collector
+	DataTopic      string
+	HeartbeatTopic string
+	StatusTopic    string
+}
+
+// StatusMessage represents the retained online status of a collector
+type StatusMessage struct {
+	Status  string `json:"status"`
+	Version string `json:"version,omitempty"`
+}
+
+// SignedMessage wraps a message published by a collector that signs its
+// messages. The signature is computed like that of a request, see
+// signatureHeaders, with PUBLISH as the method, the topic as the path and
+// the message as the body.
+type SignedMessage struct {
+	Timestamp int64           `json:"timestamp"` // Unix seconds
+	Nonce     string          `json:"nonce"`
+	Signature string          `json:"signature"`
+	Message   json.RawMessage `json:"message"`
+}
+
+// MQTTClient publishes the readings, heartbeats and status of a collector to
+// an MQTT broker. The session is persistent, so messages in flight when the
+// connection drops are delivered after reconnecting.
+type MQTTClient struct {
+	client      mqtt.Client
+	options     MQTTOptions
+	collectorID string
+
+	// Set before connecting
+	signingSecret string
+	clock         *clock.Clock
+}
+
+// NewMQTTClient creates an MQTT client of a collector. The broker publishes
+// the offline status when the connection is lost.
+func NewMQTTClient(collectorID, version string, options MQTTOptions) (*MQTTClient, error) {
+	m := &MQTTClient{
+		options:     options,
+		collectorID: collectorID,
+	}
+
+	online, err := json.Marshal(StatusMessage{Status: StatusOnline, Version: version})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode status: %w", err)
+	}
+	offline, err := json.Marshal(StatusMessage{Status: StatusOffline, Version: version})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode status: %w", err)
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(options.Broker).
+		SetClientID(options.ClientID).
+		SetUsername(options.Username).
+		SetPassword(options.Password).
+		SetKeepAlive(options.KeepAlive).
+		SetCleanSession(false).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetMaxReconnectInterval(mqttMaxReconnectInterval).
+		SetOrderMatters(false).
+		SetBinaryWill(options.StatusTopic, offline, mqttQoS, true).
+		SetOnConnectHandler(func(c mqtt.Client) {
+			// Not waited for, the handler must not block the client
+			c.Publish(options.StatusTopic, mqttQoS, true, online)
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("Connection to MQTT broker %s lost: %v", options.Broker, err)
+		})
+
+	if options.CACert != "" {
+		caPEM, err := os.ReadFile(options.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in %s", options.CACert)
+		}
+		opts.SetTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool})
+	}
+
+	m.client = mqtt.NewClient(opts)
+	return m, nil
+}
+
+// SetSigningSecret signs the readings and heartbeats with the secret issued
+// at registration. The status is not signed, as the broker publishes the
+// offline status on behalf of the collector. An empty secret publishes
+// unsigned messages.
+func (m *MQTTClient) SetSigningSecret(secret string) {
+	m.signingSecret = secret
+}
+
+// SetClock signs messages with the time of a clock synchronized with the
+// server
+func (m *MQTTClient) SetClock(c *clock.Clock) {
+	m.clock = c
+}
+
+// Connect connects to the broker. The first connection is retried in the
+// background until the broker is reachable, so an error only reports that
+// it is not up yet, and the client reconnects by itself when the connection
+// is lost later.
+func (m *MQTTClient) Connect() error {
+	token := m.client.Connect()
+	if !token.WaitTimeout(m.options.Timeout) {
+		return fmt.Errorf("connection to MQTT broker %s timed out", m.options.Broker)
+	}
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("failed to connect to MQTT broker %s: %w", m.options.Broker, err)
+	}
+	return nil
+}
+
+// Close publishes the offline status, which the broker does not do on a
+// clean disconnect, and disconnects
+func (m *MQTTClient) Close() {
+	if !m.client.IsConnectionOpen() {
+		m.client.Disconnect(0)
+		return
+	}
+	if err := m.publish(m.options.StatusTopic, true, false, StatusMessage{Status: StatusOffline}); err != nil {
+		log.Printf("Failed to publish offline status: %v", err)
+	}
+	m.client.Disconnect(250)
+}
+
+// UploadData publishes a single power data measurement
+func (m *MQTTClient) UploadData(data PowerDataRequest) error {
+	_, err := m.UploadBatchData([]PowerDataRequest{data})
+	return err
+}
+
+// UploadBatchData publishes multiple power data measurements in one message.
+// The broker does not report which readings the server stored, so the result
+// is always nil.
+func (m *MQTTClient) UploadBatchData(data []PowerDataRequest) (*BatchUploadResult, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	request := PowerDataUploadRequest{
+		CollectorID: m.collectorID,
+		Data:        data,
+	}
+	if err := m.publish(m.options.DataTopic, false, true, request); err != nil {
+		return nil, fmt.Errorf("data publish failed: %w", err)
+	}
+	return nil, nil
+}
+
+// SendHeartbeat publishes a heartbeat with diagnostics, see
+// APIClient.SendHeartbeat
+func (m *MQTTClient) SendHeartbeat(status, version string, diagnostics interface{}) error {
+	request := HeartbeatRequest{
+		Status:      status,
+		Version:     version,
+		Timestamp:   time.Now(),
+		Diagnostics: diagnostics,
+	}
+	if err := m.publish(m.options.HeartbeatTopic, false, true, request); err != nil {
+		return fmt.Errorf("heartbeat publish failed: %w", err)
+	}
+	return nil
+}
+
+// publish publishes a message as JSON, signed if sign is set and a signing
+// secret is, and waits for the broker to acknowledge it. Messages are not
+// queued while the connection is down, as the cache keeps the readings
+// until they are acknowledged.
+func (m *MQTTClient) publish(topic string, retained, sign bool, message interface{}) error {
+	if !m.client.IsConnectionOpen() {
+		return fmt.Errorf("not connected to MQTT broker %s", m.options.Broker)
+	}
+
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+	if sign && m.signingSecret != "" {
+		if payload, err = m.sign(topic, payload); err != nil {
+			return err
+		}
+	}
+
+	token := m.client.Publish(topic, mqttQoS, retained, payload)
+	if !token.WaitTimeout(m.options.Timeout) {
+		return fmt.Errorf("broker did not acknowledge the message within %v", m.options.Timeout)
+	}
+	return token.Error()
+}
+
+// sign wraps a message published to a topic into a SignedMessage
+func (m *MQTTClient) sign(topic string, payload []byte) ([]byte, error) {
+	now := time.Now()
+	if m.clock != nil {
+		now = m.clock.Now()
+	}
+
+	headers := signatureHeaders(m.signingSecret, mqttSignatureMethod, topic, payload, now)
+	timestamp, _ := strconv.ParseInt(headers[SignatureTimestampHeader], 10, 64)
+	signed, err := json.Marshal(SignedMessage{
+		Timestamp: timestamp,
+		Nonce:     headers[SignatureNonceHeader],
+		Signature: headers[SignatureHeader],
+		Message:   payload,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode signed message: %w", err)
+	}
+	return signed, nil
+}
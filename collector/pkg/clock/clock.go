@@ -0,0 +1,95 @@
+// Package clock estimates the offset of the local clock from the server
+// clock, so that readings are timestamped correctly on devices that boot
+// without a real-time clock.
+//
+// The server time is tracked with the monotonic clock from the last
+// synchronization, so corrections are not affected by the wall clock being
+// set in between.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// maxSyncRTT is the longest round trip of a response whose server time is
+// used once the clock is synchronized. The server time of a slow response is
+// off by up to half of its round trip.
+const maxSyncRTT = 2 * time.Second
+
+// Clock tracks the server time
+type Clock struct {
+	mu       sync.RWMutex
+	serverAt time.Time // server time at localAt
+	localAt  time.Time // local time of the last synchronization, zero before it
+	started  time.Time
+}
+
+// New returns a clock that is not synchronized yet
+func New() *Clock {
+	return &Clock{started: time.Now()}
+}
+
+// Observe synchronizes the clock with the server time of a response to a
+// request sent at sent and received at received, both taken by time.Now in
+// this process. The server time is assumed to be taken halfway through the
+// round trip.
+func (c *Clock) Observe(server, sent, received time.Time) {
+	rtt := received.Sub(sent)
+	if rtt < 0 || server.IsZero() {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.localAt.IsZero() && rtt > maxSyncRTT {
+		return
+	}
+	c.serverAt = server
+	c.localAt = sent.Add(rtt / 2)
+}
+
+// Synced reports whether the clock has been synchronized with the server
+func (c *Clock) Synced() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return !c.localAt.IsZero()
+}
+
+// Correct returns the server time at the local time t, which is taken by
+// time.Now in this process. It returns t and false before the clock is
+// synchronized.
+func (c *Clock) Correct(t time.Time) (time.Time, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.localAt.IsZero() {
+		return t, false
+	}
+	return c.serverAt.Add(t.Sub(c.localAt)), true
+}
+
+// Now returns the current server time, or the local time before the clock
+// is synchronized
+func (c *Clock) Now() time.Time {
+	now, _ := c.Correct(time.Now())
+	return now
+}
+
+// Offset returns how far the local clock is ahead of the server clock, and
+// false before the clock is synchronized
+func (c *Clock) Offset() (time.Duration, bool) {
+	now := time.Now()
+	corrected, ok := c.Correct(now)
+	if !ok {
+		return 0, false
+	}
+	return now.Round(0).Sub(corrected), true
+}
+
+// Started returns the time the clock was created, taken by time.Now
+func (c *Clock) Started() time.Time {
+	return c.started
+}
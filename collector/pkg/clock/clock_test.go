@@ -0,0 +1,56 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCorrect(t *testing.T) {
+	c := New()
+
+	local := time.Now()
+	if corrected, ok := c.Correct(local); ok || !corrected.Equal(local) {
+		t.Fatalf("Expected an unsynchronized clock to return the local time, got %v, %v", corrected, ok)
+	}
+
+	// The local clock is an hour behind, the response took 200ms and the
+	// server took its time halfway through
+	sent := time.Now()
+	rtt := 200 * time.Millisecond
+	received := sent.Add(rtt)
+	server := sent.Round(0).Add(rtt/2 + time.Hour)
+	c.Observe(server, sent, received)
+
+	if !c.Synced() {
+		t.Fatal("Expected the clock to be synchronized")
+	}
+	corrected, _ := c.Correct(received)
+	if offset := received.Round(0).Sub(corrected); offset != -time.Hour {
+		t.Errorf("Expected an offset of -1h, got %v", offset)
+	}
+
+	// A reading taken before the synchronization is corrected too
+	corrected, ok := c.Correct(local)
+	if !ok {
+		t.Fatal("Expected the reading to be corrected")
+	}
+	want := local.Round(0).Add(time.Hour)
+	if diff := corrected.Sub(want); diff < -time.Millisecond || diff > time.Millisecond {
+		t.Errorf("Expected %v, got %v", want, corrected)
+	}
+}
+
+func TestObserveIgnoresSlowResponses(t *testing.T) {
+	c := New()
+
+	sent := time.Now()
+	c.Observe(sent.Round(0).Add(time.Minute), sent, sent.Add(10*time.Millisecond))
+
+	// A slow response does not replace the first synchronization
+	c.Observe(sent.Round(0).Add(2*time.Minute), sent, sent.Add(maxSyncRTT+time.Second))
+
+	offset, _ := c.Offset()
+	if diff := offset + time.Minute; diff < -10*time.Millisecond || diff > 10*time.Millisecond {
+		t.Errorf("Expected an offset of -1m, got %v", offset)
+	}
+}
@@ -0,0 +1,91 @@
+package collector
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"power-collector/pkg/config"
+	"power-collector/pkg/meter"
+)
+
+// adaptiveSampler picks the samples of a channel worth emitting. A sample is
+// emitted when its power moved away from the power last emitted by more than
+// either threshold, when the meter alarm flag changed, or when the maximum
+// interval has passed since the last emission. On a change, the last sample
+// held back before it is emitted as well, so that the power between two
+// emitted readings stays within the thresholds and integrating them
+// reconstructs the energy, and a step is placed within one sample interval.
+type adaptiveSampler struct {
+	mu       sync.Mutex
+	settings config.AdaptiveConfig
+
+	emitted *meter.PowerData // last sample emitted, nil before the first one
+	held    *meter.PowerData // last sample held back since, nil if none
+}
+
+// newAdaptiveSampler returns an adaptive sampler with the given settings
+func newAdaptiveSampler(settings config.AdaptiveConfig) *adaptiveSampler {
+	return &adaptiveSampler{settings: settings}
+}
+
+// add adds a sample and returns the samples to emit, oldest first, nil if it
+// is held back
+func (a *adaptiveSampler) add(data *meter.PowerData) []*meter.PowerData {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch {
+	case a.emitted == nil:
+		// The first sample is always emitted
+	case a.changed(data):
+		if a.held != nil {
+			return a.emit(a.held, data)
+		}
+	case data.Timestamp.Sub(a.emitted.Timestamp) >= a.settings.MaxInterval*time.Second:
+	default:
+		a.held = data
+		return nil
+	}
+	return a.emit(data)
+}
+
+// changed reports whether data moved away from the sample last emitted by
+// more than either threshold. With both thresholds disabled, every sample
+// counts as changed.
+func (a *adaptiveSampler) changed(data *meter.PowerData) bool {
+	if data.Alarm != a.emitted.Alarm {
+		return true
+	}
+
+	threshold := math.Inf(1)
+	if a.settings.PowerDelta > 0 {
+		threshold = a.settings.PowerDelta
+	}
+	if a.settings.PowerPercent > 0 {
+		threshold = math.Min(threshold, a.settings.PowerPercent/100*math.Abs(a.emitted.Power))
+	}
+	if math.IsInf(threshold, 1) {
+		return true
+	}
+	return math.Abs(data.Power-a.emitted.Power) > threshold
+}
+
+// emit returns the samples, remembering the last one as emitted
+func (a *adaptiveSampler) emit(samples ...*meter.PowerData) []*meter.PowerData {
+	a.emitted = samples[len(samples)-1]
+	a.held = nil
+	return samples
+}
+
+// flush returns the sample held back, nil if there is none, so that the
+// readings emitted end with the last sample taken
+func (a *adaptiveSampler) flush() *meter.PowerData {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.held == nil {
+		return nil
+	}
+	return a.emit(a.held)[0]
+}
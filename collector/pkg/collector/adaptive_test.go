@@ -0,0 +1,197 @@
+package collector
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"power-collector/pkg/config"
+	"power-collector/pkg/meter"
+)
+
+func TestAdaptiveSampler(t *testing.T) {
+	type step struct {
+		at    int // seconds
+		power float64
+		alarm bool
+		emit  []float64 // powers of the samples emitted, oldest first
+	}
+
+	tests := []struct {
+		name     string
+		settings config.AdaptiveConfig
+		steps    []step
+	}{
+		{
+			name:     "power delta, held sample emitted before the step",
+			settings: config.AdaptiveConfig{PowerDelta: 10, MaxInterval: 60},
+			steps: []step{
+				{at: 0, power: 100, emit: []float64{100}},
+				{at: 1, power: 105},
+				{at: 2, power: 108},
+				{at: 3, power: 115, emit: []float64{108, 115}},
+				{at: 4, power: 120},
+			},
+		},
+		{
+			name:     "power percent",
+			settings: config.AdaptiveConfig{PowerPercent: 5, MaxInterval: 60},
+			steps: []step{
+				{at: 0, power: 1000, emit: []float64{1000}},
+				{at: 1, power: 1040},
+				{at: 2, power: 1060, emit: []float64{1040, 1060}},
+			},
+		},
+		{
+			name:     "either threshold, the delta at high power",
+			settings: config.AdaptiveConfig{PowerDelta: 10, PowerPercent: 5, MaxInterval: 60},
+			steps: []step{
+				{at: 0, power: 1000, emit: []float64{1000}},
+				{at: 1, power: 1015, emit: []float64{1015}},
+			},
+		},
+		{
+			name:     "either threshold, the percent at low power",
+			settings: config.AdaptiveConfig{PowerDelta: 10, PowerPercent: 5, MaxInterval: 60},
+			steps: []step{
+				{at: 0, power: 100, emit: []float64{100}},
+				{at: 1, power: 104},
+				{at: 2, power: 107, emit: []float64{104, 107}},
+			},
+		},
+		{
+			name:     "both thresholds disabled",
+			settings: config.AdaptiveConfig{MaxInterval: 60},
+			steps: []step{
+				{at: 0, power: 100, emit: []float64{100}},
+				{at: 1, power: 100, emit: []float64{100}},
+				{at: 2, power: 100.5, emit: []float64{100.5}},
+			},
+		},
+		{
+			name:     "maximum interval",
+			settings: config.AdaptiveConfig{PowerDelta: 10, MaxInterval: 3},
+			steps: []step{
+				{at: 0, power: 100, emit: []float64{100}},
+				{at: 1, power: 101},
+				{at: 2, power: 102},
+				{at: 3, power: 103, emit: []float64{103}},
+				{at: 4, power: 103},
+				{at: 6, power: 104, emit: []float64{104}},
+			},
+		},
+		{
+			name:     "alarm flag change",
+			settings: config.AdaptiveConfig{PowerDelta: 10, MaxInterval: 60},
+			steps: []step{
+				{at: 0, power: 100, emit: []float64{100}},
+				{at: 1, power: 101, alarm: true, emit: []float64{101}},
+				{at: 2, power: 102, alarm: true},
+				{at: 3, power: 102, emit: []float64{102, 102}},
+			},
+		},
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAdaptiveSampler(tt.settings)
+			for _, s := range tt.steps {
+				data := &meter.PowerData{Timestamp: start.Add(time.Duration(s.at) * time.Second), Power: s.power, Alarm: s.alarm}
+				var emitted []float64
+				for _, sample := range a.add(data) {
+					emitted = append(emitted, sample.Power)
+				}
+				if !slices.Equal(emitted, s.emit) {
+					t.Errorf("Sample of %v W at %ds: emitted %v, want %v", s.power, s.at, emitted, s.emit)
+				}
+			}
+		})
+	}
+}
+
+func TestAdaptiveSamplerEnergy(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	settings := config.AdaptiveConfig{PowerDelta: 10, MaxInterval: 60}
+	a := newAdaptiveSampler(settings)
+
+	// A load switching on for a minute between two idle periods, with
+	// noise within the threshold, sampled every second
+	var samples, emitted []*meter.PowerData
+	for i := 0; i < 300; i++ {
+		power := 100.0
+		if i >= 120 && i < 180 {
+			power = 2000
+		}
+		power += float64(i%3) * 4
+		data := &meter.PowerData{Timestamp: start.Add(time.Duration(i) * time.Second), Power: power}
+		samples = append(samples, data)
+		emitted = append(emitted, a.add(data)...)
+	}
+	if held := a.flush(); held != nil {
+		emitted = append(emitted, held)
+	}
+
+	if len(emitted) >= len(samples)/4 {
+		t.Errorf("Expected far fewer readings than samples, got %d of %d", len(emitted), len(samples))
+	}
+	if !slices.Equal(emitted[len(emitted)-1:], samples[len(samples)-1:]) {
+		t.Error("Expected the readings to end with the last sample after a flush")
+	}
+
+	// integrate returns the energy in Wh of the trapezoids between readings
+	integrate := func(readings []*meter.PowerData) float64 {
+		var energy float64
+		for i := 1; i < len(readings); i++ {
+			gap := readings[i].Timestamp.Sub(readings[i-1].Timestamp)
+			energy += (readings[i-1].Power + readings[i].Power) / 2 * gap.Hours()
+		}
+		return energy
+	}
+
+	// The power between two readings stays within the threshold of the
+	// first, bounding the error of the reconstructed energy
+	want := integrate(samples)
+	bound := settings.PowerDelta * samples[len(samples)-1].Timestamp.Sub(start).Hours()
+	if got := integrate(emitted); math.Abs(got-want) > bound {
+		t.Errorf("Reconstructed %.3f Wh, want %.3f Wh within %.3f Wh", got, want, bound)
+	}
+
+	// Without the samples held back before the steps, the switching times
+	// would be lost by up to the maximum interval
+	for _, step := range []int{119, 120, 179, 180} {
+		if !slices.Contains(emitted, samples[step]) {
+			t.Errorf("Expected the sample at %ds around a step to be emitted", step)
+		}
+	}
+}
+
+func TestAdaptiveSamplerFlush(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := newAdaptiveSampler(config.AdaptiveConfig{PowerDelta: 10, MaxInterval: 60})
+
+	if held := a.flush(); held != nil {
+		t.Errorf("Expected nothing to flush before the first sample, got %+v", held)
+	}
+
+	a.add(&meter.PowerData{Timestamp: start, Power: 100})
+	if held := a.flush(); held != nil {
+		t.Errorf("Expected nothing to flush after an emitted sample, got %+v", held)
+	}
+
+	a.add(&meter.PowerData{Timestamp: start.Add(time.Second), Power: 101})
+	a.add(&meter.PowerData{Timestamp: start.Add(2 * time.Second), Power: 102})
+	held := a.flush()
+	if held == nil || held.Power != 102 {
+		t.Fatalf("Expected the last sample held back to be flushed, got %+v", held)
+	}
+	if held := a.flush(); held != nil {
+		t.Errorf("Expected nothing to flush twice, got %+v", held)
+	}
+
+	// The flushed sample counts as emitted
+	if emitted := a.add(&meter.PowerData{Timestamp: start.Add(3 * time.Second), Power: 111}); len(emitted) != 0 {
+		t.Errorf("Expected a change from the flushed sample within the threshold to be held back, got %d readings", len(emitted))
+	}
+}
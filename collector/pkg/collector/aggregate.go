@@ -0,0 +1,137 @@
+package collector
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"power-collector/pkg/meter"
+)
+
+// maxIntegrationGap is the longest gap between two samples over which their
+// average power is trusted to count the energy the meter counter missed
+const maxIntegrationGap = 15 * time.Minute
+
+// aggregator summarizes the samples of a channel per window. Windows are
+// aligned to multiples of their length, so that the summaries of all
+// channels cover the same periods.
+type aggregator struct {
+	mu     sync.Mutex
+	window time.Duration
+
+	start time.Time        // start of the current window, zero before the first sample
+	sum   meter.PowerData  // sums of the measurements of the current window
+	agg   meter.Aggregate  // extremes of the current window
+	last  *meter.PowerData // last sample, the energy of the next one is counted from it
+}
+
+// newAggregator returns an aggregator summarizing windows of the given length
+func newAggregator(window time.Duration) *aggregator {
+	return &aggregator{window: window}
+}
+
+// add adds a sample and returns the summary of the previous window if the
+// sample starts a new one
+func (a *aggregator) add(data *meter.PowerData) *meter.PowerData {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var summary *meter.PowerData
+	start := data.Timestamp.Truncate(a.window)
+	if !a.start.IsZero() && !start.Equal(a.start) {
+		summary = a.summarize()
+	}
+
+	if a.start.IsZero() {
+		a.start = start
+		a.sum = meter.PowerData{}
+		a.agg = meter.Aggregate{
+			Window:         a.window,
+			VoltageMin:     math.Inf(1),
+			VoltageMax:     math.Inf(-1),
+			CurrentMin:     math.Inf(1),
+			CurrentMax:     math.Inf(-1),
+			PowerMin:       math.Inf(1),
+			PowerMax:       math.Inf(-1),
+			PowerFactorMin: math.Inf(1),
+			PowerFactorMax: math.Inf(-1),
+		}
+	}
+
+	a.sum.Voltage += data.Voltage
+	a.sum.Current += data.Current
+	a.sum.Power += data.Power
+	a.sum.Frequency += data.Frequency
+	a.sum.PowerFactor += data.PowerFactor
+	a.sum.Alarm = a.sum.Alarm || data.Alarm
+
+	a.agg.Samples++
+	a.agg.VoltageMin = math.Min(a.agg.VoltageMin, data.Voltage)
+	a.agg.VoltageMax = math.Max(a.agg.VoltageMax, data.Voltage)
+	a.agg.CurrentMin = math.Min(a.agg.CurrentMin, data.Current)
+	a.agg.CurrentMax = math.Max(a.agg.CurrentMax, data.Current)
+	a.agg.PowerMin = math.Min(a.agg.PowerMin, data.Power)
+	a.agg.PowerMax = math.Max(a.agg.PowerMax, data.Power)
+	a.agg.PowerFactorMin = math.Min(a.agg.PowerFactorMin, data.PowerFactor)
+	a.agg.PowerFactorMax = math.Max(a.agg.PowerFactorMax, data.PowerFactor)
+	a.agg.EnergyDelta += energyDelta(a.last, data)
+	a.last = data
+
+	return summary
+}
+
+// flush returns the summary of the current window, nil if it has no samples
+func (a *aggregator) flush() *meter.PowerData {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.start.IsZero() {
+		return nil
+	}
+	return a.summarize()
+}
+
+// summarize returns the summary of the current window and starts a new one.
+// The summary is timestamped with its last sample and carries its energy
+// counter.
+func (a *aggregator) summarize() *meter.PowerData {
+	samples := float64(a.agg.Samples)
+	agg := a.agg
+	summary := &meter.PowerData{
+		Timestamp:      a.last.Timestamp,
+		Voltage:        a.sum.Voltage / samples,
+		Current:        a.sum.Current / samples,
+		Power:          a.sum.Power / samples,
+		Energy:         a.last.Energy,
+		Frequency:      a.sum.Frequency / samples,
+		PowerFactor:    a.sum.PowerFactor / samples,
+		Alarm:          a.sum.Alarm,
+		DC:             a.last.DC,
+		Aggregate:      &agg,
+		TimeUnreliable: a.last.TimeUnreliable,
+	}
+
+	a.start = time.Time{}
+	return summary
+}
+
+// energyDelta returns the energy in Wh consumed between two samples: the
+// counter difference while the counter grows, otherwise the average power
+// integrated over the gap
+func energyDelta(prev, cur *meter.PowerData) float64 {
+	if prev == nil {
+		return 0
+	}
+
+	gap := cur.Timestamp.Sub(prev.Timestamp)
+	if gap <= 0 {
+		return 0
+	}
+	if prev.Energy != 0 && cur.Energy >= prev.Energy {
+		return cur.Energy - prev.Energy
+	}
+	if gap > maxIntegrationGap {
+		return 0
+	}
+	return (prev.Power + cur.Power) / 2 * gap.Hours()
+}
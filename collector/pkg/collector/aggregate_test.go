@@ -0,0 +1,93 @@
+package collector
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"power-collector/pkg/meter"
+)
+
+func TestAggregatorWindows(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := newAggregator(time.Minute)
+
+	samples := []struct {
+		after   time.Duration
+		voltage float64
+		power   float64
+		energy  float64
+	}{
+		{10 * time.Second, 229, 100, 1000},
+		{40 * time.Second, 231, 300, 1002},
+		{59 * time.Second, 230, 200, 1003},
+	}
+	for _, s := range samples {
+		data := &meter.PowerData{Timestamp: start.Add(s.after), Voltage: s.voltage, Power: s.power, Energy: s.energy, PowerFactor: 1}
+		if summary := a.add(data); summary != nil {
+			t.Fatalf("Expected no summary within the window, got %+v", summary)
+		}
+	}
+
+	// The next window starts at the minute, not a minute after the first
+	// sample
+	summary := a.add(&meter.PowerData{Timestamp: start.Add(65 * time.Second), Voltage: 230, Power: 50, Energy: 1004})
+	if summary == nil {
+		t.Fatal("Expected the summary of the first window")
+	}
+	agg := summary.Aggregate
+	if agg == nil || agg.Samples != 3 || agg.Window != time.Minute {
+		t.Fatalf("Expected a summary of 3 samples, got %+v", agg)
+	}
+	if !summary.Timestamp.Equal(start.Add(59 * time.Second)) {
+		t.Errorf("Expected the summary at its last sample, got %v", summary.Timestamp)
+	}
+	if summary.Voltage != 230 || summary.Power != 200 || summary.PowerFactor != 1 {
+		t.Errorf("Expected averages of 230 V and 200 W, got %v V and %v W", summary.Voltage, summary.Power)
+	}
+	if agg.VoltageMin != 229 || agg.VoltageMax != 231 || agg.PowerMin != 100 || agg.PowerMax != 300 {
+		t.Errorf("Unexpected extremes: %+v", agg)
+	}
+	if summary.Energy != 1003 || agg.EnergyDelta != 3 {
+		t.Errorf("Expected the counter 1003 and 3 Wh used, got %v and %v", summary.Energy, agg.EnergyDelta)
+	}
+
+	// The second window counts its energy from the last sample of the first
+	summary = a.flush()
+	if summary == nil || summary.Aggregate.Samples != 1 || summary.Aggregate.EnergyDelta != 1 {
+		t.Fatalf("Expected the flushed summary of 1 sample using 1 Wh, got %+v", summary)
+	}
+	if summary := a.flush(); summary != nil {
+		t.Errorf("Expected nothing to flush, got %+v", summary)
+	}
+}
+
+func TestEnergyDelta(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sample := func(after time.Duration, energy, power float64) *meter.PowerData {
+		return &meter.PowerData{Timestamp: start.Add(after), Energy: energy, Power: power}
+	}
+
+	tests := []struct {
+		name string
+		prev *meter.PowerData
+		cur  *meter.PowerData
+		want float64
+	}{
+		{"first sample", nil, sample(0, 1000, 100), 0},
+		{"same timestamp", sample(0, 1000, 100), sample(0, 1010, 100), 0},
+		{"counter", sample(0, 1000, 100), sample(time.Minute, 1004, 100), 4},
+		{"counter over a long gap", sample(0, 1000, 100), sample(time.Hour, 1100, 100), 100},
+		{"no counter, integrated", sample(0, 0, 100), sample(6*time.Minute, 0, 200), 15},
+		{"counter reset, integrated", sample(0, 1000, 60), sample(time.Minute, 2, 60), 1},
+		{"no counter over a gap beyond the limit", sample(0, 0, 100), sample(maxIntegrationGap+time.Second, 0, 100), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := energyDelta(tt.prev, tt.cur); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("energyDelta() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"sync"
+	"time"
+
+	"power-collector/pkg/client"
+	"power-collector/pkg/config"
+	"power-collector/pkg/database"
+	"power-collector/pkg/meter"
+)
+
+// alarmActionTimeout bounds the run time of the command and the webhook of
+// an alarm rule
+const alarmActionTimeout = 30 * time.Second
+
+// maxAlarmEventBatch is the number of alarm events uploaded per request
+const maxAlarmEventBatch = 100
+
+// alarmRule is an alarm rule with its state on a channel
+type alarmRule struct {
+	config.AlarmRuleConfig
+	active    bool
+	since     time.Time // when the metric left the range, zero while inside
+	threshold float64   // limit crossed by the active alarm
+}
+
+// alarmEvaluator evaluates the alarm rules of a channel on its samples
+type alarmEvaluator struct {
+	mu    sync.Mutex
+	rules []*alarmRule
+}
+
+// newAlarmEvaluator returns an evaluator of the rules that watch a channel,
+// nil if there are none
+func newAlarmEvaluator(rules []config.AlarmRuleConfig, channelKey string) *alarmEvaluator {
+	var evaluator alarmEvaluator
+	for _, rule := range rules {
+		if len(rule.Channels) == 0 || slices.Contains(rule.Channels, channelKey) {
+			evaluator.rules = append(evaluator.rules, &alarmRule{AlarmRuleConfig: rule})
+		}
+	}
+	if len(evaluator.rules) == 0 {
+		return nil
+	}
+	return &evaluator
+}
+
+// evaluate returns the alarm events a sample raises or clears
+func (e *alarmEvaluator) evaluate(collectorID string, data *meter.PowerData) []database.AlarmEvent {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var events []database.AlarmEvent
+	for _, rule := range e.rules {
+		value, ok := metricValue(rule.Metric, data)
+		if !ok {
+			continue
+		}
+
+		// The meter alarm is raised while the flag is set
+		low, high := rule.Min, rule.Max
+		if rule.Metric == config.MetricMeterAlarm {
+			high = new(float64)
+		}
+
+		state := ""
+		if !rule.active {
+			switch {
+			case high != nil && value > *high:
+				rule.threshold = *high
+			case low != nil && value < *low:
+				rule.threshold = *low
+			default:
+				rule.since = time.Time{}
+				continue
+			}
+			if rule.since.IsZero() {
+				rule.since = data.Timestamp
+			}
+			if data.Timestamp.Sub(rule.since) < rule.Delay*time.Second {
+				continue
+			}
+			rule.active = true
+			state = database.AlarmRaised
+		} else {
+			if (high != nil && value > *high-rule.Hysteresis) || (low != nil && value < *low+rule.Hysteresis) {
+				continue
+			}
+			rule.active = false
+			rule.since = time.Time{}
+			state = database.AlarmCleared
+		}
+
+		events = append(events, database.AlarmEvent{
+			CollectorID: collectorID,
+			Rule:        rule.Name,
+			Metric:      rule.Metric,
+			State:       state,
+			Value:       value,
+			Threshold:   rule.threshold,
+			Timestamp:   data.Timestamp,
+		})
+	}
+	return events
+}
+
+// rule returns the configuration of the rule with the given name
+func (e *alarmEvaluator) rule(name string) *config.AlarmRuleConfig {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, rule := range e.rules {
+		if rule.Name == name {
+			return &rule.AlarmRuleConfig
+		}
+	}
+	return nil
+}
+
+// metricValue returns the value of a metric of a reading, false if the meter
+// does not measure it
+func metricValue(metric string, data *meter.PowerData) (float64, bool) {
+	switch metric {
+	case config.MetricVoltage:
+		return data.Voltage, true
+	case config.MetricCurrent:
+		return data.Current, true
+	case config.MetricPower:
+		return data.Power, true
+	case config.MetricFrequency:
+		return data.Frequency, !data.DC
+	case config.MetricPowerFactor:
+		return data.PowerFactor, !data.DC
+	case config.MetricMeterAlarm:
+		if data.Alarm {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// evaluateAlarms evaluates the alarm rules of a channel on a sample. The
+// events are queued for upload and their actions run in the background, so
+// that alarms are handled while the server is unreachable.
+func (c *CollectorService) evaluateAlarms(ch *channel, data *meter.PowerData) {
+	if ch.alarms == nil {
+		return
+	}
+
+	events := ch.alarms.evaluate(ch.config.ID, data)
+	for i := range events {
+		event := &events[i]
+		if event.State == database.AlarmRaised {
+			log.Printf("[%s] Alarm %s raised: %s %v beyond %v", ch.config.Key, event.Rule, event.Metric, event.Value, event.Threshold)
+		} else {
+			log.Printf("[%s] Alarm %s cleared: %s %v", ch.config.Key, event.Rule, event.Metric, event.Value)
+		}
+
+		if err := c.cacheDB.StoreAlarmEvent(event); err != nil {
+			c.handleError(fmt.Sprintf("alarm event (channel %s)", ch.config.Key), err)
+		}
+
+		rule := ch.alarms.rule(event.Rule)
+		if rule.Command != "" || rule.Webhook != "" {
+			go c.runAlarmActions(ch, *rule, *event)
+		}
+	}
+
+	c.mu.RLock()
+	online := c.isOnline
+	c.mu.RUnlock()
+	if len(events) > 0 && online {
+		if err := c.uploadAlarmEvents(ch); err != nil {
+			log.Printf("[%s] Warning: %v", ch.config.Key, err)
+		}
+	}
+}
+
+// runAlarmActions runs the command and calls the webhook of an alarm rule
+func (c *CollectorService) runAlarmActions(ch *channel, rule config.AlarmRuleConfig, event database.AlarmEvent) {
+	ctx, cancel := context.WithTimeout(c.ctx, alarmActionTimeout)
+	defer cancel()
+
+	if rule.Command != "" {
+		cmd := exec.CommandContext(ctx, "sh", "-c", rule.Command)
+		cmd.Env = append(os.Environ(),
+			"POWER_ALARM_RULE="+event.Rule,
+			"POWER_ALARM_STATE="+event.State,
+			"POWER_ALARM_METRIC="+event.Metric,
+			fmt.Sprintf("POWER_ALARM_VALUE=%v", event.Value),
+			fmt.Sprintf("POWER_ALARM_THRESHOLD=%v", event.Threshold),
+			"POWER_ALARM_TIME="+event.Timestamp.Format(time.RFC3339),
+			"POWER_ALARM_COLLECTOR_ID="+ch.config.ID,
+			"POWER_ALARM_CHANNEL="+ch.config.Key,
+		)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			log.Printf("[%s] Alarm %s command failed: %v: %s", ch.config.Key, event.Rule, err, bytes.TrimSpace(output))
+		}
+	}
+
+	if rule.Webhook != "" {
+		if err := postAlarmWebhook(ctx, rule.Webhook, ch, event); err != nil {
+			log.Printf("[%s] Alarm %s webhook failed: %v", ch.config.Key, event.Rule, err)
+		}
+	}
+}
+
+// alarmWebhookPayload is the body posted to the webhook of an alarm rule
+type alarmWebhookPayload struct {
+	client.AlarmEvent
+	CollectorID string `json:"collector_id"`
+	Channel     string `json:"channel"`
+}
+
+// postAlarmWebhook posts an alarm event as JSON to a webhook
+func postAlarmWebhook(ctx context.Context, url string, ch *channel, event database.AlarmEvent) error {
+	body, err := json.Marshal(alarmWebhookPayload{
+		AlarmEvent:  newAlarmEventReport(event),
+		CollectorID: ch.config.ID,
+		Channel:     ch.config.Key,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode alarm event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// uploadAlarmEvents uploads the queued alarm events of a channel
+func (c *CollectorService) uploadAlarmEvents(ch *channel) error {
+	ch.alarmUploads.Lock()
+	defer ch.alarmUploads.Unlock()
+
+	events, err := c.cacheDB.GetUnuploadedAlarmEvents(ch.config.ID, maxAlarmEventBatch)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		return nil
+	}
+
+	var reports []client.AlarmEvent
+	var ids []uint
+	for _, event := range events {
+		reports = append(reports, newAlarmEventReport(event))
+		ids = append(ids, event.ID)
+	}
+
+	if err := ch.apiClient.ReportAlarmEvents(reports); err != nil {
+		return fmt.Errorf("failed to upload alarm events: %w", err)
+	}
+
+	if err := c.cacheDB.MarkAlarmEventsUploaded(ids); err != nil {
+		return err
+	}
+
+	log.Printf("[%s] Uploaded %d alarm events.", ch.config.Key, len(events))
+	return nil
+}
+
+// newAlarmEventReport converts a cached alarm event to its upload format
+func newAlarmEventReport(event database.AlarmEvent) client.AlarmEvent {
+	return client.AlarmEvent{
+		Rule:      event.Rule,
+		Metric:    event.Metric,
+		State:     event.State,
+		Value:     event.Value,
+		Threshold: event.Threshold,
+		Timestamp: event.Timestamp,
+	}
+}
@@ -0,0 +1,226 @@
+package collector
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"power-collector/pkg/client"
+	"power-collector/pkg/meter"
+)
+
+// Commands the server can send over the command channel
+const (
+	commandReadNow      = "read_now"
+	commandFlushCache   = "flush_cache"
+	commandResetEnergy  = "reset_energy"
+	commandReloadConfig = "reload_config"
+	commandRestart      = "restart"
+	commandUploadLogs   = "upload_logs"
+)
+
+const (
+	// defaultLogLines is the number of log lines upload_logs returns unless
+	// the lines argument asks for another amount
+	defaultLogLines = 200
+	// maxLogBytes bounds the log output sent to the server
+	maxLogBytes = 512 * 1024
+	// maxFlushBatches bounds the batches a flush_cache uploads
+	maxFlushBatches = 1000
+)
+
+// RestartRequested is signalled when the server asks the collector to restart
+func (c *CollectorService) RestartRequested() <-chan struct{} {
+	return c.restartChan
+}
+
+// commandLoop keeps the command channel of a channel open and runs the
+// commands the server sends over it
+func (c *CollectorService) commandLoop(ch *channel) {
+	defer c.wg.Done()
+
+	retryInterval := c.config.Server.RetryInterval * time.Second
+	if retryInterval <= 0 {
+		retryInterval = time.Minute
+	}
+
+	log.Printf("[%s] Starting command loop", ch.config.Key)
+
+	for {
+		conn, err := ch.apiClient.DialCommands()
+		if err != nil {
+			log.Printf("[%s] Command channel unavailable: %v", ch.config.Key, err)
+		} else {
+			log.Printf("[%s] Command channel connected", ch.config.Key)
+			c.serveCommands(ch, conn)
+		}
+
+		select {
+		case <-c.stopChan:
+			log.Printf("[%s] Command loop stopped", ch.config.Key)
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// serveCommands runs commands received on conn until it closes or the
+// service stops
+func (c *CollectorService) serveCommands(ch *channel, conn *client.CommandConn) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-c.stopChan:
+		case <-done:
+		}
+		conn.Close()
+	}()
+
+	for {
+		msg, err := conn.Receive()
+		if err != nil {
+			select {
+			case <-c.stopChan:
+			default:
+				log.Printf("[%s] Command channel closed: %v", ch.config.Key, err)
+			}
+			return
+		}
+
+		if err := conn.Ack(msg.ID); err != nil {
+			log.Printf("[%s] Failed to acknowledge command %d: %v", ch.config.Key, msg.ID, err)
+			return
+		}
+
+		log.Printf("[%s] Running command %d: %s", ch.config.Key, msg.ID, msg.Command)
+		result, err := c.runCommand(ch, msg)
+		if err != nil {
+			log.Printf("[%s] Command %d failed: %v", ch.config.Key, msg.ID, err)
+		}
+
+		if err := conn.SendResult(msg.ID, result, err); err != nil {
+			log.Printf("[%s] Failed to report result of command %d: %v", ch.config.Key, msg.ID, err)
+			return
+		}
+
+		if msg.Command == commandRestart && err == nil {
+			select {
+			case c.restartChan <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// runCommand runs a single command and returns its result
+func (c *CollectorService) runCommand(ch *channel, msg *client.CommandMessage) (string, error) {
+	switch msg.Command {
+	case commandReadNow:
+		powerData, err := c.collectChannelData(ch)
+		if err != nil {
+			return "", err
+		}
+		return powerData.String(), nil
+
+	case commandFlushCache:
+		total := 0
+		for i := 0; i < maxFlushBatches; i++ {
+			count, err := c.uploadCachedData(ch)
+			total += count
+			if err != nil {
+				return fmt.Sprintf("uploaded %d cached records", total), err
+			}
+			if count == 0 {
+				break
+			}
+		}
+		return fmt.Sprintf("uploaded %d cached records", total), nil
+
+	case commandResetEnergy:
+		resetter, ok := ch.device.(meter.EnergyResetter)
+		if !ok {
+			return "", fmt.Errorf("driver %s does not support resetting the energy counter", ch.config.Driver)
+		}
+		if err := resetter.ResetEnergy(); err != nil {
+			return "", err
+		}
+		return "energy counter reset", nil
+
+	case commandReloadConfig:
+		if err := c.syncConfig(true); err != nil {
+			return "", err
+		}
+		c.mu.RLock()
+		defer c.mu.RUnlock()
+		return fmt.Sprintf("applied config version %d", c.configVersion), nil
+
+	case commandRestart:
+		return "restarting", nil
+
+	case commandUploadLogs:
+		lines := defaultLogLines
+		if arg, ok := msg.Args["lines"]; ok {
+			n, err := strconv.Atoi(arg)
+			if err != nil || n <= 0 {
+				return "", fmt.Errorf("invalid lines argument %q", arg)
+			}
+			lines = n
+		}
+		return tailLog(c.config.Logging.File, lines)
+
+	default:
+		return "", fmt.Errorf("unknown command %q", msg.Command)
+	}
+}
+
+// tailLog returns the last lines of the log file, bounded to maxLogBytes
+func tailLog(path string, lines int) (string, error) {
+	if path == "" {
+		return "", errors.New("no log file configured")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open log file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to stat log file: %w", err)
+	}
+	partial := false
+	if info.Size() > maxLogBytes {
+		if _, err := file.Seek(-maxLogBytes, io.SeekEnd); err != nil {
+			return "", fmt.Errorf("failed to seek log file: %w", err)
+		}
+		partial = true
+	}
+
+	var tail []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxLogBytes)
+	for scanner.Scan() {
+		// The first line after seeking is cut off
+		if partial {
+			partial = false
+			continue
+		}
+		tail = append(tail, scanner.Text())
+		if len(tail) > lines {
+			tail = tail[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read log file: %w", err)
+	}
+
+	return strings.Join(tail, "\n"), nil
+}
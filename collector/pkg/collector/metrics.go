@@ -0,0 +1,266 @@
+package collector
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"power-collector/pkg/database"
+	"power-collector/pkg/meter"
+	"power-collector/pkg/modbus"
+)
+
+// Upload modes counted by the metrics
+const (
+	uploadRealtime = "realtime"
+	uploadBatch    = "batch"
+)
+
+// readLatencyBuckets are the upper bounds in seconds of the meter read
+// latency histogram
+var readLatencyBuckets = [...]float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// channelMetrics counts the meter reads and uploads of a channel
+type channelMetrics struct {
+	mu sync.Mutex
+
+	readBuckets  [len(readLatencyBuckets)]uint64 // per bucket of readLatencyBuckets, not cumulative
+	readCount    uint64
+	readSum      float64
+	readFailures uint64
+
+	uploads         map[string]uint64 // by mode and result
+	uploadedRecords uint64
+
+	rejected map[string]uint64 // by quality
+}
+
+// observeRead records the duration of a meter read including retries
+func (m *channelMetrics) observeRead(duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seconds := duration.Seconds()
+	for i, bound := range readLatencyBuckets {
+		if seconds <= bound {
+			m.readBuckets[i]++
+			break
+		}
+	}
+	m.readCount++
+	m.readSum += seconds
+	if err != nil {
+		m.readFailures++
+	}
+}
+
+// observeUpload records an upload and, if it succeeded, the number of
+// records the server stored
+func (m *channelMetrics) observeUpload(mode string, records int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.uploads == nil {
+		m.uploads = make(map[string]uint64)
+	}
+	if err != nil {
+		m.uploads[mode+"/failure"]++
+		return
+	}
+	m.uploads[mode+"/success"]++
+	m.uploadedRecords += uint64(records)
+}
+
+// observeRejected records a reading rejected as implausible
+func (m *channelMetrics) observeRejected(quality string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.rejected == nil {
+		m.rejected = make(map[string]uint64)
+	}
+	m.rejected[quality]++
+}
+
+// metricWriter writes metrics in the Prometheus text exposition format
+type metricWriter struct {
+	w   io.Writer
+	err error
+}
+
+// family writes the header of a metric
+func (m *metricWriter) family(name, kind, help string) {
+	m.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes a sample with labels given as name and value pairs
+func (m *metricWriter) sample(name string, value float64, labels ...string) {
+	if len(labels) == 0 {
+		m.printf("%s %v\n", name, value)
+		return
+	}
+
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1])))
+	}
+	m.printf("%s{%s} %v\n", name, strings.Join(pairs, ","), value)
+}
+
+func (m *metricWriter) printf(format string, args ...any) {
+	if m.err == nil {
+		_, m.err = fmt.Fprintf(m.w, format, args...)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// WriteMetrics writes the metrics of the service in the Prometheus text
+// exposition format
+func (c *CollectorService) WriteMetrics(w io.Writer) error {
+	status := c.GetStatus()
+	healthy := c.IsHealthy()
+	c.mu.RLock()
+	maxCacheSize := c.config.Data.MaxCacheSize
+	c.mu.RUnlock()
+
+	m := &metricWriter{w: w}
+
+	m.family("power_collector_up", "gauge", "Whether the collector service is running.")
+	m.sample("power_collector_up", boolValue(status.IsRunning))
+	m.family("power_collector_healthy", "gauge", "Whether the collector is collecting data without too many errors.")
+	m.sample("power_collector_healthy", boolValue(healthy))
+	m.family("power_collector_online", "gauge", "Whether the last upload reached the server.")
+	m.sample("power_collector_online", boolValue(status.IsOnline))
+	m.family("power_collector_recent_errors", "gauge", "Errors since the counter was last reset by maintenance.")
+	m.sample("power_collector_recent_errors", float64(status.ErrorCount))
+	m.family("power_collector_config_version", "gauge", "Version of the server-side configuration in effect.")
+	m.sample("power_collector_config_version", float64(status.ConfigVersion))
+	m.family("power_collector_clock_offset_seconds", "gauge", "How far the local clock is ahead of the server clock, absent before it is synchronized.")
+	if status.ClockOffset != nil {
+		m.sample("power_collector_clock_offset_seconds", *status.ClockOffset)
+	}
+
+	m.family("power_collector_last_reading_timestamp_seconds", "gauge", "Unix time of the last reading of a channel.")
+	for _, ch := range status.Channels {
+		var ts float64
+		if !ch.LastDataTime.IsZero() {
+			ts = float64(ch.LastDataTime.Unix())
+		}
+		m.sample("power_collector_last_reading_timestamp_seconds", ts, "channel", ch.Key, "collector_id", ch.CollectorID)
+	}
+
+	// Snapshot the counters of every channel
+	type snapshot struct {
+		key     string
+		buckets [len(readLatencyBuckets)]uint64
+		count   uint64
+		sum     float64
+		fails   uint64
+		uploads map[string]uint64
+		records uint64
+		reject  map[string]uint64
+	}
+	snapshots := make([]snapshot, 0, len(c.channels))
+	for _, ch := range c.channels {
+		ch.metrics.mu.Lock()
+		s := snapshot{
+			key:     ch.config.Key,
+			buckets: ch.metrics.readBuckets,
+			count:   ch.metrics.readCount,
+			sum:     ch.metrics.readSum,
+			fails:   ch.metrics.readFailures,
+			uploads: make(map[string]uint64, len(ch.metrics.uploads)),
+			records: ch.metrics.uploadedRecords,
+			reject:  make(map[string]uint64, len(ch.metrics.rejected)),
+		}
+		for k, v := range ch.metrics.uploads {
+			s.uploads[k] = v
+		}
+		for k, v := range ch.metrics.rejected {
+			s.reject[k] = v
+		}
+		ch.metrics.mu.Unlock()
+		snapshots = append(snapshots, s)
+	}
+
+	m.family("power_collector_read_duration_seconds", "histogram", "Duration of meter reads including retries.")
+	for _, s := range snapshots {
+		var cumulative uint64
+		for i, bound := range readLatencyBuckets {
+			cumulative += s.buckets[i]
+			m.sample("power_collector_read_duration_seconds_bucket", float64(cumulative), "channel", s.key, "le", fmt.Sprint(bound))
+		}
+		m.sample("power_collector_read_duration_seconds_bucket", float64(s.count), "channel", s.key, "le", "+Inf")
+		m.sample("power_collector_read_duration_seconds_sum", s.sum, "channel", s.key)
+		m.sample("power_collector_read_duration_seconds_count", float64(s.count), "channel", s.key)
+	}
+	m.family("power_collector_read_failures_total", "counter", "Meter reads that failed after all retries.")
+	for _, s := range snapshots {
+		m.sample("power_collector_read_failures_total", float64(s.fails), "channel", s.key)
+	}
+
+	m.family("power_collector_rejected_readings_total", "counter", "Readings rejected as implausible by quality.")
+	for _, s := range snapshots {
+		for _, quality := range []string{meter.QualityOutOfRange, meter.QualitySpike} {
+			m.sample("power_collector_rejected_readings_total", float64(s.reject[quality]), "channel", s.key, "quality", quality)
+		}
+	}
+
+	// Modbus counters of meters on the serial bus
+	modbusCounters := []struct {
+		name, help string
+		value      func(*modbus.Stats) uint64
+	}{
+		{"power_collector_modbus_requests_total", "Modbus requests sent to a meter.", func(s *modbus.Stats) uint64 { return s.Requests }},
+		{"power_collector_modbus_crc_errors_total", "Modbus responses with a CRC error.", func(s *modbus.Stats) uint64 { return s.CRCErrors }},
+		{"power_collector_modbus_timeouts_total", "Modbus requests without a response in time.", func(s *modbus.Stats) uint64 { return s.Timeouts }},
+		{"power_collector_modbus_exceptions_total", "Modbus exception responses.", func(s *modbus.Stats) uint64 { return s.Exceptions }},
+	}
+	for _, counter := range modbusCounters {
+		m.family(counter.name, "counter", counter.help)
+		for _, ch := range status.Channels {
+			if ch.Errors != nil {
+				m.sample(counter.name, float64(counter.value(ch.Errors)), "channel", ch.Key)
+			}
+		}
+	}
+
+	m.family("power_collector_uploads_total", "counter", "Upload requests by mode and result.")
+	for _, s := range snapshots {
+		for _, mode := range []string{uploadRealtime, uploadBatch} {
+			for _, result := range []string{"success", "failure"} {
+				m.sample("power_collector_uploads_total", float64(s.uploads[mode+"/"+result]),
+					"channel", s.key, "mode", mode, "result", result)
+			}
+		}
+	}
+	m.family("power_collector_uploaded_records_total", "counter", "Readings stored by the server.")
+	for _, s := range snapshots {
+		m.sample("power_collector_uploaded_records_total", float64(s.records), "channel", s.key)
+	}
+
+	// Offline cache
+	m.family("power_collector_cache_records", "gauge", "Records in the offline cache.")
+	m.sample("power_collector_cache_records", float64(status.CacheStats["unuploaded"]), "state", "unuploaded")
+	m.sample("power_collector_cache_records", float64(status.CacheStats["uploaded"]), "state", "uploaded")
+	m.family("power_collector_cache_capacity", "gauge", "Maximum number of records in the offline cache.")
+	m.sample("power_collector_cache_capacity", float64(maxCacheSize))
+	m.family("power_collector_cache_oldest_unuploaded_timestamp_seconds", "gauge", "Unix time of the oldest reading waiting for upload, 0 if none.")
+	m.sample("power_collector_cache_oldest_unuploaded_timestamp_seconds", float64(status.CacheStats["oldest_unuploaded"]))
+	m.family("power_collector_cache_lost_records_total", "counter", "Readings dropped or merged into per-minute averages to keep the cache within its size limit, by policy.")
+	for _, policy := range []string{database.LossDropped, database.LossDownsampled} {
+		m.sample("power_collector_cache_lost_records_total", float64(status.CacheStats[policy]), "policy", policy)
+	}
+
+	return m.err
+}
@@ -0,0 +1,85 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// startMonitor starts the local HTTP listener serving /healthz, /status and
+// /metrics if an address is configured
+func (c *CollectorService) startMonitor() error {
+	address := c.config.Monitor.Listen
+	if address == "" {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to start monitor listener: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", c.handleHealthz)
+	mux.HandleFunc("/status", c.handleStatus)
+	mux.HandleFunc("/metrics", c.handleMetrics)
+
+	c.monitor = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func(server *http.Server) {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Monitor listener failed: %v", err)
+		}
+	}(c.monitor)
+
+	log.Printf("Serving health, status and metrics on %s", listener.Addr())
+	return nil
+}
+
+// stopMonitor shuts the monitor listener down
+func (c *CollectorService) stopMonitor() {
+	if c.monitor == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.monitor.Shutdown(ctx); err != nil {
+		log.Printf("Error stopping monitor listener: %v", err)
+	}
+	c.monitor = nil
+}
+
+// handleHealthz answers 200 while the collector is healthy and 503 otherwise
+func (c *CollectorService) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !c.IsHealthy() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, "unhealthy")
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// handleStatus returns the service status as JSON
+func (c *CollectorService) handleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(c.GetStatus()); err != nil {
+		log.Printf("Failed to write status: %v", err)
+	}
+}
+
+// handleMetrics returns the metrics in the Prometheus text format
+func (c *CollectorService) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := c.WriteMetrics(w); err != nil {
+		log.Printf("Failed to write metrics: %v", err)
+	}
+}
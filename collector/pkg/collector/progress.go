@@ -0,0 +1,86 @@
+package collector
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// loopProgress tracks the iteration a loop is busy with, so that a loop stuck
+// in one is told apart from a loop waiting for its ticker
+type loopProgress struct {
+	mu          sync.Mutex
+	busySince   time.Time // zero while waiting
+	pausedSince time.Time // zero unless paused
+	pauses      int
+}
+
+// begin marks the start of an iteration
+func (p *loopProgress) begin() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.busySince = time.Now()
+}
+
+// end marks the end of an iteration
+func (p *loopProgress) end() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.busySince = time.Time{}
+}
+
+// pause stops counting the time of the current iteration, e.g. while the
+// service backs off after too many errors
+func (p *loopProgress) pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pauses == 0 {
+		p.pausedSince = time.Now()
+	}
+	p.pauses++
+}
+
+// resume counts the time of the current iteration again, leaving out the time
+// it was paused
+func (p *loopProgress) resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pauses--
+	if p.pauses > 0 {
+		return
+	}
+	if !p.busySince.IsZero() {
+		// An iteration begun while paused is counted from now on
+		from := p.pausedSince
+		if p.busySince.After(from) {
+			from = p.busySince
+		}
+		p.busySince = p.busySince.Add(time.Since(from))
+	}
+	p.pausedSince = time.Time{}
+}
+
+// busy returns how long the loop has been busy with the current iteration,
+// 0 while it is waiting or paused
+func (p *loopProgress) busy() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.busySince.IsZero() || p.pauses > 0 {
+		return 0
+	}
+	return time.Since(p.busySince)
+}
+
+// Stalled returns an error if the collection or upload loop has been busy
+// with one iteration for longer than the stall timeout, e.g. stuck reading a
+// meter or uploading to the server
+func (c *CollectorService) Stalled() error {
+	limit := c.config.Monitor.StallTimeout * time.Second
+	if busy := c.collecting.busy(); busy > limit {
+		return fmt.Errorf("data collection stalled for %v", busy.Truncate(time.Second))
+	}
+	if busy := c.uploading.busy(); busy > limit {
+		return fmt.Errorf("data upload stalled for %v", busy.Truncate(time.Second))
+	}
+	return nil
+}
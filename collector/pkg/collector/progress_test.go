@@ -0,0 +1,62 @@
+package collector
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoopProgressPause(t *testing.T) {
+	var p loopProgress
+	p.begin()
+	p.busySince = time.Now().Add(-20 * time.Minute)
+
+	// The time paused is left out of the iteration
+	p.pause()
+	p.pausedSince = p.pausedSince.Add(-10 * time.Minute)
+	if busy := p.busy(); busy != 0 {
+		t.Errorf("Expected no busy time while paused, got %v", busy)
+	}
+	p.resume()
+	if busy := p.busy(); busy < 10*time.Minute || busy > 11*time.Minute {
+		t.Errorf("Expected the busy time before the pause, got %v", busy)
+	}
+
+	// Nested pauses resume once the last one ends
+	p.pause()
+	p.pause()
+	p.resume()
+	if busy := p.busy(); busy != 0 {
+		t.Errorf("Expected no busy time while paused, got %v", busy)
+	}
+	p.resume()
+	if busy := p.busy(); busy == 0 {
+		t.Error("Expected busy time after the last pause ended")
+	}
+
+	// An iteration begun while paused is counted from the end of the pause
+	p.end()
+	p.pause()
+	p.pausedSince = p.pausedSince.Add(-10 * time.Minute)
+	p.begin()
+	p.resume()
+	if busy := p.busy(); busy > time.Second {
+		t.Errorf("Expected the iteration to be counted from the end of the pause, got %v", busy)
+	}
+}
+
+func TestBackOffStops(t *testing.T) {
+	c := &CollectorService{stopChan: make(chan struct{})}
+	c.collecting.begin()
+	close(c.stopChan)
+
+	done := make(chan struct{})
+	go func() {
+		c.backOff(time.Hour)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the back-off to end when the service is stopped")
+	}
+}
@@ -2,23 +2,43 @@ package collector
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	"power-collector/pkg/client"
+	"power-collector/pkg/clock"
 	"power-collector/pkg/config"
 	"power-collector/pkg/database"
-	"power-collector/pkg/pzem"
+	"power-collector/pkg/meter"
+	"power-collector/pkg/modbus"
+
+	// Built-in meter drivers
+	_ "power-collector/pkg/eastron"
+	_ "power-collector/pkg/pzem"
+	_ "power-collector/pkg/simulator"
 )
 
+// tokenRenewBefore is how long before its expiry a token is rotated
+const tokenRenewBefore = 7 * 24 * time.Hour
+
+// maxClockSkew is the offset from the server clock beyond which the local
+// clock is reported as wrong
+const maxClockSkew = 2 * time.Second
+
 // CollectorService represents the main collector service
 type CollectorService struct {
 	config     *config.Config
+	configFile string // saved when a token is rotated
 	version    string
-	apiClient  *client.APIClient
-	pzemDevice *pzem.PZEM004T
+	bus        *modbus.Client
+	channels   []*channel
 	cacheDB    *database.CacheDB
 	isRunning  bool
 	stopChan   chan struct{}
@@ -27,33 +47,131 @@ type CollectorService struct {
 	cancel     context.CancelFunc
 	mu         sync.RWMutex
 
+	// Tickers of the collection and upload loops, reset when the server
+	// changes the intervals
+	sampleTicker *time.Ticker
+	uploadTicker *time.Ticker
+	// configVersion is the version of the server-side config in effect
+	configVersion int
+	// restartChan is signalled when the server asks for a restart
+	restartChan chan struct{}
+	// monitor serves health, status and metrics, nil if disabled
+	monitor *http.Server
+	// clock tracks the server time readings are stamped with
+	clock *clock.Clock
+	// loggedOffset is the clock offset last logged, nil before the clock
+	// is synchronized
+	loggedOffset *time.Duration
+	// Progress of the collection and upload loops, checked before pinging
+	// the systemd watchdog
+	collecting loopProgress
+	uploading  loopProgress
+
 	// Status tracking
 	isRegistered bool
 	isOnline     bool
 	lastDataTime time.Time
 	errorCount   int
+	lastError    error
+	lastErrorAt  time.Time
 }
 
 // ServiceStatus represents the current status of the collector service
 type ServiceStatus struct {
-	IsRunning    bool             `json:"is_running"`
-	IsRegistered bool             `json:"is_registered"`
-	IsOnline     bool             `json:"is_online"`
-	LastDataTime time.Time        `json:"last_data_time"`
-	ErrorCount   int              `json:"error_count"`
-	CacheStats   map[string]int64 `json:"cache_stats,omitempty"`
+	IsRunning    bool      `json:"is_running"`
+	IsRegistered bool      `json:"is_registered"`
+	IsOnline     bool      `json:"is_online"`
+	LastDataTime time.Time `json:"last_data_time"`
+	ErrorCount   int       `json:"error_count"`
+	// ConfigVersion is the version of the server-side config in effect, 0
+	// until one has been applied
+	ConfigVersion int              `json:"config_version"`
+	CacheStats    map[string]int64 `json:"cache_stats,omitempty"`
+	Channels      []ChannelStatus  `json:"channels,omitempty"`
+	// ClockOffset is how far the local clock is ahead of the server clock
+	// in seconds, nil before it is synchronized
+	ClockOffset *float64 `json:"clock_offset,omitempty"`
+	// LastError is the last error handled by the service
+	LastError   string       `json:"last_error,omitempty"`
+	LastErrorAt *time.Time   `json:"last_error_at,omitempty"`
+	Uptime      float64      `json:"uptime"` // seconds
+	System      SystemStatus `json:"system"`
+}
+
+// ChannelStatus represents the current status of a single meter channel
+type ChannelStatus struct {
+	Key          string             `json:"key"`
+	CollectorID  string             `json:"collector_id"`
+	Name         string             `json:"name"`
+	Driver       string             `json:"driver"`
+	Address      int                `json:"address"`
+	Capabilities meter.Capabilities `json:"capabilities"`
+	LastDataTime time.Time          `json:"last_data_time"`
+	// Errors holds the Modbus counters of meters on the serial bus
+	Errors *modbus.Stats `json:"errors,omitempty"`
+	// LastReadError is the last failure to read the meter
+	LastReadError   string     `json:"last_read_error,omitempty"`
+	LastReadErrorAt *time.Time `json:"last_read_error_at,omitempty"`
+}
+
+// uplink delivers the readings and heartbeats of a channel, over HTTP or
+// MQTT
+type uplink interface {
+	UploadData(data client.PowerDataRequest) error
+	UploadBatchData(data []client.PowerDataRequest) (*client.BatchUploadResult, error)
+	SendHeartbeat(status, version string, diagnostics interface{}) error
+}
+
+// channel is a single meter on the bus whose readings are uploaded under
+// their own collector identity
+type channel struct {
+	config    config.ChannelConfig
+	device    meter.Meter
+	apiClient *client.APIClient
+	uplink    uplink
+	// lastReadError is the last failure to read the meter, taken at
+	// lastReadErrorAt
+	lastReadError   error
+	lastReadErrorAt time.Time
+	// mqttClient publishes to the broker, nil unless the MQTT uplink is
+	// enabled
+	mqttClient   *client.MQTTClient
+	lastDataTime time.Time
+	metrics      channelMetrics
+	// aggregator summarizes the samples of the channel, nil unless
+	// aggregation is enabled
+	aggregator *aggregator
+	// adaptive picks the samples of the channel to emit, nil unless
+	// adaptive sampling is enabled
+	adaptive *adaptiveSampler
+	// alarms evaluates the alarm rules watching the channel, nil if there
+	// are none
+	alarms *alarmEvaluator
+	// alarmUploads serializes the uploads of alarm events
+	alarmUploads sync.Mutex
+	// reading serializes the readings of the meter, taken by the collection
+	// loop and the read_now command
+	reading sync.Mutex
+	// uploads serializes the uploads of cached readings by the upload loop
+	// and the flush_cache command, so that a batch is not sent twice
+	uploads sync.Mutex
+	// spikes rejects readings jumping away from the recent ones
+	spikes spikeFilter
 }
 
 // NewCollectorService creates a new collector service instance
-func NewCollectorService(cfg *config.Config, version string) (*CollectorService, error) {
+func NewCollectorService(cfg *config.Config, configFile, version string) (*CollectorService, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	service := &CollectorService{
-		config:   cfg,
-		version:  version,
-		stopChan: make(chan struct{}),
-		ctx:      ctx,
-		cancel:   cancel,
+		config:      cfg,
+		configFile:  configFile,
+		version:     version,
+		stopChan:    make(chan struct{}),
+		restartChan: make(chan struct{}, 1),
+		clock:       clock.New(),
+		ctx:         ctx,
+		cancel:      cancel,
 	}
 
 	// Initialize components
@@ -65,40 +183,50 @@ func NewCollectorService(cfg *config.Config, version string) (*CollectorService,
 	return service, nil
 }
 
-// Test performs a single data collection and prints the result to the console.
-// This is intended for testing the connection to the PZEM device.
+// Test performs a single data collection on every channel and prints the
+// result to the console. This is intended for testing the connection to the
+// meters.
 func (c *CollectorService) Test() error {
 	log.Println("Performing a single data collection test...")
 
-	// The pzemDevice is initialized in NewCollectorService.
+	// The bus is opened in NewCollectorService.
 	// We need to ensure it's closed after the test.
 	defer func() {
-		if err := c.pzemDevice.Close(); err != nil {
-			log.Printf("Error closing PZEM device during test: %v", err)
+		if c.bus == nil {
+			return
+		}
+		if err := c.bus.Close(); err != nil {
+			log.Printf("Error closing serial bus during test: %v", err)
 		}
 	}()
 
-	// Read data from PZEM-004T
-	powerData, err := c.pzemDevice.ReadDataWithRetry(3)
-	if err != nil {
-		return fmt.Errorf("failed to read data from PZEM-004T during test: %w", err)
-	}
+	for _, ch := range c.channels {
+		model := ch.device.Capabilities().Model
 
-	// Validate data
-	if !powerData.IsDataValid() {
-		return fmt.Errorf("invalid data received from PZEM-004T during test: %v", powerData)
-	}
+		// Read data from the meter
+		powerData, err := meter.ReadDataWithRetry(ch.device, 3)
+		if err != nil {
+			return fmt.Errorf("failed to read data from %s at address %d during test: %w", model, ch.config.Address, err)
+		}
 
-	// Print the data
-	fmt.Println("--- Test Collection Result ---")
-	fmt.Printf("  Timestamp:   %s\n", powerData.Timestamp.Format(time.RFC3339))
-	fmt.Printf("  Voltage:     %.2f V\n", powerData.Voltage)
-	fmt.Printf("  Current:     %.3f A\n", powerData.Current)
-	fmt.Printf("  Power:       %.2f W\n", powerData.Power)
-	fmt.Printf("  Energy:      %.3f kWh\n", powerData.Energy)
-	fmt.Printf("  Frequency:   %.1f Hz\n", powerData.Frequency)
-	fmt.Printf("  Power Factor: %.2f\n", powerData.PowerFactor)
-	fmt.Println("------------------------------")
+		// Validate data
+		if err := powerData.Validate(limits(c.config.Validation)); err != nil {
+			return fmt.Errorf("invalid data received from %s at address %d during test: %w", model, ch.config.Address, err)
+		}
+
+		// Print the data
+		fmt.Printf("--- Test Collection Result: %s (%s, address %d) ---\n", ch.config.Name, model, ch.config.Address)
+		fmt.Printf("  Timestamp:   %s\n", powerData.Timestamp.Format(time.RFC3339))
+		fmt.Printf("  Voltage:     %.2f V\n", powerData.Voltage)
+		fmt.Printf("  Current:     %.3f A\n", powerData.Current)
+		fmt.Printf("  Power:       %.2f W\n", powerData.Power)
+		fmt.Printf("  Energy:      %.3f kWh\n", powerData.Energy/1000)
+		if !powerData.DC {
+			fmt.Printf("  Frequency:   %.1f Hz\n", powerData.Frequency)
+			fmt.Printf("  Power Factor: %.2f\n", powerData.PowerFactor)
+		}
+		fmt.Println("------------------------------")
+	}
 	log.Println("Test completed successfully.")
 
 	return nil
@@ -109,31 +237,163 @@ func (c *CollectorService) initialize() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	// Initialize API client
-	c.apiClient = client.NewAPIClient(
-		c.config.Server.BaseURL,
-		c.config.Server.APIPrefix,
-		c.config.Server.Timeout*time.Second,
-	)
+	// Open the serial bus shared by all meters, unless every channel uses a
+	// driver that runs without hardware
+	channels := c.config.MeterChannels()
+	if needsBus(channels) {
+		if c.config.Serial.Port == "" {
+			return fmt.Errorf("serial port is required")
+		}
 
-	// Initialize PZEM-004T device
-	pzemDevice, err := pzem.NewPZEM004T(c.config.Serial.Port, c.config.Serial.BaudRate, 0x01, c.config.Serial.Timeout*time.Second) // Assuming default address 0x01
-	if err != nil {
-		return fmt.Errorf("failed to initialize PZEM-004T device: %w", err)
+		bus, err := modbus.Open(modbus.SerialConfig{
+			Port:     c.config.Serial.Port,
+			BaudRate: c.config.Serial.BaudRate,
+			StopBits: c.config.Serial.StopBits,
+			Timeout:  c.config.Serial.Timeout * time.Second,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to open meter bus: %w", err)
+		}
+		c.bus = bus
+	}
+
+	simulator := c.config.Simulator
+	simulation := meter.SimulationOptions{
+		Seed:             simulator.Seed,
+		BaseLoad:         simulator.BaseLoad,
+		NominalVoltage:   simulator.NominalVoltage,
+		NominalFrequency: simulator.NominalFrequency,
+		Source:           simulator.Source,
+		Speed:            simulator.Speed,
+		Loop:             simulator.Loop,
+	}
+
+	// Initialize one meter and API client per channel
+	for _, channelConfig := range channels {
+		device, err := meter.Open(channelConfig.Driver, meter.Options{
+			Bus:         c.bus,
+			Address:     uint8(channelConfig.Address),
+			CollectorID: channelConfig.ID,
+			Simulation:  simulation,
+		})
+		if err != nil {
+			if c.bus != nil {
+				c.bus.Close()
+			}
+			return fmt.Errorf("failed to initialize meter of channel %s: %w", channelConfig.Key, err)
+		}
+
+		apiClient := client.NewAPIClient(
+			c.config.Server.BaseURL,
+			c.config.Server.APIPrefix,
+			c.config.Server.Timeout*time.Second,
+		)
+		if err := apiClient.SetCompression(c.compression(), c.config.Data.CompressionLevel); err != nil {
+			if c.bus != nil {
+				c.bus.Close()
+			}
+			return err
+		}
+		certFile, keyFile := channelConfig.ClientCert, channelConfig.ClientKey
+		if _, err := os.Stat(certFile); certFile != "" && os.IsNotExist(err) && channelConfig.Token != "" {
+			log.Printf("[%s] Client certificate %s not found, authenticating with token", channelConfig.Key, certFile)
+			certFile, keyFile = "", ""
+		}
+		if err := apiClient.SetTLS(certFile, keyFile, c.config.Server.CACert); err != nil {
+			if c.bus != nil {
+				c.bus.Close()
+			}
+			return fmt.Errorf("failed to configure TLS of channel %s: %w", channelConfig.Key, err)
+		}
+		apiClient.SetSigningSecret(channelConfig.SigningSecret)
+		apiClient.SetClock(c.clock)
+		ch := &channel{
+			config:    channelConfig,
+			device:    device,
+			apiClient: apiClient,
+			uplink:    apiClient,
+			alarms:    newAlarmEvaluator(c.config.AlarmRules, channelConfig.Key),
+		}
+		if c.config.MQTT.Enabled {
+			mqttClient, err := c.newMQTTClient(channelConfig.ID)
+			if err != nil {
+				if c.bus != nil {
+					c.bus.Close()
+				}
+				return fmt.Errorf("failed to configure MQTT of channel %s: %w", channelConfig.Key, err)
+			}
+			mqttClient.SetSigningSecret(channelConfig.SigningSecret)
+			mqttClient.SetClock(c.clock)
+			ch.mqttClient = mqttClient
+			ch.uplink = mqttClient
+		}
+		if c.config.Aggregation.Enabled {
+			ch.aggregator = newAggregator(c.config.Aggregation.Window * time.Second)
+		}
+		if c.config.Adaptive.Enabled {
+			ch.adaptive = newAdaptiveSampler(c.config.Adaptive)
+		}
+		c.channels = append(c.channels, ch)
+		log.Printf("Channel %s: %s (%s) at address %d", channelConfig.Key, channelConfig.Name, device.Capabilities().Model, channelConfig.Address)
 	}
-	c.pzemDevice = pzemDevice
 
 	// Initialize cache database
 	cacheDB, err := database.NewCacheDB(c.config.Data.CacheDB)
 	if err != nil {
 		return fmt.Errorf("failed to initialize cache database: %w", err)
 	}
+	if err := cacheDB.SetLimit(c.config.Data.MaxCacheSize, c.config.Data.CachePolicy); err != nil {
+		cacheDB.Close()
+		return fmt.Errorf("failed to initialize cache database: %w", err)
+	}
+	cacheDB.SetClock(c.clock)
 	c.cacheDB = cacheDB
 
 	log.Println("Collector service initialized successfully")
 	return nil
 }
 
+// compression returns the compression algorithm of batch uploads, empty
+// when compression is disabled
+func (c *CollectorService) compression() string {
+	if !c.config.Data.EnableCompression {
+		return ""
+	}
+	return c.config.Data.Compression
+}
+
+// newMQTTClient creates the MQTT client publishing the readings of a
+// collector ID
+func (c *CollectorService) newMQTTClient(collectorID string) (*client.MQTTClient, error) {
+	mqtt := c.config.MQTT
+	topic := func(template string) string {
+		return strings.ReplaceAll(template, config.CollectorIDPlaceholder, collectorID)
+	}
+	return client.NewMQTTClient(collectorID, c.version, client.MQTTOptions{
+		Broker:         mqtt.Broker,
+		ClientID:       topic(mqtt.ClientID),
+		Username:       mqtt.Username,
+		Password:       mqtt.Password,
+		CACert:         mqtt.CACert,
+		KeepAlive:      mqtt.KeepAlive * time.Second,
+		Timeout:        c.config.Server.Timeout * time.Second,
+		DataTopic:      topic(mqtt.DataTopic),
+		HeartbeatTopic: topic(mqtt.HeartbeatTopic),
+		StatusTopic:    topic(mqtt.StatusTopic),
+	})
+}
+
+// needsBus reports whether any channel uses a driver that talks to a meter on
+// the serial bus
+func needsBus(channels []config.ChannelConfig) bool {
+	for _, ch := range channels {
+		if driver, ok := meter.Lookup(ch.Driver); !ok || !driver.Virtual {
+			return true
+		}
+	}
+	return false
+}
+
 // Start starts the collector service
 func (c *CollectorService) Start() error {
 	c.mu.Lock()
@@ -148,22 +408,62 @@ func (c *CollectorService) Start() error {
 		return fmt.Errorf("registration failed: %w", err)
 	}
 
-	// Test server connection
-	log.Println("Testing connection to the server...")
-	if err := c.apiClient.TestConnection(); err != nil {
-		return fmt.Errorf("server connection test failed: %w", err)
+	if c.config.MQTT.Enabled {
+		// Readings are cached until the broker is reachable, which the
+		// clients keep trying in the background
+		log.Printf("Connecting to MQTT broker %s...", c.config.MQTT.Broker)
+		connected := true
+		for _, ch := range c.channels {
+			if err := ch.mqttClient.Connect(); err != nil {
+				log.Printf("[%s] Warning: %v, retrying in the background", ch.config.Key, err)
+				connected = false
+			}
+		}
+		if connected {
+			log.Println("MQTT broker connection successful.")
+		}
+
+		// Readings do not depend on the server, which is still used for
+		// the configuration, commands and alarm events
+		if err := c.channels[0].apiClient.TestConnection(); err != nil {
+			log.Printf("Warning: server connection test failed: %v", err)
+		}
+	} else {
+		// Test server connection
+		log.Println("Testing connection to the server...")
+		if err := c.channels[0].apiClient.TestConnection(); err != nil {
+			return fmt.Errorf("server connection test failed: %w", err)
+		}
+		log.Println("Server connection successful.")
+	}
+
+	if err := c.startMonitor(); err != nil {
+		return err
 	}
-	log.Println("Server connection successful.")
 
 	c.isRunning = true
 	log.Println("Starting collector service...")
 
+	c.sampleTicker = time.NewTicker(c.samplePeriod())
+	if c.config.Aggregation.Enabled {
+		log.Printf("Aggregating samples into windows of %v", c.config.Aggregation.Window*time.Second)
+	}
+	if a := c.config.Adaptive; a.Enabled {
+		log.Printf("Adaptive sampling: emitting readings on power changes over %.0f W and %.0f%%, at least every %v",
+			a.PowerDelta, a.PowerPercent, a.MaxInterval*time.Second)
+	}
+	c.uploadTicker = time.NewTicker(c.config.Data.UploadInterval * time.Second)
+
 	// Start background goroutines
-	c.wg.Add(4)
+	c.wg.Add(5 + len(c.channels))
 	go c.dataCollectionLoop()
 	go c.dataUploadLoop()
 	go c.heartbeatLoop()
 	go c.maintenanceLoop()
+	go c.configLoop()
+	for _, ch := range c.channels {
+		go c.commandLoop(ch)
+	}
 
 	log.Println("Collector service started successfully")
 	return nil
@@ -172,9 +472,8 @@ func (c *CollectorService) Start() error {
 // Stop stops the collector service gracefully
 func (c *CollectorService) Stop() error {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-
 	if !c.isRunning {
+		c.mu.Unlock()
 		return nil
 	}
 
@@ -184,14 +483,25 @@ func (c *CollectorService) Stop() error {
 	// Signal all goroutines to stop
 	close(c.stopChan)
 	c.cancel()
+	c.mu.Unlock()
 
-	// Wait for all goroutines to finish
+	// Wait for all goroutines to finish. The lock is released first, as the
+	// loops take it to finish their current iteration.
 	c.wg.Wait()
+	c.stopMonitor()
 
 	// Close resources
-	if c.pzemDevice != nil {
-		if err := c.pzemDevice.Close(); err != nil {
-			log.Printf("Error closing PZEM device: %v", err)
+	for _, ch := range c.channels {
+		if ch.mqttClient != nil {
+			ch.mqttClient.Close()
+		}
+		if err := ch.device.Close(); err != nil {
+			log.Printf("Error closing meter of channel %s: %v", ch.config.Key, err)
+		}
+	}
+	if c.bus != nil {
+		if err := c.bus.Close(); err != nil {
+			log.Printf("Error closing serial bus: %v", err)
 		}
 	}
 	if c.cacheDB != nil {
@@ -206,92 +516,271 @@ func (c *CollectorService) Stop() error {
 
 // ensureRegistration ensures the collector is registered with the server
 func (c *CollectorService) ensureRegistration() error {
-	if c.config.Auth.Token == "" || c.config.Collector.ID == "" {
-		return fmt.Errorf("auth token or collector ID is missing, please register first")
-	}
+	for _, ch := range c.channels {
+		if (ch.config.Token == "" && ch.config.ClientCert == "") || ch.config.ID == "" {
+			return fmt.Errorf("auth token or collector ID of channel %s is missing, please register first", ch.config.Key)
+		}
 
-	c.apiClient.SetToken(c.config.Auth.Token, c.config.Collector.ID)
+		ch.apiClient.SetToken(ch.config.Token, ch.config.ID)
+	}
 	c.isRegistered = true
 	log.Println("Authentication credentials set for API client.")
 
 	return nil
 }
 
-// dataCollectionLoop handles periodic data collection from PZEM-004T
+// dataCollectionLoop handles periodic data collection from the meters
 func (c *CollectorService) dataCollectionLoop() {
 	defer c.wg.Done()
 
-	ticker := time.NewTicker(c.config.Serial.SampleInterval * time.Second)
+	ticker := c.sampleTicker
 	defer ticker.Stop()
 
-	log.Printf("Starting data collection loop (interval: %v)", c.config.Serial.SampleInterval*time.Second)
+	log.Printf("Starting data collection loop (interval: %v)", c.sampleInterval())
 
 	for {
 		select {
 		case <-c.stopChan:
+			c.flushAggregates()
 			log.Println("Data collection loop stopped")
 			return
 		case <-ticker.C:
-			if err := c.collectData(); err != nil {
-				c.handleError("data collection", err)
+			c.collecting.begin()
+			c.collectData()
+			c.collecting.end()
+		}
+	}
+}
+
+// flushAggregates caches the summaries of the incomplete windows and the
+// samples held back by adaptive sampling, so that they are uploaded after a
+// restart
+func (c *CollectorService) flushAggregates() {
+	for _, ch := range c.channels {
+		if ch.aggregator != nil {
+			if summary := ch.aggregator.flush(); summary != nil {
+				if err := c.cacheReading(ch, summary); err != nil {
+					log.Printf("[%s] Failed to cache the incomplete aggregation window: %v", ch.config.Key, err)
+				}
+			}
+		}
+		if ch.adaptive != nil {
+			if held := ch.adaptive.flush(); held != nil {
+				if err := c.cacheReading(ch, held); err != nil {
+					log.Printf("[%s] Failed to cache the last adaptive sample: %v", ch.config.Key, err)
+				}
 			}
 		}
 	}
 }
 
-// collectData collects data from PZEM-004T and attempts real-time upload or caches it.
-func (c *CollectorService) collectData() error {
-	// Read data from PZEM-004T with retries
-	powerData, err := c.pzemDevice.ReadDataWithRetry(3)
+// collectData polls every channel on the bus in turn
+func (c *CollectorService) collectData() {
+	c.checkClock()
+	for _, ch := range c.channels {
+		if _, err := c.collectChannelData(ch); err != nil {
+			c.handleError(fmt.Sprintf("data collection (channel %s)", ch.config.Key), err)
+		}
+	}
+}
+
+// collectChannelData collects data from the meter of a single channel and
+// attempts real-time upload or caches it. With aggregation enabled, the
+// sample is added to the current window and only the summary of a completed
+// window is uploaded. With adaptive sampling enabled, only the samples the
+// sampler emits are uploaded.
+func (c *CollectorService) collectChannelData(ch *channel) (*meter.PowerData, error) {
+	ch.reading.Lock()
+	defer ch.reading.Unlock()
+
+	// Read data from the meter with retries
+	start := time.Now()
+	powerData, err := meter.ReadDataWithRetry(ch.device, 3)
+	ch.metrics.observeRead(time.Since(start), err)
 	if err != nil {
-		return fmt.Errorf("failed to read data from PZEM-004T: %w", err)
+		c.mu.Lock()
+		ch.lastReadError = err
+		ch.lastReadErrorAt = time.Now()
+		c.mu.Unlock()
+		return nil, fmt.Errorf("failed to read data from meter: %w", err)
 	}
 
-	// Validate data
-	if !powerData.IsDataValid() {
-		return fmt.Errorf("invalid data received from PZEM-004T: %v", powerData)
+	// Reject implausible readings, which are uploaded with their quality
+	// if configured
+	quality, reason := c.validateReading(ch, powerData)
+	if quality != "" {
+		ch.metrics.observeRejected(quality)
+		c.mu.RLock()
+		uploadRejected := c.config.Validation.UploadRejected
+		c.mu.RUnlock()
+		if !uploadRejected {
+			return nil, fmt.Errorf("rejected reading (%s: %v): %v", quality, reason, powerData)
+		}
+		log.Printf("[%s] Rejected reading (%s: %v), uploading it flagged", ch.config.Key, quality, reason)
+		powerData.Quality = quality
 	}
 
-	// Attempt to upload data in real-time
-	apiData := client.PowerDataRequest{
-		Timestamp:   powerData.Timestamp,
-		Voltage:     powerData.Voltage,
-		Current:     powerData.Current,
-		Power:       powerData.Power,
-		Energy:      powerData.Energy,
-		Frequency:   powerData.Frequency,
-		PowerFactor: powerData.PowerFactor,
+	// Stamp the reading by the server clock
+	if timestamp, ok := c.clock.Correct(powerData.Timestamp); ok {
+		powerData.Timestamp = timestamp
+	} else {
+		powerData.TimeUnreliable = true
+	}
+
+	// A rejected reading is neither evaluated by the alarm rules nor
+	// aggregated
+	if quality != "" {
+		if err := c.storeReading(ch, powerData); err != nil {
+			return nil, err
+		}
+		return powerData, nil
+	}
+
+	c.mu.Lock()
+	ch.lastDataTime = time.Now()
+	c.lastDataTime = ch.lastDataTime
+	c.mu.Unlock()
+
+	c.evaluateAlarms(ch, powerData)
+
+	readings := []*meter.PowerData{powerData}
+	if ch.aggregator != nil {
+		summary := ch.aggregator.add(powerData)
+		if summary == nil {
+			return powerData, nil
+		}
+		readings = []*meter.PowerData{summary}
+	} else if ch.adaptive != nil {
+		readings = ch.adaptive.add(powerData)
 	}
 
-	if err := c.apiClient.UploadData(apiData); err != nil {
+	for _, reading := range readings {
+		if err := c.storeReading(ch, reading); err != nil {
+			return nil, err
+		}
+	}
+	return powerData, nil // No error, as a failed upload was handled by caching
+}
+
+// checkClock logs the offset of the local clock from the server clock when
+// it is first known or has changed, and corrects the timestamps of the
+// readings cached before the clock was synchronized
+func (c *CollectorService) checkClock() {
+	offset, ok := c.clock.Offset()
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	first := c.loggedOffset == nil
+	changed := !first && (offset-*c.loggedOffset > maxClockSkew || *c.loggedOffset-offset > maxClockSkew)
+	if first || changed {
+		c.loggedOffset = &offset
+	}
+	c.mu.Unlock()
+
+	if changed || (first && (offset > maxClockSkew || -offset > maxClockSkew)) {
+		log.Printf("Warning: the local clock is off by %v from the server clock, reading timestamps are corrected", offset.Round(time.Millisecond))
+	} else if first {
+		log.Printf("Clock synchronized with the server (offset %v)", offset.Round(time.Millisecond))
+	}
+
+	if first {
+		count, err := c.cacheDB.CorrectTimestamps()
+		if err != nil {
+			c.handleError("clock correction", err)
+		} else if count > 0 {
+			log.Printf("Corrected the timestamps of %d readings cached before the clock was synchronized", count)
+		}
+	}
+}
+
+// storeReading uploads a reading in real time, or caches it if the upload
+// fails
+func (c *CollectorService) storeReading(ch *channel, powerData *meter.PowerData) error {
+	if powerData.Alarm {
+		log.Printf("[%s] Warning: meter alarm is active (power %.1f W)", ch.config.Key, powerData.Power)
+	}
+
+	// Number the reading, so that the server can recognize it when it is
+	// uploaded again
+	seq, err := c.cacheDB.NextSeq(ch.config.ID)
+	if err != nil {
+		return err
+	}
+
+	// Attempt to upload data in real-time
+	err = ch.uplink.UploadData(newPowerDataRequest(seq, powerData))
+	ch.metrics.observeUpload(uploadRealtime, 1, err)
+	if err != nil {
 		// If upload fails, write to cache
-		log.Printf("Real-time upload failed: %v. Caching data instead.", err)
-		c.isOnline = false // Mark as offline since we couldn't upload
-		if cacheErr := c.cacheDB.StorePowerData(c.config.Collector.ID, powerData); cacheErr != nil {
+		log.Printf("[%s] Real-time upload failed: %v. Caching data instead.", ch.config.Key, err)
+		c.setOnline(false) // Mark as offline since we couldn't upload
+		if cacheErr := c.cacheDB.StorePowerData(ch.config.ID, seq, powerData); cacheErr != nil {
 			return fmt.Errorf("failed to cache power data after upload failure: %w", cacheErr)
 		}
-		log.Printf("Data collected and cached successfully: %s", powerData.String())
+		log.Printf("[%s] Data collected and cached successfully: %s", ch.config.Key, powerData.String())
 	} else {
 		// If upload succeeds, mark as online
-		c.isOnline = true
-		log.Printf("Data collected and uploaded successfully in real-time: %s", powerData.String())
+		c.setOnline(true)
+		log.Printf("[%s] Data collected and uploaded successfully in real-time: %s", ch.config.Key, powerData.String())
 	}
 
-	c.mu.Lock()
-	c.lastDataTime = time.Now()
-	c.mu.Unlock()
+	return nil
+}
+
+// cacheReading numbers a reading and stores it in the cache for the next
+// batch upload
+func (c *CollectorService) cacheReading(ch *channel, powerData *meter.PowerData) error {
+	seq, err := c.cacheDB.NextSeq(ch.config.ID)
+	if err != nil {
+		return err
+	}
+	return c.cacheDB.StorePowerData(ch.config.ID, seq, powerData)
+}
 
-	return nil // Return nil because we handled the error by caching
+// newPowerDataRequest converts a reading to its upload format
+func newPowerDataRequest(seq uint64, data *meter.PowerData) client.PowerDataRequest {
+	request := client.PowerDataRequest{
+		Seq:            seq,
+		Timestamp:      data.Timestamp,
+		Voltage:        data.Voltage,
+		Current:        data.Current,
+		Power:          data.Power,
+		Energy:         data.Energy,
+		Frequency:      data.Frequency,
+		PowerFactor:    data.PowerFactor,
+		TimeUnreliable: data.TimeUnreliable,
+		Quality:        data.Quality,
+	}
+	if agg := data.Aggregate; agg != nil {
+		request.Aggregate = &client.PowerAggregate{
+			Window:         int(agg.Window / time.Second),
+			Samples:        agg.Samples,
+			VoltageMin:     agg.VoltageMin,
+			VoltageMax:     agg.VoltageMax,
+			CurrentMin:     agg.CurrentMin,
+			CurrentMax:     agg.CurrentMax,
+			PowerMin:       agg.PowerMin,
+			PowerMax:       agg.PowerMax,
+			PowerFactorMin: agg.PowerFactorMin,
+			PowerFactorMax: agg.PowerFactorMax,
+			EnergyDelta:    agg.EnergyDelta,
+		}
+	}
+	return request
 }
 
 // dataUploadLoop handles periodic data upload to server
 func (c *CollectorService) dataUploadLoop() {
 	defer c.wg.Done()
 
-	ticker := time.NewTicker(c.config.Data.UploadInterval * time.Second)
+	ticker := c.uploadTicker
 	defer ticker.Stop()
 
+	c.mu.RLock()
 	log.Printf("Starting data upload loop (interval: %v)", c.config.Data.UploadInterval*time.Second)
+	c.mu.RUnlock()
 
 	for {
 		select {
@@ -299,60 +788,147 @@ func (c *CollectorService) dataUploadLoop() {
 			log.Println("Data upload loop stopped")
 			return
 		case <-ticker.C:
-			if c.config.Data.AutoUpload {
-				if err := c.uploadCachedData(); err != nil {
-					c.handleError("data upload", err)
+			c.mu.RLock()
+			autoUpload := c.config.Data.AutoUpload
+			c.mu.RUnlock()
+
+			if autoUpload {
+				c.uploading.begin()
+				for _, ch := range c.channels {
+					if _, err := c.uploadCachedData(ch); err != nil {
+						c.handleError(fmt.Sprintf("data upload (channel %s)", ch.config.Key), err)
+					}
 				}
+				c.uploading.end()
 			}
 		}
 	}
 }
 
-// uploadCachedData uploads cached data to server
-func (c *CollectorService) uploadCachedData() error {
+// uploadCachedData uploads a batch of the cached data of a channel to server
+// and returns the number of records uploaded
+func (c *CollectorService) uploadCachedData(ch *channel) (int, error) {
+	ch.uploads.Lock()
+	defer ch.uploads.Unlock()
+
+	c.mu.RLock()
+	batchSize := c.config.Data.BatchSize
+	c.mu.RUnlock()
+
+	if err := c.uploadAlarmEvents(ch); err != nil {
+		log.Printf("[%s] Warning: %v", ch.config.Key, err)
+	}
+
 	// Get unuploaded data from cache
-	cachedData, err := c.cacheDB.GetUnuploadedData(c.config.Data.BatchSize)
+	cachedData, err := c.cacheDB.GetUnuploadedData(ch.config.ID, batchSize)
 	if err != nil {
-		return fmt.Errorf("failed to get unuploaded data from cache: %w", err)
+		return 0, fmt.Errorf("failed to get unuploaded data from cache: %w", err)
 	}
 
 	if len(cachedData) == 0 {
-		log.Println("No new data to upload.")
-		return nil
+		log.Printf("[%s] No new data to upload.", ch.config.Key)
+		return 0, nil
 	}
 
-	log.Printf("Found %d records to upload.", len(cachedData))
+	log.Printf("[%s] Found %d records to upload.", ch.config.Key, len(cachedData))
 
 	// Convert data to API format
 	var apiData []client.PowerDataRequest
-	var uploadedIDs []uint
 	for _, item := range cachedData {
-		apiData = append(apiData, client.PowerDataRequest{
-			Timestamp:   item.Timestamp,
-			Voltage:     item.Voltage,
-			Current:     item.Current,
-			Power:       item.Power,
-			Energy:      item.Energy,
-			Frequency:   item.Frequency,
-			PowerFactor: item.PowerFactor,
-		})
-		uploadedIDs = append(uploadedIDs, item.ID)
+		apiData = append(apiData, newPowerDataRequest(item.Seq, item.PowerData()))
 	}
 
 	// Upload batch data
-	if err := c.apiClient.UploadBatchData(apiData); err != nil {
-		c.isOnline = false
-		return fmt.Errorf("failed to upload batch data: %w", err)
+	result, err := ch.uplink.UploadBatchData(apiData)
+	if err != nil {
+		ch.metrics.observeUpload(uploadBatch, 0, err)
+		c.setOnline(false)
+		return 0, fmt.Errorf("failed to upload batch data: %w", err)
 	}
 
 	// Mark data as uploaded
+	uploadedIDs, duplicates := acknowledgedRows(cachedData, result)
+	ch.metrics.observeUpload(uploadBatch, len(uploadedIDs)-duplicates, nil)
 	if err := c.cacheDB.MarkAsUploaded(uploadedIDs); err != nil {
-		// This is a non-critical error, we log it but don't fail the entire upload
+		// This is a non-critical error, the rows are sent again and the
+		// server skips the readings it has stored
 		log.Printf("Warning: failed to mark data as uploaded: %v", err)
 	}
 
-	c.isOnline = true
-	log.Printf("Successfully uploaded %d data records.", len(apiData))
+	c.setOnline(true)
+	if duplicates > 0 {
+		log.Printf("[%s] Successfully uploaded %d data records (%d already on the server).", ch.config.Key, len(uploadedIDs), duplicates)
+	} else {
+		log.Printf("[%s] Successfully uploaded %d data records.", ch.config.Key, len(uploadedIDs))
+	}
+
+	if err := c.reportCacheLoss(ch); err != nil {
+		log.Printf("[%s] Warning: %v", ch.config.Key, err)
+	}
+	return len(uploadedIDs), nil
+}
+
+// acknowledgedRows returns the IDs of the cached rows the server stored now
+// or before, and how many it had stored before. Rows without a sequence
+// number, and all rows when the server does not deduplicate, are stored by
+// any successful upload.
+func acknowledgedRows(rows []database.PowerDataCache, result *client.BatchUploadResult) ([]uint, int) {
+	acknowledged := make(map[uint64]bool)
+	if result != nil {
+		for _, seq := range result.Accepted {
+			acknowledged[seq] = true
+		}
+		for _, seq := range result.Duplicates {
+			acknowledged[seq] = true
+		}
+	}
+
+	var ids []uint
+	for _, row := range rows {
+		if result == nil || row.Seq == 0 || acknowledged[row.Seq] {
+			ids = append(ids, row.ID)
+		}
+	}
+
+	duplicates := 0
+	if result != nil {
+		duplicates = len(result.Duplicates)
+	}
+	return ids, duplicates
+}
+
+// reportCacheLoss reports the readings of a channel that were dropped or
+// downsampled to keep the cache within its size limit
+func (c *CollectorService) reportCacheLoss(ch *channel) error {
+	losses, err := c.cacheDB.GetUnreportedLosses(ch.config.ID)
+	if err != nil {
+		return err
+	}
+	if len(losses) == 0 {
+		return nil
+	}
+
+	var reports []client.DataLossReport
+	var ids []uint
+	for _, loss := range losses {
+		reports = append(reports, client.DataLossReport{
+			Policy:  loss.Policy,
+			Records: loss.Records,
+			From:    loss.From,
+			To:      loss.To,
+		})
+		ids = append(ids, loss.ID)
+	}
+
+	if err := ch.apiClient.ReportDataLoss(reports); err != nil {
+		return fmt.Errorf("failed to report cache loss: %w", err)
+	}
+
+	if err := c.cacheDB.MarkLossesReported(ids); err != nil {
+		return err
+	}
+
+	log.Printf("[%s] Reported %d cache loss records to the server.", ch.config.Key, len(losses))
 	return nil
 }
 
@@ -380,7 +956,8 @@ func (c *CollectorService) heartbeatLoop() {
 	}
 }
 
-// sendHeartbeat sends a heartbeat to the server
+// sendHeartbeat sends a heartbeat with the service status as diagnostics
+// to the server
 func (c *CollectorService) sendHeartbeat() error {
 	var status string
 	if c.IsHealthy() {
@@ -388,18 +965,222 @@ func (c *CollectorService) sendHeartbeat() error {
 	} else {
 		status = "error"
 	}
+	diagnostics := c.GetStatus()
 
-	err := c.apiClient.SendHeartbeat(status, c.version)
-	if err != nil {
-		c.isOnline = false
-		return fmt.Errorf("failed to send heartbeat: %w", err)
+	for _, ch := range c.channels {
+		if err := ch.uplink.SendHeartbeat(status, c.version, diagnostics); err != nil {
+			c.setOnline(false)
+			return fmt.Errorf("failed to send heartbeat for channel %s: %w", ch.config.Key, err)
+		}
 	}
 
-	c.isOnline = true
+	c.setOnline(true)
 	log.Println("Heartbeat sent successfully")
 	return nil
 }
 
+// configLoop fetches the server-side configuration at startup and then
+// periodically, applying it whenever its version changes. With several
+// channels, the configuration of the first channel is used. Tokens close to
+// their expiry are rotated on the same schedule.
+func (c *CollectorService) configLoop() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.config.Server.ConfigPollInterval * time.Second)
+	defer ticker.Stop()
+
+	log.Printf("Starting config loop (interval: %v)", c.config.Server.ConfigPollInterval*time.Second)
+
+	for {
+		if err := c.syncConfig(false); err != nil {
+			c.handleError("config sync", err)
+		}
+		if err := c.renewTokens(); err != nil {
+			c.handleError("token rotation", err)
+		}
+
+		select {
+		case <-c.stopChan:
+			log.Println("Config loop stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// syncConfig fetches the server-side configuration and applies it if its
+// version differs from the one in effect, or always when forced
+func (c *CollectorService) syncConfig(force bool) error {
+	apiClient := c.channels[0].apiClient
+
+	remote, err := apiClient.GetConfig()
+	if err != nil {
+		return fmt.Errorf("failed to get config: %w", err)
+	}
+
+	c.mu.RLock()
+	current := c.configVersion
+	c.mu.RUnlock()
+	if remote.Version == current && !force {
+		return nil
+	}
+
+	if err := c.applyConfig(remote); err != nil {
+		return fmt.Errorf("failed to apply config version %d: %w", remote.Version, err)
+	}
+
+	if err := apiClient.ReportConfigApplied(remote.Version); err != nil {
+		return fmt.Errorf("failed to report applied config: %w", err)
+	}
+	return nil
+}
+
+// renewTokens rotates the token of every channel that expires within
+// tokenRenewBefore and saves the new token to the configuration file
+func (c *CollectorService) renewTokens() error {
+	for _, ch := range c.channels {
+		expiresAt := ch.apiClient.TokenExpiresAt()
+		if expiresAt.IsZero() || time.Until(expiresAt) > tokenRenewBefore {
+			continue
+		}
+
+		resp, err := ch.apiClient.RotateToken()
+		if err != nil {
+			return fmt.Errorf("failed to rotate token of channel %s: %w", ch.config.Key, err)
+		}
+
+		c.mu.Lock()
+		ch.config.Token = resp.Data.Token
+		c.config.UpdateChannel(ch.config)
+		err = config.SaveConfig(c.config, c.configFile)
+		c.mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("failed to save rotated token of channel %s, the old token stops working after the grace period: %w", ch.config.Key, err)
+		}
+
+		log.Printf("[%s] Token rotated, valid until %s", ch.config.Key, resp.Data.ExpiresAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// applyConfig applies the server-side configuration to the running service,
+// resetting the tickers of the collection and upload loops. If a setting
+// cannot be applied, the others still are but the version is not taken as
+// applied, so that it is applied again on the next poll.
+func (c *CollectorService) applyConfig(remote *client.RemoteConfig) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var errs []error
+
+	if remote.SampleInterval > 0 {
+		interval := time.Duration(remote.SampleInterval)
+		if interval != c.config.Serial.SampleInterval {
+			c.config.Serial.SampleInterval = interval
+			c.sampleTicker.Reset(c.samplePeriod())
+		}
+	}
+	if remote.UploadInterval > 0 {
+		interval := time.Duration(remote.UploadInterval)
+		if interval != c.config.Data.UploadInterval {
+			c.config.Data.UploadInterval = interval
+			c.uploadTicker.Reset(interval * time.Second)
+		}
+	}
+	if remote.BatchSize > 0 {
+		c.config.Data.BatchSize = remote.BatchSize
+	}
+	if remote.MaxCacheSize > 0 {
+		if err := c.cacheDB.SetLimit(remote.MaxCacheSize, c.config.Data.CachePolicy); err != nil {
+			log.Printf("Failed to apply the cache size of server config version %d: %v", remote.Version, err)
+			errs = append(errs, fmt.Errorf("failed to set cache size: %w", err))
+		} else {
+			c.config.Data.MaxCacheSize = remote.MaxCacheSize
+		}
+	}
+	if remote.CompressionLevel >= 1 && remote.CompressionLevel <= 9 {
+		c.config.Data.CompressionLevel = remote.CompressionLevel
+		for _, ch := range c.channels {
+			if err := ch.apiClient.SetCompression(c.compression(), c.config.Data.CompressionLevel); err != nil {
+				log.Printf("[%s] Failed to apply the compression level of server config version %d: %v", ch.config.Key, remote.Version, err)
+				errs = append(errs, fmt.Errorf("failed to set compression of channel %s: %w", ch.config.Key, err))
+			}
+		}
+	}
+	c.config.Data.AutoUpload = remote.AutoUpload
+	c.applyValidation(remote)
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	c.configVersion = remote.Version
+
+	log.Printf("Applied server config version %d (sample interval: %v, upload interval: %v, batch size: %d, cache size: %d, auto upload: %t, compression level: %d)",
+		remote.Version, c.config.Serial.SampleInterval*time.Second, c.config.Data.UploadInterval*time.Second,
+		c.config.Data.BatchSize, c.config.Data.MaxCacheSize, c.config.Data.AutoUpload, c.config.Data.CompressionLevel)
+	return nil
+}
+
+// applyValidation applies the validation settings the server sets, unless
+// they are inconsistent. The caller holds c.mu.
+func (c *CollectorService) applyValidation(remote *client.RemoteConfig) {
+	v := c.config.Validation
+	for _, setting := range []struct {
+		value  *float64
+		target *float64
+	}{
+		{remote.VoltageMax, &v.VoltageMax},
+		{remote.CurrentMax, &v.CurrentMax},
+		{remote.PowerMax, &v.PowerMax},
+		{remote.FrequencyMin, &v.FrequencyMin},
+		{remote.FrequencyMax, &v.FrequencyMax},
+		{remote.SpikeThreshold, &v.SpikeThreshold},
+	} {
+		if setting.value != nil && *setting.value > 0 {
+			*setting.target = *setting.value
+		}
+	}
+	if remote.VoltageMin != nil && *remote.VoltageMin >= 0 {
+		v.VoltageMin = *remote.VoltageMin
+	}
+	if remote.SpikeFilter != nil {
+		v.SpikeFilter = *remote.SpikeFilter
+	}
+	if remote.UploadRejected != nil {
+		v.UploadRejected = *remote.UploadRejected
+	}
+
+	if v.VoltageMin >= v.VoltageMax || v.FrequencyMin >= v.FrequencyMax {
+		log.Printf("Warning: ignoring the validation settings of server config version %d, whose ranges are empty", remote.Version)
+		return
+	}
+	if v != c.config.Validation {
+		c.config.Validation = v
+		log.Printf("Applied validation settings: voltage %g-%g V, current up to %g A, power up to %g W, frequency %g-%g Hz, spike filter: %t (threshold %g), upload rejected: %t",
+			v.VoltageMin, v.VoltageMax, v.CurrentMax, v.PowerMax, v.FrequencyMin, v.FrequencyMax, v.SpikeFilter, v.SpikeThreshold, v.UploadRejected)
+	}
+}
+
+// sampleInterval returns the sampling interval in effect
+func (c *CollectorService) sampleInterval() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.samplePeriod()
+}
+
+// samplePeriod returns the sampling interval in effect, which the
+// aggregation or adaptive sampling settings replace when enabled. The caller
+// holds c.mu.
+func (c *CollectorService) samplePeriod() time.Duration {
+	if c.config.Adaptive.Enabled {
+		return c.config.Adaptive.SampleInterval * time.Second
+	}
+	if c.config.Aggregation.Enabled {
+		return c.config.Aggregation.SampleInterval * time.Second
+	}
+	return c.config.Serial.SampleInterval * time.Second
+}
+
 // maintenanceLoop handles periodic maintenance tasks
 func (c *CollectorService) maintenanceLoop() {
 	defer c.wg.Done()
@@ -423,9 +1204,11 @@ func (c *CollectorService) maintenanceLoop() {
 // performMaintenance performs routine maintenance tasks
 func (c *CollectorService) performMaintenance() {
 	// Reset error count if everything is working fine
-	if c.isOnline && time.Since(c.lastDataTime) < c.config.Serial.SampleInterval*time.Second*2 {
+	c.mu.Lock()
+	if c.isOnline && time.Since(c.lastDataTime) < c.samplePeriod()*2 {
 		c.errorCount = 0
 	}
+	c.mu.Unlock()
 
 	// Cleanup old data from cache
 	if err := c.cacheDB.CleanupOldData(7 * 24 * time.Hour); err != nil { // Cleanup data older than 7 days
@@ -437,17 +1220,46 @@ func (c *CollectorService) performMaintenance() {
 
 // handleError handles errors and implements error recovery
 func (c *CollectorService) handleError(operation string, err error) {
+	c.mu.Lock()
 	c.errorCount++
-	log.Printf("Error in %s: %v (error count: %d)", operation, err, c.errorCount)
+	c.lastError = fmt.Errorf("%s: %w", operation, err)
+	c.lastErrorAt = time.Now()
+	errorCount := c.errorCount
+	c.mu.Unlock()
+	log.Printf("Error in %s: %v (error count: %d)", operation, err, errorCount)
 
 	// Implement exponential backoff for critical errors
-	if c.errorCount > 10 {
-		log.Printf("Too many errors, sleeping for 1 minute")
-		time.Sleep(1 * time.Minute)
+	if errorCount > 10 {
+		log.Printf("Too many errors, backing off for 1 minute")
+		c.backOff(time.Minute)
+		c.mu.Lock()
 		c.errorCount = 5 // Reset to moderate level
+		c.mu.Unlock()
 	}
 }
 
+// backOff waits for a duration or until the service is stopped. The wait is
+// left out of the time the collection and upload loops are busy, so that the
+// watchdog does not take it for a stall.
+func (c *CollectorService) backOff(d time.Duration) {
+	c.collecting.pause()
+	c.uploading.pause()
+	defer c.collecting.resume()
+	defer c.uploading.resume()
+
+	select {
+	case <-c.stopChan:
+	case <-time.After(d):
+	}
+}
+
+// setOnline records whether the last exchange with the server succeeded
+func (c *CollectorService) setOnline(online bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.isOnline = online
+}
+
 // GetStatus returns the current status of the collector service
 func (c *CollectorService) GetStatus() ServiceStatus {
 	c.mu.RLock()
@@ -459,12 +1271,46 @@ func (c *CollectorService) GetStatus() ServiceStatus {
 	}
 
 	status := ServiceStatus{
-		IsRunning:    c.isRunning,
-		IsRegistered: c.isRegistered,
-		IsOnline:     c.isOnline,
-		LastDataTime: c.lastDataTime,
-		ErrorCount:   c.errorCount,
-		CacheStats:   cacheStats,
+		IsRunning:     c.isRunning,
+		IsRegistered:  c.isRegistered,
+		IsOnline:      c.isOnline,
+		LastDataTime:  c.lastDataTime,
+		ErrorCount:    c.errorCount,
+		ConfigVersion: c.configVersion,
+		CacheStats:    cacheStats,
+		Uptime:        time.Since(c.clock.Started()).Seconds(),
+		System:        systemStatus(filepath.Dir(c.config.Data.CacheDB)),
+	}
+	if c.lastError != nil {
+		lastErrorAt := c.lastErrorAt
+		status.LastError = c.lastError.Error()
+		status.LastErrorAt = &lastErrorAt
+	}
+	if offset, ok := c.clock.Offset(); ok {
+		seconds := offset.Seconds()
+		status.ClockOffset = &seconds
+	}
+
+	for _, ch := range c.channels {
+		channelStatus := ChannelStatus{
+			Key:          ch.config.Key,
+			CollectorID:  ch.config.ID,
+			Name:         ch.config.Name,
+			Driver:       ch.config.Driver,
+			Address:      ch.config.Address,
+			Capabilities: ch.device.Capabilities(),
+			LastDataTime: ch.lastDataTime,
+		}
+		if ch.lastReadError != nil {
+			lastReadErrorAt := ch.lastReadErrorAt
+			channelStatus.LastReadError = ch.lastReadError.Error()
+			channelStatus.LastReadErrorAt = &lastReadErrorAt
+		}
+		if driver, ok := meter.Lookup(ch.config.Driver); ok && !driver.Virtual && c.bus != nil {
+			stats := c.bus.Stats(uint8(ch.config.Address))
+			channelStatus.Errors = &stats
+		}
+		status.Channels = append(status.Channels, channelStatus)
 	}
 
 	return status
@@ -480,7 +1326,7 @@ func (c *CollectorService) IsHealthy() bool {
 	}
 
 	// Check if data collection is working
-	if time.Since(c.lastDataTime) > c.config.Serial.SampleInterval*time.Second*3 {
+	if time.Since(c.lastDataTime) > c.samplePeriod()*3 {
 		return false
 	}
 
@@ -0,0 +1,181 @@
+package collector
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"power-collector/pkg/client"
+	"power-collector/pkg/clock"
+	"power-collector/pkg/config"
+	"power-collector/pkg/database"
+	"power-collector/pkg/meter"
+)
+
+// newTestService returns a service with a cache in a temporary directory and
+// the tickers of its loops, without channels
+func newTestService(t *testing.T) *CollectorService {
+	t.Helper()
+
+	cache, err := database.NewCacheDB(filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatalf("NewCacheDB failed: %v", err)
+	}
+	t.Cleanup(func() { cache.Close() })
+
+	c := &CollectorService{
+		config: &config.Config{
+			Serial: config.SerialConfig{SampleInterval: 15},
+			Data: config.DataConfig{
+				UploadInterval: 60,
+				MaxCacheSize:   1000,
+				CachePolicy:    database.PolicyDropOldest,
+			},
+			Validation: config.ValidationConfig{
+				VoltageMax:     300,
+				CurrentMax:     100,
+				PowerMax:       30000,
+				FrequencyMin:   45,
+				FrequencyMax:   65,
+				SpikeWindow:    15,
+				SpikeThreshold: 8,
+			},
+		},
+		cacheDB:      cache,
+		sampleTicker: time.NewTicker(time.Hour),
+		uploadTicker: time.NewTicker(time.Hour),
+	}
+	t.Cleanup(c.sampleTicker.Stop)
+	t.Cleanup(c.uploadTicker.Stop)
+	return c
+}
+
+func TestApplyConfig(t *testing.T) {
+	c := newTestService(t)
+
+	if err := c.applyConfig(&client.RemoteConfig{Version: 2, SampleInterval: 5, MaxCacheSize: 500}); err != nil {
+		t.Fatalf("applyConfig failed: %v", err)
+	}
+	if c.configVersion != 2 || c.config.Serial.SampleInterval != 5 || c.config.Data.MaxCacheSize != 500 {
+		t.Errorf("Expected version 2 to be applied, got version %d, %+v", c.configVersion, c.config.Data)
+	}
+
+	// A cache size the cache refuses leaves the version unapplied
+	c.config.Data.CachePolicy = "unknown"
+	if err := c.applyConfig(&client.RemoteConfig{Version: 3, MaxCacheSize: 200}); err == nil {
+		t.Fatal("applyConfig expected an error")
+	}
+	if c.configVersion != 2 {
+		t.Errorf("Expected version 2 to stay in effect, got %d", c.configVersion)
+	}
+	if c.config.Data.MaxCacheSize != 500 {
+		t.Errorf("Expected the cache size to stay 500, got %d", c.config.Data.MaxCacheSize)
+	}
+}
+
+// fakeMeter returns readings with a growing energy counter
+type fakeMeter struct {
+	mu     sync.Mutex
+	energy float64
+}
+
+func (m *fakeMeter) ReadData() (*meter.PowerData, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.energy++
+	return &meter.PowerData{Timestamp: time.Now(), Voltage: 230, Current: 0.5, Power: 115, Energy: m.energy, Frequency: 50, PowerFactor: 1}, nil
+}
+
+func (m *fakeMeter) TestConnection() error { return nil }
+func (m *fakeMeter) Close() error          { return nil }
+func (m *fakeMeter) Capabilities() meter.Capabilities {
+	return meter.Capabilities{Model: "Fake"}
+}
+
+// recordingUplink fails real-time uploads, so that readings are cached, and
+// records the sequence numbers of the batches uploaded
+type recordingUplink struct {
+	mu       sync.Mutex
+	uploaded map[uint64]int
+}
+
+func (u *recordingUplink) UploadData(client.PowerDataRequest) error {
+	return errors.New("offline")
+}
+
+func (u *recordingUplink) UploadBatchData(data []client.PowerDataRequest) (*client.BatchUploadResult, error) {
+	// Slow enough for concurrent uploads to overlap
+	time.Sleep(time.Millisecond)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	result := &client.BatchUploadResult{}
+	for _, item := range data {
+		u.uploaded[item.Seq]++
+		result.Accepted = append(result.Accepted, item.Seq)
+	}
+	return result, nil
+}
+
+func (u *recordingUplink) SendHeartbeat(string, string, interface{}) error {
+	return nil
+}
+
+func TestCommandsAgainstLoops(t *testing.T) {
+	c := newTestService(t)
+	c.clock = clock.New()
+	c.config.Data.BatchSize = 5
+	uplink := &recordingUplink{uploaded: make(map[uint64]int)}
+	ch := &channel{
+		config: config.ChannelConfig{Key: "test", ID: "test"},
+		device: &fakeMeter{},
+		uplink: uplink,
+	}
+	c.channels = []*channel{ch}
+
+	// The loops and the read_now and flush_cache commands run at once
+	var wg sync.WaitGroup
+	run := func(f func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				f()
+			}
+		}()
+	}
+	run(c.collectData)
+	run(func() {
+		if _, err := c.runCommand(ch, &client.CommandMessage{Command: commandReadNow}); err != nil {
+			t.Errorf("read_now failed: %v", err)
+		}
+	})
+	run(func() {
+		if _, err := c.uploadCachedData(ch); err != nil {
+			t.Errorf("Upload failed: %v", err)
+		}
+	})
+	run(func() {
+		if _, err := c.runCommand(ch, &client.CommandMessage{Command: commandFlushCache}); err != nil {
+			t.Errorf("flush_cache failed: %v", err)
+		}
+	})
+	wg.Wait()
+
+	if _, err := c.runCommand(ch, &client.CommandMessage{Command: commandFlushCache}); err != nil {
+		t.Fatalf("flush_cache failed: %v", err)
+	}
+	if len(uplink.uploaded) != 40 {
+		t.Errorf("Expected the 40 readings to be uploaded, got %d", len(uplink.uploaded))
+	}
+	for seq, count := range uplink.uploaded {
+		if count > 1 {
+			t.Errorf("Reading %d was uploaded %d times", seq, count)
+		}
+	}
+	if status := c.GetStatus(); !status.IsOnline {
+		t.Error("Expected the service to be online after the uploads")
+	}
+}
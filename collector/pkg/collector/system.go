@@ -0,0 +1,76 @@
+package collector
+
+import (
+	"bufio"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// SystemStatus represents the resources of the device the collector runs on
+type SystemStatus struct {
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+	GoVersion string `json:"go_version"`
+	// DiskFree and DiskTotal are the bytes of the file system holding the
+	// cache, 0 if unknown
+	DiskFree  uint64 `json:"disk_free"`
+	DiskTotal uint64 `json:"disk_total"`
+	// MemoryAlloc is the heap in use and MemorySys the memory obtained from
+	// the OS by the collector, in bytes
+	MemoryAlloc uint64 `json:"memory_alloc"`
+	MemorySys   uint64 `json:"memory_sys"`
+	// MemoryAvailable and MemoryTotal are the bytes of the device, 0 if
+	// unknown
+	MemoryAvailable uint64 `json:"memory_available"`
+	MemoryTotal     uint64 `json:"memory_total"`
+}
+
+// systemStatus returns the resources of the device, with the disk space of
+// the file system holding path
+func systemStatus(path string) SystemStatus {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	status := SystemStatus{
+		OS:          runtime.GOOS,
+		Arch:        runtime.GOARCH,
+		GoVersion:   runtime.Version(),
+		MemoryAlloc: mem.HeapAlloc,
+		MemorySys:   mem.Sys,
+	}
+	status.DiskFree, status.DiskTotal = diskUsage(path)
+	status.MemoryAvailable, status.MemoryTotal = deviceMemory()
+	return status
+}
+
+// deviceMemory returns the available and total memory of the device in
+// bytes from /proc/meminfo, zero where it does not exist
+func deviceMemory() (available, total uint64) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, 0
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// e.g. "MemAvailable:    3215872 kB"
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			total = kb * 1024
+		case "MemAvailable:":
+			available = kb * 1024
+		}
+	}
+	return available, total
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd
+
+package collector
+
+// diskUsage is not supported on this platform
+func diskUsage(path string) (free, total uint64) {
+	return 0, 0
+}
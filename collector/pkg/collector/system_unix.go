@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd
+
+package collector
+
+import "syscall"
+
+// diskUsage returns the free and total bytes of the file system holding
+// path, zero if it cannot be determined
+func diskUsage(path string) (free, total uint64) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), uint64(stat.Blocks) * uint64(stat.Bsize)
+}
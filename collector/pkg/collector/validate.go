@@ -0,0 +1,167 @@
+package collector
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"power-collector/pkg/config"
+	"power-collector/pkg/meter"
+)
+
+const (
+	// spikeConfirmations is the number of rejected readings in a row after
+	// which a jump is taken as a change of level, and the readings before it
+	// are forgotten
+	spikeConfirmations = 3
+
+	// minRelativeSpread bounds the spread of a measurement from below as a
+	// share of its median, so that a steady measurement does not turn the
+	// smallest change into a spike
+	minRelativeSpread = 0.01
+
+	// madScale turns the median absolute deviation into an estimate of the
+	// standard deviation of normally distributed readings
+	madScale = 1.4826
+
+	// energyMargin is the jump of the energy counter in Wh beyond what the
+	// maximum power explains that is still accepted, covering the counter
+	// resolution
+	energyMargin = 10
+)
+
+// spikeFilter rejects readings of a channel that jump away from the recent
+// ones. Voltage and frequency are compared with the median of the recent
+// readings, with their median absolute deviation as the spread (a Hampel
+// filter), so that a spike does not shift the reference. The energy counter
+// may not grow faster than the maximum power allows. Current and power are
+// not checked, as loads switch on and off.
+type spikeFilter struct {
+	mu        sync.Mutex
+	voltage   []float64
+	frequency []float64
+	// Energy counter and local time of the last reading accepted, zero
+	// after a change of level
+	lastEnergy float64
+	lastAt     time.Time
+	// rejected counts the readings rejected in a row
+	rejected int
+}
+
+// check returns an error describing the jump if data is a spike, otherwise
+// it adds data to the recent readings. It runs before the timestamp of data
+// is corrected by the server clock.
+func (f *spikeFilter) check(data *meter.PowerData, v config.ValidationConfig) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := f.jump(data, v)
+	if err != nil {
+		f.rejected++
+		if f.rejected < spikeConfirmations {
+			return err
+		}
+		// The jump persists, so it is a change of level
+		f.voltage, f.frequency = nil, nil
+		f.lastEnergy, f.lastAt = 0, time.Time{}
+	}
+	f.rejected = 0
+
+	f.voltage = appendWindow(f.voltage, data.Voltage, v.SpikeWindow)
+	if !data.DC {
+		f.frequency = appendWindow(f.frequency, data.Frequency, v.SpikeWindow)
+	}
+	f.lastEnergy, f.lastAt = data.Energy, data.Timestamp
+	return nil
+}
+
+// jump returns an error describing the first implausible jump of data from
+// the recent readings
+func (f *spikeFilter) jump(data *meter.PowerData, v config.ValidationConfig) error {
+	if outlier(f.voltage, data.Voltage, v.SpikeThreshold) {
+		return fmt.Errorf("voltage %.1f V jumped from a median of %.1f V", data.Voltage, median(f.voltage))
+	}
+	if !data.DC && outlier(f.frequency, data.Frequency, v.SpikeThreshold) {
+		return fmt.Errorf("frequency %.2f Hz jumped from a median of %.2f Hz", data.Frequency, median(f.frequency))
+	}
+
+	// A counter that went backwards was reset or rolled over
+	if f.lastEnergy > 0 && data.Energy > f.lastEnergy {
+		elapsed := data.Timestamp.Sub(f.lastAt)
+		limit := v.PowerMax*max(elapsed, 0).Hours() + energyMargin
+		if data.Energy-f.lastEnergy > limit {
+			return fmt.Errorf("energy counter rose by %.0f Wh in %v", data.Energy-f.lastEnergy, elapsed.Round(time.Second))
+		}
+	}
+	return nil
+}
+
+// outlier reports whether value deviates from the median of the recent
+// values by more than threshold times their spread. Too few recent values
+// do not tell.
+func outlier(recent []float64, value, threshold float64) bool {
+	if len(recent) < config.MinSpikeWindow {
+		return false
+	}
+
+	m := median(recent)
+	deviations := make([]float64, len(recent))
+	for i, r := range recent {
+		deviations[i] = math.Abs(r - m)
+	}
+	spread := max(madScale*median(deviations), minRelativeSpread*math.Abs(m))
+	return math.Abs(value-m) > threshold*spread
+}
+
+// median returns the median of values, which must not be empty
+func median(values []float64) float64 {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// appendWindow appends value to window, keeping the last size values
+func appendWindow(window []float64, value float64, size int) []float64 {
+	window = append(window, value)
+	if len(window) > size {
+		window = window[len(window)-size:]
+	}
+	return window
+}
+
+// validateReading checks a reading against the limits and the spike filter
+// of its channel. It returns the quality of a rejected reading with the
+// reason, or an empty quality if the reading is plausible.
+func (c *CollectorService) validateReading(ch *channel, data *meter.PowerData) (string, error) {
+	c.mu.RLock()
+	v := c.config.Validation
+	c.mu.RUnlock()
+
+	if err := data.Validate(limits(v)); err != nil {
+		return meter.QualityOutOfRange, err
+	}
+	if v.SpikeFilter {
+		if err := ch.spikes.check(data, v); err != nil {
+			return meter.QualitySpike, err
+		}
+	}
+	return "", nil
+}
+
+// limits returns the measurement ranges of the validation settings
+func limits(v config.ValidationConfig) meter.Limits {
+	return meter.Limits{
+		VoltageMin:   v.VoltageMin,
+		VoltageMax:   v.VoltageMax,
+		CurrentMax:   v.CurrentMax,
+		PowerMax:     v.PowerMax,
+		FrequencyMin: v.FrequencyMin,
+		FrequencyMax: v.FrequencyMax,
+	}
+}
@@ -0,0 +1,149 @@
+package collector
+
+import (
+	"testing"
+	"time"
+
+	"power-collector/pkg/client"
+	"power-collector/pkg/config"
+	"power-collector/pkg/meter"
+)
+
+// spikeSettings are the validation settings the spike filter tests run with
+var spikeSettings = config.ValidationConfig{PowerMax: 30000, SpikeFilter: true, SpikeWindow: 15, SpikeThreshold: 8}
+
+// newFilledFilter returns a spike filter holding a window of readings whose
+// voltage alternates around 230 V by deviation, taken a second apart
+func newFilledFilter(t *testing.T, start time.Time, deviation float64) *spikeFilter {
+	t.Helper()
+
+	f := &spikeFilter{}
+	for i := 0; i < spikeSettings.SpikeWindow; i++ {
+		voltage := 230 + deviation
+		if i%2 == 1 {
+			voltage = 230 - deviation
+		}
+		data := &meter.PowerData{Timestamp: start.Add(time.Duration(i) * time.Second), Voltage: voltage, Frequency: 50, Energy: 1000}
+		if err := f.check(data, spikeSettings); err != nil {
+			t.Fatalf("Expected reading %d to be accepted: %v", i, err)
+		}
+	}
+	return f
+}
+
+func TestSpikeFilter(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := start.Add(time.Minute)
+
+	tests := []struct {
+		name      string
+		deviation float64 // of the voltages in the window
+		data      meter.PowerData
+		spike     bool
+	}{
+		{"within the spread", 0.5, meter.PowerData{Voltage: 232, Frequency: 50, Energy: 1000}, false},
+		{"voltage spike", 0.5, meter.PowerData{Voltage: 260, Frequency: 50, Energy: 1000}, true},
+		{"frequency spike", 0.5, meter.PowerData{Voltage: 230, Frequency: 58, Energy: 1000}, true},
+		{"frequency of a DC meter", 0.5, meter.PowerData{Voltage: 230, Frequency: 0, DC: true, Energy: 1000}, false},
+		// The spread of a steady voltage is 1% of its median, 2.3 V, so
+		// that 8 spreads allow 18.4 V
+		{"steady voltage, small change", 0, meter.PowerData{Voltage: 230.5, Frequency: 50, Energy: 1000}, false},
+		{"steady voltage, within the floor", 0, meter.PowerData{Voltage: 245, Frequency: 50, Energy: 1000}, false},
+		{"steady voltage, beyond the floor", 0, meter.PowerData{Voltage: 250, Frequency: 50, Energy: 1000}, true},
+		// 30 kW for the 46 s since the last reading and a margin of 10 Wh
+		// allow 393 Wh
+		{"energy within the maximum power", 0.5, meter.PowerData{Voltage: 230, Frequency: 50, Energy: 1380}, false},
+		{"energy jump", 0.5, meter.PowerData{Voltage: 230, Frequency: 50, Energy: 2000}, true},
+		{"energy counter reset", 0.5, meter.PowerData{Voltage: 230, Frequency: 50, Energy: 5}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFilledFilter(t, start, tt.deviation)
+			data := tt.data
+			data.Timestamp = at
+			err := f.check(&data, spikeSettings)
+			if tt.spike && err == nil {
+				t.Error("Expected the reading to be rejected as a spike")
+			}
+			if !tt.spike && err != nil {
+				t.Errorf("Expected the reading to be accepted: %v", err)
+			}
+		})
+	}
+}
+
+func TestSpikeFilterLevelShift(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := newFilledFilter(t, start, 0.5)
+
+	at := start.Add(time.Minute)
+	for i := 1; i <= spikeConfirmations; i++ {
+		data := &meter.PowerData{Timestamp: at.Add(time.Duration(i) * time.Second), Voltage: 260, Frequency: 50, Energy: 1000}
+		err := f.check(data, spikeSettings)
+		if i < spikeConfirmations && err == nil {
+			t.Fatalf("Expected reading %d of the jump to be rejected", i)
+		}
+		if i == spikeConfirmations && err != nil {
+			t.Fatalf("Expected the jump to be accepted as a change of level after %d readings: %v", i, err)
+		}
+	}
+
+	// The readings before the change of level are forgotten
+	data := &meter.PowerData{Timestamp: at.Add(time.Minute), Voltage: 261, Frequency: 50, Energy: 1000}
+	if err := f.check(data, spikeSettings); err != nil {
+		t.Errorf("Expected the new level to be accepted: %v", err)
+	}
+
+	// A single spike does not count towards a change of level
+	f = newFilledFilter(t, start, 0.5)
+	for i, voltage := range []float64{260, 230, 260, 230, 260} {
+		data := &meter.PowerData{Timestamp: at.Add(time.Duration(i) * time.Second), Voltage: voltage, Frequency: 50, Energy: 1000}
+		if err := f.check(data, spikeSettings); (voltage == 260) != (err != nil) {
+			t.Errorf("Reading %d of %v V: unexpected result %v", i, voltage, err)
+		}
+	}
+}
+
+func TestApplyValidation(t *testing.T) {
+	value := func(v float64) *float64 { return &v }
+	enabled := true
+
+	c := &CollectorService{
+		config: &config.Config{
+			Validation: config.ValidationConfig{
+				VoltageMax:     300,
+				CurrentMax:     100,
+				PowerMax:       30000,
+				FrequencyMin:   45,
+				FrequencyMax:   65,
+				SpikeWindow:    15,
+				SpikeThreshold: 8,
+			},
+		},
+	}
+	c.applyValidation(&client.RemoteConfig{
+		Version:     2,
+		PowerMax:    value(100),
+		CurrentMax:  value(0), // not set
+		VoltageMin:  value(180),
+		SpikeFilter: &enabled,
+	})
+	v := c.config.Validation
+	if v.PowerMax != 100 || v.VoltageMin != 180 || !v.SpikeFilter {
+		t.Errorf("Expected the server settings to be applied, got %+v", v)
+	}
+	if v.CurrentMax != 100 || v.VoltageMax != 300 || v.UploadRejected {
+		t.Errorf("Expected the settings the server leaves unset to stay, got %+v", v)
+	}
+
+	// Settings leaving an empty range are ignored as a whole
+	c.applyValidation(&client.RemoteConfig{
+		Version:    3,
+		PowerMax:   value(200),
+		VoltageMin: value(310),
+	})
+	if c.config.Validation != v {
+		t.Errorf("Expected inconsistent settings to be ignored, got %+v", c.config.Validation)
+	}
+}
@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
 	"gopkg.in/ini.v1"
@@ -16,6 +18,26 @@ type Config struct {
 	Auth      AuthConfig      `ini:"auth"`
 	Data      DataConfig      `ini:"data"`
 	Logging   LoggingConfig   `ini:"logging"`
+	Simulator SimulatorConfig `ini:"simulator"`
+	Monitor   MonitorConfig   `ini:"monitor"`
+	// Aggregation summarizes fast samples into windows
+	Aggregation AggregationConfig `ini:"aggregation"`
+	// Adaptive emits fast samples only when the power changes
+	Adaptive AdaptiveConfig `ini:"adaptive"`
+	// MQTT publishes readings, heartbeats and status to a broker instead of
+	// uploading them to the server
+	MQTT MQTTConfig `ini:"mqtt"`
+	// Validation rejects implausible readings
+	Validation ValidationConfig `ini:"validation"`
+
+	// Channels lists the meters polled on the shared bus, parsed from
+	// [channel.<key>] sections. When empty, a single channel is derived from
+	// the [collector], [auth] and [serial] sections.
+	Channels []ChannelConfig `ini:"-"`
+
+	// AlarmRules lists the local alarm rules, parsed from [alarm.<name>]
+	// sections
+	AlarmRules []AlarmRuleConfig `ini:"-"`
 }
 
 // CollectorConfig represents collector-specific configuration
@@ -26,10 +48,30 @@ type CollectorConfig struct {
 	Location    string `ini:"location"`
 }
 
+// ChannelConfig represents a single meter on the bus and the collector
+// identity its readings are uploaded under
+type ChannelConfig struct {
+	Key              string `ini:"-"`
+	Driver           string `ini:"driver"`
+	Address          int    `ini:"address"`
+	ID               string `ini:"id"`
+	Name             string `ini:"name"`
+	Description      string `ini:"description"`
+	Location         string `ini:"location"`
+	Token            string `ini:"token"`
+	RegistrationCode string `ini:"registration_code"`
+	ClientCert       string `ini:"client_cert"`
+	ClientKey        string `ini:"client_key"`
+	SigningSecret    string `ini:"signing_secret"`
+}
+
 // SerialConfig represents serial port configuration
 type SerialConfig struct {
+	Driver         string        `ini:"driver"`
 	Port           string        `ini:"port"`
 	BaudRate       int           `ini:"baud_rate"`
+	StopBits       int           `ini:"stop_bits"`
+	Address        int           `ini:"address"`
 	SampleInterval time.Duration `ini:"sample_interval"`
 	Timeout        time.Duration `ini:"timeout"`
 }
@@ -41,22 +83,157 @@ type ServerConfig struct {
 	Timeout       time.Duration `ini:"timeout"`
 	RetryInterval time.Duration `ini:"retry_interval"`
 	MaxRetries    int           `ini:"max_retries"`
+	// ConfigPollInterval is how often the server-side configuration is
+	// fetched and applied
+	ConfigPollInterval time.Duration `ini:"config_poll_interval"`
+	// CACert holds the CA certificates the server certificate is verified
+	// against, the system pool is used if empty
+	CACert string `ini:"ca_cert"`
 }
 
-// AuthConfig represents authentication configuration
+// AuthConfig represents authentication configuration. A client
+// certificate authenticates the collector instead of the token; if the files
+// do not exist yet, the certificate is requested during registration.
 type AuthConfig struct {
 	Token            string `ini:"token"`
 	RegistrationCode string `ini:"registration_code"`
+	ClientCert       string `ini:"client_cert"`
+	ClientKey        string `ini:"client_key"`
+	// SigningSecret signs every request if set. It is issued on
+	// registration.
+	SigningSecret string `ini:"signing_secret"`
 }
 
 // DataConfig represents data handling configuration
 type DataConfig struct {
 	CacheDB           string        `ini:"cache_db"`
 	MaxCacheSize      int           `ini:"max_cache_size"`
+	CachePolicy       string        `ini:"cache_policy"`
 	BatchSize         int           `ini:"batch_size"`
 	UploadInterval    time.Duration `ini:"upload_interval"`
 	AutoUpload        bool          `ini:"auto_upload"`
 	EnableCompression bool          `ini:"enable_compression"`
+	Compression       string        `ini:"compression"`
+	CompressionLevel  int           `ini:"compression_level"`
+}
+
+// SimulatorConfig represents the settings of the simulated and replay
+// meter drivers
+type SimulatorConfig struct {
+	Seed             int64   `ini:"seed"`
+	BaseLoad         float64 `ini:"base_load"`
+	NominalVoltage   float64 `ini:"nominal_voltage"`
+	NominalFrequency float64 `ini:"nominal_frequency"`
+	Source           string  `ini:"source"`
+	Speed            float64 `ini:"speed"`
+	Loop             bool    `ini:"loop"`
+}
+
+// MonitorConfig represents the local HTTP listener serving health, status
+// and metrics, and the progress check of the systemd watchdog
+type MonitorConfig struct {
+	// Listen is the address to listen on, e.g. 127.0.0.1:9108. The
+	// listener is disabled if empty.
+	Listen string `ini:"listen"`
+	// StallTimeout is how long in seconds the collection or upload loop may
+	// be busy with one iteration before the systemd watchdog is no longer
+	// pinged
+	StallTimeout time.Duration `ini:"stall_timeout"`
+}
+
+// AggregationConfig represents the sampling of the meters at a high rate
+// with per-window summaries uploaded instead of the single readings
+type AggregationConfig struct {
+	Enabled        bool          `ini:"enabled"`
+	SampleInterval time.Duration `ini:"sample_interval"` // seconds between samples, replaces [serial] sample_interval
+	Window         time.Duration `ini:"window"`          // seconds summarized per upload
+}
+
+// AdaptiveConfig represents the sampling of the meters at a high rate with a
+// reading emitted only when the power changes or MaxInterval has passed.
+// A change by more than PowerDelta or more than PowerPercent of the power
+// last emitted is emitted. A threshold of 0 is disabled; with both disabled,
+// every sample is emitted.
+type AdaptiveConfig struct {
+	Enabled        bool          `ini:"enabled"`
+	SampleInterval time.Duration `ini:"sample_interval"` // seconds between samples, replaces [serial] sample_interval
+	PowerDelta     float64       `ini:"power_delta"`     // W
+	PowerPercent   float64       `ini:"power_percent"`   // % of the power last emitted
+	MaxInterval    time.Duration `ini:"max_interval"`    // seconds after which a reading is emitted anyway
+}
+
+// Default power change thresholds of adaptive sampling, which apply where the
+// config file does not set them, as 0 disables a threshold
+const (
+	DefaultAdaptivePowerDelta   = 10 // W
+	DefaultAdaptivePowerPercent = 5  // %
+)
+
+// ValidationConfig represents the checks readings must pass before they are
+// uploaded. The server config may override them.
+type ValidationConfig struct {
+	VoltageMin   float64 `ini:"voltage_min"`   // V
+	VoltageMax   float64 `ini:"voltage_max"`   // V
+	CurrentMax   float64 `ini:"current_max"`   // A
+	PowerMax     float64 `ini:"power_max"`     // W
+	FrequencyMin float64 `ini:"frequency_min"` // Hz
+	FrequencyMax float64 `ini:"frequency_max"` // Hz
+	// SpikeFilter rejects readings jumping away from the recent ones by
+	// more than SpikeThreshold times their spread
+	SpikeFilter    bool    `ini:"spike_filter"`
+	SpikeWindow    int     `ini:"spike_window"` // recent readings compared against
+	SpikeThreshold float64 `ini:"spike_threshold"`
+	// UploadRejected uploads rejected readings with their quality flag
+	// instead of dropping them
+	UploadRejected bool `ini:"upload_rejected"`
+}
+
+// MQTTConfig represents the MQTT uplink. Topics and the client ID may
+// contain {collector_id}, which is replaced by the collector ID of the
+// channel.
+type MQTTConfig struct {
+	Enabled   bool          `ini:"enabled"`
+	Broker    string        `ini:"broker"` // e.g. tcp://localhost:1883 or ssl://broker:8883
+	ClientID  string        `ini:"client_id"`
+	Username  string        `ini:"username"`
+	Password  string        `ini:"password"`
+	CACert    string        `ini:"ca_cert"`    // CA certificates of ssl:// brokers, the system pool if empty
+	KeepAlive time.Duration `ini:"keep_alive"` // seconds
+	// Topics of the readings, the heartbeats and the retained online status
+	DataTopic      string `ini:"data_topic"`
+	HeartbeatTopic string `ini:"heartbeat_topic"`
+	StatusTopic    string `ini:"status_topic"`
+}
+
+// CollectorIDPlaceholder is replaced by the collector ID in MQTT topics and
+// client IDs
+const CollectorIDPlaceholder = "{collector_id}"
+
+// Metrics of a reading alarm rules can watch
+const (
+	MetricVoltage     = "voltage"
+	MetricCurrent     = "current"
+	MetricPower       = "power"
+	MetricFrequency   = "frequency"
+	MetricPowerFactor = "power_factor"
+	MetricMeterAlarm  = "meter_alarm" // the alarm flag of the meter, 1 when set
+)
+
+// AlarmRuleConfig represents a local alarm rule evaluated on every sample.
+// The alarm is raised when the metric leaves the range between Min and Max
+// for Delay seconds, and cleared when it is back inside the range by
+// Hysteresis.
+type AlarmRuleConfig struct {
+	Name       string        `ini:"-"`
+	Metric     string        `ini:"metric"`
+	Min        *float64      `ini:"min"`
+	Max        *float64      `ini:"max"`
+	Hysteresis float64       `ini:"hysteresis"`
+	Delay      time.Duration `ini:"delay"`
+	Channels   []string      `ini:"channels"` // keys of the channels watched, all if empty
+	// Actions run when the alarm is raised or cleared
+	Command string `ini:"command"`
+	Webhook string `ini:"webhook"`
 }
 
 // LoggingConfig represents logging configuration
@@ -68,8 +245,18 @@ type LoggingConfig struct {
 	MaxAge     int    `ini:"max_age"`
 }
 
+// Section name prefixes of channel and alarm rule definitions
+const (
+	channelSectionPrefix = "channel."
+	alarmSectionPrefix   = "alarm."
+)
+
 var globalConfig *Config
 
+// MinSpikeWindow is the fewest recent readings the spread of a measurement
+// is estimated from
+const MinSpikeWindow = 5
+
 // LoadConfig loads configuration from file
 func LoadConfig(configFile string) (*Config, error) {
 	// Check if config file exists
@@ -82,11 +269,42 @@ func LoadConfig(configFile string) (*Config, error) {
 		return nil, fmt.Errorf("failed to load config file: %w", err)
 	}
 
-	config := &Config{}
+	config := &Config{
+		Adaptive: AdaptiveConfig{
+			PowerDelta:   DefaultAdaptivePowerDelta,
+			PowerPercent: DefaultAdaptivePowerPercent,
+		},
+	}
 	if err := cfg.MapTo(config); err != nil {
 		return nil, fmt.Errorf("failed to parse config: %w", err)
 	}
 
+	// Parse channel sections
+	for _, section := range cfg.Sections() {
+		key, ok := strings.CutPrefix(section.Name(), channelSectionPrefix)
+		if !ok || key == "" {
+			continue
+		}
+		channel := ChannelConfig{Key: key}
+		if err := section.MapTo(&channel); err != nil {
+			return nil, fmt.Errorf("failed to parse channel %s: %w", key, err)
+		}
+		config.Channels = append(config.Channels, channel)
+	}
+
+	// Parse alarm rule sections
+	for _, section := range cfg.Sections() {
+		name, ok := strings.CutPrefix(section.Name(), alarmSectionPrefix)
+		if !ok || name == "" {
+			continue
+		}
+		rule := AlarmRuleConfig{Name: name}
+		if err := section.MapTo(&rule); err != nil {
+			return nil, fmt.Errorf("failed to parse alarm rule %s: %w", name, err)
+		}
+		config.AlarmRules = append(config.AlarmRules, rule)
+	}
+
 	// Validate required fields
 	if err := validateConfig(config); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
@@ -107,11 +325,10 @@ func validateConfig(config *Config) error {
 		return fmt.Errorf("collector name is required")
 	}
 
-	if config.Serial.Port == "" {
-		return fmt.Errorf("serial port is required")
-	}
-
-	if config.Serial.BaudRate <= 0 {
+	// The serial port is checked when the meters are opened, as drivers
+	// without hardware do not need one. Network gateways ignore the baud
+	// rate.
+	if config.Serial.BaudRate <= 0 && !strings.Contains(config.Serial.Port, "://") {
 		return fmt.Errorf("invalid baud rate: %d", config.Serial.BaudRate)
 	}
 
@@ -119,18 +336,271 @@ func validateConfig(config *Config) error {
 		return fmt.Errorf("server base URL is required")
 	}
 
-	if config.Auth.Token == "" && config.Auth.RegistrationCode == "" {
-		return fmt.Errorf("either token or registration code is required")
+	if config.Serial.Driver == "" {
+		config.Serial.Driver = "pzem004t"
+	}
+
+	if config.Serial.StopBits == 0 {
+		config.Serial.StopBits = 1
+	}
+	if config.Serial.StopBits != 1 && config.Serial.StopBits != 2 {
+		return fmt.Errorf("invalid stop bits: %d", config.Serial.StopBits)
+	}
+
+	if config.Serial.Address == 0 {
+		config.Serial.Address = 1
+	}
+
+	if len(config.Channels) == 0 {
+		if config.Auth.Token == "" && config.Auth.RegistrationCode == "" && config.Auth.ClientCert == "" {
+			return fmt.Errorf("either token, registration code or client certificate is required")
+		}
+		if (config.Auth.ClientCert == "") != (config.Auth.ClientKey == "") {
+			return fmt.Errorf("client certificate and key must be set together")
+		}
+	}
+
+	addresses := make(map[int]string)
+	for i := range config.Channels {
+		channel := &config.Channels[i]
+		if channel.Driver == "" {
+			channel.Driver = config.Serial.Driver
+		}
+		if channel.Address == 0 {
+			channel.Address = config.Serial.Address
+		}
+		if channel.Address < 1 || channel.Address > 247 {
+			return fmt.Errorf("channel %s: invalid slave address: %d", channel.Key, channel.Address)
+		}
+		if other, ok := addresses[channel.Address]; ok {
+			return fmt.Errorf("channel %s: slave address %d already used by channel %s", channel.Key, channel.Address, other)
+		}
+		addresses[channel.Address] = channel.Key
+		if channel.Name == "" {
+			return fmt.Errorf("channel %s: name is required", channel.Key)
+		}
+		if channel.Token == "" && channel.RegistrationCode == "" && channel.ClientCert == "" {
+			return fmt.Errorf("channel %s: either token, registration code or client certificate is required", channel.Key)
+		}
+		if (channel.ClientCert == "") != (channel.ClientKey == "") {
+			return fmt.Errorf("channel %s: client certificate and key must be set together", channel.Key)
+		}
 	}
 
 	if config.Data.MaxCacheSize <= 0 {
 		config.Data.MaxCacheSize = 10000
 	}
 
+	if config.Data.CachePolicy == "" {
+		config.Data.CachePolicy = "drop_oldest"
+	}
+	if config.Data.CachePolicy != "drop_oldest" && config.Data.CachePolicy != "downsample" {
+		return fmt.Errorf("invalid cache policy: %s", config.Data.CachePolicy)
+	}
+
 	if config.Data.BatchSize <= 0 {
 		config.Data.BatchSize = 100
 	}
 
+	if config.Data.Compression == "" {
+		config.Data.Compression = "gzip"
+	}
+	if config.Data.Compression != "gzip" && config.Data.Compression != "zstd" {
+		return fmt.Errorf("invalid compression: %s", config.Data.Compression)
+	}
+	if config.Data.CompressionLevel == 0 {
+		config.Data.CompressionLevel = 6
+	}
+	if config.Data.CompressionLevel < 1 || config.Data.CompressionLevel > 9 {
+		return fmt.Errorf("invalid compression level: %d", config.Data.CompressionLevel)
+	}
+
+	if config.Server.ConfigPollInterval <= 0 {
+		config.Server.ConfigPollInterval = 300
+	}
+
+	if config.Monitor.StallTimeout <= 0 {
+		config.Monitor.StallTimeout = 600
+	}
+
+	if config.Aggregation.Enabled {
+		if config.Aggregation.SampleInterval <= 0 {
+			config.Aggregation.SampleInterval = 1
+		}
+		if config.Aggregation.Window <= 0 {
+			config.Aggregation.Window = 60
+		}
+		if config.Aggregation.Window < config.Aggregation.SampleInterval {
+			return fmt.Errorf("aggregation window of %ds is shorter than the sample interval of %ds",
+				config.Aggregation.Window, config.Aggregation.SampleInterval)
+		}
+	}
+
+	if config.Adaptive.Enabled {
+		if err := validateAdaptive(&config.Adaptive); err != nil {
+			return fmt.Errorf("adaptive: %w", err)
+		}
+		if config.Aggregation.Enabled {
+			return fmt.Errorf("adaptive sampling and aggregation cannot be enabled together")
+		}
+	}
+
+	if err := validateValidation(&config.Validation); err != nil {
+		return fmt.Errorf("validation: %w", err)
+	}
+
+	if config.MQTT.Enabled {
+		if err := validateMQTT(&config.MQTT); err != nil {
+			return fmt.Errorf("mqtt: %w", err)
+		}
+	}
+
+	channelKeys := make(map[string]bool)
+	for _, channel := range config.MeterChannels() {
+		channelKeys[channel.Key] = true
+	}
+	for i := range config.AlarmRules {
+		if err := validateAlarmRule(&config.AlarmRules[i], channelKeys); err != nil {
+			return fmt.Errorf("alarm rule %s: %w", config.AlarmRules[i].Name, err)
+		}
+	}
+
+	return nil
+}
+
+// validateAdaptive checks the adaptive sampling settings and sets the
+// defaults of the intervals. The thresholds are defaulted by LoadConfig.
+func validateAdaptive(a *AdaptiveConfig) error {
+	if a.SampleInterval <= 0 {
+		a.SampleInterval = 1
+	}
+	if a.MaxInterval <= 0 {
+		a.MaxInterval = 60
+	}
+	if a.PowerDelta < 0 || a.PowerPercent < 0 {
+		return fmt.Errorf("power change thresholds must not be negative")
+	}
+
+	if a.MaxInterval < a.SampleInterval {
+		return fmt.Errorf("maximum interval of %ds is shorter than the sample interval of %ds", a.MaxInterval, a.SampleInterval)
+	}
+	return nil
+}
+
+// validateValidation checks the validation limits and sets their defaults
+func validateValidation(v *ValidationConfig) error {
+	if v.VoltageMax <= 0 {
+		v.VoltageMax = 300
+	}
+	if v.CurrentMax <= 0 {
+		v.CurrentMax = 100
+	}
+	if v.PowerMax <= 0 {
+		v.PowerMax = 30000
+	}
+	if v.FrequencyMin <= 0 {
+		v.FrequencyMin = 45
+	}
+	if v.FrequencyMax <= 0 {
+		v.FrequencyMax = 65
+	}
+	if v.SpikeWindow <= 0 {
+		v.SpikeWindow = 15
+	}
+	if v.SpikeThreshold <= 0 {
+		v.SpikeThreshold = 8
+	}
+
+	if v.VoltageMin < 0 || v.VoltageMin >= v.VoltageMax {
+		return fmt.Errorf("invalid voltage range %g-%g V", v.VoltageMin, v.VoltageMax)
+	}
+	if v.FrequencyMin >= v.FrequencyMax {
+		return fmt.Errorf("invalid frequency range %g-%g Hz", v.FrequencyMin, v.FrequencyMax)
+	}
+	if v.SpikeWindow < MinSpikeWindow {
+		return fmt.Errorf("spike window of %d readings is shorter than %d", v.SpikeWindow, MinSpikeWindow)
+	}
+	return nil
+}
+
+// validateMQTT validates the MQTT uplink and sets its defaults
+func validateMQTT(mqtt *MQTTConfig) error {
+	if mqtt.Broker == "" {
+		return fmt.Errorf("broker is required")
+	}
+	if mqtt.ClientID == "" {
+		mqtt.ClientID = "power-collector-" + CollectorIDPlaceholder
+	}
+	if !strings.Contains(mqtt.ClientID, CollectorIDPlaceholder) {
+		// Every channel connects with its own session
+		return fmt.Errorf("client ID must contain %s", CollectorIDPlaceholder)
+	}
+	if mqtt.KeepAlive <= 0 {
+		mqtt.KeepAlive = 60
+	}
+
+	topics := []struct {
+		name  string
+		topic *string
+		def   string
+	}{
+		{"data topic", &mqtt.DataTopic, "power/" + CollectorIDPlaceholder + "/data"},
+		{"heartbeat topic", &mqtt.HeartbeatTopic, "power/" + CollectorIDPlaceholder + "/heartbeat"},
+		{"status topic", &mqtt.StatusTopic, "power/" + CollectorIDPlaceholder + "/status"},
+	}
+	for _, t := range topics {
+		if *t.topic == "" {
+			*t.topic = t.def
+		}
+		// The server subscribes with a wildcard in place of the collector
+		// ID, which has to fill a whole topic level
+		if !slices.Contains(strings.Split(*t.topic, "/"), CollectorIDPlaceholder) {
+			return fmt.Errorf("%s must contain %s as a topic level", t.name, CollectorIDPlaceholder)
+		}
+		if strings.ContainsAny(*t.topic, "+#") {
+			return fmt.Errorf("%s must not contain wildcards: %s", t.name, *t.topic)
+		}
+	}
+
+	return nil
+}
+
+// validateAlarmRule validates an alarm rule against the keys of the
+// configured channels
+func validateAlarmRule(rule *AlarmRuleConfig, channelKeys map[string]bool) error {
+	switch rule.Metric {
+	case MetricVoltage, MetricCurrent, MetricPower, MetricFrequency, MetricPowerFactor:
+		if rule.Min == nil && rule.Max == nil {
+			return fmt.Errorf("min or max is required")
+		}
+		if rule.Min != nil && rule.Max != nil && *rule.Min >= *rule.Max {
+			return fmt.Errorf("min %v is not below max %v", *rule.Min, *rule.Max)
+		}
+	case MetricMeterAlarm:
+		if rule.Min != nil || rule.Max != nil {
+			return fmt.Errorf("min and max do not apply to the meter alarm")
+		}
+	case "":
+		return fmt.Errorf("metric is required")
+	default:
+		return fmt.Errorf("unknown metric: %s", rule.Metric)
+	}
+
+	if rule.Hysteresis < 0 {
+		return fmt.Errorf("invalid hysteresis: %v", rule.Hysteresis)
+	}
+	if rule.Delay < 0 {
+		return fmt.Errorf("invalid delay: %d", rule.Delay)
+	}
+	for _, key := range rule.Channels {
+		if !channelKeys[key] {
+			return fmt.Errorf("unknown channel: %s", key)
+		}
+	}
+	if rule.Webhook != "" && !strings.HasPrefix(rule.Webhook, "http://") && !strings.HasPrefix(rule.Webhook, "https://") {
+		return fmt.Errorf("webhook is not an HTTP URL: %s", rule.Webhook)
+	}
+
 	return nil
 }
 
@@ -142,9 +612,74 @@ func SaveConfig(config *Config, configFile string) error {
 		return fmt.Errorf("failed to convert config to ini: %w", err)
 	}
 
+	for i := range config.Channels {
+		channel := &config.Channels[i]
+		section, err := cfg.NewSection(channelSectionPrefix + channel.Key)
+		if err != nil {
+			return fmt.Errorf("failed to create channel section %s: %w", channel.Key, err)
+		}
+		if err := section.ReflectFrom(channel); err != nil {
+			return fmt.Errorf("failed to convert channel %s to ini: %w", channel.Key, err)
+		}
+	}
+
+	for i := range config.AlarmRules {
+		rule := &config.AlarmRules[i]
+		section, err := cfg.NewSection(alarmSectionPrefix + rule.Name)
+		if err != nil {
+			return fmt.Errorf("failed to create alarm rule section %s: %w", rule.Name, err)
+		}
+		if err := section.ReflectFrom(rule); err != nil {
+			return fmt.Errorf("failed to convert alarm rule %s to ini: %w", rule.Name, err)
+		}
+	}
+
 	if err := cfg.SaveTo(configFile); err != nil {
 		return fmt.Errorf("failed to save config file: %w", err)
 	}
 
 	return nil
 }
+
+// MeterChannels returns the channels to poll. A configuration without any
+// [channel.<key>] section yields a single channel for the collector itself.
+func (c *Config) MeterChannels() []ChannelConfig {
+	if len(c.Channels) > 0 {
+		return c.Channels
+	}
+
+	return []ChannelConfig{{
+		Key:              "default",
+		Driver:           c.Serial.Driver,
+		Address:          c.Serial.Address,
+		ID:               c.Collector.ID,
+		Name:             c.Collector.Name,
+		Description:      c.Collector.Description,
+		Location:         c.Collector.Location,
+		Token:            c.Auth.Token,
+		RegistrationCode: c.Auth.RegistrationCode,
+		ClientCert:       c.Auth.ClientCert,
+		ClientKey:        c.Auth.ClientKey,
+		SigningSecret:    c.Auth.SigningSecret,
+	}}
+}
+
+// UpdateChannel stores changes made to a channel returned by MeterChannels,
+// such as the token and ID assigned during registration or a changed slave
+// address
+func (c *Config) UpdateChannel(channel ChannelConfig) {
+	if len(c.Channels) == 0 {
+		c.Collector.ID = channel.ID
+		c.Auth.Token = channel.Token
+		c.Auth.SigningSecret = channel.SigningSecret
+		c.Serial.Address = channel.Address
+		return
+	}
+
+	for i := range c.Channels {
+		if c.Channels[i].Key == channel.Key {
+			c.Channels[i] = channel
+			return
+		}
+	}
+}
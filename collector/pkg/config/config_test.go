@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -118,6 +119,10 @@ max_age = 30
 	if !cfg.Data.AutoUpload {
 		t.Error("Expected auto upload to be true")
 	}
+
+	if cfg.Monitor.StallTimeout != 600 {
+		t.Errorf("Expected default stall timeout 600, got %v", cfg.Monitor.StallTimeout)
+	}
 	globalConfig = nil
 }
 
@@ -212,6 +217,72 @@ func TestValidateConfig(t *testing.T) {
 			},
 			expectError: true,
 		},
+		{
+			name: "Client certificate instead of token",
+			config: &Config{
+				Collector: CollectorConfig{
+					ID:   "test-id",
+					Name: "test-name",
+				},
+				Serial: SerialConfig{
+					Port:     "/dev/ttyUSB0",
+					BaudRate: 9600,
+				},
+				Server: ServerConfig{
+					BaseURL: "https://localhost:8443",
+				},
+				Auth: AuthConfig{
+					ClientCert: "client.crt",
+					ClientKey:  "client.key",
+				},
+			},
+			expectError: false,
+		},
+		{
+			name: "Client certificate without key",
+			config: &Config{
+				Collector: CollectorConfig{
+					ID:   "test-id",
+					Name: "test-name",
+				},
+				Serial: SerialConfig{
+					Port:     "/dev/ttyUSB0",
+					BaudRate: 9600,
+				},
+				Server: ServerConfig{
+					BaseURL: "https://localhost:8443",
+				},
+				Auth: AuthConfig{
+					ClientCert: "client.crt",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Aggregation window shorter than the sample interval",
+			config: &Config{
+				Collector: CollectorConfig{
+					ID:   "test-id",
+					Name: "test-name",
+				},
+				Serial: SerialConfig{
+					Port:     "/dev/ttyUSB0",
+					BaudRate: 9600,
+				},
+				Server: ServerConfig{
+					BaseURL: "http://localhost:8080",
+				},
+				Auth: AuthConfig{
+					Token: "test-token",
+				},
+				Aggregation: AggregationConfig{
+					Enabled:        true,
+					SampleInterval: 10,
+					Window:         5,
+				},
+			},
+			expectError: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -254,3 +325,342 @@ func TestGetConfig(t *testing.T) {
 	// Reset global config
 	globalConfig = nil
 }
+
+func TestLoadConfigWithChannels(t *testing.T) {
+	configContent := `
+[collector]
+name = Panel Collector
+
+[serial]
+port = /dev/ttyUSB0
+baud_rate = 9600
+
+[server]
+base_url = http://localhost:8080
+
+[channel.kitchen]
+address = 1
+id = kitchen-collector
+name = Kitchen
+location = Floor 1
+token = kitchen-token
+
+[channel.garage]
+address = 2
+name = Garage
+registration_code = REG-GARAGE
+`
+
+	tempFile, err := os.CreateTemp("", "test_config_*.ini")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tempFile.Name())
+
+	if _, err := tempFile.WriteString(configContent); err != nil {
+		t.Fatalf("Failed to write config content: %v", err)
+	}
+	tempFile.Close()
+
+	cfg, err := LoadConfig(tempFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	defer func() { globalConfig = nil }()
+
+	channels := cfg.MeterChannels()
+	if len(channels) != 2 {
+		t.Fatalf("Expected 2 channels, got %d", len(channels))
+	}
+	if channels[0].Key != "kitchen" || channels[0].Address != 1 || channels[0].Token != "kitchen-token" {
+		t.Errorf("Unexpected kitchen channel: %+v", channels[0])
+	}
+	if channels[1].Key != "garage" || channels[1].Address != 2 || channels[1].RegistrationCode != "REG-GARAGE" {
+		t.Errorf("Unexpected garage channel: %+v", channels[1])
+	}
+
+	// Registration results must survive a save and reload
+	garage := channels[1]
+	garage.ID = "garage-collector"
+	garage.Token = "garage-token"
+	cfg.UpdateChannel(garage)
+
+	if err := SaveConfig(cfg, tempFile.Name()); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+
+	reloaded, err := LoadConfig(tempFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to reload config: %v", err)
+	}
+	if len(reloaded.Channels) != 2 || reloaded.Channels[1].Token != "garage-token" || reloaded.Channels[1].ID != "garage-collector" {
+		t.Errorf("Channel registration not persisted: %+v", reloaded.Channels)
+	}
+}
+
+func TestMeterChannelsDefault(t *testing.T) {
+	cfg := &Config{
+		Collector: CollectorConfig{ID: "single", Name: "Single"},
+		Serial:    SerialConfig{Address: 3},
+		Auth:      AuthConfig{Token: "single-token"},
+	}
+
+	channels := cfg.MeterChannels()
+	if len(channels) != 1 {
+		t.Fatalf("Expected 1 channel, got %d", len(channels))
+	}
+	if channels[0].ID != "single" || channels[0].Address != 3 || channels[0].Token != "single-token" {
+		t.Errorf("Unexpected default channel: %+v", channels[0])
+	}
+
+	channels[0].Token = "new-token"
+	cfg.UpdateChannel(channels[0])
+	if cfg.Auth.Token != "new-token" {
+		t.Errorf("Expected auth token to be updated, got '%s'", cfg.Auth.Token)
+	}
+}
+
+func TestLoadConfigWithAlarmRules(t *testing.T) {
+	configContent := `
+[collector]
+name = Alarm Collector
+
+[serial]
+port = /dev/ttyUSB0
+baud_rate = 9600
+
+[server]
+base_url = http://localhost:8080
+
+[auth]
+token = alarm-token
+
+[alarm.over_power]
+metric = power
+max = 2000
+hysteresis = 100
+delay = 30
+command = /usr/local/bin/shed-load
+
+[alarm.voltage]
+metric = voltage
+min = 0
+max = 253
+webhook = http://localhost:9000/hook
+`
+
+	tempFile, err := os.CreateTemp("", "test_config_*.ini")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tempFile.Name())
+
+	if _, err := tempFile.WriteString(configContent); err != nil {
+		t.Fatalf("Failed to write config content: %v", err)
+	}
+	tempFile.Close()
+
+	cfg, err := LoadConfig(tempFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	defer func() { globalConfig = nil }()
+
+	if len(cfg.AlarmRules) != 2 {
+		t.Fatalf("Expected 2 alarm rules, got %d", len(cfg.AlarmRules))
+	}
+	power := cfg.AlarmRules[0]
+	if power.Name != "over_power" || power.Min != nil || power.Max == nil || *power.Max != 2000 || power.Delay != 30 {
+		t.Errorf("Unexpected over_power rule: %+v", power)
+	}
+	voltage := cfg.AlarmRules[1]
+	if voltage.Min == nil || *voltage.Min != 0 || voltage.Webhook != "http://localhost:9000/hook" {
+		t.Errorf("Unexpected voltage rule: %+v", voltage)
+	}
+
+	// Rules must survive a save and reload, such as after a token rotation
+	if err := SaveConfig(cfg, tempFile.Name()); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+	reloaded, err := LoadConfig(tempFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to reload config: %v", err)
+	}
+	if len(reloaded.AlarmRules) != 2 || reloaded.AlarmRules[0].Min != nil || *reloaded.AlarmRules[0].Max != 2000 ||
+		reloaded.AlarmRules[0].Command != "/usr/local/bin/shed-load" {
+		t.Errorf("Alarm rules not persisted: %+v", reloaded.AlarmRules)
+	}
+}
+
+func TestValidateAlarmRule(t *testing.T) {
+	limit := func(v float64) *float64 { return &v }
+	channels := map[string]bool{"default": true}
+
+	tests := []struct {
+		name    string
+		rule    AlarmRuleConfig
+		wantErr bool
+	}{
+		{"upper limit", AlarmRuleConfig{Metric: MetricCurrent, Max: limit(10)}, false},
+		{"band", AlarmRuleConfig{Metric: MetricFrequency, Min: limit(49.5), Max: limit(50.5)}, false},
+		{"meter alarm", AlarmRuleConfig{Metric: MetricMeterAlarm, Channels: []string{"default"}}, false},
+		{"missing metric", AlarmRuleConfig{Max: limit(10)}, true},
+		{"unknown metric", AlarmRuleConfig{Metric: "temperature", Max: limit(10)}, true},
+		{"no limits", AlarmRuleConfig{Metric: MetricPower}, true},
+		{"inverted band", AlarmRuleConfig{Metric: MetricVoltage, Min: limit(250), Max: limit(200)}, true},
+		{"meter alarm with limit", AlarmRuleConfig{Metric: MetricMeterAlarm, Max: limit(1)}, true},
+		{"negative hysteresis", AlarmRuleConfig{Metric: MetricPower, Max: limit(10), Hysteresis: -1}, true},
+		{"unknown channel", AlarmRuleConfig{Metric: MetricPower, Max: limit(10), Channels: []string{"garage"}}, true},
+		{"invalid webhook", AlarmRuleConfig{Metric: MetricPower, Max: limit(10), Webhook: "localhost:9000"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateAlarmRule(&tt.rule, channels)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateAlarmRule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateMQTT(t *testing.T) {
+	mqtt := MQTTConfig{Enabled: true, Broker: "tcp://localhost:1883"}
+	if err := validateMQTT(&mqtt); err != nil {
+		t.Fatalf("validateMQTT() error = %v", err)
+	}
+	if mqtt.DataTopic != "power/{collector_id}/data" {
+		t.Errorf("Expected default data topic, got %s", mqtt.DataTopic)
+	}
+	if mqtt.ClientID != "power-collector-{collector_id}" {
+		t.Errorf("Expected default client ID, got %s", mqtt.ClientID)
+	}
+
+	tests := []struct {
+		name string
+		mqtt MQTTConfig
+	}{
+		{"missing broker", MQTTConfig{}},
+		{"shared client ID", MQTTConfig{Broker: "tcp://localhost:1883", ClientID: "collector"}},
+		{"topic without collector ID", MQTTConfig{Broker: "tcp://localhost:1883", DataTopic: "power/data"}},
+		{"collector ID within a level", MQTTConfig{Broker: "tcp://localhost:1883", DataTopic: "power/meter-{collector_id}/data"}},
+		{"wildcard", MQTTConfig{Broker: "tcp://localhost:1883", StatusTopic: "power/{collector_id}/#"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateMQTT(&tt.mqtt); err == nil {
+				t.Error("validateMQTT() expected an error")
+			}
+		})
+	}
+}
+
+func TestValidateValidation(t *testing.T) {
+	v := ValidationConfig{}
+	if err := validateValidation(&v); err != nil {
+		t.Fatalf("validateValidation() error = %v", err)
+	}
+	if v.VoltageMax != 300 || v.FrequencyMin != 45 || v.SpikeWindow != 15 {
+		t.Errorf("Expected default limits, got %+v", v)
+	}
+
+	tests := []struct {
+		name string
+		v    ValidationConfig
+	}{
+		{"empty voltage range", ValidationConfig{VoltageMin: 250, VoltageMax: 200}},
+		{"empty frequency range", ValidationConfig{FrequencyMin: 65, FrequencyMax: 45}},
+		{"short spike window", ValidationConfig{SpikeWindow: 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateValidation(&tt.v); err == nil {
+				t.Error("validateValidation() expected an error")
+			}
+		})
+	}
+}
+
+func TestValidateAdaptive(t *testing.T) {
+	a := AdaptiveConfig{Enabled: true}
+	if err := validateAdaptive(&a); err != nil {
+		t.Fatalf("validateAdaptive() error = %v", err)
+	}
+	if a.SampleInterval != 1 || a.MaxInterval != 60 {
+		t.Errorf("Expected default intervals, got %+v", a)
+	}
+	if a.PowerDelta != 0 || a.PowerPercent != 0 {
+		t.Errorf("Expected disabled thresholds to stay disabled, got %+v", a)
+	}
+
+	tests := []struct {
+		name string
+		a    AdaptiveConfig
+	}{
+		{"negative power delta", AdaptiveConfig{PowerDelta: -1}},
+		{"negative power percent", AdaptiveConfig{PowerPercent: -1}},
+		{"maximum interval shorter than the sample interval", AdaptiveConfig{SampleInterval: 10, MaxInterval: 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateAdaptive(&tt.a); err == nil {
+				t.Error("validateAdaptive() expected an error")
+			}
+		})
+	}
+}
+
+func TestLoadConfigAdaptiveThresholds(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings string
+		delta    float64
+		percent  float64
+	}{
+		{"defaults", "", DefaultAdaptivePowerDelta, DefaultAdaptivePowerPercent},
+		{"delta disabled", "power_delta = 0", 0, DefaultAdaptivePowerPercent},
+		{"both disabled", "power_delta = 0\npower_percent = 0", 0, 0},
+		{"set", "power_delta = 25\npower_percent = 2", 25, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configContent := `
+[collector]
+name = Test Collector
+
+[serial]
+port = /dev/ttyUSB0
+baud_rate = 9600
+
+[server]
+base_url = http://localhost:8080
+
+[auth]
+token = test-token
+
+[adaptive]
+enabled = true
+` + tt.settings + "\n"
+
+			path := filepath.Join(t.TempDir(), "config.ini")
+			if err := os.WriteFile(path, []byte(configContent), 0644); err != nil {
+				t.Fatalf("Failed to write config content: %v", err)
+			}
+
+			cfg, err := LoadConfig(path)
+			if err != nil {
+				t.Fatalf("Failed to load config: %v", err)
+			}
+			defer func() { globalConfig = nil }()
+
+			if cfg.Adaptive.PowerDelta != tt.delta || cfg.Adaptive.PowerPercent != tt.percent {
+				t.Errorf("Expected thresholds of %v W and %v%%, got %v W and %v%%",
+					tt.delta, tt.percent, cfg.Adaptive.PowerDelta, cfg.Adaptive.PowerPercent)
+			}
+		})
+	}
+}
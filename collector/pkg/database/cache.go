@@ -2,9 +2,12 @@ package database
 
 import (
 	"fmt"
+	"math/rand/v2"
+	"sync"
 	"time"
 
-	"power-collector/pkg/pzem"
+	"power-collector/pkg/clock"
+	"power-collector/pkg/meter"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -15,6 +18,7 @@ import (
 type PowerDataCache struct {
 	ID          uint      `gorm:"primaryKey"`
 	CollectorID string    `gorm:"index;not null"`
+	Seq         uint64    `gorm:"index"` // sequence number the server deduplicates on
 	Timestamp   time.Time `gorm:"not null"`
 	Voltage     float64   `json:"voltage"`
 	Current     float64   `json:"current"`
@@ -23,13 +27,107 @@ type PowerDataCache struct {
 	Frequency   float64   `json:"frequency"`
 	PowerFactor float64   `json:"power_factor"`
 	Uploaded    bool      `gorm:"default:false;index"`
+	// Samples is the number of readings the row holds: 1 for a single
+	// reading, the samples of the window for the summary of an
+	// aggregation window, and those merged for a downsampled row. Dropping
+	// the row loses all of them.
+	Samples     int  `gorm:"default:1"`
+	Downsampled bool `gorm:"default:false"`
+	// Set for the summaries of aggregation windows, see meter.Aggregate
+	WindowSeconds  int `gorm:"default:0"` // 0 for single readings
+	VoltageMin     float64
+	VoltageMax     float64
+	CurrentMin     float64
+	CurrentMax     float64
+	PowerMin       float64
+	PowerMax       float64
+	PowerFactorMin float64
+	PowerFactorMax float64
+	EnergyDelta    float64
+	// TimeUnreliable is set for readings taken before the clock was
+	// synchronized with the server. Those taken by the running process are
+	// corrected once it is, from their Uptime, the time since the start of
+	// the Run by the monotonic clock.
+	TimeUnreliable bool          `gorm:"default:false;index"`
+	Run            int64         // start of the process, Unix nanoseconds
+	Uptime         time.Duration // nanoseconds
+	// Quality is set for readings rejected as implausible, which are
+	// uploaded flagged
+	Quality   string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Cache eviction policies applied when the cache is full
+const (
+	PolicyDropOldest = "drop_oldest" // delete the oldest unuploaded readings
+	PolicyDownsample = "downsample"  // merge the oldest unuploaded readings into per-minute averages
+)
+
+// Kinds of cache loss
+const (
+	LossDropped     = "dropped"
+	LossDownsampled = "downsampled"
+)
+
+// downsampleChunk is the number of readings merged per step of the
+// downsample policy
+const downsampleChunk = 1000
+
+// CacheLoss records unuploaded readings removed from the cache to keep it
+// within its size limit, until it is reported to the server
+type CacheLoss struct {
+	ID          uint      `gorm:"primaryKey"`
+	CollectorID string    `gorm:"index;not null"`
+	Policy      string    `gorm:"not null"` // LossDropped or LossDownsampled
+	Records     int       // number of readings dropped or folded into averages
+	From        time.Time // timestamp of the first reading affected
+	To          time.Time // timestamp of the last reading affected
+	Reported    bool      `gorm:"default:false;index"`
+	CreatedAt   time.Time
+}
+
+// States of an alarm event
+const (
+	AlarmRaised  = "raised"
+	AlarmCleared = "cleared"
+)
+
+// AlarmEvent records a local alarm rule being raised or cleared, until it is
+// uploaded to the server
+type AlarmEvent struct {
+	ID          uint      `gorm:"primaryKey"`
+	CollectorID string    `gorm:"index;not null"`
+	Rule        string    `gorm:"not null"` // name of the alarm rule
+	Metric      string    // metric the rule watches
+	State       string    `gorm:"not null"` // AlarmRaised or AlarmCleared
+	Value       float64   // value of the metric when the state changed
+	Threshold   float64   // limit the value crossed
+	Timestamp   time.Time `gorm:"not null"`
+	Uploaded    bool      `gorm:"default:false;index"`
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 }
 
+// Sequence holds the last sequence number assigned to a reading of a
+// collector
+type Sequence struct {
+	CollectorID string `gorm:"primaryKey"`
+	Last        uint64
+}
+
 // CacheDB represents the local cache database
 type CacheDB struct {
 	db *gorm.DB
+
+	// clock tells the time since the start of the run of readings taken
+	// before it is synchronized, nil if it is not tracked
+	clock *clock.Clock
+
+	// mu guards the size limit and serializes its enforcement
+	mu      sync.Mutex
+	maxSize int
+	policy  string
 }
 
 // NewCacheDB creates a new cache database instance
@@ -41,12 +139,44 @@ func NewCacheDB(dbPath string) (*CacheDB, error) {
 		return nil, fmt.Errorf("failed to open cache database: %w", err)
 	}
 
+	// SQLite takes one writer at a time. The loops and the commands write
+	// concurrently, so they share a single connection rather than fail with
+	// "database is locked".
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cache database: %w", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+
 	// Auto-migrate the schema
-	if err := db.AutoMigrate(&PowerDataCache{}); err != nil {
+	if err := db.AutoMigrate(&PowerDataCache{}, &CacheLoss{}, &AlarmEvent{}, &Sequence{}); err != nil {
 		return nil, fmt.Errorf("failed to migrate database schema: %w", err)
 	}
 
-	return &CacheDB{db: db}, nil
+	return &CacheDB{db: db, policy: PolicyDropOldest}, nil
+}
+
+// SetLimit bounds the number of rows in the cache. When a new reading
+// exceeds maxSize, uploaded rows are deleted first, then the policy is
+// applied to the oldest unuploaded rows. A maxSize of 0 disables the limit.
+func (c *CacheDB) SetLimit(maxSize int, policy string) error {
+	if policy != PolicyDropOldest && policy != PolicyDownsample {
+		return fmt.Errorf("unknown cache policy: %s", policy)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxSize = maxSize
+	c.policy = policy
+	return nil
+}
+
+// SetClock records the time since the start of the process of readings
+// stored before the clock is synchronized, so that CorrectTimestamps can
+// correct them
+func (c *CacheDB) SetClock(clk *clock.Clock) {
+	c.clock = clk
 }
 
 // Close closes the database connection
@@ -58,15 +188,50 @@ func (c *CacheDB) Close() error {
 	return sqlDB.Close()
 }
 
-// StorePowerData stores power data to cache
-func (c *CacheDB) StorePowerData(collectorID string, data interface{}) error {
-	// Handle different data types (from PZEM or API response)
+// seqCounterBits is the number of low bits of a sequence number counting the
+// readings of a counter, below the random epoch of the counter
+const seqCounterBits = 32
+
+// NextSeq returns the next sequence number of a collector. A new counter, as
+// after the cache database was recreated, starts at a random epoch of 31 bits
+// in the bits above seqCounterBits, so that it does not repeat the numbers
+// the server stored before without relying on the clock, which may be wrong
+// after a boot without a real-time clock. The numbers stay below 2^63, which
+// the databases store. Two counters of a collector collide only if they
+// draw the same epoch, with a chance of 1 in 2^31 per recreated cache, or
+// if one assigns more than 2^32 numbers; the server then drops the readings
+// of the later one as duplicates.
+func (c *CacheDB) NextSeq(collectorID string) (uint64, error) {
+	var seq uint64
+	err := c.db.Transaction(func(tx *gorm.DB) error {
+		sequence := Sequence{CollectorID: collectorID}
+		if err := tx.Where(&sequence).FirstOrInit(&sequence).Error; err != nil {
+			return err
+		}
+		if sequence.Last == 0 {
+			sequence.Last = (rand.Uint64N(1<<31-1) + 1) << seqCounterBits
+		}
+		sequence.Last++
+		seq = sequence.Last
+		return tx.Save(&sequence).Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to assign sequence number: %w", err)
+	}
+
+	return seq, nil
+}
+
+// StorePowerData stores power data to cache under its sequence number
+func (c *CacheDB) StorePowerData(collectorID string, seq uint64, data interface{}) error {
+	// Handle different data types (from a meter or API response)
 	var cache PowerDataCache
 
 	switch v := data.(type) {
-	case *pzem.PowerData:
+	case *meter.PowerData:
 		cache = PowerDataCache{
 			CollectorID: collectorID,
+			Seq:         seq,
 			Timestamp:   v.Timestamp,
 			Voltage:     v.Voltage,
 			Current:     v.Current,
@@ -74,12 +239,30 @@ func (c *CacheDB) StorePowerData(collectorID string, data interface{}) error {
 			Energy:      v.Energy,
 			Frequency:   v.Frequency,
 			PowerFactor: v.PowerFactor,
+			Quality:     v.Quality,
 			Uploaded:    false,
 		}
+		if agg := v.Aggregate; agg != nil {
+			cache.Samples = agg.Samples
+			cache.WindowSeconds = int(agg.Window / time.Second)
+			cache.VoltageMin, cache.VoltageMax = agg.VoltageMin, agg.VoltageMax
+			cache.CurrentMin, cache.CurrentMax = agg.CurrentMin, agg.CurrentMax
+			cache.PowerMin, cache.PowerMax = agg.PowerMin, agg.PowerMax
+			cache.PowerFactorMin, cache.PowerFactorMax = agg.PowerFactorMin, agg.PowerFactorMax
+			cache.EnergyDelta = agg.EnergyDelta
+		}
+		if v.TimeUnreliable {
+			cache.TimeUnreliable = true
+			if c.clock != nil {
+				cache.Run = c.clock.Started().UnixNano()
+				cache.Uptime = v.Timestamp.Sub(c.clock.Started())
+			}
+		}
 	case map[string]interface{}:
 		// From JSON/API response
 		cache = PowerDataCache{
 			CollectorID: collectorID,
+			Seq:         seq,
 			Timestamp:   parseTimestamp(v["timestamp"]),
 			Voltage:     parseFloat64(v["voltage"]),
 			Current:     parseFloat64(v["current"]),
@@ -97,13 +280,394 @@ func (c *CacheDB) StorePowerData(collectorID string, data interface{}) error {
 		return fmt.Errorf("failed to store cache data: %w", err)
 	}
 
+	return c.enforceLimit()
+}
+
+// enforceLimit brings the cache back within its size limit
+func (c *CacheDB) enforceLimit() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.maxSize <= 0 {
+		return nil
+	}
+
+	var total int64
+	if err := c.db.Model(&PowerDataCache{}).Count(&total).Error; err != nil {
+		return fmt.Errorf("failed to count cached data: %w", err)
+	}
+	excess := int(total) - c.maxSize
+	if excess <= 0 {
+		return nil
+	}
+
+	// Uploaded rows are already safe on the server
+	result := c.db.Where("id IN (?)",
+		c.db.Model(&PowerDataCache{}).Select("id").Where("uploaded = ?", true).Order("timestamp ASC").Limit(excess)).
+		Delete(&PowerDataCache{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to evict uploaded data: %w", result.Error)
+	}
+	excess -= int(result.RowsAffected)
+
+	if excess > 0 && c.policy == PolicyDownsample {
+		merged, err := c.downsample(excess)
+		if err != nil {
+			return err
+		}
+		excess -= merged
+	}
+
+	// Drop the oldest readings when downsampling was not enough
+	if excess > 0 {
+		if err := c.dropOldest(excess); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-// GetUnuploadedData retrieves data that hasn't been uploaded yet
-func (c *CacheDB) GetUnuploadedData(limit int) ([]PowerDataCache, error) {
+// dropOldest deletes the oldest n unuploaded rows and records the loss of
+// all the readings they hold, including the samples of window summaries
+func (c *CacheDB) dropOldest(n int) error {
+	var rows []PowerDataCache
+	if err := c.db.Where("uploaded = ?", false).Order("timestamp ASC").Limit(n).Find(&rows).Error; err != nil {
+		return fmt.Errorf("failed to retrieve oldest data: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, 0, len(rows))
+	losses := make(map[string]*CacheLoss)
+	var order []string
+	for _, row := range rows {
+		ids = append(ids, row.ID)
+		loss, ok := losses[row.CollectorID]
+		if !ok {
+			loss = &CacheLoss{CollectorID: row.CollectorID, Policy: LossDropped, From: row.Timestamp}
+			losses[row.CollectorID] = loss
+			order = append(order, row.CollectorID)
+		}
+		loss.Records += row.Samples
+		loss.To = row.Timestamp
+	}
+
+	return c.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&PowerDataCache{}, ids).Error; err != nil {
+			return fmt.Errorf("failed to drop oldest data: %w", err)
+		}
+		for _, collectorID := range order {
+			if err := tx.Create(losses[collectorID]).Error; err != nil {
+				return fmt.Errorf("failed to record cache loss: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// downsample merges the oldest unuploaded rows into per-minute averages
+// until at least n rows have been removed or no rows are left to merge. It
+// returns the number of rows removed. Summaries of windows of a minute or
+// longer are left alone, as merging them would not remove any rows.
+func (c *CacheDB) downsample(n int) (int, error) {
+	removed := 0
+	for removed < n {
+		var rows []PowerDataCache
+		err := c.db.Where("uploaded = ? AND downsampled = ? AND window_seconds < ?", false, false, 60).
+			Order("timestamp ASC").
+			Limit(downsampleChunk).
+			Find(&rows).Error
+		if err != nil {
+			return removed, fmt.Errorf("failed to retrieve data to downsample: %w", err)
+		}
+		if len(rows) == 0 {
+			break
+		}
+
+		// Leave the last minute of a full chunk to the next step, as it may
+		// continue past the chunk
+		if len(rows) == downsampleChunk {
+			last := rows[len(rows)-1].Timestamp.Truncate(time.Minute)
+			end := len(rows)
+			for end > 0 && rows[end-1].Timestamp.Truncate(time.Minute).Equal(last) {
+				end--
+			}
+			if end > 0 {
+				rows = rows[:end]
+			}
+		}
+
+		merged, losses := mergeMinutes(rows)
+		ids := make([]uint, 0, len(rows))
+		for _, row := range rows {
+			ids = append(ids, row.ID)
+		}
+
+		err = c.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Delete(&PowerDataCache{}, ids).Error; err != nil {
+				return fmt.Errorf("failed to delete downsampled data: %w", err)
+			}
+			if err := tx.Create(&merged).Error; err != nil {
+				return fmt.Errorf("failed to store downsampled data: %w", err)
+			}
+			for _, loss := range losses {
+				if err := tx.Create(loss).Error; err != nil {
+					return fmt.Errorf("failed to record cache loss: %w", err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return removed, err
+		}
+		removed += len(rows) - len(merged)
+	}
+
+	return removed, nil
+}
+
+// mergeMinutes averages rows by collector and minute. Energy is a counter,
+// so the last reading of each minute is kept. A merged row keeps the
+// sequence number of the first reading of its minute and is timestamped
+// with the start of the minute. Summaries of aggregation windows are only
+// merged with each other, into a summary of the windows of the minute that
+// is timestamped with its last window like the summaries themselves, so
+// that their extremes and energy are kept. Readings with unreliable
+// timestamps are only merged with those of the same run, rejected readings
+// only with those of the same quality. It returns the merged rows and, per
+// collector, the readings folded into the averages of minutes merged from
+// several rows.
+func mergeMinutes(rows []PowerDataCache) ([]PowerDataCache, []*CacheLoss) {
+	type bucketKey struct {
+		collectorID string
+		minute      int64
+		window      bool
+		unreliable  bool
+		run         int64
+		quality     string
+	}
+
+	var merged []PowerDataCache
+	buckets := make(map[bucketKey]int)
+	rowCounts := make(map[int]int)   // rows merged into a bucket after its first
+	first := make(map[int]time.Time) // timestamp of the first row of a bucket
+	last := make(map[int]time.Time)  // timestamp of the last row of a bucket
+	losses := make(map[string]*CacheLoss)
+	var order []string
+
+	for _, row := range rows {
+		minute := row.Timestamp.Truncate(time.Minute)
+		window := row.WindowSeconds > 0
+		key := bucketKey{row.CollectorID, minute.Unix(), window, row.TimeUnreliable, row.Run, row.Quality}
+		i, ok := buckets[key]
+		if !ok {
+			buckets[key] = len(merged)
+			i = len(merged)
+			first[i] = row.Timestamp
+			merged = append(merged, PowerDataCache{
+				CollectorID:    row.CollectorID,
+				Seq:            row.Seq,
+				Timestamp:      minute,
+				Energy:         row.Energy,
+				Downsampled:    true,
+				VoltageMin:     row.VoltageMin,
+				VoltageMax:     row.VoltageMax,
+				CurrentMin:     row.CurrentMin,
+				CurrentMax:     row.CurrentMax,
+				PowerMin:       row.PowerMin,
+				PowerMax:       row.PowerMax,
+				PowerFactorMin: row.PowerFactorMin,
+				PowerFactorMax: row.PowerFactorMax,
+				TimeUnreliable: row.TimeUnreliable,
+				Run:            row.Run,
+				Uptime:         row.Uptime - row.Timestamp.Sub(minute),
+				Quality:        row.Quality,
+			})
+		} else {
+			rowCounts[i]++
+		}
+		last[i] = row.Timestamp
+
+		m := &merged[i]
+		m.Voltage += row.Voltage * float64(row.Samples)
+		m.Current += row.Current * float64(row.Samples)
+		m.Power += row.Power * float64(row.Samples)
+		m.Energy = row.Energy
+		m.Frequency += row.Frequency * float64(row.Samples)
+		m.PowerFactor += row.PowerFactor * float64(row.Samples)
+		m.Samples += row.Samples
+
+		if window {
+			m.Timestamp = row.Timestamp
+			m.Uptime = row.Uptime
+			m.WindowSeconds += row.WindowSeconds
+			m.VoltageMin = min(m.VoltageMin, row.VoltageMin)
+			m.VoltageMax = max(m.VoltageMax, row.VoltageMax)
+			m.CurrentMin = min(m.CurrentMin, row.CurrentMin)
+			m.CurrentMax = max(m.CurrentMax, row.CurrentMax)
+			m.PowerMin = min(m.PowerMin, row.PowerMin)
+			m.PowerMax = max(m.PowerMax, row.PowerMax)
+			m.PowerFactorMin = min(m.PowerFactorMin, row.PowerFactorMin)
+			m.PowerFactorMax = max(m.PowerFactorMax, row.PowerFactorMax)
+			m.EnergyDelta += row.EnergyDelta
+		}
+	}
+
+	for i := range merged {
+		m := &merged[i]
+		samples := float64(m.Samples)
+		m.Voltage /= samples
+		m.Current /= samples
+		m.Power /= samples
+		m.Frequency /= samples
+		m.PowerFactor /= samples
+
+		// All readings of a minute merged from several rows are folded
+		// into its average, the first row's as well
+		if rowCounts[i] > 0 {
+			loss, ok := losses[m.CollectorID]
+			if !ok {
+				loss = &CacheLoss{CollectorID: m.CollectorID, Policy: LossDownsampled, From: first[i]}
+				losses[m.CollectorID] = loss
+				order = append(order, m.CollectorID)
+			}
+			loss.Records += m.Samples
+			if first[i].Before(loss.From) {
+				loss.From = first[i]
+			}
+			if last[i].After(loss.To) {
+				loss.To = last[i]
+			}
+		}
+	}
+
+	result := make([]*CacheLoss, 0, len(order))
+	for _, collectorID := range order {
+		result = append(result, losses[collectorID])
+	}
+	return merged, result
+}
+
+// CorrectTimestamps corrects the timestamps of the readings the running
+// process stored before the clock was synchronized, and returns the number
+// of readings corrected. Nothing is corrected before the clock is
+// synchronized.
+func (c *CacheDB) CorrectTimestamps() (int, error) {
+	if c.clock == nil {
+		return 0, nil
+	}
+	started, ok := c.clock.Correct(c.clock.Started())
+	if !ok {
+		return 0, nil
+	}
+
+	var rows []PowerDataCache
+	if err := c.db.Select("id", "uptime").
+		Where("time_unreliable = ? AND run = ?", true, c.clock.Started().UnixNano()).
+		Find(&rows).Error; err != nil {
+		return 0, fmt.Errorf("failed to find readings to correct: %w", err)
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+
+	err := c.db.Transaction(func(tx *gorm.DB) error {
+		for _, row := range rows {
+			if err := tx.Model(&PowerDataCache{}).Where("id = ?", row.ID).Updates(map[string]interface{}{
+				"timestamp":       started.Add(row.Uptime),
+				"time_unreliable": false,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to correct timestamps: %w", err)
+	}
+
+	return len(rows), nil
+}
+
+// GetUnreportedLosses returns the cache losses of a collector that have not
+// been reported to the server yet
+func (c *CacheDB) GetUnreportedLosses(collectorID string) ([]CacheLoss, error) {
+	var losses []CacheLoss
+	err := c.db.Where("collector_id = ? AND reported = ?", collectorID, false).
+		Order("id ASC").
+		Find(&losses).Error
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve cache losses: %w", err)
+	}
+
+	return losses, nil
+}
+
+// MarkLossesReported marks cache losses as reported to the server
+func (c *CacheDB) MarkLossesReported(ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	err := c.db.Model(&CacheLoss{}).
+		Where("id IN ?", ids).
+		Update("reported", true).Error
+
+	if err != nil {
+		return fmt.Errorf("failed to mark cache losses as reported: %w", err)
+	}
+
+	return nil
+}
+
+// StoreAlarmEvent queues an alarm event for upload
+func (c *CacheDB) StoreAlarmEvent(event *AlarmEvent) error {
+	if err := c.db.Create(event).Error; err != nil {
+		return fmt.Errorf("failed to store alarm event: %w", err)
+	}
+	return nil
+}
+
+// GetUnuploadedAlarmEvents returns the oldest alarm events of a collector
+// that have not been uploaded yet
+func (c *CacheDB) GetUnuploadedAlarmEvents(collectorID string, limit int) ([]AlarmEvent, error) {
+	var events []AlarmEvent
+	err := c.db.Where("collector_id = ? AND uploaded = ?", collectorID, false).
+		Order("id ASC").
+		Limit(limit).
+		Find(&events).Error
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve alarm events: %w", err)
+	}
+
+	return events, nil
+}
+
+// MarkAlarmEventsUploaded marks alarm events as uploaded
+func (c *CacheDB) MarkAlarmEventsUploaded(ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	err := c.db.Model(&AlarmEvent{}).
+		Where("id IN ?", ids).
+		Update("uploaded", true).Error
+
+	if err != nil {
+		return fmt.Errorf("failed to mark alarm events as uploaded: %w", err)
+	}
+
+	return nil
+}
+
+// GetUnuploadedData retrieves data of a collector that hasn't been uploaded yet
+func (c *CacheDB) GetUnuploadedData(collectorID string, limit int) ([]PowerDataCache, error) {
 	var data []PowerDataCache
-	err := c.db.Where("uploaded = ?", false).
+	err := c.db.Where("collector_id = ? AND uploaded = ?", collectorID, false).
 		Order("timestamp ASC").
 		Limit(limit).
 		Find(&data).Error
@@ -143,6 +707,13 @@ func (c *CacheDB) CleanupOldData(olderThan time.Duration) error {
 		return fmt.Errorf("failed to cleanup old data: %w", result.Error)
 	}
 
+	result = c.db.Where("uploaded = ? AND updated_at < ?", true, cutoff).
+		Delete(&AlarmEvent{})
+
+	if result.Error != nil {
+		return fmt.Errorf("failed to cleanup old alarm events: %w", result.Error)
+	}
+
 	return nil
 }
 
@@ -167,6 +738,30 @@ func (c *CacheDB) GetCacheStats() (map[string]int64, error) {
 	// Uploaded records
 	stats["uploaded"] = total - unuploaded
 
+	// Unix time of the oldest unuploaded record, 0 if there is none
+	var oldest PowerDataCache
+	if err := c.db.Where("uploaded = ?", false).Order("timestamp").Limit(1).Find(&oldest).Error; err != nil {
+		return nil, fmt.Errorf("failed to find oldest unuploaded record: %w", err)
+	}
+	stats["oldest_unuploaded"] = 0
+	if oldest.ID != 0 {
+		stats["oldest_unuploaded"] = oldest.Timestamp.Unix()
+	}
+
+	// Readings lost to the size limit
+	var losses []struct {
+		Policy  string
+		Records int64
+	}
+	if err := c.db.Model(&CacheLoss{}).Select("policy, SUM(records) AS records").Group("policy").Scan(&losses).Error; err != nil {
+		return nil, fmt.Errorf("failed to count cache losses: %w", err)
+	}
+	stats[LossDropped] = 0
+	stats[LossDownsampled] = 0
+	for _, loss := range losses {
+		stats[loss.Policy] = loss.Records
+	}
+
 	return stats, nil
 }
 
@@ -185,6 +780,104 @@ func (c *CacheDB) GetLatestData(collectorID string, limit int) ([]PowerDataCache
 	return data, nil
 }
 
+// GetAllData returns all cached data of a collector in chronological order,
+// or of every collector if the cache holds no rows for collectorID
+func (c *CacheDB) GetAllData(collectorID string) ([]PowerDataCache, error) {
+	var data []PowerDataCache
+	query := c.db.Order("timestamp ASC")
+	if collectorID != "" {
+		var count int64
+		if err := c.db.Model(&PowerDataCache{}).Where("collector_id = ?", collectorID).Count(&count).Error; err != nil {
+			return nil, fmt.Errorf("failed to count cached data: %w", err)
+		}
+		if count > 0 {
+			query = query.Where("collector_id = ?", collectorID)
+		}
+	}
+
+	if err := query.Find(&data).Error; err != nil {
+		return nil, fmt.Errorf("failed to retrieve cached data: %w", err)
+	}
+
+	return data, nil
+}
+
+// ListData returns up to limit cached rows, newest first. An empty
+// collectorID matches every collector and a limit of 0 returns all rows.
+func (c *CacheDB) ListData(collectorID string, unuploadedOnly bool, limit int) ([]PowerDataCache, error) {
+	var data []PowerDataCache
+	query := c.db.Order("timestamp DESC")
+	if collectorID != "" {
+		query = query.Where("collector_id = ?", collectorID)
+	}
+	if unuploadedOnly {
+		query = query.Where("uploaded = ?", false)
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	if err := query.Find(&data).Error; err != nil {
+		return nil, fmt.Errorf("failed to retrieve cached data: %w", err)
+	}
+
+	return data, nil
+}
+
+// Purge deletes cached rows of a collector, or of every collector if
+// collectorID is empty, and returns the number of rows deleted. Unless
+// uploadedOnly is set, readings that were never uploaded are deleted too.
+// Sequence numbers are kept so that new readings are not deduplicated
+// against purged ones.
+func (c *CacheDB) Purge(collectorID string, uploadedOnly bool) (int64, error) {
+	query := c.db.Where("1 = 1")
+	if collectorID != "" {
+		query = query.Where("collector_id = ?", collectorID)
+	}
+	if uploadedOnly {
+		query = query.Where("uploaded = ?", true)
+	}
+
+	result := query.Delete(&PowerDataCache{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to purge cached data: %w", result.Error)
+	}
+
+	return result.RowsAffected, nil
+}
+
+// PowerData returns the reading of a row, with the summary of its window if
+// it is an aggregate
+func (data *PowerDataCache) PowerData() *meter.PowerData {
+	powerData := &meter.PowerData{
+		Timestamp:      data.Timestamp,
+		Voltage:        data.Voltage,
+		Current:        data.Current,
+		Power:          data.Power,
+		Energy:         data.Energy,
+		Frequency:      data.Frequency,
+		PowerFactor:    data.PowerFactor,
+		TimeUnreliable: data.TimeUnreliable,
+		Quality:        data.Quality,
+	}
+	if data.WindowSeconds > 0 {
+		powerData.Aggregate = &meter.Aggregate{
+			Window:         time.Duration(data.WindowSeconds) * time.Second,
+			Samples:        data.Samples,
+			VoltageMin:     data.VoltageMin,
+			VoltageMax:     data.VoltageMax,
+			CurrentMin:     data.CurrentMin,
+			CurrentMax:     data.CurrentMax,
+			PowerMin:       data.PowerMin,
+			PowerMax:       data.PowerMax,
+			PowerFactorMin: data.PowerFactorMin,
+			PowerFactorMax: data.PowerFactorMax,
+			EnergyDelta:    data.EnergyDelta,
+		}
+	}
+	return powerData
+}
+
 // ToAPIFormat converts cache data to API request format
 func (data *PowerDataCache) ToAPIFormat() map[string]interface{} {
 	return map[string]interface{}{
@@ -0,0 +1,389 @@
+package database
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"power-collector/pkg/clock"
+	"power-collector/pkg/meter"
+)
+
+// newTestCache opens a cache in a temporary directory holding count
+// readings taken every 15 seconds
+func newTestCache(t *testing.T, maxSize int, policy string, count int) (*CacheDB, time.Time) {
+	t.Helper()
+
+	cache, err := NewCacheDB(filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatalf("NewCacheDB failed: %v", err)
+	}
+	t.Cleanup(func() { cache.Close() })
+
+	if err := cache.SetLimit(maxSize, policy); err != nil {
+		t.Fatalf("SetLimit failed: %v", err)
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < count; i++ {
+		data := &meter.PowerData{
+			Timestamp: start.Add(time.Duration(i) * 15 * time.Second),
+			Voltage:   230,
+			Power:     float64(i),
+			Energy:    float64(i),
+		}
+		seq, err := cache.NextSeq("test")
+		if err != nil {
+			t.Fatalf("NextSeq failed: %v", err)
+		}
+		if err := cache.StorePowerData("test", seq, data); err != nil {
+			t.Fatalf("StorePowerData failed: %v", err)
+		}
+	}
+
+	return cache, start
+}
+
+func TestDropOldest(t *testing.T) {
+	cache, start := newTestCache(t, 10, PolicyDropOldest, 15)
+
+	data, err := cache.GetAllData("test")
+	if err != nil {
+		t.Fatalf("GetAllData failed: %v", err)
+	}
+	if len(data) != 10 {
+		t.Fatalf("Expected 10 cached rows, got %d", len(data))
+	}
+	if !data[0].Timestamp.Equal(start.Add(5 * 15 * time.Second)) {
+		t.Errorf("Expected the oldest 5 rows to be dropped, first row at %v", data[0].Timestamp)
+	}
+
+	losses, err := cache.GetUnreportedLosses("test")
+	if err != nil {
+		t.Fatalf("GetUnreportedLosses failed: %v", err)
+	}
+	dropped := 0
+	for _, loss := range losses {
+		if loss.Policy != LossDropped {
+			t.Errorf("Expected policy %s, got %s", LossDropped, loss.Policy)
+		}
+		dropped += loss.Records
+	}
+	if dropped != 5 {
+		t.Errorf("Expected 5 dropped readings, got %d", dropped)
+	}
+}
+
+func TestDropUploadedFirst(t *testing.T) {
+	cache, _ := newTestCache(t, 0, PolicyDropOldest, 10)
+
+	data, err := cache.GetUnuploadedData("test", 4)
+	if err != nil {
+		t.Fatalf("GetUnuploadedData failed: %v", err)
+	}
+	var ids []uint
+	for _, row := range data {
+		ids = append(ids, row.ID)
+	}
+	if err := cache.MarkAsUploaded(ids); err != nil {
+		t.Fatalf("MarkAsUploaded failed: %v", err)
+	}
+
+	cache.SetLimit(8, PolicyDropOldest)
+	if err := cache.StorePowerData("test", 0, &meter.PowerData{Timestamp: time.Now(), Voltage: 230}); err != nil {
+		t.Fatalf("StorePowerData failed: %v", err)
+	}
+
+	stats, err := cache.GetCacheStats()
+	if err != nil {
+		t.Fatalf("GetCacheStats failed: %v", err)
+	}
+	if stats["total"] != 8 || stats["unuploaded"] != 7 || stats[LossDropped] != 0 {
+		t.Errorf("Expected only uploaded rows to be evicted, got %v", stats)
+	}
+}
+
+func TestOldestUnuploaded(t *testing.T) {
+	cache, start := newTestCache(t, 0, PolicyDropOldest, 5)
+
+	data, err := cache.GetUnuploadedData("test", 2)
+	if err != nil {
+		t.Fatalf("GetUnuploadedData failed: %v", err)
+	}
+	if err := cache.MarkAsUploaded([]uint{data[0].ID, data[1].ID}); err != nil {
+		t.Fatalf("MarkAsUploaded failed: %v", err)
+	}
+
+	stats, err := cache.GetCacheStats()
+	if err != nil {
+		t.Fatalf("GetCacheStats failed: %v", err)
+	}
+	if want := start.Add(2 * 15 * time.Second).Unix(); stats["oldest_unuploaded"] != want {
+		t.Errorf("Expected oldest unuploaded reading at %d, got %d", want, stats["oldest_unuploaded"])
+	}
+}
+
+func TestPurgeUploaded(t *testing.T) {
+	cache, start := newTestCache(t, 0, PolicyDropOldest, 6)
+
+	data, err := cache.GetUnuploadedData("test", 3)
+	if err != nil {
+		t.Fatalf("GetUnuploadedData failed: %v", err)
+	}
+	if err := cache.MarkAsUploaded([]uint{data[0].ID, data[1].ID, data[2].ID}); err != nil {
+		t.Fatalf("MarkAsUploaded failed: %v", err)
+	}
+
+	purged, err := cache.Purge("", true)
+	if err != nil {
+		t.Fatalf("Purge failed: %v", err)
+	}
+	if purged != 3 {
+		t.Errorf("Expected 3 uploaded rows to be purged, got %d", purged)
+	}
+
+	rows, err := cache.ListData("test", false, 2)
+	if err != nil {
+		t.Fatalf("ListData failed: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("Expected 2 rows, got %d", len(rows))
+	}
+	if !rows[0].Timestamp.Equal(start.Add(5 * 15 * time.Second)) {
+		t.Errorf("Expected the newest row first, got %v", rows[0].Timestamp)
+	}
+
+	if purged, err = cache.Purge("test", false); err != nil || purged != 3 {
+		t.Errorf("Expected the 3 remaining rows to be purged, got %d (%v)", purged, err)
+	}
+}
+
+func TestCorrectTimestamps(t *testing.T) {
+	cache, _ := newTestCache(t, 0, PolicyDropOldest, 0)
+	clk := clock.New()
+	cache.SetClock(clk)
+
+	// A reading taken before the clock is synchronized
+	taken := time.Now()
+	if err := cache.StorePowerData("test", 1, &meter.PowerData{Timestamp: taken, Voltage: 230, TimeUnreliable: true}); err != nil {
+		t.Fatalf("StorePowerData failed: %v", err)
+	}
+	if count, err := cache.CorrectTimestamps(); err != nil || count != 0 {
+		t.Fatalf("Expected nothing to be corrected before the clock is synchronized, got %d (%v)", count, err)
+	}
+
+	// The local clock turns out to be a day behind
+	sent := time.Now()
+	clk.Observe(sent.Round(0).Add(24*time.Hour), sent, sent)
+
+	count, err := cache.CorrectTimestamps()
+	if err != nil || count != 1 {
+		t.Fatalf("Expected 1 reading to be corrected, got %d (%v)", count, err)
+	}
+
+	rows, err := cache.GetUnuploadedData("test", 10)
+	if err != nil {
+		t.Fatalf("GetUnuploadedData failed: %v", err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("Expected 1 row, got %d", len(rows))
+	}
+	if rows[0].TimeUnreliable {
+		t.Error("Expected the corrected reading to be reliable")
+	}
+	if diff := rows[0].Timestamp.Sub(taken.Add(24 * time.Hour)); diff < -time.Millisecond || diff > time.Millisecond {
+		t.Errorf("Expected the reading to be moved by a day, got %v", rows[0].Timestamp)
+	}
+}
+
+func TestDownsample(t *testing.T) {
+	// 4 readings per minute, 10 minutes
+	cache, start := newTestCache(t, 30, PolicyDownsample, 40)
+
+	data, err := cache.GetAllData("test")
+	if err != nil {
+		t.Fatalf("GetAllData failed: %v", err)
+	}
+	if len(data) > 30 {
+		t.Fatalf("Expected at most 30 cached rows, got %d", len(data))
+	}
+
+	first := data[0]
+	if !first.Downsampled || first.Samples != 4 {
+		t.Fatalf("Expected the first minute merged from 4 readings, got %+v", first)
+	}
+	if !first.Timestamp.Equal(start) {
+		t.Errorf("Expected merged row at %v, got %v", start, first.Timestamp)
+	}
+	if first.Power != 1.5 {
+		t.Errorf("Expected average power 1.5, got %v", first.Power)
+	}
+	if first.Energy != 3 {
+		t.Errorf("Expected the last energy reading 3, got %v", first.Energy)
+	}
+
+	stats, err := cache.GetCacheStats()
+	if err != nil {
+		t.Fatalf("GetCacheStats failed: %v", err)
+	}
+	if stats[LossDropped] != 0 {
+		t.Errorf("Expected no dropped readings, got %d", stats[LossDropped])
+	}
+	merged := 0
+	for _, row := range data {
+		if row.Downsampled {
+			merged += row.Samples
+		}
+	}
+	if stats[LossDownsampled] != int64(merged) {
+		t.Errorf("Expected %d downsampled readings, got %d", merged, stats[LossDownsampled])
+	}
+}
+
+func TestCacheLossCountsReadings(t *testing.T) {
+	// 4 readings per minute, 10 minutes, the oldest merged into minutes
+	cache, start := newTestCache(t, 30, PolicyDownsample, 40)
+	data, err := cache.GetAllData("test")
+	if err != nil {
+		t.Fatalf("GetAllData failed: %v", err)
+	}
+	merged := 0
+	for _, row := range data {
+		if row.Downsampled {
+			merged += row.Samples
+		}
+	}
+
+	// Dropping merged rows loses all the readings they were merged from
+	if err := cache.SetLimit(5, PolicyDropOldest); err != nil {
+		t.Fatalf("SetLimit failed: %v", err)
+	}
+	seq, err := cache.NextSeq("test")
+	if err != nil {
+		t.Fatalf("NextSeq failed: %v", err)
+	}
+	if err := cache.StorePowerData("test", seq, &meter.PowerData{Timestamp: start.Add(10 * time.Minute), Power: 40, Energy: 40}); err != nil {
+		t.Fatalf("StorePowerData failed: %v", err)
+	}
+
+	data, err = cache.GetAllData("test")
+	if err != nil {
+		t.Fatalf("GetAllData failed: %v", err)
+	}
+	if len(data) != 5 {
+		t.Fatalf("Expected 5 cached rows, got %d", len(data))
+	}
+	kept := 0
+	for _, row := range data {
+		kept += row.Samples
+	}
+
+	losses, err := cache.GetUnreportedLosses("test")
+	if err != nil {
+		t.Fatalf("GetUnreportedLosses failed: %v", err)
+	}
+	counts := make(map[string]int)
+	for _, loss := range losses {
+		counts[loss.Policy] += loss.Records
+	}
+	if counts[LossDropped] != 41-kept {
+		t.Errorf("Expected the %d readings no longer cached to be counted as dropped, got %d", 41-kept, counts[LossDropped])
+	}
+	// Each merged minute counts the readings it was merged from, also
+	// when it was dropped later
+	if merged == 0 || counts[LossDownsampled] != merged {
+		t.Errorf("Expected %d downsampled readings, got %d", merged, counts[LossDownsampled])
+	}
+}
+
+func TestMergeMinutesKeepsRejected(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rows := []PowerDataCache{
+		{CollectorID: "test", Seq: 1, Timestamp: start, Power: 100, Samples: 1},
+		{CollectorID: "test", Seq: 2, Timestamp: start.Add(15 * time.Second), Power: 900, Samples: 1, Quality: meter.QualitySpike},
+		{CollectorID: "test", Seq: 3, Timestamp: start.Add(30 * time.Second), Power: 300, Samples: 1},
+	}
+
+	merged, _ := mergeMinutes(rows)
+	if len(merged) != 2 {
+		t.Fatalf("Expected the rejected reading kept apart, got %d rows", len(merged))
+	}
+	if merged[0].Power != 200 || merged[0].Quality != "" {
+		t.Errorf("Expected the plausible readings averaged to 200, got %+v", merged[0])
+	}
+	if merged[1].Power != 900 || merged[1].Quality != meter.QualitySpike {
+		t.Errorf("Expected the rejected reading with its quality, got %+v", merged[1])
+	}
+}
+
+func TestMergeMinutesWindows(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rows := []PowerDataCache{
+		{CollectorID: "test", Seq: 1, Timestamp: start.Add(14 * time.Second), Power: 100, Samples: 15, WindowSeconds: 15,
+			PowerMin: 50, PowerMax: 150, EnergyDelta: 0.4},
+		{CollectorID: "test", Seq: 2, Timestamp: start.Add(20 * time.Second), Power: 1000, Samples: 1},
+		{CollectorID: "test", Seq: 3, Timestamp: start.Add(29 * time.Second), Power: 300, Samples: 5, WindowSeconds: 15,
+			PowerMin: 200, PowerMax: 400, EnergyDelta: 0.5},
+	}
+
+	merged, losses := mergeMinutes(rows)
+	if len(merged) != 2 {
+		t.Fatalf("Expected the single reading kept apart from the summaries, got %d rows", len(merged))
+	}
+
+	summary := merged[0]
+	if summary.WindowSeconds != 30 || summary.Samples != 20 || summary.Power != 150 {
+		t.Errorf("Expected a 30 s summary of 20 samples averaging 150 W, got %+v", summary)
+	}
+	if summary.PowerMin != 50 || summary.PowerMax != 400 || math.Abs(summary.EnergyDelta-0.9) > 1e-9 {
+		t.Errorf("Expected the extremes and energy of the summaries kept, got %+v", summary)
+	}
+	if !summary.Timestamp.Equal(rows[2].Timestamp) || summary.Seq != 1 {
+		t.Errorf("Expected the summary stamped with its last window and its first sequence number, got %+v", summary)
+	}
+
+	reading := merged[1]
+	if reading.WindowSeconds != 0 || reading.Power != 1000 || !reading.Timestamp.Equal(start) {
+		t.Errorf("Expected the single reading at the start of its minute, got %+v", reading)
+	}
+
+	if len(losses) != 1 || losses[0].Records != 20 {
+		t.Errorf("Expected the 20 samples of the merged summaries counted, got %+v", losses)
+	}
+}
+
+func TestNextSeq(t *testing.T) {
+	// firstSeqs returns the first two sequence numbers of a new cache
+	firstSeqs := func() (uint64, uint64) {
+		cache, err := NewCacheDB(filepath.Join(t.TempDir(), "cache.db"))
+		if err != nil {
+			t.Fatalf("NewCacheDB failed: %v", err)
+		}
+		defer cache.Close()
+
+		first, err := cache.NextSeq("test")
+		if err != nil {
+			t.Fatalf("NextSeq failed: %v", err)
+		}
+		second, err := cache.NextSeq("test")
+		if err != nil {
+			t.Fatalf("NextSeq failed: %v", err)
+		}
+		return first, second
+	}
+
+	first, second := firstSeqs()
+	if second != first+1 {
+		t.Errorf("Expected consecutive numbers, got %d and %d", first, second)
+	}
+	if first>>seqCounterBits == 0 || first >= 1<<63 {
+		t.Errorf("Expected a random epoch below 2^63, got %d", first)
+	}
+
+	// A recreated cache starts a new epoch rather than from the clock
+	again, _ := firstSeqs()
+	if again>>seqCounterBits == first>>seqCounterBits {
+		t.Errorf("Expected a recreated cache to draw a new epoch, both started at %d", first)
+	}
+}
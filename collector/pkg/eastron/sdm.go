@@ -0,0 +1,110 @@
+package eastron
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	"power-collector/pkg/meter"
+	"power-collector/pkg/modbus"
+)
+
+func init() {
+	meter.Register(meter.Driver{
+		Name:        "sdm120",
+		Description: "Eastron SDM120-Modbus single phase energy meter",
+		Open: func(opts meter.Options) (meter.Meter, error) {
+			return NewSDM(opts.Bus, opts.Address, "SDM120"), nil
+		},
+	})
+	meter.Register(meter.Driver{
+		Name:        "sdm230",
+		Description: "Eastron SDM230-Modbus single phase energy meter",
+		Open: func(opts meter.Options) (meter.Meter, error) {
+			return NewSDM(opts.Bus, opts.Address, "SDM230"), nil
+		},
+	})
+}
+
+// Input register addresses shared by the SDM120 and SDM230. Every value is an
+// IEEE 754 float spanning two registers.
+const (
+	regVoltage           = 0x0000
+	regCurrent           = 0x0006
+	regActivePower       = 0x000C
+	regPowerFactor       = 0x001E
+	regFrequency         = 0x0046
+	regTotalActiveEnergy = 0x0156
+)
+
+// SDM represents an Eastron SDM120 or SDM230 Modbus meter
+type SDM struct {
+	bus     *modbus.Client
+	address uint8
+	model   string
+}
+
+// NewSDM returns the Eastron meter with the given Modbus address on a bus
+func NewSDM(bus *modbus.Client, address uint8, model string) *SDM {
+	return &SDM{
+		bus:     bus,
+		address: address,
+		model:   model,
+	}
+}
+
+// Close is a no-op, the bus is owned by the caller
+func (s *SDM) Close() error {
+	return nil
+}
+
+// Capabilities describes the Eastron meter
+func (s *SDM) Capabilities() meter.Capabilities {
+	return meter.Capabilities{
+		Model:       "Eastron " + s.model,
+		Frequency:   true,
+		PowerFactor: true,
+		Energy:      true,
+	}
+}
+
+// ReadData reads power data from the meter. The instantaneous values are read
+// with a single request spanning voltage to frequency, the energy counter with
+// a second one.
+func (s *SDM) ReadData() (*meter.PowerData, error) {
+	instant, err := s.bus.ReadInputRegisters(s.address, regVoltage, regFrequency+2-regVoltage)
+	if err != nil {
+		return nil, err
+	}
+
+	energy, err := s.bus.ReadInputRegisters(s.address, regTotalActiveEnergy, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	return &meter.PowerData{
+		Timestamp: time.Now(),
+		Voltage:   float64(readFloat(instant, regVoltage)),
+		Current:   float64(readFloat(instant, regCurrent)),
+		Power:     float64(readFloat(instant, regActivePower)),
+		Energy:    float64(readFloat(energy, 0)) * 1000, // kWh to Wh
+		Frequency: float64(readFloat(instant, regFrequency)),
+		// The sign of the power factor only indicates leading or lagging load
+		PowerFactor: math.Abs(float64(readFloat(instant, regPowerFactor))),
+	}, nil
+}
+
+// TestConnection tests the connection to the meter
+func (s *SDM) TestConnection() error {
+	_, err := s.ReadData()
+	if err != nil {
+		return fmt.Errorf("failed to read from %s: %w", s.model, err)
+	}
+	return nil
+}
+
+// readFloat decodes the float starting at register offset reg of data
+func readFloat(data []byte, reg int) float32 {
+	return math.Float32frombits(binary.BigEndian.Uint32(data[reg*2:]))
+}
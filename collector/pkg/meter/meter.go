@@ -0,0 +1,270 @@
+package meter
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"power-collector/pkg/modbus"
+)
+
+// PowerData represents a power measurement read from a meter
+type PowerData struct {
+	Timestamp   time.Time `json:"timestamp"`
+	Voltage     float64   `json:"voltage"`      // Volts
+	Current     float64   `json:"current"`      // Amperes
+	Power       float64   `json:"power"`        // Watts
+	Energy      float64   `json:"energy"`       // Wh
+	Frequency   float64   `json:"frequency"`    // Hz
+	PowerFactor float64   `json:"power_factor"` // Power Factor
+	Alarm       bool      `json:"alarm"`        // Alarm status
+	DC          bool      `json:"dc,omitempty"` // Direct current measurement without frequency and power factor
+	// TimeUnreliable is set if Timestamp is by the local clock, which was
+	// not synchronized with the server yet
+	TimeUnreliable bool `json:"time_unreliable,omitempty"`
+	// Quality is set if the reading was rejected as implausible, see
+	// QualityOutOfRange and QualitySpike
+	Quality string `json:"quality,omitempty"`
+	// Aggregate is set if the data summarizes the samples of a window. The
+	// measurements are then the averages of the samples, Timestamp and
+	// Energy those of the last one.
+	Aggregate *Aggregate `json:"aggregate,omitempty"`
+}
+
+// Qualities of rejected readings
+const (
+	QualityOutOfRange = "out_of_range" // a measurement is outside the limits
+	QualitySpike      = "spike"        // an implausible jump from the previous readings
+)
+
+// Aggregate holds the extremes of the samples of an aggregation window
+type Aggregate struct {
+	Window         time.Duration `json:"window"`
+	Samples        int           `json:"samples"`
+	VoltageMin     float64       `json:"voltage_min"`
+	VoltageMax     float64       `json:"voltage_max"`
+	CurrentMin     float64       `json:"current_min"`
+	CurrentMax     float64       `json:"current_max"`
+	PowerMin       float64       `json:"power_min"`
+	PowerMax       float64       `json:"power_max"`
+	PowerFactorMin float64       `json:"power_factor_min"`
+	PowerFactorMax float64       `json:"power_factor_max"`
+	EnergyDelta    float64       `json:"energy_delta"` // Wh consumed since the last sample of the previous window
+}
+
+// Capabilities describes what a meter measures and supports
+type Capabilities struct {
+	Model       string `json:"model"`
+	DC          bool   `json:"dc"`
+	Frequency   bool   `json:"frequency"`
+	PowerFactor bool   `json:"power_factor"`
+	Energy      bool   `json:"energy"`
+	Alarm       bool   `json:"alarm"`
+}
+
+// Meter is implemented by every meter driver
+type Meter interface {
+	// ReadData reads a single measurement from the meter
+	ReadData() (*PowerData, error)
+	// TestConnection checks that the meter responds
+	TestConnection() error
+	// Close releases the resources held by the meter
+	Close() error
+	// Capabilities describes the meter
+	Capabilities() Capabilities
+}
+
+// EnergyResetter is implemented by meters whose energy counter can be reset
+type EnergyResetter interface {
+	// ResetEnergy resets the energy counter of the meter to zero
+	ResetEnergy() error
+}
+
+// Options represents the parameters a driver opens a meter with
+type Options struct {
+	Bus         *modbus.Client
+	Address     uint8
+	CollectorID string
+
+	// Simulation configures the simulated and replay drivers
+	Simulation SimulationOptions
+}
+
+// SimulationOptions represents the settings of the drivers that run without
+// meter hardware
+type SimulationOptions struct {
+	Seed             int64   // random seed of the simulated driver, 0 for time based
+	BaseLoad         float64 // always-on load in W
+	NominalVoltage   float64 // V
+	NominalFrequency float64 // Hz
+	Source           string  // CSV file or exported cache database to replay
+	Speed            float64 // replay speed multiplier
+	Loop             bool    // restart the replay at the end of the source
+}
+
+// Driver represents a registered meter driver
+type Driver struct {
+	Name        string
+	Description string
+	// Virtual drivers do not talk to a meter on the serial bus
+	Virtual bool
+	Open    func(opts Options) (Meter, error)
+}
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]Driver)
+)
+
+// Register makes a meter driver available by its name. It panics if a driver
+// with the same name is already registered.
+func Register(driver Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+
+	if _, exists := drivers[driver.Name]; exists {
+		panic(fmt.Sprintf("meter: driver %s registered twice", driver.Name))
+	}
+	drivers[driver.Name] = driver
+}
+
+// Open opens a meter using the named driver
+func Open(name string, opts Options) (Meter, error) {
+	driver, ok := Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown meter driver %q (available: %v)", name, Drivers())
+	}
+
+	return driver.Open(opts)
+}
+
+// Lookup returns the driver registered under name
+func Lookup(name string) (Driver, bool) {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+
+	driver, ok := drivers[name]
+	return driver, ok
+}
+
+// Drivers returns the sorted names of the registered drivers
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ReadDataWithRetry reads data with retry mechanism
+func ReadDataWithRetry(m Meter, maxRetries int) (*PowerData, error) {
+	var lastErr error
+
+	for i := 0; i < maxRetries; i++ {
+		data, err := m.ReadData()
+		if err == nil {
+			return data, nil
+		}
+
+		lastErr = err
+
+		// Exception responses are answers of the meter, retrying does not
+		// change them
+		var exception *modbus.ExceptionError
+		if errors.As(err, &exception) {
+			return nil, err
+		}
+
+		if i < maxRetries-1 {
+			time.Sleep(100 * time.Millisecond) // Wait before retry
+		}
+	}
+
+	return nil, fmt.Errorf("failed after %d retries, last error: %w", maxRetries, lastErr)
+}
+
+// Limits represents the ranges readings must lie in to be plausible
+type Limits struct {
+	VoltageMin   float64 // V
+	VoltageMax   float64 // V
+	CurrentMax   float64 // A
+	PowerMax     float64 // W
+	FrequencyMin float64 // Hz
+	FrequencyMax float64 // Hz
+}
+
+// DefaultLimits are the ranges of typical household and small industrial
+// installations
+var DefaultLimits = Limits{
+	VoltageMin:   0,
+	VoltageMax:   300,
+	CurrentMax:   100,
+	PowerMax:     30000,
+	FrequencyMin: 45,
+	FrequencyMax: 65,
+}
+
+// Validate returns an error describing the first measurement outside the
+// limits
+func (data *PowerData) Validate(limits Limits) error {
+	if data.Voltage < limits.VoltageMin || data.Voltage > limits.VoltageMax {
+		return fmt.Errorf("voltage %.1f V outside %g-%g V", data.Voltage, limits.VoltageMin, limits.VoltageMax)
+	}
+
+	if data.Current < 0 || data.Current > limits.CurrentMax {
+		return fmt.Errorf("current %.3f A outside 0-%g A", data.Current, limits.CurrentMax)
+	}
+
+	if data.Power < 0 || data.Power > limits.PowerMax {
+		return fmt.Errorf("power %.1f W outside 0-%g W", data.Power, limits.PowerMax)
+	}
+
+	if data.Energy < 0 { // Energy should not be negative
+		return fmt.Errorf("negative energy %.0f Wh", data.Energy)
+	}
+
+	// DC meters report neither frequency nor power factor
+	if data.DC {
+		return nil
+	}
+
+	if data.Frequency < limits.FrequencyMin || data.Frequency > limits.FrequencyMax {
+		return fmt.Errorf("frequency %.1f Hz outside %g-%g Hz", data.Frequency, limits.FrequencyMin, limits.FrequencyMax)
+	}
+
+	if data.PowerFactor < 0 || data.PowerFactor > 1 { // 0-1 power factor range
+		return fmt.Errorf("power factor %.2f outside 0-1", data.PowerFactor)
+	}
+
+	return nil
+}
+
+// String returns a string representation of the power data
+func (data *PowerData) String() string {
+	var s string
+	if data.DC {
+		s = fmt.Sprintf(
+			"Voltage: %.2fV DC, Current: %.2fA, Power: %.1fW, Energy: %.0fWh, Alarm: %t",
+			data.Voltage, data.Current, data.Power, data.Energy, data.Alarm,
+		)
+	} else {
+		s = fmt.Sprintf(
+			"Voltage: %.1fV, Current: %.3fA, Power: %.1fW, Energy: %.0fWh, "+
+				"Frequency: %.1fHz, PowerFactor: %.2f, Alarm: %t",
+			data.Voltage, data.Current, data.Power, data.Energy,
+			data.Frequency, data.PowerFactor, data.Alarm,
+		)
+	}
+
+	if agg := data.Aggregate; agg != nil {
+		s += fmt.Sprintf(" (average of %d samples over %v, power %.1f-%.1fW, %.2fWh used)",
+			agg.Samples, agg.Window, agg.PowerMin, agg.PowerMax, agg.EnergyDelta)
+	}
+	return s
+}
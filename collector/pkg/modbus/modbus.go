@@ -0,0 +1,352 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+// Modbus function codes used by the supported meters
+const (
+	FuncReadHoldingRegisters = 0x03
+	FuncReadInputRegisters   = 0x04
+	FuncWriteSingleRegister  = 0x06
+)
+
+// Modbus exception codes
+const (
+	ExceptionIllegalFunction    = 0x01
+	ExceptionIllegalDataAddress = 0x02
+	ExceptionIllegalDataValue   = 0x03
+	ExceptionSlaveDeviceFailure = 0x04
+	ExceptionAcknowledge        = 0x05
+	ExceptionSlaveDeviceBusy    = 0x06
+	// Answered by a Modbus TCP gateway on behalf of a slave
+	ExceptionGatewayPathUnavailable = 0x0A
+	ExceptionGatewayTargetFailed    = 0x0B
+)
+
+// defaultTimeout is the response timeout used when none is configured
+const defaultTimeout = time.Second
+
+var (
+	// ErrTimeout is returned when a slave does not answer in time
+	ErrTimeout = errors.New("response timeout")
+	// ErrCRC is returned when a response fails the CRC check
+	ErrCRC = errors.New("CRC verification failed")
+)
+
+// ExceptionError represents a Modbus exception response of a slave
+type ExceptionError struct {
+	Function uint8
+	Code     uint8
+}
+
+// Error implements the error interface
+func (e *ExceptionError) Error() string {
+	return fmt.Sprintf("modbus exception 0x%02X (%s) for function 0x%02X", e.Code, exceptionText(e.Code), e.Function)
+}
+
+// exceptionText describes an exception code
+func exceptionText(code uint8) string {
+	switch code {
+	case ExceptionIllegalFunction:
+		return "illegal function"
+	case ExceptionIllegalDataAddress:
+		return "illegal data address"
+	case ExceptionIllegalDataValue:
+		return "illegal data value"
+	case ExceptionSlaveDeviceFailure:
+		return "slave device failure"
+	case ExceptionAcknowledge:
+		return "acknowledge"
+	case ExceptionSlaveDeviceBusy:
+		return "slave device busy"
+	case ExceptionGatewayPathUnavailable:
+		return "gateway path unavailable"
+	case ExceptionGatewayTargetFailed:
+		return "gateway target device failed to respond"
+	default:
+		return "unknown exception"
+	}
+}
+
+// Stats represents the request and error counters of a slave
+type Stats struct {
+	Requests   uint64 `json:"requests"`
+	CRCErrors  uint64 `json:"crc_errors"`
+	Timeouts   uint64 `json:"timeouts"`
+	Exceptions uint64 `json:"exceptions"`
+}
+
+// Client represents a Modbus master on a bus shared by one or more slave
+// devices. The bus is a local serial port or a TCP connection to a gateway.
+type Client struct {
+	transport transport
+	mu        sync.Mutex
+	stats     map[uint8]*Stats
+}
+
+// SerialConfig represents the line settings of a bus
+type SerialConfig struct {
+	// Port is a serial device, tcp://host:port for a Modbus TCP gateway or
+	// rtu+tcp://host:port for RTU frames tunnelled over TCP
+	Port     string
+	BaudRate int
+	StopBits int
+	Timeout  time.Duration
+	// Trace, if set, receives the raw frames exchanged on the bus
+	Trace TraceFunc
+}
+
+// Open opens the bus named by the port of the config
+func Open(cfg SerialConfig) (*Client, error) {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	scheme, address, isNetwork := ParseNetworkPort(cfg.Port)
+	if !isNetwork {
+		p, err := openSerialPort(cfg)
+		if err != nil {
+			return nil, err
+		}
+		rtu := newRTUTransport(p, timeout)
+		rtu.trace = cfg.Trace
+		return newClient(rtu), nil
+	}
+
+	switch scheme {
+	case SchemeTCP:
+		conn, err := dialConn(address, timeout)
+		if err != nil {
+			return nil, err
+		}
+		tcp := newTCPTransport(conn, timeout)
+		tcp.trace = cfg.Trace
+		return newClient(tcp), nil
+	case SchemeRTUOverTCP:
+		conn, err := dialConn(address, timeout)
+		if err != nil {
+			return nil, err
+		}
+		rtu := newRTUTransport(conn, timeout)
+		rtu.trace = cfg.Trace
+		return newClient(rtu), nil
+	default:
+		return nil, fmt.Errorf("unsupported transport %q", scheme)
+	}
+}
+
+// openSerialPort opens a local serial port
+func openSerialPort(cfg SerialConfig) (port, error) {
+	stopBits := serial.Stop1
+	if cfg.StopBits == 2 {
+		stopBits = serial.Stop2
+	}
+
+	// Reads return after the poll interval so that the silence between
+	// frames can be detected, the response timeout is enforced by the
+	// transport
+	config := &serial.Config{
+		Name:        cfg.Port,
+		Baud:        cfg.BaudRate,
+		ReadTimeout: pollInterval,
+		Size:        8,
+		Parity:      serial.ParityNone,
+		StopBits:    stopBits,
+	}
+
+	serialPort, err := serial.OpenPort(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open serial port: %w", err)
+	}
+	return serialPort, nil
+}
+
+// newClient returns a client on an open transport
+func newClient(t transport) *Client {
+	return &Client{
+		transport: t,
+		stats:     make(map[uint8]*Stats),
+	}
+}
+
+// Close closes the connection of the bus
+func (c *Client) Close() error {
+	if c.transport != nil {
+		return c.transport.Close()
+	}
+	return nil
+}
+
+// Stats returns the counters of the slave at the given address
+func (c *Client) Stats(address uint8) Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if stats, ok := c.stats[address]; ok {
+		return *stats
+	}
+	return Stats{}
+}
+
+// Transact sends a request PDU (function code and data) to the slave at the
+// given address and returns the response PDU. Exception responses are
+// returned as *ExceptionError.
+func (c *Client) Transact(address uint8, pdu []byte) ([]byte, error) {
+	// Only one request may be in flight on the bus at a time
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats, ok := c.stats[address]
+	if !ok {
+		stats = &Stats{}
+		c.stats[address] = stats
+	}
+	stats.Requests++
+
+	response, err := c.transport.RoundTrip(address, pdu)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrTimeout):
+			stats.Timeouts++
+		case errors.Is(err, ErrCRC):
+			stats.CRCErrors++
+		}
+		return nil, err
+	}
+
+	if len(response) == 0 {
+		return nil, fmt.Errorf("empty response")
+	}
+	if response[0]&0x80 != 0 {
+		stats.Exceptions++
+		var code uint8
+		if len(response) > 1 {
+			code = response[1]
+		}
+		return nil, &ExceptionError{Function: response[0] &^ 0x80, Code: code}
+	}
+
+	return response, nil
+}
+
+// ReadInputRegisters reads quantity input registers starting at start and
+// returns their raw big-endian contents
+func (c *Client) ReadInputRegisters(address uint8, start, quantity uint16) ([]byte, error) {
+	return c.readRegisters(address, FuncReadInputRegisters, start, quantity)
+}
+
+// ReadHoldingRegisters reads quantity holding registers starting at start and
+// returns their raw big-endian contents
+func (c *Client) ReadHoldingRegisters(address uint8, start, quantity uint16) ([]byte, error) {
+	return c.readRegisters(address, FuncReadHoldingRegisters, start, quantity)
+}
+
+// readRegisters issues a register read request and validates the byte count
+// of the response
+func (c *Client) readRegisters(address, function uint8, start, quantity uint16) ([]byte, error) {
+	pdu := make([]byte, 5)
+	pdu[0] = function
+	binary.BigEndian.PutUint16(pdu[1:], start)
+	binary.BigEndian.PutUint16(pdu[3:], quantity)
+
+	response, err := c.Transact(address, pdu)
+	if err != nil {
+		return nil, err
+	}
+
+	// Response PDU structure: [Function Code][Byte Count][Data...]
+	if err := CheckFunction(response, function); err != nil {
+		return nil, err
+	}
+	byteCount := int(quantity) * 2
+	if len(response) < 2 || int(response[1]) != byteCount || len(response)-2 != byteCount {
+		return nil, fmt.Errorf("insufficient data received: got %d bytes, expected %d", len(response)-2, byteCount)
+	}
+
+	return response[2:], nil
+}
+
+// WriteSingleRegister writes value to a holding register of the slave. The
+// slave echoes the request on success.
+func (c *Client) WriteSingleRegister(address uint8, register, value uint16) error {
+	pdu := make([]byte, 5)
+	pdu[0] = FuncWriteSingleRegister
+	binary.BigEndian.PutUint16(pdu[1:], register)
+	binary.BigEndian.PutUint16(pdu[3:], value)
+
+	response, err := c.Transact(address, pdu)
+	if err != nil {
+		return err
+	}
+
+	if err := CheckFunction(response, FuncWriteSingleRegister); err != nil {
+		return err
+	}
+	if len(response) != len(pdu) || string(response) != string(pdu) {
+		return fmt.Errorf("unexpected response to register write: % X", response)
+	}
+
+	return nil
+}
+
+// CheckFunction checks that a response PDU returned by Transact answers the
+// given function code
+func CheckFunction(response []byte, function uint8) error {
+	if len(response) == 0 {
+		return fmt.Errorf("empty response")
+	}
+	if response[0] != function {
+		return fmt.Errorf("unexpected function code in response: 0x%02X", response[0])
+	}
+	return nil
+}
+
+// CRC16 calculates the CRC-16 (Modbus) checksum
+func CRC16(data []byte) uint16 {
+	var crc uint16 = 0xFFFF
+
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if (crc & 0x0001) != 0 {
+				crc >>= 1
+				crc ^= 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+
+	return crc
+}
+
+// AppendCRC appends the CRC of frame to it (little-endian)
+func AppendCRC(frame []byte) []byte {
+	crc := CRC16(frame)
+	frame = append(frame, byte(crc&0xFF))      // CRC low byte
+	frame = append(frame, byte((crc>>8)&0xFF)) // CRC high byte
+	return frame
+}
+
+// VerifyCRC verifies the CRC of a received frame
+func VerifyCRC(data []byte) bool {
+	if len(data) < 4 {
+		return false
+	}
+
+	// Extract received CRC (last 2 bytes, little-endian)
+	receivedCRC := uint16(data[len(data)-2]) + (uint16(data[len(data)-1]) << 8)
+
+	// Calculate CRC for data without CRC bytes
+	calculatedCRC := CRC16(data[:len(data)-2])
+
+	return receivedCRC == calculatedCRC
+}
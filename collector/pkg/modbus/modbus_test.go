@@ -0,0 +1,155 @@
+package modbus
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakePort answers reads with queued chunks and reports silence once they
+// are used up
+type fakePort struct {
+	chunks  [][]byte
+	written []byte
+	flushes int
+}
+
+func (p *fakePort) Read(b []byte) (int, error) {
+	if len(p.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, p.chunks[0])
+	p.chunks = p.chunks[1:]
+	return n, nil
+}
+
+func (p *fakePort) Write(b []byte) (int, error) {
+	p.written = append(p.written, b...)
+	return len(b), nil
+}
+
+func (p *fakePort) Flush() error {
+	p.flushes++
+	return nil
+}
+
+func (p *fakePort) Close() error {
+	return nil
+}
+
+func TestReadInputRegistersPartialReads(t *testing.T) {
+	response := AppendCRC([]byte{0x01, FuncReadInputRegisters, 0x04, 0x08, 0xFD, 0x00, 0x0A})
+	port := &fakePort{chunks: [][]byte{response[:2], response[2:5], response[5:]}}
+	client := newClient(newRTUTransport(port, 50*time.Millisecond))
+
+	data, err := client.ReadInputRegisters(1, 0x0000, 2)
+	if err != nil {
+		t.Fatalf("ReadInputRegisters failed: %v", err)
+	}
+	if len(data) != 4 || data[0] != 0x08 || data[1] != 0xFD {
+		t.Errorf("unexpected register data: % X", data)
+	}
+	if port.flushes != 1 {
+		t.Errorf("expected input to be flushed before the request, got %d flushes", port.flushes)
+	}
+	if stats := client.Stats(1); stats.Requests != 1 || stats.Timeouts != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestTransactErrors(t *testing.T) {
+	exception := AppendCRC([]byte{0x01, FuncReadInputRegisters | 0x80, ExceptionIllegalDataAddress})
+	corrupted := AppendCRC([]byte{0x01, FuncWriteSingleRegister, 0x00, 0x01, 0x00, 0x10})
+	corrupted[len(corrupted)-1] ^= 0xFF
+
+	port := &fakePort{}
+	client := newClient(newRTUTransport(port, 20*time.Millisecond))
+
+	port.chunks = [][]byte{exception}
+	_, err := client.ReadInputRegisters(1, 0x0100, 1)
+	var exceptionErr *ExceptionError
+	if !errors.As(err, &exceptionErr) {
+		t.Fatalf("expected an exception error, got %v", err)
+	}
+	if exceptionErr.Function != FuncReadInputRegisters || exceptionErr.Code != ExceptionIllegalDataAddress {
+		t.Errorf("unexpected exception: %+v", exceptionErr)
+	}
+
+	port.chunks = [][]byte{corrupted}
+	if err := client.WriteSingleRegister(1, 0x0001, 0x0010); !errors.Is(err, ErrCRC) {
+		t.Errorf("expected a CRC error, got %v", err)
+	}
+
+	port.chunks = nil
+	if _, err := client.ReadInputRegisters(1, 0x0000, 1); !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+
+	stats := client.Stats(1)
+	if stats.Requests != 3 || stats.Exceptions != 1 || stats.CRCErrors != 1 || stats.Timeouts != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestModbusTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	// Gateway answering every read with register values 0x1234, preceded by
+	// a stale answer to an earlier transaction
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		request := make([]byte, 12)
+		if _, err := io.ReadFull(conn, request); err != nil {
+			return
+		}
+		stale := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x05, request[6], request[7], 0x02, 0xFF, 0xFF}
+		response := []byte{request[0], request[1], 0x00, 0x00, 0x00, 0x05, request[6], request[7], 0x02, 0x12, 0x34}
+		conn.Write(append(stale, response...))
+	}()
+
+	client, err := Open(SerialConfig{Port: "tcp://" + listener.Addr().String(), Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer client.Close()
+
+	data, err := client.ReadHoldingRegisters(7, 0x0001, 1)
+	if err != nil {
+		t.Fatalf("ReadHoldingRegisters failed: %v", err)
+	}
+	if len(data) != 2 || data[0] != 0x12 || data[1] != 0x34 {
+		t.Errorf("unexpected register data: % X", data)
+	}
+}
+
+func TestParseNetworkPort(t *testing.T) {
+	tests := []struct {
+		port    string
+		scheme  string
+		address string
+		network bool
+	}{
+		{"/dev/ttyUSB0", "", "", false},
+		{"tcp://10.0.0.5", SchemeTCP, "10.0.0.5:502", true},
+		{"tcp://10.0.0.5:5020", SchemeTCP, "10.0.0.5:5020", true},
+		{"rtu+tcp://gateway.local:4196", SchemeRTUOverTCP, "gateway.local:4196", true},
+	}
+
+	for _, tt := range tests {
+		scheme, address, ok := ParseNetworkPort(tt.port)
+		if scheme != tt.scheme || address != tt.address || ok != tt.network {
+			t.Errorf("ParseNetworkPort(%q) = %q, %q, %t", tt.port, scheme, address, ok)
+		}
+	}
+}
@@ -0,0 +1,390 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Network transport schemes accepted in place of a serial device
+const (
+	SchemeTCP        = "tcp"     // Modbus TCP (MBAP header, no CRC)
+	SchemeRTUOverTCP = "rtu+tcp" // RTU frames tunnelled over a raw TCP socket
+)
+
+// defaultTCPPort is the registered Modbus TCP port
+const defaultTCPPort = "502"
+
+const (
+	// maxFrameLength is the largest Modbus RTU frame
+	maxFrameLength = 256
+	// pollInterval is how long a single read waits for more bytes. A read
+	// that returns nothing after part of a frame arrived marks the end of
+	// the frame.
+	pollInterval = 100 * time.Millisecond
+)
+
+// transport carries request PDUs to the slaves of a bus
+type transport interface {
+	// RoundTrip sends a request PDU to the slave at address and returns
+	// the response PDU
+	RoundTrip(address uint8, pdu []byte) ([]byte, error)
+	io.Closer
+}
+
+// Directions of traced frames
+const (
+	TraceTX = "TX" // request sent to a slave
+	TraceRX = "RX" // response received, also if incomplete or corrupt
+)
+
+// TraceFunc receives every frame a transport sends or receives, including
+// the RTU address and CRC or the Modbus TCP header
+type TraceFunc func(direction string, frame []byte)
+
+// port is the byte stream RTU frames are exchanged over
+type port interface {
+	io.ReadWriteCloser
+	// Flush discards data received but not read yet
+	Flush() error
+}
+
+// ParseNetworkPort splits a tcp:// or rtu+tcp:// port into its scheme and
+// host:port address. It reports false for serial devices.
+func ParseNetworkPort(name string) (scheme, address string, ok bool) {
+	scheme, address, ok = strings.Cut(name, "://")
+	if !ok {
+		return "", "", false
+	}
+
+	address = strings.TrimSuffix(address, "/")
+	if _, _, err := net.SplitHostPort(address); err != nil && scheme == SchemeTCP {
+		address = net.JoinHostPort(address, defaultTCPPort)
+	}
+	return scheme, address, true
+}
+
+// rtuTransport exchanges RTU frames over a serial line or a TCP tunnel
+type rtuTransport struct {
+	port    port
+	timeout time.Duration
+	trace   TraceFunc
+}
+
+// newRTUTransport returns an RTU transport on an open port
+func newRTUTransport(p port, timeout time.Duration) *rtuTransport {
+	return &rtuTransport{port: p, timeout: timeout}
+}
+
+// RoundTrip sends an RTU request frame and reads the response frame
+// Frame format: [address, function, data..., CRC_Low, CRC_High]
+func (t *rtuTransport) RoundTrip(address uint8, pdu []byte) ([]byte, error) {
+	frame := append([]byte{address}, pdu...)
+	frame = AppendCRC(frame)
+
+	// Discard late answers to earlier requests
+	if err := t.port.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to flush port: %w", err)
+	}
+
+	// Send request
+	if t.trace != nil {
+		t.trace(TraceTX, frame)
+	}
+	if _, err := t.port.Write(frame); err != nil {
+		return nil, fmt.Errorf("failed to write command: %w", err)
+	}
+
+	// Read response
+	response, err := t.readFrame()
+	if t.trace != nil && len(response) > 0 {
+		t.trace(TraceRX, response)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify CRC
+	if !VerifyCRC(response) {
+		return nil, ErrCRC
+	}
+
+	if response[0] != address {
+		return nil, fmt.Errorf("unexpected slave address in response: got %d, expected %d", response[0], address)
+	}
+
+	return response[1 : len(response)-2], nil
+}
+
+// readFrame reads a response frame. It returns once the length encoded in
+// the frame has been received, or when the line falls silent after part of a
+// frame for functions whose length is not known. The bytes of an incomplete
+// frame are returned along with the error.
+func (t *rtuTransport) readFrame() ([]byte, error) {
+	deadline := time.Now().Add(t.timeout)
+	frame := make([]byte, 0, maxFrameLength)
+	buf := make([]byte, maxFrameLength)
+
+	for {
+		n, err := t.port.Read(buf[:maxFrameLength-len(frame)])
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read response: %w", err)
+		}
+		frame = append(frame, buf[:n]...)
+
+		if expected := frameLength(frame); expected > 0 && len(frame) >= expected {
+			return frame[:expected], nil
+		}
+		if len(frame) == maxFrameLength {
+			break
+		}
+
+		// Silence after part of a frame ends it
+		if n == 0 && len(frame) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			if len(frame) == 0 {
+				return nil, ErrTimeout
+			}
+			break
+		}
+	}
+
+	// The shortest frame is a response without data: address, function, CRC
+	if len(frame) < 4 {
+		return frame, fmt.Errorf("incomplete frame of %d bytes: %w", len(frame), ErrTimeout)
+	}
+	if expected := frameLength(frame); expected > 0 && len(frame) < expected {
+		return frame, fmt.Errorf("incomplete frame of %d/%d bytes: %w", len(frame), expected, ErrTimeout)
+	}
+
+	return frame, nil
+}
+
+// Close closes the port
+func (t *rtuTransport) Close() error {
+	return t.port.Close()
+}
+
+// frameLength returns the total length of a response frame, or 0 while it
+// cannot be told from the bytes received so far
+func frameLength(frame []byte) int {
+	if len(frame) < 2 {
+		return 0
+	}
+
+	function := frame[1]
+	switch {
+	case function&0x80 != 0:
+		// [address, function, exception code, CRC]
+		return 5
+	case function == FuncReadHoldingRegisters || function == FuncReadInputRegisters:
+		// [address, function, byte count, data..., CRC]
+		if len(frame) < 3 {
+			return 0
+		}
+		return 5 + int(frame[2])
+	case function == FuncWriteSingleRegister:
+		// [address, function, register, value, CRC]
+		return 8
+	default:
+		return 0
+	}
+}
+
+// tcpTransport exchanges Modbus TCP frames with a gateway, addressing the
+// slaves behind it by unit identifier
+type tcpTransport struct {
+	conn          *netPort
+	timeout       time.Duration
+	transactionID uint16
+	trace         TraceFunc
+}
+
+// newTCPTransport returns a Modbus TCP transport on a connection
+func newTCPTransport(conn *netPort, timeout time.Duration) *tcpTransport {
+	return &tcpTransport{conn: conn, timeout: timeout}
+}
+
+// RoundTrip sends a Modbus TCP request and reads the matching response
+// Frame format: [transaction ID, protocol ID 0, length, unit ID, PDU...]
+func (t *tcpTransport) RoundTrip(address uint8, pdu []byte) ([]byte, error) {
+	t.transactionID++
+	request := make([]byte, 7, 7+len(pdu))
+	binary.BigEndian.PutUint16(request[0:], t.transactionID)
+	binary.BigEndian.PutUint16(request[4:], uint16(len(pdu)+1))
+	request[6] = address
+	request = append(request, pdu...)
+
+	if t.trace != nil {
+		t.trace(TraceTX, request)
+	}
+	if _, err := t.conn.Write(request); err != nil {
+		return nil, fmt.Errorf("failed to write command: %w", err)
+	}
+
+	deadline := time.Now().Add(t.timeout)
+	header := make([]byte, 7)
+	for {
+		if err := t.conn.readFull(header, deadline); err != nil {
+			return nil, err
+		}
+
+		length := int(binary.BigEndian.Uint16(header[4:]))
+		if binary.BigEndian.Uint16(header[2:]) != 0 || length < 2 || length > maxFrameLength {
+			t.conn.reset()
+			return nil, fmt.Errorf("invalid Modbus TCP header: % X", header)
+		}
+
+		response := make([]byte, length-1)
+		if err := t.conn.readFull(response, deadline); err != nil {
+			return nil, err
+		}
+		if t.trace != nil {
+			t.trace(TraceRX, append(header[:7:7], response...))
+		}
+
+		// Skip late answers to earlier requests
+		if binary.BigEndian.Uint16(header[0:]) != t.transactionID {
+			continue
+		}
+
+		if header[6] != address {
+			return nil, fmt.Errorf("unexpected unit ID in response: got %d, expected %d", header[6], address)
+		}
+		return response, nil
+	}
+}
+
+// Close closes the connection
+func (t *tcpTransport) Close() error {
+	return t.conn.Close()
+}
+
+// netPort is a TCP connection to a serial gateway. It redials on the next
+// request after the connection failed.
+type netPort struct {
+	address string
+	timeout time.Duration
+	conn    net.Conn
+}
+
+// dialConn connects to a gateway
+func dialConn(address string, timeout time.Duration) (*netPort, error) {
+	p := &netPort{address: address, timeout: timeout}
+	if err := p.connect(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// connect dials the gateway unless a connection is open
+func (p *netPort) connect() error {
+	if p.conn != nil {
+		return nil
+	}
+
+	conn, err := net.DialTimeout("tcp", p.address, p.timeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", p.address, err)
+	}
+	p.conn = conn
+	return nil
+}
+
+// reset drops the connection so that the next request redials
+func (p *netPort) reset() {
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+}
+
+// Read reads what arrives within the poll interval. Silence is reported as
+// io.EOF like a serial port does.
+func (p *netPort) Read(b []byte) (int, error) {
+	if err := p.connect(); err != nil {
+		return 0, err
+	}
+
+	p.conn.SetReadDeadline(time.Now().Add(pollInterval))
+	n, err := p.conn.Read(b)
+	if err != nil {
+		if isTimeout(err) {
+			return n, io.EOF
+		}
+		p.reset()
+	}
+	return n, err
+}
+
+// readFull reads exactly len(b) bytes before the deadline
+func (p *netPort) readFull(b []byte, deadline time.Time) error {
+	if err := p.connect(); err != nil {
+		return err
+	}
+
+	p.conn.SetReadDeadline(deadline)
+	if _, err := io.ReadFull(p.conn, b); err != nil {
+		if isTimeout(err) {
+			return ErrTimeout
+		}
+		p.reset()
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	return nil
+}
+
+// Write sends b, dialing the gateway first if needed
+func (p *netPort) Write(b []byte) (int, error) {
+	if err := p.connect(); err != nil {
+		return 0, err
+	}
+
+	p.conn.SetWriteDeadline(time.Now().Add(p.timeout))
+	n, err := p.conn.Write(b)
+	if err != nil {
+		p.reset()
+	}
+	return n, err
+}
+
+// Flush discards data received but not read yet
+func (p *netPort) Flush() error {
+	if err := p.connect(); err != nil {
+		return err
+	}
+
+	buf := make([]byte, maxFrameLength)
+	for {
+		p.conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+		if _, err := p.conn.Read(buf); err != nil {
+			if isTimeout(err) {
+				return nil
+			}
+			p.reset()
+			return err
+		}
+	}
+}
+
+// Close closes the connection
+func (p *netPort) Close() error {
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn = nil
+	return err
+}
+
+// isTimeout reports whether err is a deadline expiry
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -4,81 +4,92 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/tarm/serial"
+	"power-collector/pkg/meter"
+	"power-collector/pkg/modbus"
 )
 
-// PowerData represents the power measurement data from PZEM-004T
-type PowerData struct {
-	Timestamp   time.Time `json:"timestamp"`
-	Voltage     float64   `json:"voltage"`      // Volts
-	Current     float64   `json:"current"`      // Amperes
-	Power       float64   `json:"power"`        // Watts
-	Energy      float64   `json:"energy"`       // Wh
-	Frequency   float64   `json:"frequency"`    // Hz
-	PowerFactor float64   `json:"power_factor"` // Power Factor
-	Alarm       bool      `json:"alarm"`        // Alarm status
+func init() {
+	meter.Register(meter.Driver{
+		Name:        "pzem004t",
+		Description: "Peacefair PZEM-004T v3 AC energy meter",
+		Open: func(opts meter.Options) (meter.Meter, error) {
+			return New(opts.Bus, opts.Address), nil
+		},
+	})
 }
 
+// PZEM-004T v3 holding registers and vendor function codes
+const (
+	registerAlarmThreshold = 0x0001 // power alarm threshold, 1W resolution
+	registerAddress        = 0x0002 // Modbus slave address, 0x01-0xF7
+
+	funcResetEnergy = 0x42
+
+	// MaxAddress is the highest slave address a PZEM module accepts
+	MaxAddress = 0xF7
+)
+
 // PZEM004T represents the PZEM-004T device
 type PZEM004T struct {
-	port    *serial.Port
+	bus     *modbus.Client
 	address uint8
+	ownsBus bool
 }
 
-// NewPZEM004T creates a new PZEM-004T instance
-func NewPZEM004T(portName string, baudRate int, address uint8, timeout time.Duration) (*PZEM004T, error) {
-	config := &serial.Config{
-		Name:        portName,
-		Baud:        baudRate,
-		ReadTimeout: timeout,
-		Size:        8,
-		Parity:      serial.ParityNone,
-		StopBits:    serial.Stop1,
+// New returns the PZEM-004T slave with the given Modbus address on a bus
+func New(bus *modbus.Client, address uint8) *PZEM004T {
+	return &PZEM004T{
+		bus:     bus,
+		address: address,
 	}
+}
 
-	port, err := serial.OpenPort(config)
+// NewPZEM004T creates a new PZEM-004T instance on a dedicated serial port
+func NewPZEM004T(portName string, baudRate int, address uint8, timeout time.Duration) (*PZEM004T, error) {
+	bus, err := modbus.Open(modbus.SerialConfig{
+		Port:     portName,
+		BaudRate: baudRate,
+		Timeout:  timeout,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to open serial port: %w", err)
+		return nil, err
 	}
 
-	return &PZEM004T{
-		port:    port,
-		address: address,
-	}, nil
+	device := New(bus, address)
+	device.ownsBus = true
+	return device, nil
 }
 
-// Close closes the serial port connection
+// Close closes the serial port connection if the device owns it. Devices
+// sharing a bus leave the port open for the other slaves.
 func (p *PZEM004T) Close() error {
-	if p.port != nil {
-		return p.port.Close()
+	if p.ownsBus {
+		return p.bus.Close()
 	}
 	return nil
 }
 
-// ReadData reads power data from PZEM-004T module
-func (p *PZEM004T) ReadData() (*PowerData, error) {
-	// Build read command
-	cmd := p.buildReadCommand()
-
-	// Send command
-	_, err := p.port.Write(cmd)
-	if err != nil {
-		return nil, fmt.Errorf("failed to write command: %w", err)
+// Capabilities describes the PZEM-004T
+func (p *PZEM004T) Capabilities() meter.Capabilities {
+	return meter.Capabilities{
+		Model:       "PZEM-004T v3",
+		Frequency:   true,
+		PowerFactor: true,
+		Energy:      true,
+		Alarm:       true,
 	}
+}
 
-	// Read response (expect 25 bytes)
-	response := make([]byte, 25)
-	n, err := p.port.Read(response)
+// ReadData reads power data from PZEM-004T module
+// Request: read 10 input registers starting at 0x0000
+func (p *PZEM004T) ReadData() (*meter.PowerData, error) {
+	registers, err := p.bus.ReadInputRegisters(p.address, 0x0000, 10)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
-	}
-
-	if n < 25 {
-		return nil, fmt.Errorf("insufficient data received: got %d bytes, expected 25", n)
+		return nil, err
 	}
 
 	// Parse response
-	data, err := p.parseResponse(response[:n])
+	data, err := p.parseRegisters(registers)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse response: %w", err)
 	}
@@ -86,82 +97,28 @@ func (p *PZEM004T) ReadData() (*PowerData, error) {
 	return data, nil
 }
 
-// buildReadCommand constructs the command frame to read PZEM-004T data
-// Command format: [address, 0x04, 0x00, 0x00, 0x00, 0x0A, CRC_Low, CRC_High]
-func (p *PZEM004T) buildReadCommand() []byte {
-	frame := []byte{p.address, 0x04, 0x00, 0x00, 0x00, 0x0A}
-	crc := p.calculateCRC16(frame)
-
-	// Append CRC (little-endian)
-	frame = append(frame, byte(crc&0xFF))      // CRC low byte
-	frame = append(frame, byte((crc>>8)&0xFF)) // CRC high byte
-
-	return frame
-}
-
-// calculateCRC16 calculates CRC-16 (Modbus) checksum
-func (p *PZEM004T) calculateCRC16(data []byte) uint16 {
-	var crc uint16 = 0xFFFF
-
-	for _, b := range data {
-		crc ^= uint16(b)
-		for i := 0; i < 8; i++ {
-			if (crc & 0x0001) != 0 {
-				crc >>= 1
-				crc ^= 0xA001
-			} else {
-				crc >>= 1
-			}
-		}
-	}
-
-	return crc
-}
-
-// verifyCRC verifies the CRC of received data frame
-func (p *PZEM004T) verifyCRC(data []byte) bool {
-	if len(data) < 5 {
-		return false
-	}
-
-	// Extract received CRC (last 2 bytes, little-endian)
-	receivedCRC := uint16(data[len(data)-2]) + (uint16(data[len(data)-1]) << 8)
-
-	// Calculate CRC for data without CRC bytes
-	calculatedCRC := p.calculateCRC16(data[:len(data)-2])
-
-	return receivedCRC == calculatedCRC
-}
-
-// parseResponse parses the PZEM-004T response data
-func (p *PZEM004T) parseResponse(data []byte) (*PowerData, error) {
-	if len(data) < 25 {
+// parseRegisters parses the PZEM-004T register contents
+func (p *PZEM004T) parseRegisters(data []byte) (*meter.PowerData, error) {
+	if len(data) < 20 {
 		return nil, fmt.Errorf("insufficient data length: %d", len(data))
 	}
 
-	// Verify CRC
-	if !p.verifyCRC(data) {
-		return nil, fmt.Errorf("CRC verification failed")
-	}
-
 	// Parse data according to PZEM-004T protocol
-	// Data frame structure:
-	// [Address][Function Code][Byte Count][Data...][CRC_Low][CRC_High]
-	// Byte count = 20, Data = 20 bytes (voltage, current, power, energy, frequency, power factor, alarm)
-
-	// Extract data fields (big-endian format)
-	voltage := uint16(data[3])<<8 + uint16(data[4])
-	current := uint32(data[7])<<24 + uint32(data[8])<<16 +
-		uint32(data[5])<<8 + uint32(data[6])
-	power := uint32(data[11])<<24 + uint32(data[12])<<16 +
-		uint32(data[9])<<8 + uint32(data[10])
-	energy := uint32(data[15])<<24 + uint32(data[16])<<16 +
-		uint32(data[13])<<8 + uint32(data[14])
-	frequency := uint16(data[17])<<8 + uint16(data[18])
-	powerFactor := uint16(data[19])<<8 + uint16(data[20])
-	alarm := data[21]
-
-	return &PowerData{
+	// 20 bytes: voltage, current, power, energy, frequency, power factor, alarm
+	// 32-bit values are sent as low word first, each word big-endian
+
+	voltage := uint16(data[0])<<8 + uint16(data[1])
+	current := uint32(data[4])<<24 + uint32(data[5])<<16 +
+		uint32(data[2])<<8 + uint32(data[3])
+	power := uint32(data[8])<<24 + uint32(data[9])<<16 +
+		uint32(data[6])<<8 + uint32(data[7])
+	energy := uint32(data[12])<<24 + uint32(data[13])<<16 +
+		uint32(data[10])<<8 + uint32(data[11])
+	frequency := uint16(data[14])<<8 + uint16(data[15])
+	powerFactor := uint16(data[16])<<8 + uint16(data[17])
+	alarm := uint16(data[18])<<8 + uint16(data[19])
+
+	return &meter.PowerData{
 		Timestamp:   time.Now(),
 		Voltage:     float64(voltage) / 10.0,      // 0.1V resolution
 		Current:     float64(current) / 1000.0,    // 0.001A resolution
@@ -179,71 +136,66 @@ func (p *PZEM004T) TestConnection() error {
 	return err
 }
 
-// SetAddress sets the PZEM-004T device address
-func (p *PZEM004T) SetAddress(newAddress uint8) {
-	p.address = newAddress
-}
-
-// GetAddress returns the current device address
-func (p *PZEM004T) GetAddress() uint8 {
-	return p.address
-}
+// SetAddress writes a new slave address to the PZEM-004T. The module answers
+// on the new address from then on.
+func (p *PZEM004T) SetAddress(newAddress uint8) error {
+	if newAddress < 1 || newAddress > MaxAddress {
+		return fmt.Errorf("invalid slave address %d: must be between 1 and %d", newAddress, MaxAddress)
+	}
 
-// ReadDataWithRetry reads data with retry mechanism
-func (p *PZEM004T) ReadDataWithRetry(maxRetries int) (*PowerData, error) {
-	var lastErr error
-
-	for i := 0; i < maxRetries; i++ {
-		data, err := p.ReadData()
-		if err == nil {
-			return data, nil
-		}
-
-		lastErr = err
-		if i < maxRetries-1 {
-			time.Sleep(100 * time.Millisecond) // Wait before retry
-		}
+	if err := p.bus.WriteSingleRegister(p.address, registerAddress, uint16(newAddress)); err != nil {
+		return fmt.Errorf("failed to write slave address: %w", err)
 	}
 
-	return nil, fmt.Errorf("failed after %d retries, last error: %w", maxRetries, lastErr)
+	p.address = newAddress
+	return nil
 }
 
-// IsDataValid validates if the power data is within expected ranges
-func (data *PowerData) IsDataValid() bool {
-	// Basic validation ranges for typical household/industrial use
-	if data.Voltage < 0 || data.Voltage > 300 { // 0-300V
-		return false
+// AlarmThreshold reads the power alarm threshold in watts
+func (p *PZEM004T) AlarmThreshold() (uint16, error) {
+	data, err := p.bus.ReadHoldingRegisters(p.address, registerAlarmThreshold, 1)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read alarm threshold: %w", err)
 	}
 
-	if data.Current < 0 || data.Current > 100 { // 0-100A
-		return false
-	}
+	return uint16(data[0])<<8 + uint16(data[1]), nil
+}
 
-	if data.Power < 0 || data.Power > 30000 { // 0-30kW
-		return false
+// SetAlarmThreshold sets the power alarm threshold in watts. The alarm flag
+// of the readings is raised while the power exceeds it.
+func (p *PZEM004T) SetAlarmThreshold(watts uint16) error {
+	if err := p.bus.WriteSingleRegister(p.address, registerAlarmThreshold, watts); err != nil {
+		return fmt.Errorf("failed to write alarm threshold: %w", err)
 	}
+	return nil
+}
 
-	if data.Energy < 0 { // Energy should not be negative
-		return false
-	}
+// ResetEnergy resets the energy counter of the PZEM-004T to zero
+func (p *PZEM004T) ResetEnergy() error {
+	return resetEnergy(p.bus, p.address)
+}
 
-	if data.Frequency < 45 || data.Frequency > 65 { // 45-65Hz (typical power frequency range)
-		return false
+// resetEnergy resets the energy counter of a PZEM module
+// Request: [address, 0x42, CRC], the module echoes it on success
+func resetEnergy(bus *modbus.Client, address uint8) error {
+	response, err := bus.Transact(address, []byte{funcResetEnergy})
+	if err != nil {
+		return fmt.Errorf("failed to reset energy: %w", err)
 	}
 
-	if data.PowerFactor < 0 || data.PowerFactor > 1 { // 0-1 power factor range
-		return false
+	if err := modbus.CheckFunction(response, funcResetEnergy); err != nil {
+		return fmt.Errorf("failed to reset energy: %w", err)
 	}
 
-	return true
+	return nil
 }
 
-// String returns a string representation of the power data
-func (data *PowerData) String() string {
-	return fmt.Sprintf(
-		"Voltage: %.1fV, Current: %.3fA, Power: %.1fW, Energy: %.0fWh, "+
-			"Frequency: %.1fHz, PowerFactor: %.2f, Alarm: %t",
-		data.Voltage, data.Current, data.Power, data.Energy,
-		data.Frequency, data.PowerFactor, data.Alarm,
-	)
+// GetAddress returns the current device address
+func (p *PZEM004T) GetAddress() uint8 {
+	return p.address
+}
+
+// ReadDataWithRetry reads data with retry mechanism
+func (p *PZEM004T) ReadDataWithRetry(maxRetries int) (*meter.PowerData, error) {
+	return meter.ReadDataWithRetry(p, maxRetries)
 }
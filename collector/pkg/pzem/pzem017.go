@@ -0,0 +1,93 @@
+package pzem
+
+import (
+	"fmt"
+	"time"
+
+	"power-collector/pkg/meter"
+	"power-collector/pkg/modbus"
+)
+
+func init() {
+	meter.Register(meter.Driver{
+		Name:        "pzem017",
+		Description: "Peacefair PZEM-017 DC energy meter (serial line 8N2)",
+		Open: func(opts meter.Options) (meter.Meter, error) {
+			return NewPZEM017(opts.Bus, opts.Address), nil
+		},
+	})
+}
+
+// PZEM017 represents the PZEM-017 DC meter
+type PZEM017 struct {
+	bus     *modbus.Client
+	address uint8
+}
+
+// NewPZEM017 returns the PZEM-017 slave with the given Modbus address on a bus
+func NewPZEM017(bus *modbus.Client, address uint8) *PZEM017 {
+	return &PZEM017{
+		bus:     bus,
+		address: address,
+	}
+}
+
+// Close is a no-op, the bus is owned by the caller
+func (p *PZEM017) Close() error {
+	return nil
+}
+
+// Capabilities describes the PZEM-017
+func (p *PZEM017) Capabilities() meter.Capabilities {
+	return meter.Capabilities{
+		Model:  "PZEM-017",
+		DC:     true,
+		Energy: true,
+		Alarm:  true,
+	}
+}
+
+// ReadData reads power data from the PZEM-017 module
+// Request: read 8 input registers starting at 0x0000
+func (p *PZEM017) ReadData() (*meter.PowerData, error) {
+	data, err := p.bus.ReadInputRegisters(p.address, 0x0000, 8)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 16 {
+		return nil, fmt.Errorf("failed to parse response: insufficient data length: %d", len(data))
+	}
+
+	// 16 bytes: voltage, current, power, energy, high voltage alarm, low voltage alarm
+	// 32-bit values are sent as low word first, each word big-endian
+	voltage := uint16(data[0])<<8 + uint16(data[1])
+	current := uint16(data[2])<<8 + uint16(data[3])
+	power := uint32(data[6])<<24 + uint32(data[7])<<16 +
+		uint32(data[4])<<8 + uint32(data[5])
+	energy := uint32(data[10])<<24 + uint32(data[11])<<16 +
+		uint32(data[8])<<8 + uint32(data[9])
+	highVoltageAlarm := uint16(data[12])<<8 + uint16(data[13])
+	lowVoltageAlarm := uint16(data[14])<<8 + uint16(data[15])
+
+	return &meter.PowerData{
+		Timestamp: time.Now(),
+		Voltage:   float64(voltage) / 100.0, // 0.01V resolution
+		Current:   float64(current) / 100.0, // 0.01A resolution
+		Power:     float64(power) / 10.0,    // 0.1W resolution
+		Energy:    float64(energy),          // 1Wh resolution
+		Alarm:     highVoltageAlarm != 0 || lowVoltageAlarm != 0,
+		DC:        true,
+	}, nil
+}
+
+// TestConnection tests the connection to the PZEM-017 module
+func (p *PZEM017) TestConnection() error {
+	_, err := p.ReadData()
+	return err
+}
+
+// ResetEnergy resets the energy counter of the PZEM-017 to zero
+func (p *PZEM017) ResetEnergy() error {
+	return resetEnergy(p.bus, p.address)
+}
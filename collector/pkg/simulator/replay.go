@@ -0,0 +1,261 @@
+package simulator
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"power-collector/pkg/database"
+	"power-collector/pkg/meter"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func init() {
+	meter.Register(meter.Driver{
+		Name:        "replay",
+		Description: "Replays recorded readings from a CSV file or an exported cache database",
+		Virtual:     true,
+		Open: func(opts meter.Options) (meter.Meter, error) {
+			return NewReplay(opts.Simulation, opts.CollectorID)
+		},
+	})
+}
+
+// Replay plays back recorded readings at real or accelerated speed. Readings
+// are stamped with the time they are played back at.
+type Replay struct {
+	records []meter.PowerData
+	speed   float64
+	loop    bool
+
+	mu           sync.Mutex
+	wallStart    time.Time
+	energyOffset float64 // Wh added per completed loop to keep the counter monotonic
+}
+
+// NewReplay loads the recorded readings of source. Files ending in .csv are
+// read as CSV, anything else as a cache database exported by the collector,
+// from which the rows of collectorID are replayed if it holds any.
+func NewReplay(opts meter.SimulationOptions, collectorID string) (*Replay, error) {
+	if opts.Source == "" {
+		return nil, fmt.Errorf("replay source is required")
+	}
+
+	var records []meter.PowerData
+	var err error
+	if strings.EqualFold(filepath.Ext(opts.Source), ".csv") {
+		records, err = loadCSV(opts.Source)
+	} else {
+		records, err = loadCacheDB(opts.Source, collectorID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load replay source %s: %w", opts.Source, err)
+	}
+
+	if len(records) == 0 {
+		return nil, fmt.Errorf("replay source %s contains no readings", opts.Source)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Timestamp.Before(records[j].Timestamp)
+	})
+
+	speed := opts.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+
+	return &Replay{
+		records: records,
+		speed:   speed,
+		loop:    opts.Loop,
+	}, nil
+}
+
+// Close is a no-op
+func (r *Replay) Close() error {
+	return nil
+}
+
+// Capabilities describes the replayed meter
+func (r *Replay) Capabilities() meter.Capabilities {
+	return meter.Capabilities{
+		Model:       "Replay",
+		Frequency:   true,
+		PowerFactor: true,
+		Energy:      true,
+	}
+}
+
+// TestConnection always succeeds
+func (r *Replay) TestConnection() error {
+	return nil
+}
+
+// ReadData returns the recorded reading at the current playback position
+func (r *Replay) ReadData() (*meter.PowerData, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if r.wallStart.IsZero() {
+		r.wallStart = now
+	}
+
+	first := r.records[0]
+	last := r.records[len(r.records)-1]
+
+	position := first.Timestamp.Add(time.Duration(float64(now.Sub(r.wallStart)) * r.speed))
+	if position.After(last.Timestamp) {
+		if !r.loop {
+			return nil, fmt.Errorf("replay finished at %s", last.Timestamp.Format(time.RFC3339))
+		}
+
+		// Start over, continuing the energy counter where the recording ended
+		r.energyOffset += last.Energy - first.Energy
+		r.wallStart = now
+		position = first.Timestamp
+	}
+
+	// Last record at or before the playback position
+	i := sort.Search(len(r.records), func(i int) bool {
+		return r.records[i].Timestamp.After(position)
+	}) - 1
+	if i < 0 {
+		i = 0
+	}
+
+	data := r.records[i]
+	data.Timestamp = now
+	data.Energy += r.energyOffset
+	return &data, nil
+}
+
+// loadCSV reads readings from a CSV file whose header names the columns
+// timestamp (RFC 3339), voltage, current, power, energy, frequency and
+// power_factor
+func loadCSV(path string) ([]meter.PowerData, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["timestamp"]; !ok {
+		return nil, fmt.Errorf("missing timestamp column")
+	}
+
+	var records []meter.PowerData
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		timestamp, err := time.Parse(time.RFC3339, row[columns["timestamp"]])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid timestamp: %w", line, err)
+		}
+
+		value := func(name string) (float64, error) {
+			i, ok := columns[name]
+			if !ok || i >= len(row) || row[i] == "" {
+				return 0, nil
+			}
+			return strconv.ParseFloat(row[i], 64)
+		}
+
+		data := meter.PowerData{Timestamp: timestamp}
+		for name, field := range map[string]*float64{
+			"voltage":      &data.Voltage,
+			"current":      &data.Current,
+			"power":        &data.Power,
+			"energy":       &data.Energy,
+			"frequency":    &data.Frequency,
+			"power_factor": &data.PowerFactor,
+		} {
+			if *field, err = value(name); err != nil {
+				return nil, fmt.Errorf("line %d: invalid %s: %w", line, name, err)
+			}
+		}
+		records = append(records, data)
+	}
+
+	return records, nil
+}
+
+// loadCacheDB reads readings from a cache database exported by the collector.
+// The database is opened read-only and not migrated, so that the export is
+// left as it is. The rows of collectorID are read if it has any, otherwise
+// those of every collector.
+func loadCacheDB(path, collectorID string) ([]meter.PowerData, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+
+	db, err := gorm.Open(sqlite.Open("file:"+path+"?mode=ro"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cache database: %w", err)
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+
+	query := db.Model(&database.PowerDataCache{}).Order("timestamp ASC")
+	if collectorID != "" {
+		var count int64
+		if err := db.Model(&database.PowerDataCache{}).Where("collector_id = ?", collectorID).Count(&count).Error; err != nil {
+			return nil, fmt.Errorf("failed to count cached data: %w", err)
+		}
+		if count > 0 {
+			query = query.Where("collector_id = ?", collectorID)
+		}
+	}
+
+	var rows []database.PowerDataCache
+	if err := query.Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to read cached data: %w", err)
+	}
+
+	records := make([]meter.PowerData, 0, len(rows))
+	for _, row := range rows {
+		records = append(records, meter.PowerData{
+			Timestamp:   row.Timestamp,
+			Voltage:     row.Voltage,
+			Current:     row.Current,
+			Power:       row.Power,
+			Energy:      row.Energy,
+			Frequency:   row.Frequency,
+			PowerFactor: row.PowerFactor,
+		})
+	}
+
+	return records, nil
+}
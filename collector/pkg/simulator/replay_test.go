@@ -0,0 +1,150 @@
+package simulator
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"power-collector/pkg/database"
+	"power-collector/pkg/meter"
+)
+
+func TestLoadCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "readings.csv")
+	csv := "Timestamp, voltage, power, energy\n" +
+		"2024-01-01T00:01:00Z, 231, 120.5, 1001\n" +
+		"2024-01-01T00:00:00Z, 230, , 1000\n"
+	if err := os.WriteFile(path, []byte(csv), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := loadCSV(path)
+	if err != nil {
+		t.Fatalf("loadCSV failed: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+	if records[0].Voltage != 231 || records[0].Power != 120.5 || records[0].Energy != 1001 {
+		t.Errorf("Unexpected first record: %+v", records[0])
+	}
+	if records[1].Power != 0 || records[1].Current != 0 {
+		t.Errorf("Expected empty and missing columns to read as 0, got %+v", records[1])
+	}
+
+	tests := []struct {
+		name string
+		csv  string
+	}{
+		{"missing timestamp column", "voltage\n230\n"},
+		{"invalid timestamp", "timestamp,voltage\nyesterday,230\n"},
+		{"invalid value", "timestamp,voltage\n2024-01-01T00:00:00Z,high\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "readings.csv")
+			if err := os.WriteFile(path, []byte(tt.csv), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := loadCSV(path); err == nil {
+				t.Error("loadCSV expected an error")
+			}
+		})
+	}
+}
+
+func TestLoadCacheDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "export.db")
+	cache, err := database.NewCacheDB(path)
+	if err != nil {
+		t.Fatalf("NewCacheDB failed: %v", err)
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, id := range []string{"a", "a", "b"} {
+		seq, err := cache.NextSeq(id)
+		if err != nil {
+			t.Fatalf("NextSeq failed: %v", err)
+		}
+		data := &meter.PowerData{Timestamp: start.Add(time.Duration(i) * time.Minute), Power: float64(i)}
+		if err := cache.StorePowerData(id, seq, data); err != nil {
+			t.Fatalf("StorePowerData failed: %v", err)
+		}
+	}
+	cache.Close()
+
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := loadCacheDB(path, "a")
+	if err != nil {
+		t.Fatalf("loadCacheDB failed: %v", err)
+	}
+	if len(records) != 2 || records[1].Power != 1 {
+		t.Errorf("Expected the 2 readings of collector a, got %+v", records)
+	}
+
+	records, err = loadCacheDB(path, "unknown")
+	if err != nil {
+		t.Fatalf("loadCacheDB failed: %v", err)
+	}
+	if len(records) != 3 {
+		t.Errorf("Expected the readings of every collector for an unknown one, got %d", len(records))
+	}
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Error("Expected the exported database to be left unchanged")
+	}
+}
+
+func TestReplaySpeed(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := &Replay{
+		records: []meter.PowerData{
+			{Timestamp: start, Power: 10, Energy: 100},
+			{Timestamp: start.Add(time.Minute), Power: 20, Energy: 101},
+			{Timestamp: start.Add(2 * time.Minute), Power: 30, Energy: 102},
+			{Timestamp: start.Add(3 * time.Minute), Power: 40, Energy: 103},
+		},
+		speed: 6,
+		loop:  true,
+	}
+
+	tests := []struct {
+		name    string
+		elapsed time.Duration // wall time since the start of the playback
+		power   float64
+		energy  float64
+	}{
+		{"start", 0, 10, 100},
+		{"scaled past the second record", 15 * time.Second, 20, 101},
+		{"scaled past the third record", 21 * time.Second, 30, 102},
+		{"looped, continuing the energy counter", 31 * time.Second, 10, 103},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r.wallStart = time.Now().Add(-tt.elapsed)
+			r.energyOffset = 0
+			data, err := r.ReadData()
+			if err != nil {
+				t.Fatalf("ReadData failed: %v", err)
+			}
+			if data.Power != tt.power || data.Energy != tt.energy {
+				t.Errorf("Expected power %v and energy %v, got %v and %v", tt.power, tt.energy, data.Power, data.Energy)
+			}
+		})
+	}
+
+	r.loop = false
+	r.wallStart = time.Now().Add(-time.Minute)
+	if _, err := r.ReadData(); err == nil {
+		t.Error("Expected an error once the replay finished")
+	}
+}
@@ -0,0 +1,189 @@
+package simulator
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"power-collector/pkg/meter"
+)
+
+func init() {
+	meter.Register(meter.Driver{
+		Name:        "simulated",
+		Description: "Simulated household load without meter hardware",
+		Virtual:     true,
+		Open: func(opts meter.Options) (meter.Meter, error) {
+			return NewSimulated(opts.Simulation, opts.Address), nil
+		},
+	})
+}
+
+// Household load model parameters
+const (
+	fridgeOnDuration  = 15 * time.Minute
+	fridgeOffDuration = 25 * time.Minute
+	fridgePower       = 110.0 // W while the compressor runs
+	fridgeStartPower  = 450.0 // W inrush while the compressor starts
+	eveningPower      = 220.0 // W of lighting and entertainment
+	voltageDropPerKW  = 1.5   // V lost on the supply line per kW of load
+)
+
+// cookingWindow represents a part of the day during which cooking peaks occur
+type cookingWindow struct {
+	start, end  int     // hours of day
+	probability float64 // chance per minute that an appliance is switched on
+	minPower    float64 // W
+	maxPower    float64 // W
+	duration    time.Duration
+}
+
+var cookingWindows = []cookingWindow{
+	{start: 6, end: 9, probability: 0.04, minPower: 1800, maxPower: 2200, duration: 4 * time.Minute},    // kettle, toaster
+	{start: 11, end: 14, probability: 0.03, minPower: 1000, maxPower: 1800, duration: 12 * time.Minute}, // microwave, hob
+	{start: 17, end: 20, probability: 0.05, minPower: 1500, maxPower: 2800, duration: 25 * time.Minute}, // oven, hob
+}
+
+// Simulated generates realistic household load curves without hardware
+type Simulated struct {
+	opts   meter.SimulationOptions
+	rand   *rand.Rand
+	mu     sync.Mutex
+	phase  time.Duration // fridge cycle offset
+	energy float64       // Wh
+	last   time.Time
+
+	// current cooking event
+	cookingUntil time.Time
+	cookingPower float64
+	lastMinute   time.Time
+}
+
+// NewSimulated creates a simulated meter. Channels on different addresses get
+// different load curves from the same seed.
+func NewSimulated(opts meter.SimulationOptions, address uint8) *Simulated {
+	if opts.BaseLoad <= 0 {
+		opts.BaseLoad = 120
+	}
+	if opts.NominalVoltage <= 0 {
+		opts.NominalVoltage = 230
+	}
+	if opts.NominalFrequency <= 0 {
+		opts.NominalFrequency = 50
+	}
+
+	seed := opts.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	r := rand.New(rand.NewSource(seed + int64(address)))
+
+	return &Simulated{
+		opts:   opts,
+		rand:   r,
+		phase:  time.Duration(r.Int63n(int64(fridgeOnDuration + fridgeOffDuration))),
+		energy: float64(r.Intn(500000)),
+	}
+}
+
+// Close is a no-op
+func (s *Simulated) Close() error {
+	return nil
+}
+
+// Capabilities describes the simulated meter
+func (s *Simulated) Capabilities() meter.Capabilities {
+	return meter.Capabilities{
+		Model:       "Simulated",
+		Frequency:   true,
+		PowerFactor: true,
+		Energy:      true,
+	}
+}
+
+// TestConnection always succeeds
+func (s *Simulated) TestConnection() error {
+	return nil
+}
+
+// ResetEnergy resets the simulated energy counter to zero
+func (s *Simulated) ResetEnergy() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.energy = 0
+	return nil
+}
+
+// ReadData returns the simulated load at the current time
+func (s *Simulated) ReadData() (*meter.PowerData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	// Resistive loads have a power factor near 1, motors and electronics less
+	resistive := 0.0
+	reactive := s.opts.BaseLoad * (1 + 0.1*(s.rand.Float64()-0.5))
+
+	// Fridge compressor cycle with a short inrush after switching on
+	cycle := (time.Duration(now.UnixNano()) + s.phase) % (fridgeOnDuration + fridgeOffDuration)
+	if cycle < fridgeOnDuration {
+		if cycle < 5*time.Second {
+			reactive += fridgeStartPower
+		} else {
+			reactive += fridgePower * (1 + 0.05*(s.rand.Float64()-0.5))
+		}
+	}
+
+	// Lighting and entertainment in the evening
+	hour := now.Hour()
+	if hour >= 18 && hour < 23 {
+		reactive += eveningPower * (0.8 + 0.4*s.rand.Float64())
+	}
+
+	// Cooking peaks, decided once per minute
+	minute := now.Truncate(time.Minute)
+	if !minute.Equal(s.lastMinute) {
+		s.lastMinute = minute
+		if now.After(s.cookingUntil) {
+			for _, window := range cookingWindows {
+				if hour >= window.start && hour < window.end && s.rand.Float64() < window.probability {
+					s.cookingPower = window.minPower + s.rand.Float64()*(window.maxPower-window.minPower)
+					s.cookingUntil = now.Add(window.duration)
+					break
+				}
+			}
+		}
+	}
+	if now.Before(s.cookingUntil) {
+		// Thermostats switch the heating elements on and off
+		if s.rand.Float64() < 0.8 {
+			resistive += s.cookingPower
+		}
+	}
+
+	power := resistive + reactive
+	powerFactor := (resistive*0.99 + reactive*0.85) / power
+
+	voltage := s.opts.NominalVoltage + s.rand.NormFloat64()*1.0 - voltageDropPerKW*power/1000
+	frequency := s.opts.NominalFrequency + s.rand.NormFloat64()*0.02
+	current := power / (voltage * powerFactor)
+
+	// Integrate the energy counter since the previous reading
+	if !s.last.IsZero() {
+		s.energy += power * now.Sub(s.last).Hours()
+	}
+	s.last = now
+
+	return &meter.PowerData{
+		Timestamp:   now,
+		Voltage:     math.Round(voltage*10) / 10,
+		Current:     math.Round(current*1000) / 1000,
+		Power:       math.Round(power*10) / 10,
+		Energy:      math.Floor(s.energy),
+		Frequency:   math.Round(frequency*10) / 10,
+		PowerFactor: math.Round(powerFactor*100) / 100,
+	}, nil
+}
@@ -0,0 +1,104 @@
+// Package systemd reports the state of the collector to systemd when it runs
+// as a Type=notify service, and pings the service watchdog while the
+// collector is making progress. Nothing is sent when the collector is not
+// started by systemd.
+package systemd
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+)
+
+var (
+	mu sync.Mutex
+	// status is the last status reported, restored when the watchdog check
+	// passes again
+	status string
+)
+
+// Ready tells systemd that the collector has started
+func Ready(s string) {
+	setStatus(s)
+	notify(daemon.SdNotifyReady, "STATUS="+s)
+}
+
+// Reloading tells systemd that the collector is restarting in place. The
+// restarted process reports Ready again.
+func Reloading() {
+	notify(daemon.SdNotifyReloading, "STATUS=Restarting")
+}
+
+// Stopping tells systemd that the collector is shutting down
+func Stopping() {
+	notify(daemon.SdNotifyStopping, "STATUS=Stopping")
+}
+
+// Status reports a status line shown by systemctl status
+func Status(s string) {
+	setStatus(s)
+	notify("STATUS=" + s)
+}
+
+// Watchdog pings the systemd watchdog at half its interval until stop is
+// closed, as long as check returns nil. While check fails, the ping is
+// withheld and the failure reported as the status, so that systemd restarts
+// the collector once WatchdogSec has passed. It returns at once if the
+// watchdog is not enabled for the process.
+func Watchdog(stop <-chan struct{}, check func() error) {
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		log.Printf("Failed to read systemd watchdog settings: %v", err)
+		return
+	}
+	if interval == 0 {
+		return
+	}
+	log.Printf("systemd watchdog enabled (interval: %v)", interval)
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	stalled := false
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := check(); err != nil {
+				if !stalled {
+					log.Printf("Withholding systemd watchdog ping: %v", err)
+					notify("STATUS=" + err.Error())
+					stalled = true
+				}
+				continue
+			}
+			if stalled {
+				log.Println("Collector is making progress again, resuming systemd watchdog pings")
+				mu.Lock()
+				notify("STATUS=" + status)
+				mu.Unlock()
+				stalled = false
+			}
+			notify(daemon.SdNotifyWatchdog)
+		}
+	}
+}
+
+// setStatus records the status to restore after a failed watchdog check
+func setStatus(s string) {
+	mu.Lock()
+	defer mu.Unlock()
+	status = s
+}
+
+// notify sends the states to systemd. The environment is kept, so that a
+// process replacing the collector in place can notify as well.
+func notify(states ...string) {
+	if _, err := daemon.SdNotify(false, strings.Join(states, "\n")); err != nil {
+		log.Printf("Failed to notify systemd: %v", err)
+	}
+}
@@ -28,7 +28,7 @@ func getDashboardData(c *gin.Context) {
 		DataPointsToday    int64   `json:"data_points_today"`
 		DataPointsThisWeek int64   `json:"data_points_this_week"`
 		AveragePower       float64 `json:"average_power"`
-		TotalEnergy        float64 `json:"total_energy"`
+		TotalEnergy        float64 `json:"total_energy"` // kWh
 	}
 
 	model.DB.Model(&model.User{}).Count(&stats.TotalUsers)
@@ -46,7 +46,7 @@ func getDashboardData(c *gin.Context) {
 
 	// Calculate average power and total energy
 	model.DB.Model(&model.PowerData{}).
-		Select("AVG(power), SUM(energy)").
+		Select("AVG(power), COALESCE(SUM(energy_delta), 0) / 1000.0").
 		Row().Scan(&stats.AveragePower, &stats.TotalEnergy)
 
 	// Get recent activity data (last 24 hours)
@@ -138,7 +138,7 @@ func getPowerDataAnalytics(c *gin.Context) {
 		AvgPower    float64 `json:"avg_power"`
 		MaxPower    float64 `json:"max_power"`
 		MinPower    float64 `json:"min_power"`
-		TotalEnergy float64 `json:"total_energy"`
+		TotalEnergy float64 `json:"total_energy"` // kWh
 		DataPoints  int64   `json:"data_points"`
 	}
 
@@ -150,7 +150,7 @@ func getPowerDataAnalytics(c *gin.Context) {
 				AVG(power) as avg_power,
 				MAX(power) as max_power,
 				MIN(power) as min_power,
-				SUM(energy) as total_energy,
+				COALESCE(SUM(energy_delta), 0) / 1000.0 as total_energy,
 				COUNT(*) as data_points
 			FROM power_data 
 			WHERE timestamp >= ?`
@@ -161,7 +161,7 @@ func getPowerDataAnalytics(c *gin.Context) {
 				AVG(power) as avg_power,
 				MAX(power) as max_power,
 				MIN(power) as min_power,
-				SUM(energy) as total_energy,
+				COALESCE(SUM(energy_delta), 0) / 1000.0 as total_energy,
 				COUNT(*) as data_points
 			FROM power_data 
 			WHERE timestamp >= ?`
@@ -183,12 +183,12 @@ func getPowerDataAnalytics(c *gin.Context) {
 		AvgPower        float64 `json:"avg_power"`
 		MaxPower        float64 `json:"max_power"`
 		MinPower        float64 `json:"min_power"`
-		TotalEnergy     float64 `json:"total_energy"`
+		TotalEnergy     float64 `json:"total_energy"` // kWh
 		PeakHour        string  `json:"peak_hour"`
 		LowestHour      string  `json:"lowest_hour"`
 	}
 
-	query.Select("COUNT(*), AVG(power), MAX(power), MIN(power), SUM(energy)").
+	query.Select("COUNT(*), AVG(power), MAX(power), MIN(power), COALESCE(SUM(energy_delta), 0) / 1000.0").
 		Row().Scan(&summary.TotalDataPoints, &summary.AvgPower, &summary.MaxPower, &summary.MinPower, &summary.TotalEnergy)
 
 	// Find peak and lowest consumption periods
@@ -246,24 +246,24 @@ func getCollectorData(c *gin.Context) {
 			LastDataTime    time.Time `json:"last_data_time"`
 			AvgPower        float64   `json:"avg_power"`
 			MaxPower        float64   `json:"max_power"`
-			TotalEnergy     float64   `json:"total_energy"`
+			TotalEnergy     float64   `json:"total_energy"` // kWh
 			IsOnline        bool      `json:"is_online"`
 		}
 
 		model.DB.Model(&model.PowerData{}).
-			Where("collector_id = ?", collector.ID).
+			Where("collector_id = ?", collector.CollectorID).
 			Count(&stats.TotalDataPoints)
 
 		model.DB.Model(&model.PowerData{}).
-			Where("collector_id = ?", collector.ID).
-			Select("MIN(timestamp), MAX(timestamp), AVG(power), MAX(power), SUM(energy)").
+			Where("collector_id = ?", collector.CollectorID).
+			Select("MIN(timestamp), MAX(timestamp), AVG(power), MAX(power), COALESCE(SUM(energy_delta), 0) / 1000.0").
 			Row().Scan(&stats.FirstDataTime, &stats.LastDataTime, &stats.AvgPower, &stats.MaxPower, &stats.TotalEnergy)
 
 		stats.IsOnline = collector.IsOnline()
 
 		// Get recent power trend (last 10 readings)
 		var recentData []model.PowerData
-		model.DB.Where("collector_id = ?", collector.ID).
+		model.DB.Where("collector_id = ?", collector.CollectorID).
 			Order("timestamp DESC").
 			Limit(10).
 			Find(&recentData)
@@ -282,7 +282,7 @@ func getCollectorData(c *gin.Context) {
 	case "realtime":
 		// Get latest real-time data
 		var latestData model.PowerData
-		if err := model.DB.Where("collector_id = ?", collector.ID).
+		if err := model.DB.Where("collector_id = ?", collector.CollectorID).
 			Order("timestamp DESC").First(&latestData).Error; err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "No data found for collector"})
 			return
@@ -318,7 +318,7 @@ func getCollectorData(c *gin.Context) {
 		var historyData []model.PowerData
 		limit := 1000 // Limit to prevent too much data
 
-		model.DB.Where("collector_id = ? AND timestamp >= ?", collector.ID, startTime).
+		model.DB.Where("collector_id = ? AND timestamp >= ?", collector.CollectorID, startTime).
 			Order("timestamp ASC").
 			Limit(limit).
 			Find(&historyData)
@@ -14,6 +14,9 @@ import (
 	"gorm.io/gorm"
 )
 
+// maxTrendHours bounds the period of the diagnostics trend of a collector
+const maxTrendHours = 30 * 24
+
 func registerCollectorRoutes(r *gin.RouterGroup) {
 	// Collector management routes
 	collectors := r.Group("/collectors")
@@ -25,6 +28,12 @@ func registerCollectorRoutes(r *gin.RouterGroup) {
 		collectors.DELETE("/:id", deleteCollector)
 		collectors.GET("/:id/status", getCollectorStatus)
 		collectors.POST("/:id/config", updateCollectorConfig)
+		collectors.GET("/:id/data-loss", getCollectorDataLoss)
+		collectors.GET("/:id/alarms", getCollectorAlarmEvents)
+		collectors.GET("/:id/rejected", getCollectorRejectedReadings)
+		collectors.POST("/:id/token/rotate", rotateCollectorToken)
+		collectors.POST("/:id/token/revoke", revokeCollectorToken)
+		collectors.PUT("/:id/signing", updateCollectorSigning)
 	}
 
 	// Registration codes
@@ -120,13 +129,14 @@ func createCollector(c *gin.Context) {
 
 	currentUserID := c.GetUint("user_id")
 	collector := &model.Collector{
-		CollectorID: req.CollectorID,
-		Name:        req.Name,
-		Description: req.Description,
-		Location:    req.Location,
-		IsActive:    true,
-		Token:       token,
-		UserID:      currentUserID,
+		CollectorID:    req.CollectorID,
+		Name:           req.Name,
+		Description:    req.Description,
+		Location:       req.Location,
+		IsActive:       true,
+		Token:          token,
+		TokenExpiresAt: time.Now().Add(settings.CollectorSettings.TokenExpires),
+		UserID:         currentUserID,
 	}
 
 	if err := model.DB.Create(collector).Error; err != nil {
@@ -282,9 +292,261 @@ func getCollectorStatus(c *gin.Context) {
 		DataCount:    dataCount,
 	}
 
+	// Latest diagnostics and their trend over the requested hours
+	var latest model.CollectorHeartbeat
+	if err := model.DB.Where("collector_id = ?", collector.CollectorID).
+		Order("timestamp DESC").
+		Limit(1).
+		Find(&latest).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get collector diagnostics"})
+		return
+	}
+	if latest.ID != 0 {
+		status.Diagnostics = &latest
+	}
+
+	hours, _ := strconv.Atoi(c.DefaultQuery("hours", "24"))
+	if hours < 1 || hours > maxTrendHours {
+		hours = 24
+	}
+	status.Trend = []model.CollectorHeartbeat{}
+	if err := model.DB.Where("collector_id = ? AND timestamp >= ?", collector.CollectorID, time.Now().Add(-time.Duration(hours)*time.Hour)).
+		Order("timestamp").
+		Find(&status.Trend).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get collector diagnostics"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"data": status})
 }
 
+func getCollectorDataLoss(c *gin.Context) {
+	id := c.Param("id")
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+
+	var collector model.Collector
+	if err := model.DB.First(&collector, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Collector not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get collector"})
+		}
+		return
+	}
+
+	var losses []model.CollectorDataLoss
+	var total int64
+
+	query := model.DB.Model(&model.CollectorDataLoss{}).Where("collector_id = ?", collector.CollectorID)
+
+	// Count total
+	query.Count(&total)
+
+	// Get losses with pagination, newest first
+	offset := (page - 1) * pageSize
+	if err := query.Order("id DESC").Offset(offset).Limit(pageSize).Find(&losses).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get data loss"})
+		return
+	}
+
+	c.JSON(http.StatusOK, model.ListResponse{
+		Data: losses,
+		Pagination: model.Pagination{
+			Total:    total,
+			Current:  page,
+			PageSize: pageSize,
+		},
+	})
+}
+
+func getCollectorRejectedReadings(c *gin.Context) {
+	id := c.Param("id")
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+
+	var collector model.Collector
+	if err := model.DB.First(&collector, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Collector not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get collector"})
+		}
+		return
+	}
+
+	var readings []model.RejectedReading
+	var total int64
+
+	query := model.DB.Model(&model.RejectedReading{}).Where("collector_id = ?", collector.CollectorID)
+
+	// Filter by quality
+	if quality := c.Query("quality"); quality != "" {
+		query = query.Where("quality = ?", quality)
+	}
+
+	// Count total
+	query.Count(&total)
+
+	// Get readings with pagination, newest first
+	offset := (page - 1) * pageSize
+	if err := query.Order("timestamp DESC").Offset(offset).Limit(pageSize).Find(&readings).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get rejected readings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, model.ListResponse{
+		Data: readings,
+		Pagination: model.Pagination{
+			Total:    total,
+			Current:  page,
+			PageSize: pageSize,
+		},
+	})
+}
+
+func getCollectorAlarmEvents(c *gin.Context) {
+	id := c.Param("id")
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+
+	var collector model.Collector
+	if err := model.DB.First(&collector, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Collector not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get collector"})
+		}
+		return
+	}
+
+	var events []model.CollectorAlarmEvent
+	var total int64
+
+	query := model.DB.Model(&model.CollectorAlarmEvent{}).Where("collector_id = ?", collector.CollectorID)
+
+	// Count total
+	query.Count(&total)
+
+	// Get events with pagination, newest first
+	offset := (page - 1) * pageSize
+	if err := query.Order("timestamp DESC").Offset(offset).Limit(pageSize).Find(&events).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get alarm events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, model.ListResponse{
+		Data: events,
+		Pagination: model.Pagination{
+			Total:    total,
+			Current:  page,
+			PageSize: pageSize,
+		},
+	})
+}
+
+// rotateCollectorToken issues a new token to a collector. The old token
+// stops working at once, so the new one has to be configured on the
+// collector by hand.
+func rotateCollectorToken(c *gin.Context) {
+	id := c.Param("id")
+
+	var collector model.Collector
+	if err := model.DB.First(&collector, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Collector not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get collector"})
+		}
+		return
+	}
+
+	token := auth.GenerateSecureToken()
+	collector.RotateToken(token, settings.CollectorSettings.TokenExpires, 0)
+	if err := model.DB.Save(&collector).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Token rotated successfully",
+		"data": model.CollectorTokenResponse{
+			Token:     token,
+			ExpiresAt: collector.TokenExpiresAt,
+		},
+	})
+}
+
+// revokeCollectorToken makes the tokens of a collector invalid until a new
+// one is issued by rotation
+func revokeCollectorToken(c *gin.Context) {
+	id := c.Param("id")
+
+	var collector model.Collector
+	if err := model.DB.First(&collector, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Collector not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get collector"})
+		}
+		return
+	}
+
+	collector.RevokeToken()
+	if err := model.DB.Save(&collector).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Token revoked successfully",
+	})
+}
+
+// updateCollectorSigning enforces or relaxes request signing for a
+// collector. A secret is generated when signing is required and the
+// collector has none, or when a rotation is requested; only then is it
+// returned.
+func updateCollectorSigning(c *gin.Context) {
+	id := c.Param("id")
+
+	var req model.CollectorSigningRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	var collector model.Collector
+	if err := model.DB.First(&collector, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Collector not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get collector"})
+		}
+		return
+	}
+
+	response := model.CollectorSigningResponse{RequireSignature: req.RequireSignature}
+	if req.RotateSecret || (req.RequireSignature && collector.SigningSecret == "") {
+		collector.SigningSecret = auth.GenerateSecureToken()
+		response.SigningSecret = collector.SigningSecret
+	}
+	collector.RequireSignature = req.RequireSignature
+
+	if err := model.DB.Save(&collector).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update request signing"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Request signing updated successfully",
+		"data":    response,
+	})
+}
+
 func updateCollectorConfig(c *gin.Context) {
 	id := c.Param("id")
 
@@ -305,11 +567,15 @@ func updateCollectorConfig(c *gin.Context) {
 	}
 
 	req.CollectorID = collector.CollectorID
+	req.AppliedVersion = 0
+	req.AppliedAt = time.Time{}
 
-	// Update or create config
+	// Update or create config. Every change bumps the version, so that the
+	// collector picks it up on its next poll.
 	var config model.CollectorConfig
 	if err := model.DB.Where("collector_id = ?", collector.CollectorID).First(&config).Error; err != nil {
 		// Create new config
+		req.Version = 1
 		if err := model.DB.Create(&req).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create config"})
 			return
@@ -320,8 +586,19 @@ func updateCollectorConfig(c *gin.Context) {
 		config.SampleInterval = req.SampleInterval
 		config.UploadInterval = req.UploadInterval
 		config.MaxCacheSize = req.MaxCacheSize
+		config.BatchSize = req.BatchSize
 		config.AutoUpload = req.AutoUpload
 		config.CompressionLevel = req.CompressionLevel
+		config.VoltageMin = req.VoltageMin
+		config.VoltageMax = req.VoltageMax
+		config.CurrentMax = req.CurrentMax
+		config.PowerMax = req.PowerMax
+		config.FrequencyMin = req.FrequencyMin
+		config.FrequencyMax = req.FrequencyMax
+		config.SpikeFilter = req.SpikeFilter
+		config.SpikeThreshold = req.SpikeThreshold
+		config.UploadRejected = req.UploadRejected
+		config.Version++
 
 		if err := model.DB.Save(&config).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update config"})
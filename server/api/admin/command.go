@@ -0,0 +1,127 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"Power-Monitor/internal/command"
+	"Power-Monitor/internal/realtime"
+	"Power-Monitor/model"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func registerCommandRoutes(r *gin.RouterGroup) {
+	// Collector command channel
+	r.POST("/collectors/:id/commands", sendCollectorCommand)
+	r.GET("/collectors/:id/commands", getCollectorCommands)
+	r.GET("/commands/:id", getCollectorCommand)
+}
+
+func sendCollectorCommand(c *gin.Context) {
+	id := c.Param("id")
+
+	var collector model.Collector
+	if err := model.DB.First(&collector, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Collector not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get collector"})
+		}
+		return
+	}
+
+	var req model.CollectorCommandRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	if !model.IsValidCommand(req.Command) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown command"})
+		return
+	}
+
+	cmd, err := command.Send(collector.CollectorID, req.Command, req.Args, c.GetUint("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send command"})
+		return
+	}
+
+	message := "Command sent to collector"
+	if !realtime.IsCollectorConnected(collector.CollectorID) {
+		message = "Collector is offline, command queued until it connects"
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": message,
+		"data":    cmd,
+	})
+}
+
+func getCollectorCommands(c *gin.Context) {
+	id := c.Param("id")
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+
+	var collector model.Collector
+	if err := model.DB.First(&collector, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Collector not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get collector"})
+		}
+		return
+	}
+
+	var commands []model.CollectorCommand
+	var total int64
+
+	query := model.DB.Model(&model.CollectorCommand{}).Where("collector_id = ?", collector.CollectorID)
+
+	// Status filter
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	// Count total
+	query.Count(&total)
+
+	// Get commands with pagination, newest first
+	offset := (page - 1) * pageSize
+	if err := query.Order("id DESC").Offset(offset).Limit(pageSize).Find(&commands).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get commands"})
+		return
+	}
+
+	c.JSON(http.StatusOK, model.ListResponse{
+		Data: commands,
+		Pagination: model.Pagination{
+			Total:    total,
+			Current:  page,
+			PageSize: pageSize,
+		},
+	})
+}
+
+func getCollectorCommand(c *gin.Context) {
+	id := c.Param("id")
+
+	var cmd model.CollectorCommand
+	if err := model.DB.First(&cmd, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Command not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get command"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    cmd,
+	})
+}
@@ -12,6 +12,9 @@ func RegisterRoutes(r *gin.RouterGroup) {
 	// Collector management
 	registerCollectorRoutes(r)
 
+	// Collector commands
+	registerCommandRoutes(r)
+
 	// System management
 	registerSystemRoutes(r)
 
@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"math"
 	"net/http"
+	"sort"
 	"strconv"
 	"time"
 
+	"Power-Monitor/internal/energy"
 	"Power-Monitor/model"
 
 	"github.com/gin-gonic/gin"
@@ -35,7 +37,7 @@ func getDashboard(c *gin.Context) {
 		TotalCollectors  int     `json:"total_collectors"`
 		OnlineCollectors int     `json:"online_collectors"`
 		TotalPower       float64 `json:"total_power"`
-		TotalEnergy      float64 `json:"total_energy"`
+		TotalEnergy      float64 `json:"total_energy"` // kWh consumed today
 		AlertsCount      int     `json:"alerts_count"`
 	}
 
@@ -43,6 +45,7 @@ func getDashboard(c *gin.Context) {
 
 	// Calculate online collectors and power consumption
 	now := time.Now()
+	todayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
 	for _, collector := range collectors {
 		if collector.IsOnline() {
 			summary.OnlineCollectors++
@@ -50,11 +53,17 @@ func getDashboard(c *gin.Context) {
 
 		// Get latest power data
 		var latestData model.PowerData
-		if err := model.DB.Where("collector_id = ?", collector.ID).
+		if err := model.DB.Where("collector_id = ?", collector.CollectorID).
 			Order("timestamp DESC").First(&latestData).Error; err == nil {
 			summary.TotalPower += latestData.Power
-			summary.TotalEnergy += latestData.Energy
 		}
+
+		var todayEnergy float64
+		model.DB.Model(&model.PowerData{}).
+			Select("COALESCE(SUM(energy_delta) / 1000.0, 0)").
+			Where("collector_id = ? AND timestamp >= ?", collector.CollectorID, todayStart).
+			Row().Scan(&todayEnergy)
+		summary.TotalEnergy += todayEnergy
 	}
 
 	// Get recent data for charts (last 24 hours)
@@ -113,11 +122,12 @@ func getEnergyConsumption(c *gin.Context) {
 	var data []struct {
 		CollectorID string    `json:"collector_id"`
 		Timestamp   time.Time `json:"timestamp"`
-		Energy      float64   `json:"energy"`
+		Energy      float64   `json:"energy"`       // Wh, cumulative meter counter
+		EnergyDelta float64   `json:"energy_delta"` // Wh consumed since the previous reading
 	}
 
 	model.DB.Model(&model.PowerData{}).
-		Select("collector_id, timestamp, energy").
+		Select("collector_id, timestamp, energy, energy_delta").
 		Where("collector_id IN ? AND timestamp >= ?", collectorIDs, startTime).
 		Order("timestamp ASC").
 		Find(&data)
@@ -176,7 +186,7 @@ func getPowerTrends(c *gin.Context) {
 		AveragePower   float64 `json:"average_power"`
 		MaxPower       float64 `json:"max_power"`
 		MinPower       float64 `json:"min_power"`
-		EnergyConsumed float64 `json:"energy_consumed"`
+		EnergyConsumed float64 `json:"energy_consumed"` // kWh
 		DataPoints     int64   `json:"data_points"`
 	}
 
@@ -214,7 +224,7 @@ func getPowerTrends(c *gin.Context) {
 		if entry.DataPoints == 0 || data.Power < entry.MinPower {
 			entry.MinPower = data.Power
 		}
-		entry.EnergyConsumed += data.Energy
+		entry.EnergyConsumed += data.EnergyDelta / 1000.0 // Convert to kWh
 		entry.DataPoints++
 		periodMap[period] = entry
 	}
@@ -240,6 +250,9 @@ func getPowerTrends(c *gin.Context) {
 			DataPoints:     entry.DataPoints,
 		})
 	}
+	sort.Slice(trends, func(i, j int) bool {
+		return trends[i].Period < trends[j].Period
+	})
 
 	// Calculate trend analysis
 	var analysis struct {
@@ -273,7 +286,9 @@ func getPowerTrends(c *gin.Context) {
 			analysis.TrendDirection = "stable"
 		}
 
-		analysis.PercentChange = ((secondAvg - firstAvg) / firstAvg) * 100
+		if firstAvg > 0 {
+			analysis.PercentChange = ((secondAvg - firstAvg) / firstAvg) * 100
+		}
 
 		// Find peak and lowest periods
 		maxPower := trends[0].AveragePower
@@ -357,9 +372,8 @@ func getCostAnalysis(c *gin.Context) {
 		SELECT 
 			c.collector_id,
 			c.name as collector_name,
-			COALESCE(SUM(pd.energy) / 1000, 0) as total_energy_kwh,
-			COALESCE(AVG(pd.power), 0) as average_power,
-			COALESCE(COUNT(pd.id) * (SELECT sample_interval FROM collector_configs WHERE collector_id = c.collector_id LIMIT 1) / 3600.0, 0) as operating_hours
+			COALESCE(SUM(pd.energy_delta) / 1000.0, 0) as total_energy_kwh,
+			COALESCE(AVG(pd.power), 0) as average_power
 		FROM collectors c
 		LEFT JOIN power_data pd ON c.collector_id = pd.collector_id AND pd.timestamp >= ?
 		WHERE c.user_id = ? AND c.is_active = true
@@ -391,8 +405,21 @@ func getCostAnalysis(c *gin.Context) {
 	// Use GORM's standard query methods instead of raw SQL
 	var powerDataList []model.PowerData
 	model.DB.Where("collector_id IN ? AND timestamp >= ?", collectorIDs, startTime).
+		Order("collector_id, timestamp, id").
 		Find(&powerDataList)
 
+	// Operating hours are the time the readings of each collector cover
+	operatingHours := make(map[string]float64)
+	for first, i := 0, 1; i <= len(powerDataList); i++ {
+		if i == len(powerDataList) || powerDataList[i].CollectorID != powerDataList[first].CollectorID {
+			operatingHours[powerDataList[first].CollectorID] = energy.Covered(powerDataList[first:i]).Hours()
+			first = i
+		}
+	}
+	for i := range collectorCosts {
+		collectorCosts[i].OperatingHours = operatingHours[collectorCosts[i].CollectorID]
+	}
+
 	// Group by date manually
 	dailyMap := make(map[string]struct {
 		EnergyUsed float64
@@ -402,7 +429,7 @@ func getCostAnalysis(c *gin.Context) {
 	for _, data := range powerDataList {
 		date := data.Timestamp.Format("2006-01-02")
 		entry := dailyMap[date]
-		entry.EnergyUsed += data.Energy / 1000.0 // Convert to kWh
+		entry.EnergyUsed += data.EnergyDelta / 1000.0 // Convert to kWh
 		entry.DataPoints++
 		dailyMap[date] = entry
 	}
@@ -869,7 +896,7 @@ func predictLinearTrend(collectorIDs []string, todayStart, now time.Time, hasCur
 	hourlyMap := make(map[int]float64)
 	for _, data := range powerDataList {
 		hour := data.Timestamp.Hour()
-		hourlyMap[hour] += data.Energy / 1000.0 // Convert to kWh
+		hourlyMap[hour] += data.EnergyDelta / 1000.0 // Convert to kWh
 	}
 
 	// Convert map to slice
@@ -948,7 +975,7 @@ func predictSeasonalPattern(collectorIDs []string, todayStart, now time.Time, ha
 
 		var dayEnergy float64
 		err := model.DB.Model(&model.PowerData{}).
-			Select("COALESCE(SUM(energy) / 1000.0, 0)").
+			Select("COALESCE(SUM(energy_delta) / 1000.0, 0)").
 			Where("collector_id IN ? AND timestamp >= ? AND timestamp < ?", collectorIDs, dayStart, dayEnd).
 			Row().Scan(&dayEnergy)
 
@@ -1021,7 +1048,7 @@ func predictMovingAverage(collectorIDs []string, todayStart, now time.Time, hasC
 
 		var dayEnergy float64
 		err := model.DB.Model(&model.PowerData{}).
-			Select("COALESCE(SUM(energy) / 1000.0, 0)").
+			Select("COALESCE(SUM(energy_delta) / 1000.0, 0)").
 			Where("collector_id IN ? AND timestamp >= ? AND timestamp < ?", collectorIDs, dayStart, dayEnd).
 			Row().Scan(&dayEnergy)
 
@@ -1152,8 +1179,8 @@ func createFallbackPrediction(collectorIDs []string, todayStart, now time.Time,
 
 	for _, data := range anyHistoricalData {
 		date := data.Timestamp.Format("2006-01-02")
-		dayMap[date] += data.Energy / 1000.0 // Convert to kWh
-		totalEnergy += data.Energy / 1000.0
+		dayMap[date] += data.EnergyDelta / 1000.0 // Convert to kWh
+		totalEnergy += data.EnergyDelta / 1000.0
 	}
 
 	var avgDailyEnergy float64
@@ -1200,7 +1227,7 @@ func createFallbackPrediction(collectorIDs []string, todayStart, now time.Time,
 func getTodayActualConsumption(collectorIDs []string, todayStart, now time.Time) float64 {
 	var consumption float64
 	model.DB.Model(&model.PowerData{}).
-		Select("COALESCE(SUM(energy) / 1000.0, 0)").
+		Select("COALESCE(SUM(energy_delta) / 1000.0, 0)").
 		Where("collector_id IN ? AND timestamp >= ? AND timestamp <= ?", collectorIDs, todayStart, now).
 		Row().Scan(&consumption)
 	return consumption
@@ -1228,7 +1255,7 @@ func generateHourlyPredictions(collectorIDs []string, todayStart, now time.Time,
 	var totalEnergy float64
 	var totalCount int64
 	for _, data := range historicalData {
-		totalEnergy += data.Energy
+		totalEnergy += data.EnergyDelta
 		totalCount++
 	}
 	overallAvg := totalEnergy / float64(totalCount)
@@ -1242,7 +1269,7 @@ func generateHourlyPredictions(collectorIDs []string, todayStart, now time.Time,
 	for _, data := range historicalData {
 		hour := data.Timestamp.Hour()
 		entry := hourlyMap[hour]
-		entry.TotalEnergy += data.Energy
+		entry.TotalEnergy += data.EnergyDelta
 		entry.Count++
 		hourlyMap[hour] = entry
 	}
@@ -1300,7 +1327,7 @@ func generateCollectorPredictions(collectorIDs []string, todayStart, now time.Ti
 			Row().Scan(&collectorData.Name)
 
 		model.DB.Model(&model.PowerData{}).
-			Select("COALESCE(SUM(energy) / 1000.0, 0)").
+			Select("COALESCE(SUM(energy_delta) / 1000.0, 0)").
 			Where("collector_id = ? AND timestamp >= ? AND timestamp <= ?", collectorID, todayStart, now).
 			Row().Scan(&collectorData.CurrentEnergy)
 
@@ -1313,7 +1340,7 @@ func generateCollectorPredictions(collectorIDs []string, todayStart, now time.Ti
 		dailyTotals := make(map[string]float64)
 		for _, data := range historicalPowerData {
 			date := data.Timestamp.Format("2006-01-02")
-			dailyTotals[date] += data.Energy / 1000.0 // Convert to kWh
+			dailyTotals[date] += data.EnergyDelta / 1000.0 // Convert to kWh
 		}
 
 		// Calculate average of daily totals
@@ -244,7 +244,7 @@ func getDataStatistics(c *gin.Context) {
 		AvgPower        float64   `json:"avg_power"`
 		MaxPower        float64   `json:"max_power"`
 		MinPower        float64   `json:"min_power"`
-		TotalEnergy     float64   `json:"total_energy"`
+		TotalEnergy     float64   `json:"total_energy"` // kWh
 		LastUpdated     time.Time `json:"last_updated"`
 	}
 
@@ -255,7 +255,7 @@ func getDataStatistics(c *gin.Context) {
 
 	model.DB.Model(&model.PowerData{}).
 		Where("collector_id = ?", collector.CollectorID).
-		Select("AVG(power), MAX(power), MIN(power), MAX(energy), MAX(timestamp)").
+		Select("AVG(power), MAX(power), MIN(power), COALESCE(SUM(energy_delta), 0) / 1000.0, MAX(timestamp)").
 		Row().Scan(&stats.AvgPower, &stats.MaxPower, &stats.MinPower, &stats.TotalEnergy, &stats.LastUpdated)
 
 	c.JSON(http.StatusOK, gin.H{
@@ -290,7 +290,7 @@ func getCollectorDataView(c *gin.Context) {
 			LastDataTime    time.Time `json:"last_data_time"`
 			AvgPower        float64   `json:"avg_power"`
 			MaxPower        float64   `json:"max_power"`
-			TotalEnergy     float64   `json:"total_energy"`
+			TotalEnergy     float64   `json:"total_energy"` // kWh
 			IsOnline        bool      `json:"is_online"`
 		}
 
@@ -300,7 +300,7 @@ func getCollectorDataView(c *gin.Context) {
 
 		model.DB.Model(&model.PowerData{}).
 			Where("collector_id = ?", collector.CollectorID).
-			Select("MIN(timestamp), MAX(timestamp), AVG(power), MAX(power), SUM(energy)").
+			Select("MIN(timestamp), MAX(timestamp), AVG(power), MAX(power), COALESCE(SUM(energy_delta), 0) / 1000.0").
 			Row().Scan(&stats.FirstDataTime, &stats.LastDataTime, &stats.AvgPower, &stats.MaxPower, &stats.TotalEnergy)
 
 		stats.IsOnline = collector.IsOnline()
@@ -429,7 +429,7 @@ func getPowerDataAnalytics(c *gin.Context) {
 		AvgPower    float64 `json:"avg_power"`
 		MaxPower    float64 `json:"max_power"`
 		MinPower    float64 `json:"min_power"`
-		TotalEnergy float64 `json:"total_energy"`
+		TotalEnergy float64 `json:"total_energy"` // kWh
 		DataPoints  int64   `json:"data_points"`
 	}
 
@@ -441,7 +441,7 @@ func getPowerDataAnalytics(c *gin.Context) {
 				AVG(power) as avg_power,
 				MAX(power) as max_power,
 				MIN(power) as min_power,
-				SUM(energy) as total_energy,
+				COALESCE(SUM(energy_delta), 0) / 1000.0 as total_energy,
 				COUNT(*) as data_points
 			FROM power_data 
 			WHERE collector_id = ANY($1) AND timestamp >= $2
@@ -454,7 +454,7 @@ func getPowerDataAnalytics(c *gin.Context) {
 				AVG(power) as avg_power,
 				MAX(power) as max_power,
 				MIN(power) as min_power,
-				SUM(energy) as total_energy,
+				COALESCE(SUM(energy_delta), 0) / 1000.0 as total_energy,
 				COUNT(*) as data_points
 			FROM power_data 
 			WHERE collector_id = ANY($1) AND timestamp >= $2
@@ -470,7 +470,7 @@ func getPowerDataAnalytics(c *gin.Context) {
 		AvgPower        float64 `json:"avg_power"`
 		MaxPower        float64 `json:"max_power"`
 		MinPower        float64 `json:"min_power"`
-		TotalEnergy     float64 `json:"total_energy"`
+		TotalEnergy     float64 `json:"total_energy"` // kWh
 		PeakHour        string  `json:"peak_hour"`
 		LowestHour      string  `json:"lowest_hour"`
 	}
@@ -478,7 +478,7 @@ func getPowerDataAnalytics(c *gin.Context) {
 	// Get summary stats for user's collectors only
 	model.DB.Model(&model.PowerData{}).
 		Where("collector_id = ANY(?) AND timestamp >= ?", collectorIDs, startTime).
-		Select("COUNT(*), AVG(power), MAX(power), MIN(power), SUM(energy)").
+		Select("COUNT(*), AVG(power), MAX(power), MIN(power), COALESCE(SUM(energy_delta), 0) / 1000.0").
 		Row().Scan(&summary.TotalDataPoints, &summary.AvgPower, &summary.MaxPower, &summary.MinPower, &summary.TotalEnergy)
 
 	// Find peak and lowest consumption periods
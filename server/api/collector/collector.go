@@ -2,11 +2,13 @@ package collector
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
 	"Power-Monitor/internal/auth"
-	"Power-Monitor/internal/influxdb"
+	"Power-Monitor/internal/ingest"
+	"Power-Monitor/internal/pki"
 	"Power-Monitor/internal/realtime"
 	"Power-Monitor/model"
 	"Power-Monitor/settings"
@@ -17,18 +19,33 @@ import (
 	"gorm.io/gorm"
 )
 
+// errRegistrationCodeUsed is returned when a registration code was used by
+// a concurrent registration
+var errRegistrationCodeUsed = errors.New("registration code already used")
+
+// maxClockSkew is the clock offset beyond which the clock of a collector is
+// reported as wrong
+const maxClockSkew = 5 * time.Second
+
 // RegisterRoutes registers collector API routes
 func RegisterRoutes(r *gin.RouterGroup) {
 	r.POST("/data", uploadPowerData)
 	r.POST("/data/batch", uploadPowerDataBatch)
+	r.POST("/data/loss", reportDataLoss)
+	r.POST("/alarms", reportAlarmEvents)
 	r.GET("/config", getCollectorConfig)
+	r.POST("/config/applied", collectorConfigApplied)
 	r.POST("/heartbeat", heartbeat)
+	r.POST("/token/rotate", rotateToken)
+	r.GET("/ws", realtime.HandleCollectorWebSocket)
 }
 
 // RegisterAuthRoutes registers authentication routes for collectors
 func RegisterAuthRoutes(r *gin.RouterGroup) {
 	collector := r.Group("/collector")
 	collector.POST("/register", registerCollector)
+	collector.GET("/ca.crt", getCACertificate)
+	collector.GET("/crl", getCRL)
 }
 
 // uploadPowerData handles single power data upload from collector
@@ -45,64 +62,31 @@ func uploadPowerData(c *gin.Context) {
 		return
 	}
 
-	// Save to database for quick access
-	powerData := &model.PowerData{
-		CollectorID: collectorID,
-		Timestamp:   req.Timestamp,
-		Voltage:     req.Voltage,
-		Current:     req.Current,
-		Power:       req.Power,
-		Energy:      req.Energy,
-		Frequency:   req.Frequency,
-		PowerFactor: req.PowerFactor,
-	}
-
-	if err := model.DB.Create(powerData).Error; err != nil {
+	stored, result, err := ingest.PowerData(collectorID, []model.PowerDataRequest{req})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save data"})
 		return
 	}
 
-	// Save to InfluxDB for time-series analytics
-	if settings.InfluxDBSettings.Enabled {
-		influxClient := influxdb.GetClient()
-		if influxClient != nil {
-			influxData := influxdb.PowerDataPoint{
-				CollectorID: collectorID,
-				Timestamp:   req.Timestamp,
-				Voltage:     req.Voltage,
-				Current:     req.Current,
-				Power:       req.Power,
-				Energy:      req.Energy,
-				Frequency:   req.Frequency,
-				PowerFactor: req.PowerFactor,
-			}
-
-			if err := influxClient.WritePowerData(influxData); err != nil {
-				// Log error but don't fail the request
-				logger.Errorf("Failed to write to InfluxDB: %v", err)
-			}
-		}
-	}
-
 	// Update collector last seen time
 	updateCollectorLastSeen(collectorID, c.ClientIP())
 
-	// Broadcast real-time data
-	realtimeData := realtime.PowerDataMessage{
-		CollectorID: collectorID,
-		Timestamp:   req.Timestamp,
-		Voltage:     req.Voltage,
-		Current:     req.Current,
-		Power:       req.Power,
-		Energy:      req.Energy,
-		Frequency:   req.Frequency,
-		PowerFactor: req.PowerFactor,
+	// A reading that was stored before is acknowledged again, so that the
+	// collector stops retrying it
+	if stored == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success":     true,
+			"message":     "Data already uploaded",
+			"duplicate":   len(result.Duplicates) > 0,
+			"server_time": time.Now(),
+		})
+		return
 	}
-	realtime.BroadcastPowerData(realtimeData)
 
 	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "Data uploaded successfully",
+		"success":     true,
+		"message":     "Data uploaded successfully",
+		"server_time": time.Now(),
 	})
 }
 
@@ -126,76 +110,124 @@ func uploadPowerDataBatch(c *gin.Context) {
 		return
 	}
 
-	// Prepare data for batch insert
-	var powerDataList []model.PowerData
-	for _, data := range req.Data {
-		powerData := model.PowerData{
+	stored, result, err := ingest.PowerData(collectorID, req.Data)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save batch data"})
+		return
+	}
+
+	// Update collector last seen time
+	updateCollectorLastSeen(collectorID, c.ClientIP())
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"message":     "Batch data uploaded successfully",
+		"count":       stored,
+		"data":        result,
+		"server_time": time.Now(),
+	})
+}
+
+// reportDataLoss records readings the collector dropped or downsampled while
+// its offline cache was full
+func reportDataLoss(c *gin.Context) {
+	collectorID := c.GetString("collector_id")
+	if collectorID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid collector token"})
+		return
+	}
+
+	var req model.DataLossReportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	var losses []model.CollectorDataLoss
+	for _, report := range req.Losses {
+		if report.Policy != model.DataLossDropped && report.Policy != model.DataLossDownsampled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown data loss policy"})
+			return
+		}
+		losses = append(losses, model.CollectorDataLoss{
 			CollectorID: collectorID,
-			Timestamp:   data.Timestamp,
-			Voltage:     data.Voltage,
-			Current:     data.Current,
-			Power:       data.Power,
-			Energy:      data.Energy,
-			Frequency:   data.Frequency,
-			PowerFactor: data.PowerFactor,
+			Policy:      report.Policy,
+			Records:     report.Records,
+			From:        report.From,
+			To:          report.To,
+		})
+		logger.Warnf("Collector %s %s %d cached readings between %s and %s",
+			collectorID, report.Policy, report.Records, report.From.Format(time.RFC3339), report.To.Format(time.RFC3339))
+	}
+
+	if len(losses) > 0 {
+		if err := model.DB.Create(&losses).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save data loss"})
+			return
 		}
-		powerDataList = append(powerDataList, powerData)
 	}
 
-	// Batch insert to the database
-	if err := model.DB.CreateInBatches(powerDataList, 100).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save batch data"})
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Data loss recorded",
+		"count":   len(losses),
+	})
+}
+
+// reportAlarmEvents records the local alarms the collector raised or
+// cleared and alerts the connected clients
+func reportAlarmEvents(c *gin.Context) {
+	collectorID := c.GetString("collector_id")
+	if collectorID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid collector token"})
 		return
 	}
 
-	// Batch insert to InfluxDB
-	if settings.InfluxDBSettings.Enabled {
-		influxClient := influxdb.GetClient()
-		if influxClient != nil {
-			var influxDataList []influxdb.PowerDataPoint
-			for _, data := range req.Data {
-				influxData := influxdb.PowerDataPoint{
-					CollectorID: collectorID,
-					Timestamp:   data.Timestamp,
-					Voltage:     data.Voltage,
-					Current:     data.Current,
-					Power:       data.Power,
-					Energy:      data.Energy,
-					Frequency:   data.Frequency,
-					PowerFactor: data.PowerFactor,
-				}
-				influxDataList = append(influxDataList, influxData)
-			}
-			if err := influxClient.WritePowerDataBatch(influxDataList); err != nil {
-				// Log error but don't fail the request
-				logger.Errorf("Failed to write batch to InfluxDB: %v", err)
-			}
+	var req model.AlarmEventReportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	var events []model.CollectorAlarmEvent
+	for _, report := range req.Events {
+		if report.State != model.AlarmRaised && report.State != model.AlarmCleared {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown alarm state"})
+			return
 		}
+		events = append(events, model.CollectorAlarmEvent{
+			CollectorID: collectorID,
+			Rule:        report.Rule,
+			Metric:      report.Metric,
+			State:       report.State,
+			Value:       report.Value,
+			Threshold:   report.Threshold,
+			Timestamp:   report.Timestamp,
+		})
 	}
 
-	// Update collector last seen time
-	updateCollectorLastSeen(collectorID, c.ClientIP())
+	if len(events) > 0 {
+		if err := model.DB.Create(&events).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save alarm events"})
+			return
+		}
+	}
 
-	// Broadcast the latest data point
-	if len(req.Data) > 0 {
-		latestData := req.Data[len(req.Data)-1]
-		realtimeData := realtime.PowerDataMessage{
-			CollectorID: collectorID,
-			Timestamp:   latestData.Timestamp,
-			Voltage:     latestData.Voltage,
-			Current:     latestData.Current,
-			Power:       latestData.Power,
-			Energy:      latestData.Energy,
-			Frequency:   latestData.Frequency,
-			PowerFactor: latestData.PowerFactor,
+	for _, event := range events {
+		message := fmt.Sprintf("Alarm %s of collector %s %s at %s (%s %v, limit %v)", event.Rule, collectorID,
+			event.State, event.Timestamp.Format(time.RFC3339), event.Metric, event.Value, event.Threshold)
+		if event.State == model.AlarmRaised {
+			logger.Warn(message)
+		} else {
+			logger.Info(message)
 		}
-		realtime.BroadcastPowerData(realtimeData)
+		realtime.BroadcastAlert("collector_alarm", message, event)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"message": "Batch data uploaded successfully",
-		"count":   len(req.Data),
+		"message": "Alarm events recorded",
+		"count":   len(events),
 	})
 }
 
@@ -216,8 +248,10 @@ func getCollectorConfig(c *gin.Context) {
 				SampleInterval:   15,
 				UploadInterval:   60,
 				MaxCacheSize:     1000,
+				BatchSize:        100,
 				AutoUpload:       true,
 				CompressionLevel: 6,
+				Version:          1,
 			}
 			model.DB.Create(&config)
 		} else {
@@ -226,26 +260,93 @@ func getCollectorConfig(c *gin.Context) {
 		}
 	}
 
+	// The server time lets the collector correct its clock before its first
+	// reading
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"data":        config,
+		"server_time": time.Now(),
+	})
+}
+
+// collectorConfigApplied records the config version the collector is running
+func collectorConfigApplied(c *gin.Context) {
+	collectorID := c.GetString("collector_id")
+	if collectorID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid collector token"})
+		return
+	}
+
+	var req model.CollectorConfigAppliedRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	result := model.DB.Model(&model.CollectorConfig{}).
+		Where("collector_id = ?", collectorID).
+		Updates(map[string]interface{}{
+			"applied_version": req.Version,
+			"applied_at":      time.Now(),
+		})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update config"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Collector config not found"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"data":    config,
+		"message": "Applied config version recorded",
 	})
 }
 
-// heartbeat handles collector heartbeat
+// heartbeat handles collector heartbeat and records it with its
+// diagnostics. The offset of the collector clock is recorded if the heartbeat
+// carries its timestamp, and the server time is returned for the collector to
+// correct its clock.
 func heartbeat(c *gin.Context) {
+	receivedAt := time.Now()
 	collectorID := c.GetString("collector_id")
 	if collectorID == "" {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid collector token"})
 		return
 	}
 
+	var req model.CollectorHeartbeatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
 	updateCollectorLastSeen(collectorID, c.ClientIP())
 
+	if err := ingest.Heartbeat(collectorID, req, receivedAt); err != nil {
+		logger.Errorf("Failed to record heartbeat of collector %s: %v", collectorID, err)
+	}
+
+	if req.Timestamp != nil {
+		offset := req.Timestamp.Sub(receivedAt)
+		model.DB.Model(&model.Collector{}).
+			Where("collector_id = ?", collectorID).
+			Updates(map[string]interface{}{
+				"clock_offset":     offset.Seconds(),
+				"clock_checked_at": receivedAt,
+			})
+		if offset > maxClockSkew || -offset > maxClockSkew {
+			logger.Warnf("Clock of collector %s is off by %s", collectorID, offset.Round(time.Millisecond))
+		}
+	}
+
+	now := time.Now()
 	c.JSON(http.StatusOK, gin.H{
-		"success":   true,
-		"message":   "Heartbeat received",
-		"timestamp": time.Now(),
+		"success":     true,
+		"message":     "Heartbeat received",
+		"timestamp":   now,
+		"server_time": now,
 	})
 }
 
@@ -275,48 +376,87 @@ func registerCollector(c *gin.Context) {
 	// Generate collector token (static token)
 	token := auth.GenerateSecureToken()
 
-	// Create collector
 	collector := &model.Collector{
-		CollectorID: req.CollectorID,
-		Name:        req.Name,
-		Description: req.Description,
-		Location:    req.Location,
-		IsActive:    true,
-		LastSeenAt:  time.Now(),
-		Token:       token,
-		Version:     req.Version,
-		IPAddress:   c.ClientIP(),
-		UserID:      regCode.UserID,
-	}
-
-	if err := model.DB.Create(collector).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create collector"})
-		return
+		CollectorID:    req.CollectorID,
+		Name:           req.Name,
+		Description:    req.Description,
+		Location:       req.Location,
+		IsActive:       true,
+		LastSeenAt:     time.Now(),
+		Token:          token,
+		TokenExpiresAt: time.Now().Add(settings.CollectorSettings.TokenExpires),
+		SigningSecret:  auth.GenerateSecureToken(),
+		Version:        req.Version,
+		IPAddress:      c.ClientIP(),
+		UserID:         regCode.UserID,
 	}
 
-	// Create default configuration
+	// Default configuration
 	config := &model.CollectorConfig{
 		CollectorID:      req.CollectorID,
 		SampleInterval:   15,
 		UploadInterval:   60,
 		MaxCacheSize:     1000,
+		BatchSize:        100,
 		AutoUpload:       true,
 		CompressionLevel: 6,
+		Version:          1,
 	}
 
-	if err := model.DB.Create(config).Error; err != nil {
-		// Not critical, just log
-		logger.Errorf("Failed to create collector config: %v", err)
-	}
+	// The client certificate, the collector, its configuration and the use
+	// of the registration code are stored together, so that a failure does
+	// not leave a certificate or a collector behind
+	var certPEM []byte
+	var certErr error
+	err := model.DB.Transaction(func(tx *gorm.DB) error {
+		if req.CSR != "" && settings.CollectorSettings.EnableClientCert {
+			certPEM, _, certErr = pki.IssueCollectorCert(tx, req.CollectorID, []byte(req.CSR))
+			if certErr != nil {
+				return certErr
+			}
+		}
 
-	// Mark registration code as used
-	regCode.IsUsed = true
-	regCode.UsedBy = req.CollectorID
-	model.DB.Save(&regCode)
+		if err := tx.Create(collector).Error; err != nil {
+			return fmt.Errorf("failed to create collector: %w", err)
+		}
+		if err := tx.Create(config).Error; err != nil {
+			return fmt.Errorf("failed to create collector config: %w", err)
+		}
+
+		// Mark registration code as used, unless a concurrent registration
+		// used it first
+		result := tx.Model(&model.RegistrationCode{}).
+			Where("id = ? AND is_used = ?", regCode.ID, false).
+			Updates(map[string]interface{}{"is_used": true, "used_by": req.CollectorID})
+		if result.Error != nil {
+			return fmt.Errorf("failed to mark registration code as used: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return errRegistrationCodeUsed
+		}
+		return nil
+	})
+	switch {
+	case certErr != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to issue client certificate: " + certErr.Error()})
+		return
+	case errors.Is(err, errRegistrationCodeUsed):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired registration code"})
+		return
+	case err != nil:
+		logger.Errorf("Failed to register collector %s: %v", req.CollectorID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create collector"})
+		return
+	}
 
 	response := model.CollectorRegisterResponse{
-		Token:  token,
-		Config: *config,
+		Token:         token,
+		TokenExpires:  collector.TokenExpiresAt,
+		Config:        *config,
+		SigningSecret: collector.SigningSecret,
+	}
+	if certPEM != nil {
+		response.Certificate = string(certPEM)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -326,6 +466,49 @@ func registerCollector(c *gin.Context) {
 	})
 }
 
+// rotateToken issues a new token to the collector before its current one
+// expires. The token the collector authenticated with stays valid for the
+// grace period, so requests in flight and a lost response do not lock it out.
+func rotateToken(c *gin.Context) {
+	collectorID := c.GetString("collector_id")
+	if collectorID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid collector token"})
+		return
+	}
+
+	var collector model.Collector
+	if err := model.DB.Where("collector_id = ?", collectorID).First(&collector).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Collector not found"})
+		return
+	}
+
+	token := auth.GenerateSecureToken()
+	if c.GetBool("collector_previous_token") {
+		// The collector never received the token of the last rotation, so
+		// replace that one and keep the grace period of the token in use
+		collector.Token = token
+		collector.TokenExpiresAt = time.Now().Add(settings.CollectorSettings.TokenExpires)
+	} else {
+		collector.RotateToken(token, settings.CollectorSettings.TokenExpires, settings.CollectorSettings.TokenRotationGrace)
+	}
+
+	if err := model.DB.Save(&collector).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate token"})
+		return
+	}
+
+	logger.Infof("Collector %s rotated its token, valid until %s", collectorID, collector.TokenExpiresAt.Format(time.RFC3339))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Token rotated successfully",
+		"data": model.CollectorTokenResponse{
+			Token:     token,
+			ExpiresAt: collector.TokenExpiresAt,
+		},
+	})
+}
+
 // updateCollectorLastSeen updates the collector's last seen time and IP
 func updateCollectorLastSeen(collectorID, ipAddress string) {
 	model.DB.Model(&model.Collector{}).
@@ -0,0 +1,39 @@
+package collector
+
+import (
+	"net/http"
+
+	"Power-Monitor/internal/pki"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uozi-tech/cosy/logger"
+)
+
+// getCACertificate returns the CA certificate collectors use to verify
+// their client certificates
+func getCACertificate(c *gin.Context) {
+	ca := pki.Get()
+	if ca == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Client certificates are not enabled"})
+		return
+	}
+
+	c.Data(http.StatusOK, "application/x-pem-file", ca.CertPEM())
+}
+
+// getCRL returns the list of revoked collector client certificates
+func getCRL(c *gin.Context) {
+	if pki.Get() == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Client certificates are not enabled"})
+		return
+	}
+
+	crl, err := pki.CollectorCRL()
+	if err != nil {
+		logger.Errorf("Failed to generate CRL: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate CRL"})
+		return
+	}
+
+	c.Data(http.StatusOK, "application/x-pem-file", crl)
+}
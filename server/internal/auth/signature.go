@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+)
+
+// Headers of signed collector requests
+const (
+	SignatureHeader          = "X-Signature"
+	SignatureTimestampHeader = "X-Signature-Timestamp" // Unix seconds
+	SignatureNonceHeader     = "X-Signature-Nonce"
+)
+
+// SignRequest returns the hex encoded HMAC-SHA256 of a request: the method,
+// the path with query, the timestamp, the nonce and the SHA-256 of the body
+// as sent, each on its own line
+func SignRequest(secret, method, path string, timestamp int64, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + path + "\n" + strconv.FormatInt(timestamp, 10) + "\n" + nonce + "\n"))
+	mac.Write([]byte(hex.EncodeToString(bodyHash[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyRequestSignature reports whether signature is the signature of the
+// request, comparing in constant time
+func VerifyRequestSignature(secret, method, path string, timestamp int64, nonce string, body []byte, signature string) bool {
+	expected := SignRequest(secret, method, path, timestamp, nonce, body)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
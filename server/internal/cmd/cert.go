@@ -0,0 +1,241 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"Power-Monitor/internal/pki"
+	"Power-Monitor/model"
+
+	"github.com/urfave/cli/v3"
+	"gorm.io/gorm"
+)
+
+// IssueCertCommand issues a client certificate to a collector
+var IssueCertCommand = &cli.Command{
+	Name:  "issue",
+	Usage: "Issue a client certificate to a collector",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "id",
+			Aliases:  []string{"i"},
+			Usage:    "Collector ID",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "csr",
+			Usage: "Certificate signing request of the collector (a key pair is generated if not provided)",
+		},
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Value:   ".",
+			Usage:   "Directory to write client.crt, client.key and ca.crt to",
+		},
+	},
+	Action: IssueCert,
+}
+
+// RevokeCertCommand revokes client certificates of a collector
+var RevokeCertCommand = &cli.Command{
+	Name:  "revoke",
+	Usage: "Revoke client certificates of a collector",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "id",
+			Aliases:  []string{"i"},
+			Usage:    "Collector ID",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:    "serial",
+			Aliases: []string{"s"},
+			Usage:   "Serial number of the certificate (all certificates of the collector if not provided)",
+		},
+	},
+	Action: RevokeCert,
+}
+
+// ListCertsCommand lists issued client certificates
+var ListCertsCommand = &cli.Command{
+	Name:  "list",
+	Usage: "List issued client certificates",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "id",
+			Aliases: []string{"i"},
+			Usage:   "Collector ID (all collectors if not provided)",
+		},
+	},
+	Action: ListCerts,
+}
+
+// CRLCommand writes the certificate revocation list
+var CRLCommand = &cli.Command{
+	Name:  "crl",
+	Usage: "Write the revocation list of client certificates",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   "File to write the CRL to (stdout if not provided)",
+		},
+	},
+	Action: WriteCRL,
+}
+
+// initPKI initializes the database and the CA
+func initPKI(confPath string) (*gorm.DB, error) {
+	db, err := initDatabase(confPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize database: %v", err)
+	}
+	if err := pki.Init(pki.Dir()); err != nil {
+		return nil, fmt.Errorf("failed to initialize certificate authority: %v", err)
+	}
+	return db, nil
+}
+
+// IssueCert issues a client certificate to a collector
+func IssueCert(ctx context.Context, command *cli.Command) error {
+	db, err := initPKI(command.Root().String("config"))
+	if err != nil {
+		return err
+	}
+
+	collectorID := command.String("id")
+	output := command.String("output")
+
+	var collector model.Collector
+	if err := db.Where("collector_id = ?", collectorID).First(&collector).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("collector '%s' not found", collectorID)
+		}
+		return fmt.Errorf("failed to find collector: %v", err)
+	}
+
+	var csrPEM []byte
+	if csrFile := command.String("csr"); csrFile != "" {
+		if csrPEM, err = os.ReadFile(csrFile); err != nil {
+			return fmt.Errorf("failed to read CSR: %v", err)
+		}
+	}
+
+	certPEM, keyPEM, err := pki.IssueCollectorCert(model.DB, collectorID, csrPEM)
+	if err != nil {
+		return fmt.Errorf("failed to issue certificate: %v", err)
+	}
+
+	if err := os.MkdirAll(output, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(output, "client.crt"), certPEM, 0644); err != nil {
+		return fmt.Errorf("failed to write certificate: %v", err)
+	}
+	if keyPEM != nil {
+		if err := os.WriteFile(filepath.Join(output, "client.key"), keyPEM, 0600); err != nil {
+			return fmt.Errorf("failed to write key: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(output, "ca.crt"), pki.Get().CertPEM(), 0644); err != nil {
+		return fmt.Errorf("failed to write CA certificate: %v", err)
+	}
+
+	cert, err := pki.ParseCertificate(certPEM)
+	if err != nil {
+		return fmt.Errorf("failed to parse certificate: %v", err)
+	}
+
+	fmt.Printf("Certificate issued to collector '%s'\n", collectorID)
+	fmt.Printf("Serial: %s\n", pki.Serial(cert))
+	fmt.Printf("Expires: %s\n", cert.NotAfter.Format("2006-01-02 15:04:05"))
+	fmt.Printf("Files written to: %s\n", output)
+	return nil
+}
+
+// RevokeCert revokes client certificates of a collector
+func RevokeCert(ctx context.Context, command *cli.Command) error {
+	if _, err := initPKI(command.Root().String("config")); err != nil {
+		return err
+	}
+
+	collectorID := command.String("id")
+
+	count, err := pki.RevokeCollectorCerts(collectorID, command.String("serial"))
+	if err != nil {
+		return fmt.Errorf("failed to revoke certificates: %v", err)
+	}
+	if count == 0 {
+		return fmt.Errorf("no valid certificates found for collector '%s'", collectorID)
+	}
+
+	fmt.Printf("%d certificate(s) of collector '%s' revoked\n", count, collectorID)
+	return nil
+}
+
+// ListCerts lists issued client certificates
+func ListCerts(ctx context.Context, command *cli.Command) error {
+	db, err := initDatabase(command.Root().String("config"))
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %v", err)
+	}
+
+	query := db.Order("collector_id, created_at")
+	if collectorID := command.String("id"); collectorID != "" {
+		query = query.Where("collector_id = ?", collectorID)
+	}
+
+	var certs []model.CollectorCertificate
+	if err := query.Find(&certs).Error; err != nil {
+		return fmt.Errorf("failed to fetch certificates: %v", err)
+	}
+
+	if len(certs) == 0 {
+		fmt.Println("No certificates found")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "COLLECTOR ID\tSERIAL\tISSUED\tEXPIRES\tREVOKED")
+	for _, cert := range certs {
+		revoked := "-"
+		if cert.RevokedAt != nil {
+			revoked = cert.RevokedAt.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			cert.CollectorID,
+			cert.Serial,
+			cert.CreatedAt.Format("2006-01-02 15:04"),
+			cert.NotAfter.Format("2006-01-02 15:04"),
+			revoked)
+	}
+	return w.Flush()
+}
+
+// WriteCRL writes the certificate revocation list
+func WriteCRL(ctx context.Context, command *cli.Command) error {
+	if _, err := initPKI(command.Root().String("config")); err != nil {
+		return err
+	}
+
+	crl, err := pki.CollectorCRL()
+	if err != nil {
+		return fmt.Errorf("failed to generate CRL: %v", err)
+	}
+
+	output := command.String("output")
+	if output == "" {
+		_, err = os.Stdout.Write(crl)
+		return err
+	}
+	if err := os.WriteFile(output, crl, 0644); err != nil {
+		return fmt.Errorf("failed to write CRL: %v", err)
+	}
+
+	fmt.Printf("CRL written to: %s\n", output)
+	return nil
+}
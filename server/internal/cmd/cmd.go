@@ -48,9 +48,31 @@ func NewAppCmd() *cli.Command {
 					UpdateCollectorCommand,
 					ConfigCollectorCommand,
 					StatusCollectorCommand,
+					RotateTokenCommand,
+					RevokeTokenCommand,
+					SigningCollectorCommand,
 					DeleteCollectorCommand,
 				},
 			},
+			// Collector client certificate commands
+			{
+				Name:  "cert",
+				Usage: "Collector client certificate commands",
+				Commands: []*cli.Command{
+					IssueCertCommand,
+					RevokeCertCommand,
+					ListCertsCommand,
+					CRLCommand,
+				},
+			},
+			// Stored data maintenance commands
+			{
+				Name:  "data",
+				Usage: "Stored data maintenance commands",
+				Commands: []*cli.Command{
+					RebuildEnergyCommand,
+				},
+			},
 			// Registration code management commands
 			{
 				Name:  "regcode",
@@ -7,10 +7,12 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strconv"
 	"text/tabwriter"
 	"time"
 
 	"Power-Monitor/model"
+	"Power-Monitor/settings"
 
 	"github.com/google/uuid"
 	"github.com/urfave/cli/v3"
@@ -124,6 +126,11 @@ var ConfigCollectorCommand = &cli.Command{
 			Aliases: []string{"mcs"},
 			Usage:   "Maximum cache size (number of records)",
 		},
+		&cli.IntFlag{
+			Name:    "batch-size",
+			Aliases: []string{"bs"},
+			Usage:   "Records per batch upload",
+		},
 		&cli.BoolFlag{
 			Name:    "auto-upload",
 			Aliases: []string{"au"},
@@ -177,6 +184,63 @@ var DeleteCollectorCommand = &cli.Command{
 	Action: DeleteCollector,
 }
 
+// RotateTokenCommand issues a new token to a collector
+var RotateTokenCommand = &cli.Command{
+	Name:  "rotate-token",
+	Usage: "Issue a new token to a collector, invalidating the current one",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "id",
+			Aliases:  []string{"i"},
+			Usage:    "Collector ID",
+			Required: true,
+		},
+	},
+	Action: RotateToken,
+}
+
+// RevokeTokenCommand revokes the token of a collector
+var RevokeTokenCommand = &cli.Command{
+	Name:  "revoke-token",
+	Usage: "Revoke the token of a collector until a new one is issued",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "id",
+			Aliases:  []string{"i"},
+			Usage:    "Collector ID",
+			Required: true,
+		},
+	},
+	Action: RevokeToken,
+}
+
+// SigningCollectorCommand configures request signing of a collector
+var SigningCollectorCommand = &cli.Command{
+	Name:  "signing",
+	Usage: "Require or stop requiring signed requests from a collector",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "id",
+			Aliases:  []string{"i"},
+			Usage:    "Collector ID",
+			Required: true,
+		},
+		&cli.BoolFlag{
+			Name:  "require",
+			Usage: "Reject unsigned requests",
+		},
+		&cli.BoolFlag{
+			Name:  "no-require",
+			Usage: "Accept unsigned requests",
+		},
+		&cli.BoolFlag{
+			Name:  "rotate-secret",
+			Usage: "Generate a new signing secret",
+		},
+	},
+	Action: SigningCollector,
+}
+
 // generateCollectorToken generates a random token for a collector.
 func generateCollectorToken() (string, error) {
 	bytes := make([]byte, 32) // Generates a 64-character hex string
@@ -226,13 +290,14 @@ func AddCollector(ctx context.Context, command *cli.Command) error {
 
 	// Create collector
 	collector := model.Collector{
-		CollectorID: collectorID,
-		Name:        name,
-		Description: description,
-		Location:    location,
-		IsActive:    true,
-		UserID:      userID,
-		Token:       collectorToken,
+		CollectorID:    collectorID,
+		Name:           name,
+		Description:    description,
+		Location:       location,
+		IsActive:       true,
+		UserID:         userID,
+		Token:          collectorToken,
+		TokenExpiresAt: time.Now().Add(settings.CollectorSettings.TokenExpires),
 	}
 
 	if err := db.Create(&collector).Error; err != nil {
@@ -258,6 +323,7 @@ func AddCollector(ctx context.Context, command *cli.Command) error {
 	fmt.Printf("Database ID: %d\n", collector.ID)
 	fmt.Printf("Name: %s\n", name)
 	fmt.Printf("Token: %s\n", collector.Token)
+	fmt.Printf("Token Expires: %s\n", collector.TokenExpiresAt.Format("2006-01-02 15:04:05"))
 	if description != "" {
 		fmt.Printf("Description: %s\n", description)
 	}
@@ -393,6 +459,7 @@ func ConfigCollector(ctx context.Context, command *cli.Command) error {
 	sampleInterval := command.Int("sample-interval")
 	uploadInterval := command.Int("upload-interval")
 	maxCacheSize := command.Int("max-cache-size")
+	batchSize := command.Int("batch-size")
 	autoUpload := command.Bool("auto-upload")
 	noAutoUpload := command.Bool("no-auto-upload")
 	compressionLevel := command.Int("compression-level")
@@ -418,6 +485,9 @@ func ConfigCollector(ctx context.Context, command *cli.Command) error {
 	if maxCacheSize > 0 {
 		updates["max_cache_size"] = maxCacheSize
 	}
+	if batchSize > 0 {
+		updates["batch_size"] = batchSize
+	}
 	if autoUpload && noAutoUpload {
 		return fmt.Errorf("cannot set both auto-upload and no-auto-upload flags")
 	}
@@ -437,6 +507,9 @@ func ConfigCollector(ctx context.Context, command *cli.Command) error {
 		return fmt.Errorf("no configuration updates specified")
 	}
 
+	// Bump the version so that the collector applies the change
+	updates["version"] = gorm.Expr("version + 1")
+
 	// Update config
 	if err := db.Model(&config).Updates(updates).Error; err != nil {
 		return fmt.Errorf("failed to update collector config: %v", err)
@@ -502,6 +575,17 @@ func StatusCollector(ctx context.Context, command *cli.Command) error {
 
 		fmt.Printf("Created: %s\n", collector.CreatedAt.Format("2006-01-02 15:04:05"))
 
+		switch {
+		case collector.TokenExpiresAt.IsZero():
+			fmt.Printf("Token Expires: Not set\n")
+		case collector.TokenExpired():
+			fmt.Printf("Token Expires: %s (EXPIRED)\n", collector.TokenExpiresAt.Format("2006-01-02 15:04:05"))
+		default:
+			fmt.Printf("Token Expires: %s\n", collector.TokenExpiresAt.Format("2006-01-02 15:04:05"))
+		}
+		fmt.Printf("Signing Secret Set: %t\n", collector.SigningSecret != "")
+		fmt.Printf("Require Signature: %t\n", collector.RequireSignature)
+
 		// Get configuration
 		var config model.CollectorConfig
 		if err := db.Where("collector_id = ?", collector.CollectorID).First(&config).Error; err == nil {
@@ -509,8 +593,16 @@ func StatusCollector(ctx context.Context, command *cli.Command) error {
 			fmt.Printf("Sample Interval: %d seconds\n", config.SampleInterval)
 			fmt.Printf("Upload Interval: %d seconds\n", config.UploadInterval)
 			fmt.Printf("Max Cache Size: %d records\n", config.MaxCacheSize)
+			fmt.Printf("Batch Size: %d records\n", config.BatchSize)
 			fmt.Printf("Auto Upload: %t\n", config.AutoUpload)
 			fmt.Printf("Compression Level: %d\n", config.CompressionLevel)
+			fmt.Printf("Voltage Range: %s-%s V\n", optionalFloat(config.VoltageMin), optionalFloat(config.VoltageMax))
+			fmt.Printf("Current Limit: %s A\n", optionalFloat(config.CurrentMax))
+			fmt.Printf("Power Limit: %s W\n", optionalFloat(config.PowerMax))
+			fmt.Printf("Frequency Range: %s-%s Hz\n", optionalFloat(config.FrequencyMin), optionalFloat(config.FrequencyMax))
+			fmt.Printf("Spike Filter: %s (threshold: %s)\n", optionalBool(config.SpikeFilter), optionalFloat(config.SpikeThreshold))
+			fmt.Printf("Upload Rejected: %s\n", optionalBool(config.UploadRejected))
+			fmt.Printf("Config Version: %d (applied: %d)\n", config.Version, config.AppliedVersion)
 		}
 
 		// Get data count
@@ -522,6 +614,138 @@ func StatusCollector(ctx context.Context, command *cli.Command) error {
 	return nil
 }
 
+// optionalFloat formats a setting the collector's own config applies to
+// when nil
+func optionalFloat(v *float64) string {
+	if v == nil {
+		return "collector default"
+	}
+	return strconv.FormatFloat(*v, 'g', -1, 64)
+}
+
+// optionalBool formats a setting the collector's own config applies to when
+// nil
+func optionalBool(v *bool) string {
+	if v == nil {
+		return "collector default"
+	}
+	return strconv.FormatBool(*v)
+}
+
+// RotateToken issues a new token to a collector
+func RotateToken(ctx context.Context, command *cli.Command) error {
+	confPath := command.Root().String("config")
+	db, err := initDatabase(confPath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %v", err)
+	}
+
+	collectorID := command.String("id")
+
+	var collector model.Collector
+	if err := db.Where("collector_id = ?", collectorID).First(&collector).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("collector '%s' not found", collectorID)
+		}
+		return fmt.Errorf("failed to find collector: %v", err)
+	}
+
+	token, err := generateCollectorToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate collector token: %v", err)
+	}
+
+	collector.RotateToken(token, settings.CollectorSettings.TokenExpires, 0)
+	if err := db.Save(&collector).Error; err != nil {
+		return fmt.Errorf("failed to rotate collector token: %v", err)
+	}
+
+	fmt.Printf("Token of collector '%s' rotated successfully\n", collectorID)
+	fmt.Printf("Token: %s\n", token)
+	fmt.Printf("Token Expires: %s\n", collector.TokenExpiresAt.Format("2006-01-02 15:04:05"))
+	return nil
+}
+
+// RevokeToken revokes the token of a collector
+func RevokeToken(ctx context.Context, command *cli.Command) error {
+	confPath := command.Root().String("config")
+	db, err := initDatabase(confPath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %v", err)
+	}
+
+	collectorID := command.String("id")
+
+	var collector model.Collector
+	if err := db.Where("collector_id = ?", collectorID).First(&collector).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("collector '%s' not found", collectorID)
+		}
+		return fmt.Errorf("failed to find collector: %v", err)
+	}
+
+	collector.RevokeToken()
+	if err := db.Save(&collector).Error; err != nil {
+		return fmt.Errorf("failed to revoke collector token: %v", err)
+	}
+
+	fmt.Printf("Token of collector '%s' revoked, rotate it to issue a new one\n", collectorID)
+	return nil
+}
+
+// SigningCollector configures request signing of a collector. A secret is
+// generated when signing is required and the collector has none.
+func SigningCollector(ctx context.Context, command *cli.Command) error {
+	confPath := command.Root().String("config")
+	db, err := initDatabase(confPath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %v", err)
+	}
+
+	collectorID := command.String("id")
+	require := command.Bool("require")
+	noRequire := command.Bool("no-require")
+	if require && noRequire {
+		return fmt.Errorf("cannot set both require and no-require flags")
+	}
+
+	var collector model.Collector
+	if err := db.Where("collector_id = ?", collectorID).First(&collector).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("collector '%s' not found", collectorID)
+		}
+		return fmt.Errorf("failed to find collector: %v", err)
+	}
+
+	if require {
+		collector.RequireSignature = true
+	}
+	if noRequire {
+		collector.RequireSignature = false
+	}
+
+	rotated := false
+	if command.Bool("rotate-secret") || (collector.RequireSignature && collector.SigningSecret == "") {
+		secret, err := generateCollectorToken()
+		if err != nil {
+			return fmt.Errorf("failed to generate signing secret: %v", err)
+		}
+		collector.SigningSecret = secret
+		rotated = true
+	}
+
+	if err := db.Save(&collector).Error; err != nil {
+		return fmt.Errorf("failed to update collector: %v", err)
+	}
+
+	fmt.Printf("Request signing of collector '%s' updated\n", collectorID)
+	fmt.Printf("Require Signature: %t\n", collector.RequireSignature)
+	if rotated {
+		fmt.Printf("Signing Secret: %s\n", collector.SigningSecret)
+	}
+	return nil
+}
+
 // DeleteCollector deletes a collector
 func DeleteCollector(ctx context.Context, command *cli.Command) error {
 	confPath := command.Root().String("config")
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"Power-Monitor/internal/energy"
+	"Power-Monitor/model"
+
+	"github.com/urfave/cli/v3"
+)
+
+// RebuildEnergyCommand recomputes the per-interval energy deltas of stored readings
+var RebuildEnergyCommand = &cli.Command{
+	Name:  "rebuild-energy",
+	Usage: "Recompute the energy consumed between stored readings",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "id",
+			Aliases: []string{"i"},
+			Usage:   "Collector ID (all collectors if not provided)",
+		},
+	},
+	Action: RebuildEnergy,
+}
+
+// RebuildEnergy recomputes the energy deltas of one or all collectors
+func RebuildEnergy(ctx context.Context, command *cli.Command) error {
+	confPath := command.Root().String("config")
+	db, err := initDatabase(confPath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %v", err)
+	}
+
+	var collectorIDs []string
+	if id := command.String("id"); id != "" {
+		collectorIDs = []string{id}
+	} else if err := db.Model(&model.PowerData{}).Distinct().Pluck("collector_id", &collectorIDs).Error; err != nil {
+		return fmt.Errorf("failed to fetch collectors: %v", err)
+	}
+
+	for _, collectorID := range collectorIDs {
+		count, err := energy.Rebuild(db, collectorID)
+		if err != nil {
+			return fmt.Errorf("failed to rebuild energy of collector '%s': %v", collectorID, err)
+		}
+		fmt.Printf("Collector %s: %d readings processed\n", collectorID, count)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"Power-Monitor/internal/realtime"
+	"Power-Monitor/model"
+
+	"github.com/uozi-tech/cosy/logger"
+)
+
+// handler persists the command channel events of collectors
+type handler struct{}
+
+// Init registers the command handler with the realtime service
+func Init() {
+	realtime.SetCollectorHandler(handler{})
+}
+
+// Send stores a command for a collector and delivers it right away if the
+// collector is connected. Otherwise it stays pending until the collector
+// opens its command channel.
+func Send(collectorID, command string, args map[string]string, userID uint) (*model.CollectorCommand, error) {
+	if !model.IsValidCommand(command) {
+		return nil, fmt.Errorf("unknown command %q", command)
+	}
+
+	var argsJSON string
+	if len(args) > 0 {
+		data, err := json.Marshal(args)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode command arguments: %w", err)
+		}
+		argsJSON = string(data)
+	}
+
+	cmd := &model.CollectorCommand{
+		CollectorID: collectorID,
+		Command:     command,
+		Args:        argsJSON,
+		Status:      model.CommandStatusPending,
+		UserID:      userID,
+	}
+	if err := model.DB.Create(cmd).Error; err != nil {
+		return nil, fmt.Errorf("failed to save command: %w", err)
+	}
+
+	if err := deliver(cmd); err != nil && !errors.Is(err, realtime.ErrCollectorOffline) {
+		logger.Errorf("Failed to deliver command %d to collector %s: %v", cmd.ID, collectorID, err)
+	}
+
+	return cmd, nil
+}
+
+// deliver sends a stored command over the command channel of its collector
+func deliver(cmd *model.CollectorCommand) error {
+	msg := realtime.CommandMessage{
+		Type:    realtime.CommandMessageCommand,
+		ID:      cmd.ID,
+		Command: cmd.Command,
+	}
+	if cmd.Args != "" {
+		if err := json.Unmarshal([]byte(cmd.Args), &msg.Args); err != nil {
+			return fmt.Errorf("invalid command arguments: %w", err)
+		}
+	}
+
+	return realtime.SendToCollector(cmd.CollectorID, msg)
+}
+
+// OnConnect delivers the commands queued while the collector was offline
+func (handler) OnConnect(collectorID string) {
+	var pending []model.CollectorCommand
+	if err := model.DB.Where("collector_id = ? AND status = ?", collectorID, model.CommandStatusPending).
+		Order("id").Find(&pending).Error; err != nil {
+		logger.Errorf("Failed to load pending commands of collector %s: %v", collectorID, err)
+		return
+	}
+
+	for i := range pending {
+		if err := deliver(&pending[i]); err != nil {
+			logger.Errorf("Failed to deliver command %d to collector %s: %v", pending[i].ID, collectorID, err)
+			return
+		}
+	}
+}
+
+// OnMessage records acknowledgements and results reported by the collector
+func (handler) OnMessage(collectorID string, msg realtime.CommandMessage) {
+	now := time.Now()
+	query := model.DB.Model(&model.CollectorCommand{}).
+		Where("id = ? AND collector_id = ?", msg.ID, collectorID)
+
+	var err error
+	switch msg.Type {
+	case realtime.CommandMessageAck:
+		err = query.Where("status = ?", model.CommandStatusPending).
+			Updates(map[string]interface{}{
+				"status":   model.CommandStatusAcked,
+				"acked_at": now,
+			}).Error
+	case realtime.CommandMessageResult:
+		status := model.CommandStatusDone
+		if !msg.Success {
+			status = model.CommandStatusFailed
+		}
+		err = query.Updates(map[string]interface{}{
+			"status":       status,
+			"result":       msg.Result,
+			"error":        msg.Error,
+			"completed_at": now,
+		}).Error
+	default:
+		logger.Warnf("Unknown message type %q from collector %s", msg.Type, collectorID)
+		return
+	}
+
+	if err != nil {
+		logger.Errorf("Failed to update command %d of collector %s: %v", msg.ID, collectorID, err)
+	}
+}
@@ -0,0 +1,199 @@
+package energy
+
+import (
+	"time"
+
+	"Power-Monitor/model"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// MaxIntegrationGap is the longest gap between two readings over which
+	// the average power is trusted to estimate the energy consumed
+	MaxIntegrationGap = 15 * time.Minute
+
+	// CounterRollover is the value in Wh at which meter counters wrap to
+	// zero. The PZEM counters overflow after 9999.99 kWh.
+	CounterRollover = 10_000_000
+
+	// rolloverMargin is the share of CounterRollover below the limit a
+	// counter must have reached for a drop to be treated as a rollover
+	rolloverMargin = 0.01
+)
+
+// Delta returns the energy in Wh consumed between the prev and cur
+// readings of one collector. The counter difference is used while the
+// counter grows. A counter that went backwards was reset or rolled over; the
+// power integrated over the interval is used instead when the readings are
+// close enough, otherwise the rollover remainder or the energy counted since
+// the reset. A zero counter is taken as a reading without a counter value, so
+// such intervals are always integrated. Where the counter cannot be used, the
+// summary of an aggregation window provides the energy the collector counted
+// over its samples, which is more accurate than integrating two averages.
+func Delta(prev, cur *model.PowerData) float64 {
+	if prev == nil {
+		return 0
+	}
+
+	gap := cur.Timestamp.Sub(prev.Timestamp)
+	if gap <= 0 {
+		return 0
+	}
+
+	counted := prev.Energy != 0 && cur.Energy != 0
+	switch {
+	case counted && cur.Energy >= prev.Energy:
+		return cur.Energy - prev.Energy
+	case cur.WindowEnergy != nil && gap <= MaxIntegrationGap:
+		return *cur.WindowEnergy
+	case !counted:
+		return integrate(prev, cur, gap)
+	case gap <= MaxIntegrationGap:
+		return integrate(prev, cur, gap)
+	case prev.Energy >= CounterRollover*(1-rolloverMargin):
+		return CounterRollover - prev.Energy + cur.Energy
+	default:
+		return cur.Energy
+	}
+}
+
+// integrate estimates the energy in Wh from the average power of two
+// readings, or 0 if they are too far apart
+func integrate(prev, cur *model.PowerData, gap time.Duration) float64 {
+	if gap > MaxIntegrationGap {
+		return 0
+	}
+	return (prev.Power + cur.Power) / 2 * gap.Hours()
+}
+
+// Covered returns the time covered by the readings of one collector in time
+// order. The summary of an aggregation window covers its window, a single
+// reading the interval since the previous reading. Intervals longer than
+// MaxIntegrationGap are taken as the collector not running and not counted.
+func Covered(readings []model.PowerData) time.Duration {
+	var total time.Duration
+	for i := range readings {
+		if readings[i].WindowSeconds > 0 {
+			total += time.Duration(readings[i].WindowSeconds) * time.Second
+			continue
+		}
+		if i == 0 {
+			continue
+		}
+		if gap := readings[i].Timestamp.Sub(readings[i-1].Timestamp); gap > 0 && gap <= MaxIntegrationGap {
+			total += gap
+		}
+	}
+	return total
+}
+
+// Recalculate computes the energy deltas of the readings of a collector
+// between from and to, plus the first reading after to, whose delta depends
+// on the readings before it. It must run after readings in that range were
+// inserted so that late and out-of-order uploads are accounted for.
+func Recalculate(tx *gorm.DB, collectorID string, from, to time.Time) error {
+	var readings []model.PowerData
+
+	var prev model.PowerData
+	err := tx.Where("collector_id = ? AND timestamp < ?", collectorID, from).
+		Order("timestamp DESC, id DESC").
+		Limit(1).
+		Find(&prev).Error
+	if err != nil {
+		return err
+	}
+	if prev.ID != 0 {
+		readings = append(readings, prev)
+	}
+
+	var inRange []model.PowerData
+	err = tx.Where("collector_id = ? AND timestamp >= ? AND timestamp <= ?", collectorID, from, to).
+		Order("timestamp, id").
+		Find(&inRange).Error
+	if err != nil {
+		return err
+	}
+	readings = append(readings, inRange...)
+
+	var next model.PowerData
+	err = tx.Where("collector_id = ? AND timestamp > ?", collectorID, to).
+		Order("timestamp, id").
+		Limit(1).
+		Find(&next).Error
+	if err != nil {
+		return err
+	}
+	if next.ID != 0 {
+		readings = append(readings, next)
+	}
+
+	start := 0
+	if prev.ID != 0 {
+		start = 1
+	}
+	return update(tx, readings, start)
+}
+
+// Rebuild recomputes the energy deltas of every reading of a collector in
+// batches, returning the number of readings processed
+func Rebuild(db *gorm.DB, collectorID string) (int, error) {
+	const batchSize = 1000
+
+	var (
+		total int
+		prev  *model.PowerData
+	)
+	for {
+		var batch []model.PowerData
+		query := db.Where("collector_id = ?", collectorID)
+		if prev != nil {
+			query = query.Where("(timestamp > ? OR (timestamp = ? AND id > ?))", prev.Timestamp, prev.Timestamp, prev.ID)
+		}
+		if err := query.Order("timestamp, id").Limit(batchSize).Find(&batch).Error; err != nil {
+			return total, err
+		}
+		if len(batch) == 0 {
+			return total, nil
+		}
+
+		readings := batch
+		start := 0
+		if prev != nil {
+			readings = append([]model.PowerData{*prev}, batch...)
+			start = 1
+		}
+		if err := update(db, readings, start); err != nil {
+			return total, err
+		}
+
+		total += len(batch)
+		last := batch[len(batch)-1]
+		prev = &last
+	}
+}
+
+// update stores the deltas of readings[start:] computed against their
+// predecessors, skipping readings whose delta did not change
+func update(tx *gorm.DB, readings []model.PowerData, start int) error {
+	for i := start; i < len(readings); i++ {
+		var prev *model.PowerData
+		if i > 0 {
+			prev = &readings[i-1]
+		}
+
+		delta := Delta(prev, &readings[i])
+		if delta == readings[i].EnergyDelta {
+			continue
+		}
+		readings[i].EnergyDelta = delta
+
+		err := tx.Model(&model.PowerData{}).
+			Where("id = ?", readings[i].ID).
+			UpdateColumn("energy_delta", delta).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package energy
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"Power-Monitor/model"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestDelta(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	reading := func(after time.Duration, energy, power float64) *model.PowerData {
+		return &model.PowerData{Timestamp: start.Add(after), Energy: energy, Power: power}
+	}
+	windowEnergy := 12.0
+	window := reading(time.Minute, 0, 100)
+	window.WindowEnergy = &windowEnergy
+
+	tests := []struct {
+		name string
+		prev *model.PowerData
+		cur  *model.PowerData
+		want float64
+	}{
+		{"first reading", nil, reading(0, 1000, 100), 0},
+		{"same timestamp", reading(0, 1000, 100), reading(0, 1010, 100), 0},
+		{"counter delta", reading(0, 1000, 100), reading(time.Minute, 1025, 100), 25},
+		{"counter delta over a long gap", reading(0, 1000, 100), reading(time.Hour, 1100, 100), 100},
+		{"counter reset to 0, integrated", reading(0, 5000, 120), reading(time.Minute, 0, 120), 2},
+		{"counter reset within the gap limit, integrated", reading(0, 5000, 60), reading(10*time.Minute, 3, 60), 10},
+		{"counter reset after a long gap", reading(0, 5000, 60), reading(time.Hour, 30, 60), 30},
+		{"rollover", reading(0, 9_999_900, 60), reading(30*time.Minute, 50, 60), 150},
+		{"no counter over a gap beyond the limit", reading(0, 0, 100), reading(20*time.Minute, 0, 100), 0},
+		{"no counter, integrated", reading(0, 0, 100), reading(10*time.Minute, 0, 200), 25},
+		{"aggregation window energy", reading(0, 0, 100), window, 12},
+		{"counter preferred over window energy", reading(0, 1000, 100), func() *model.PowerData {
+			cur := reading(time.Minute, 1005, 100)
+			cur.WindowEnergy = &windowEnergy
+			return cur
+		}(), 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Delta(tt.prev, tt.cur); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Delta() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCovered(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	readings := []model.PowerData{
+		{Timestamp: start},
+		{Timestamp: start.Add(time.Minute)},
+		{Timestamp: start.Add(2 * time.Minute)},
+		// The collector was off for an hour
+		{Timestamp: start.Add(62 * time.Minute)},
+		{Timestamp: start.Add(62*time.Minute + 15*time.Second)},
+		// Summaries of 5 minute windows
+		{Timestamp: start.Add(70 * time.Minute), WindowSeconds: 300},
+		{Timestamp: start.Add(75 * time.Minute), WindowSeconds: 300},
+	}
+
+	if got, want := Covered(readings), 2*time.Minute+15*time.Second+10*time.Minute; got != want {
+		t.Errorf("Covered() = %v, want %v", got, want)
+	}
+	if got := Covered(readings[:1]); got != 0 {
+		t.Errorf("Expected a single reading to cover nothing, got %v", got)
+	}
+}
+
+// newTestDB opens an in-memory database holding the power data table
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger:                                   logger.Default.LogMode(logger.Silent),
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	if err := db.AutoMigrate(&model.PowerData{}); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// deltas returns the energy deltas of the readings of a collector in time
+// order
+func deltas(t *testing.T, db *gorm.DB, collectorID string) []float64 {
+	t.Helper()
+
+	var values []float64
+	if err := db.Model(&model.PowerData{}).Where("collector_id = ?", collectorID).
+		Order("timestamp, id").Pluck("energy_delta", &values).Error; err != nil {
+		t.Fatalf("Failed to read deltas: %v", err)
+	}
+	return values
+}
+
+func TestRecalculateOutOfOrder(t *testing.T) {
+	db := newTestDB(t)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for minute, energy := range map[int]float64{1: 1000, 3: 1020, 4: 1030} {
+		reading := model.PowerData{CollectorID: "test", Timestamp: start.Add(time.Duration(minute) * time.Minute), Energy: energy}
+		if err := db.Create(&reading).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Recalculate(db, "test", start, start.Add(time.Hour)); err != nil {
+		t.Fatalf("Recalculate failed: %v", err)
+	}
+	if got := deltas(t, db, "test"); len(got) != 3 || got[1] != 20 || got[2] != 10 {
+		t.Fatalf("Expected deltas [0 20 10], got %v", got)
+	}
+
+	// A late reading between the first two splits the delta of the next one
+	late := model.PowerData{CollectorID: "test", Timestamp: start.Add(2 * time.Minute), Energy: 1005}
+	if err := db.Create(&late).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := Recalculate(db, "test", late.Timestamp, late.Timestamp); err != nil {
+		t.Fatalf("Recalculate failed: %v", err)
+	}
+
+	want := []float64{0, 5, 15, 10}
+	got := deltas(t, db, "test")
+	if len(got) != len(want) {
+		t.Fatalf("Expected deltas %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Expected deltas %v, got %v", want, got)
+		}
+	}
+}
+
+func TestRebuild(t *testing.T) {
+	db := newTestDB(t)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Stored out of order, with stale deltas
+	for _, i := range []int{2, 0, 1, 3} {
+		reading := model.PowerData{
+			CollectorID: "test",
+			Timestamp:   start.Add(time.Duration(i) * time.Minute),
+			Energy:      1000 + float64(i*i),
+			EnergyDelta: 99,
+		}
+		if err := db.Create(&reading).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	other := model.PowerData{CollectorID: "other", Timestamp: start, Energy: 1, EnergyDelta: 99}
+	if err := db.Create(&other).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	total, err := Rebuild(db, "test")
+	if err != nil {
+		t.Fatalf("Rebuild failed: %v", err)
+	}
+	if total != 4 {
+		t.Errorf("Expected 4 readings processed, got %d", total)
+	}
+
+	want := []float64{0, 1, 3, 5}
+	got := deltas(t, db, "test")
+	for i := range want {
+		if i >= len(got) || got[i] != want[i] {
+			t.Fatalf("Expected deltas %v, got %v", want, got)
+		}
+	}
+	if got := deltas(t, db, "other"); got[0] != 99 {
+		t.Errorf("Expected the readings of other collectors to be left alone, got %v", got)
+	}
+}
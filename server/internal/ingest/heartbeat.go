@@ -0,0 +1,63 @@
+package ingest
+
+import (
+	"time"
+
+	"Power-Monitor/model"
+)
+
+// Heartbeat records a heartbeat of a collector received at receivedAt with
+// the diagnostics it carries. Of the meter channels in the diagnostics, the
+// one of the collector is recorded.
+func Heartbeat(collectorID string, req model.CollectorHeartbeatRequest, receivedAt time.Time) error {
+	heartbeat := model.CollectorHeartbeat{
+		CollectorID: collectorID,
+		Timestamp:   receivedAt,
+		Status:      req.Status,
+		Version:     req.Version,
+	}
+
+	if d := req.Diagnostics; d != nil {
+		heartbeat.IsOnline = d.IsOnline
+		heartbeat.ErrorCount = d.ErrorCount
+		heartbeat.LastError = d.LastError
+		heartbeat.LastErrorAt = d.LastErrorAt
+		heartbeat.ConfigVersion = d.ConfigVersion
+		heartbeat.Uptime = d.Uptime
+		heartbeat.CacheTotal = d.CacheStats["total"]
+		heartbeat.CacheUnuploaded = d.CacheStats["unuploaded"]
+		if oldest := d.CacheStats["oldest_unuploaded"]; oldest > 0 {
+			t := time.Unix(oldest, 0)
+			heartbeat.CacheOldestUnuploaded = &t
+		}
+		heartbeat.OS = d.System.OS
+		heartbeat.Arch = d.System.Arch
+		heartbeat.DiskFree = d.System.DiskFree
+		heartbeat.DiskTotal = d.System.DiskTotal
+		heartbeat.MemoryAlloc = d.System.MemoryAlloc
+		heartbeat.MemorySys = d.System.MemorySys
+		heartbeat.MemoryAvailable = d.System.MemoryAvailable
+		heartbeat.MemoryTotal = d.System.MemoryTotal
+
+		for _, ch := range d.Channels {
+			if ch.CollectorID != collectorID {
+				continue
+			}
+			if !ch.LastDataTime.IsZero() {
+				lastDataTime := ch.LastDataTime
+				heartbeat.LastDataTime = &lastDataTime
+			}
+			heartbeat.LastReadError = ch.LastReadError
+			heartbeat.LastReadErrorAt = ch.LastReadErrorAt
+			if ch.Errors != nil {
+				heartbeat.ModbusRequests = ch.Errors.Requests
+				heartbeat.ModbusCRCErrors = ch.Errors.CRCErrors
+				heartbeat.ModbusTimeouts = ch.Errors.Timeouts
+				heartbeat.ModbusExceptions = ch.Errors.Exceptions
+			}
+			break
+		}
+	}
+
+	return model.DB.Create(&heartbeat).Error
+}
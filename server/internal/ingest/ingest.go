@@ -0,0 +1,254 @@
+// Package ingest stores the readings collectors upload over HTTP or publish
+// over MQTT
+package ingest
+
+import (
+	"Power-Monitor/internal/energy"
+	"Power-Monitor/internal/influxdb"
+	"Power-Monitor/internal/realtime"
+	"Power-Monitor/model"
+	"Power-Monitor/settings"
+
+	"github.com/uozi-tech/cosy/logger"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PowerData stores the readings of a collector, skipping those stored
+// before. The energy of the new readings is accounted, they are written to
+// InfluxDB and the latest one is broadcast to connected clients. Readings the
+// collector rejected as implausible are only recorded. It returns the number
+// of readings stored with the sequence numbers accepted and those already
+// seen.
+func PowerData(collectorID string, data []model.PowerDataRequest) (int, model.PowerDataBatchResult, error) {
+	var plausible, rejected []model.PowerDataRequest
+	for _, item := range data {
+		if item.Quality != "" {
+			rejected = append(rejected, item)
+		} else {
+			plausible = append(plausible, item)
+		}
+	}
+
+	storedRejected, rejectedResult, err := rejectedReadings(collectorID, rejected)
+	if err != nil {
+		return 0, model.PowerDataBatchResult{}, err
+	}
+	stored, result, err := powerData(collectorID, plausible)
+	if err != nil {
+		return 0, model.PowerDataBatchResult{}, err
+	}
+
+	result.Accepted = append(result.Accepted, rejectedResult.Accepted...)
+	result.Duplicates = append(result.Duplicates, rejectedResult.Duplicates...)
+	return stored + storedRejected, result, nil
+}
+
+// rejectedReadings records the readings a collector rejected, skipping those
+// recorded before
+func rejectedReadings(collectorID string, data []model.PowerDataRequest) (int, model.PowerDataBatchResult, error) {
+	fresh, accepted, duplicates, err := filterDuplicates(&model.RejectedReading{}, collectorID, data)
+	if err != nil {
+		return 0, model.PowerDataBatchResult{}, err
+	}
+	result := model.PowerDataBatchResult{
+		Accepted:   accepted,
+		Duplicates: duplicates,
+	}
+	if len(fresh) == 0 {
+		return 0, result, nil
+	}
+
+	readings := make([]model.RejectedReading, 0, len(fresh))
+	for _, item := range fresh {
+		reading := model.RejectedReading{
+			CollectorID:    collectorID,
+			Timestamp:      item.Timestamp,
+			Quality:        item.Quality,
+			Voltage:        item.Voltage,
+			Current:        item.Current,
+			Power:          item.Power,
+			Energy:         item.Energy,
+			Frequency:      item.Frequency,
+			PowerFactor:    item.PowerFactor,
+			TimeUnreliable: item.TimeUnreliable,
+		}
+		if item.Seq != 0 {
+			seq := item.Seq
+			reading.Seq = &seq
+		}
+		readings = append(readings, reading)
+	}
+
+	if err := model.DB.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(readings, 100).Error; err != nil {
+		return 0, model.PowerDataBatchResult{}, err
+	}
+	logger.Warnf("Collector %s uploaded %d readings it rejected as implausible", collectorID, len(fresh))
+	return len(fresh), result, nil
+}
+
+// powerData stores the plausible readings of a collector, see PowerData
+func powerData(collectorID string, data []model.PowerDataRequest) (int, model.PowerDataBatchResult, error) {
+	// Skip readings that were stored by an earlier attempt
+	fresh, accepted, duplicates, err := filterDuplicates(&model.PowerData{}, collectorID, data)
+	if err != nil {
+		return 0, model.PowerDataBatchResult{}, err
+	}
+	result := model.PowerDataBatchResult{
+		Accepted:   accepted,
+		Duplicates: duplicates,
+	}
+	if len(duplicates) > 0 {
+		logger.Infof("Collector %s re-sent %d readings that were already stored", collectorID, len(duplicates))
+	}
+	if len(fresh) == 0 {
+		return 0, result, nil
+	}
+
+	// Prepare data for batch insert
+	var powerDataList []model.PowerData
+	for _, item := range fresh {
+		powerDataList = append(powerDataList, newPowerData(collectorID, item))
+	}
+
+	// Batch insert to the database and account the energy of the new readings
+	from, to := powerDataList[0].Timestamp, powerDataList[0].Timestamp
+	for _, item := range powerDataList {
+		if item.Timestamp.Before(from) {
+			from = item.Timestamp
+		}
+		if item.Timestamp.After(to) {
+			to = item.Timestamp
+		}
+	}
+
+	err = model.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(powerDataList, 100).Error; err != nil {
+			return err
+		}
+		return energy.Recalculate(tx, collectorID, from, to)
+	})
+	if err != nil {
+		return 0, model.PowerDataBatchResult{}, err
+	}
+
+	// Batch insert to InfluxDB for time-series analytics
+	if settings.InfluxDBSettings.Enabled {
+		influxClient := influxdb.GetClient()
+		if influxClient != nil {
+			var influxDataList []influxdb.PowerDataPoint
+			for _, item := range fresh {
+				influxDataList = append(influxDataList, influxdb.PowerDataPoint{
+					CollectorID: collectorID,
+					Timestamp:   item.Timestamp,
+					Voltage:     item.Voltage,
+					Current:     item.Current,
+					Power:       item.Power,
+					Energy:      item.Energy,
+					Frequency:   item.Frequency,
+					PowerFactor: item.PowerFactor,
+				})
+			}
+			if err := influxClient.WritePowerDataBatch(influxDataList); err != nil {
+				// Log error but don't fail the upload
+				logger.Errorf("Failed to write batch to InfluxDB: %v", err)
+			}
+		}
+	}
+
+	// Broadcast the latest data point
+	latestData := fresh[len(fresh)-1]
+	realtime.BroadcastPowerData(realtime.PowerDataMessage{
+		CollectorID: collectorID,
+		Timestamp:   latestData.Timestamp,
+		Voltage:     latestData.Voltage,
+		Current:     latestData.Current,
+		Power:       latestData.Power,
+		Energy:      latestData.Energy,
+		Frequency:   latestData.Frequency,
+		PowerFactor: latestData.PowerFactor,
+	})
+
+	return len(fresh), result, nil
+}
+
+// newPowerData converts an uploaded reading to its database record. The
+// summary of an aggregation window keeps its minimum and maximum values.
+func newPowerData(collectorID string, data model.PowerDataRequest) model.PowerData {
+	powerData := model.PowerData{
+		CollectorID: collectorID,
+		Timestamp:   data.Timestamp,
+		Voltage:     data.Voltage,
+		Current:     data.Current,
+		Power:       data.Power,
+		Energy:      data.Energy,
+		Frequency:   data.Frequency,
+		PowerFactor: data.PowerFactor,
+	}
+	if data.Seq != 0 {
+		seq := data.Seq
+		powerData.Seq = &seq
+	}
+	if agg := data.Aggregate; agg != nil && agg.Window > 0 && agg.Samples > 0 {
+		powerData.WindowSeconds = agg.Window
+		powerData.Samples = agg.Samples
+		powerData.VoltageMin = &agg.VoltageMin
+		powerData.VoltageMax = &agg.VoltageMax
+		powerData.CurrentMin = &agg.CurrentMin
+		powerData.CurrentMax = &agg.CurrentMax
+		powerData.PowerMin = &agg.PowerMin
+		powerData.PowerMax = &agg.PowerMax
+		powerData.PowerFactorMin = &agg.PowerFactorMin
+		powerData.PowerFactorMax = &agg.PowerFactorMax
+		powerData.WindowEnergy = &agg.EnergyDelta
+	}
+	powerData.TimeUnreliable = data.TimeUnreliable
+	return powerData
+}
+
+// filterDuplicates drops the readings whose sequence number the collector
+// has uploaded before, as stored in the table of the model table, including
+// repeats within data.
+// It returns the readings to store with the sequence numbers accepted and
+// those already seen. Readings without a sequence number are always stored.
+func filterDuplicates(table interface{}, collectorID string, data []model.PowerDataRequest) (fresh []model.PowerDataRequest, accepted, duplicates []uint64, err error) {
+	var seqs []uint64
+	for _, item := range data {
+		if item.Seq != 0 {
+			seqs = append(seqs, item.Seq)
+		}
+	}
+
+	// Soft-deleted rows still hold their index entry
+	seen := make(map[uint64]bool)
+	for start := 0; start < len(seqs); start += 500 {
+		end := min(start+500, len(seqs))
+		var existing []uint64
+		if err := model.DB.Unscoped().Model(table).
+			Where("collector_id = ? AND seq IN ?", collectorID, seqs[start:end]).
+			Pluck("seq", &existing).Error; err != nil {
+			return nil, nil, nil, err
+		}
+		for _, seq := range existing {
+			seen[seq] = true
+		}
+	}
+
+	accepted = []uint64{}
+	duplicates = []uint64{}
+	for _, item := range data {
+		if item.Seq == 0 {
+			fresh = append(fresh, item)
+			continue
+		}
+		if seen[item.Seq] {
+			duplicates = append(duplicates, item.Seq)
+			continue
+		}
+		seen[item.Seq] = true
+		accepted = append(accepted, item.Seq)
+		fresh = append(fresh, item)
+	}
+
+	return fresh, accepted, duplicates, nil
+}
@@ -9,7 +9,10 @@ import (
 	"time"
 
 	"Power-Monitor/internal/auth"
+	"Power-Monitor/internal/command"
 	"Power-Monitor/internal/influxdb"
+	"Power-Monitor/internal/mqtt"
+	"Power-Monitor/internal/pki"
 	"Power-Monitor/internal/realtime"
 	"Power-Monitor/model"
 	"Power-Monitor/settings"
@@ -36,9 +39,19 @@ func Boot(ctx context.Context) {
 	// Initialize authentication service
 	initAuthService()
 
+	// Initialize the CA for collector client certificates
+	if settings.CollectorSettings.EnableClientCert {
+		initPKI()
+	}
+
 	// Initialize realtime service
 	initRealtimeService(ctx)
 
+	// Subscribe to the topics of collectors using the MQTT uplink
+	if settings.MQTTSettings.Enabled {
+		initMQTT()
+	}
+
 	// Create default admin user if none exists
 	createDefaultAdmin()
 
@@ -99,15 +112,38 @@ func initAuthService() {
 	logger.Info("Authentication service initialized successfully")
 }
 
+// initPKI loads or creates the CA that signs collector client certificates
+func initPKI() {
+	logger.Info("Initializing collector certificate authority...")
+
+	if err := pki.Init(pki.Dir()); err != nil {
+		logger.Fatalf("Failed to initialize certificate authority: %v", err)
+	}
+
+	logger.Info("Collector certificate authority initialized successfully")
+}
+
 // initRealtimeService initializes the realtime communication service
 func initRealtimeService(ctx context.Context) {
 	logger.Info("Initializing realtime service...")
 
 	realtime.Init(ctx)
+	command.Init()
 
 	logger.Info("Realtime service initialized successfully")
 }
 
+// initMQTT subscribes to the topics collectors publish to over MQTT
+func initMQTT() {
+	logger.Infof("Initializing MQTT subscriber for broker %s...", settings.MQTTSettings.Broker)
+
+	if err := mqtt.Init(); err != nil {
+		logger.Fatalf("Failed to initialize MQTT subscriber: %v", err)
+	}
+
+	logger.Info("MQTT subscriber initialized successfully")
+}
+
 // createDefaultAdmin creates a default admin user if no users exist
 func createDefaultAdmin() {
 	logger.Info("Checking for existing users...")
@@ -176,6 +212,9 @@ func startTokenCleanupService(ctx context.Context) {
 			return
 		case <-ticker.C:
 			cleanupExpiredTokens()
+			cleanupSignatureNonces()
+			cleanupCollectorHeartbeats()
+			cleanupStaleCommands()
 		}
 	}
 }
@@ -208,6 +247,79 @@ func cleanupExpiredTokens() {
 	}
 }
 
+// cleanupSignatureNonces removes nonces of signed requests whose timestamp is
+// outside the signature window, as those requests are rejected anyway
+func cleanupSignatureNonces() {
+	result := model.DB.Where("timestamp < ?", time.Now().Add(-settings.CollectorSettings.SignatureWindow)).
+		Delete(&model.CollectorNonce{})
+	if result.Error != nil {
+		logger.Errorf("Failed to cleanup signature nonces: %v", result.Error)
+		return
+	}
+
+	if result.RowsAffected > 0 {
+		logger.Infof("Cleaned up %d signature nonces", result.RowsAffected)
+	}
+}
+
+// cleanupCollectorHeartbeats removes collector heartbeats older than the
+// retention period, keeping them all when no retention period is set
+func cleanupCollectorHeartbeats() {
+	retention := settings.CollectorSettings.HeartbeatRetention
+	if retention <= 0 {
+		return
+	}
+
+	result := model.DB.Unscoped().
+		Where("timestamp < ?", time.Now().Add(-retention)).
+		Delete(&model.CollectorHeartbeat{})
+	if result.Error != nil {
+		logger.Errorf("Failed to cleanup collector heartbeats: %v", result.Error)
+		return
+	}
+
+	if result.RowsAffected > 0 {
+		logger.Infof("Cleaned up %d collector heartbeats", result.RowsAffected)
+	}
+}
+
+// cleanupStaleCommands marks commands whose result did not arrive within the
+// command timeout as failed, and commands not delivered before they expired
+// as expired
+func cleanupStaleCommands() {
+	now := time.Now()
+
+	if timeout := settings.CollectorSettings.CommandTimeout; timeout > 0 {
+		result := model.DB.Model(&model.CollectorCommand{}).
+			Where("status = ? AND acked_at < ?", model.CommandStatusAcked, now.Add(-timeout)).
+			Updates(map[string]interface{}{
+				"status":       model.CommandStatusFailed,
+				"error":        fmt.Sprintf("no result within %s", timeout),
+				"completed_at": now,
+			})
+		if result.Error != nil {
+			logger.Errorf("Failed to cleanup timed out commands: %v", result.Error)
+		} else if result.RowsAffected > 0 {
+			logger.Infof("Marked %d timed out commands as failed", result.RowsAffected)
+		}
+	}
+
+	if expires := settings.CollectorSettings.CommandExpires; expires > 0 {
+		result := model.DB.Model(&model.CollectorCommand{}).
+			Where("status = ? AND created_at < ?", model.CommandStatusPending, now.Add(-expires)).
+			Updates(map[string]interface{}{
+				"status":       model.CommandStatusExpired,
+				"error":        fmt.Sprintf("not delivered within %s", expires),
+				"completed_at": now,
+			})
+		if result.Error != nil {
+			logger.Errorf("Failed to cleanup expired commands: %v", result.Error)
+		} else if result.RowsAffected > 0 {
+			logger.Infof("Marked %d undelivered commands as expired", result.RowsAffected)
+		}
+	}
+}
+
 // updateCollectorStatus updates collector status based on last seen time
 func updateCollectorStatus() {
 	// This is a placeholder for collector status monitoring
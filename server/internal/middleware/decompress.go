@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+)
+
+// maxDecompressedBody bounds the size of a decompressed request body
+const maxDecompressedBody = 32 << 20
+
+// Decompress returns middleware that transparently decompresses request
+// bodies sent with Content-Encoding gzip or zstd
+func Decompress() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body io.ReadCloser
+
+		switch c.GetHeader("Content-Encoding") {
+		case "", "identity":
+			c.Next()
+			return
+		case "gzip":
+			reader, err := gzip.NewReader(c.Request.Body)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid gzip body"})
+				c.Abort()
+				return
+			}
+			body = reader
+		case "zstd":
+			decoder, err := zstd.NewReader(c.Request.Body)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid zstd body"})
+				c.Abort()
+				return
+			}
+			body = decoder.IOReadCloser()
+		default:
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Unsupported content encoding"})
+			c.Abort()
+			return
+		}
+		defer body.Close()
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, body, maxDecompressedBody)
+		c.Request.Header.Del("Content-Encoding")
+		c.Request.Header.Del("Content-Length")
+		c.Request.ContentLength = -1
+
+		c.Next()
+	}
+}
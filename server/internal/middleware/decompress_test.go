@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compress returns data compressed with the content encoding
+func compress(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	switch encoding {
+	case "gzip":
+		w := gzip.NewWriter(&buf)
+		w.Write(data)
+		w.Close()
+	case "zstd":
+		w, err := zstd.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+		w.Close()
+	default:
+		buf.Write(data)
+	}
+	return buf.Bytes()
+}
+
+func TestDecompress(t *testing.T) {
+	body := []byte(`{"power":100}`)
+	large := make([]byte, maxDecompressedBody+1)
+	corrupt := compress(t, "gzip", bytes.Repeat(body, 100))
+	corrupt = append(corrupt[:20], bytes.Repeat([]byte{0xff}, 64)...)
+
+	tests := []struct {
+		name     string
+		encoding string
+		sent     []byte
+		status   int
+	}{
+		{"uncompressed", "", body, http.StatusOK},
+		{"gzip", "gzip", compress(t, "gzip", body), http.StatusOK},
+		{"zstd", "zstd", compress(t, "zstd", body), http.StatusOK},
+		{"unsupported encoding", "br", body, http.StatusUnsupportedMediaType},
+		{"invalid gzip header", "gzip", body, http.StatusBadRequest},
+		{"corrupt gzip body", "gzip", corrupt, http.StatusBadRequest},
+		{"corrupt zstd body", "zstd", body, http.StatusBadRequest},
+		{"gzip beyond the size limit", "gzip", compress(t, "gzip", large), http.StatusRequestEntityTooLarge},
+		{"zstd beyond the size limit", "zstd", compress(t, "zstd", large), http.StatusRequestEntityTooLarge},
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/test", Decompress(), func(c *gin.Context) {
+		received, err := io.ReadAll(c.Request.Body)
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			c.Status(http.StatusRequestEntityTooLarge)
+		case err != nil:
+			c.Status(http.StatusBadRequest)
+		case !bytes.Equal(received, body):
+			c.Status(http.StatusInternalServerError)
+		default:
+			c.Status(http.StatusOK)
+		}
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/test", bytes.NewReader(tt.sent))
+			if tt.encoding != "" {
+				req.Header.Set("Content-Encoding", tt.encoding)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+}
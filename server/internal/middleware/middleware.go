@@ -1,12 +1,15 @@
 package middleware
 
 import (
+	"crypto/x509"
 	"net/http"
 	"strings"
 	"time"
 
 	"Power-Monitor/internal/auth"
+	"Power-Monitor/internal/pki"
 	"Power-Monitor/model"
+	"Power-Monitor/settings"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -75,11 +78,20 @@ func JWTAuth() gin.HandlerFunc {
 	}
 }
 
+// TokenExpiresHeader carries the expiry of the token a collector
+// authenticated with
+const TokenExpiresHeader = "X-Token-Expires-At"
+
 // CollectorAuth returns collector authentication middleware
 func CollectorAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
+			// Collectors may authenticate with a client certificate instead
+			if cert := clientCertificate(c); cert != nil {
+				certificateAuth(c, cert)
+				return
+			}
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
 			c.Abort()
 			return
@@ -94,22 +106,80 @@ func CollectorAuth() gin.HandlerFunc {
 		}
 
 		token := tokenParts[1]
+		now := time.Now()
 
-		// Static token validation
+		// The token replaced by the last rotation is accepted during its
+		// grace period
 		var collector model.Collector
-		if err := model.DB.Where("token = ? AND is_active = ?",
-			token, true).First(&collector).Error; err != nil {
+		if err := model.DB.Where("is_active = ? AND (token = ? OR (previous_token = ? AND previous_token_expires_at > ?))",
+			true, token, token, now).First(&collector).Error; err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid collector token"})
 			c.Abort()
 			return
 		}
 
+		previous := collector.Token != token
+		expiresAt := collector.TokenExpiresAt
+		if previous {
+			expiresAt = collector.PreviousTokenExpiresAt
+		} else if collector.TokenExpired() {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Collector token expired"})
+			c.Abort()
+			return
+		} else if expiresAt.IsZero() {
+			// Tokens issued before expiry was enforced start expiring now
+			collector.TokenExpiresAt = now.Add(settings.CollectorSettings.TokenExpires)
+			expiresAt = collector.TokenExpiresAt
+		}
+
 		// Update last seen time
-		collector.LastSeenAt = time.Now()
+		collector.LastSeenAt = now
 		collector.IPAddress = c.ClientIP()
 		model.DB.Save(&collector)
 
+		// Tell the collector when to rotate its token
+		c.Header(TokenExpiresHeader, expiresAt.UTC().Format(time.RFC3339))
+
 		c.Set("collector_id", collector.CollectorID)
+		c.Set("collector", &collector)
+		c.Set("collector_previous_token", previous)
 		c.Next()
 	}
 }
+
+// clientCertificate returns the client certificate of the request if client
+// certificate authentication is enabled and the TLS handshake verified it
+// against the CA
+func clientCertificate(c *gin.Context) *x509.Certificate {
+	if !settings.CollectorSettings.EnableClientCert || c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+	return c.Request.TLS.VerifiedChains[0][0]
+}
+
+// certificateAuth authenticates the collector whose ID is the common name
+// of a verified client certificate
+func certificateAuth(c *gin.Context, cert *x509.Certificate) {
+	collectorID, err := pki.VerifyCollectorCert(cert)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid client certificate"})
+		c.Abort()
+		return
+	}
+
+	var collector model.Collector
+	if err := model.DB.Where("collector_id = ? AND is_active = ?", collectorID, true).First(&collector).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid client certificate"})
+		c.Abort()
+		return
+	}
+
+	// Update last seen time
+	collector.LastSeenAt = time.Now()
+	collector.IPAddress = c.ClientIP()
+	model.DB.Save(&collector)
+
+	c.Set("collector_id", collector.CollectorID)
+	c.Set("collector", &collector)
+	c.Next()
+}
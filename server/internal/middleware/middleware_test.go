@@ -0,0 +1,189 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"Power-Monitor/internal/pki"
+	"Power-Monitor/model"
+	"Power-Monitor/settings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB replaces the global database with an in-memory database
+// holding the collector tables for the duration of the test
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger:                                   logger.Default.LogMode(logger.Silent),
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	if err := db.AutoMigrate(&model.Collector{}, &model.CollectorCertificate{}, &model.CollectorNonce{}); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	prev := model.DB
+	model.DB = db
+	t.Cleanup(func() {
+		model.DB = prev
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// newTestRouter returns a router serving a handler that answers with the
+// collector authenticated by the middleware
+func newTestRouter(middleware ...gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/test", append(middleware, func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"collector_id":   c.GetString("collector_id"),
+			"previous_token": c.GetBool("collector_previous_token"),
+		})
+	})...)
+	return r
+}
+
+func TestCollectorAuthToken(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now()
+
+	collectors := []model.Collector{
+		{CollectorID: "current", Name: "current", IsActive: true, Token: "token-current",
+			TokenExpiresAt: now.Add(time.Hour)},
+		{CollectorID: "rotated", Name: "rotated", IsActive: true, Token: "token-new",
+			TokenExpiresAt: now.Add(time.Hour), PreviousToken: "token-old", PreviousTokenExpiresAt: now.Add(time.Minute)},
+		{CollectorID: "grace-over", Name: "grace-over", IsActive: true, Token: "token-newer",
+			TokenExpiresAt: now.Add(time.Hour), PreviousToken: "token-older", PreviousTokenExpiresAt: now.Add(-time.Minute)},
+		{CollectorID: "expired", Name: "expired", IsActive: true, Token: "token-expired",
+			TokenExpiresAt: now.Add(-time.Minute)},
+	}
+	for i := range collectors {
+		if err := db.Create(&collectors[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := newTestRouter(CollectorAuth())
+	tests := []struct {
+		name     string
+		header   string
+		status   int
+		previous bool
+	}{
+		{"current token", "Bearer token-current", http.StatusOK, false},
+		{"previous token within the grace period", "Bearer token-old", http.StatusOK, true},
+		{"new token after rotation", "Bearer token-new", http.StatusOK, false},
+		{"previous token after the grace period", "Bearer token-older", http.StatusUnauthorized, false},
+		{"expired token", "Bearer token-expired", http.StatusUnauthorized, false},
+		{"unknown token", "Bearer token-unknown", http.StatusUnauthorized, false},
+		{"malformed header", "token-current", http.StatusUnauthorized, false},
+		{"no credentials", "", http.StatusUnauthorized, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/test", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d: %s", tt.status, w.Code, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if w.Header().Get(TokenExpiresHeader) == "" {
+				t.Error("Expected the token expiry header")
+			}
+			previous := `"previous_token":false`
+			if tt.previous {
+				previous = `"previous_token":true`
+			}
+			if !strings.Contains(w.Body.String(), previous) {
+				t.Errorf("Expected %s, got %s", previous, w.Body)
+			}
+		})
+	}
+}
+
+func TestCollectorAuthCertificate(t *testing.T) {
+	db := newTestDB(t)
+
+	enabled := settings.CollectorSettings.EnableClientCert
+	settings.CollectorSettings.EnableClientCert = true
+	t.Cleanup(func() { settings.CollectorSettings.EnableClientCert = enabled })
+
+	if err := db.Create(&model.Collector{CollectorID: "cert", Name: "cert", IsActive: true, Token: "token-cert"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&model.Collector{CollectorID: "other", Name: "other", IsActive: true, Token: "token-other"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// certificate returns a certificate of a collector with a serial number
+	certificate := func(collectorID string, serial int64) *x509.Certificate {
+		return &x509.Certificate{Subject: pkix.Name{CommonName: collectorID}, SerialNumber: big.NewInt(serial)}
+	}
+	revokedAt := time.Now()
+	records := []model.CollectorCertificate{
+		{CollectorID: "cert", Serial: pki.Serial(certificate("cert", 1)), NotAfter: time.Now().Add(time.Hour)},
+		{CollectorID: "cert", Serial: pki.Serial(certificate("cert", 2)), NotAfter: time.Now().Add(time.Hour), RevokedAt: &revokedAt},
+		{CollectorID: "other", Serial: pki.Serial(certificate("other", 3)), NotAfter: time.Now().Add(time.Hour)},
+	}
+	for i := range records {
+		if err := db.Create(&records[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := newTestRouter(CollectorAuth())
+	tests := []struct {
+		name   string
+		cert   *x509.Certificate
+		status int
+	}{
+		{"issued certificate", certificate("cert", 1), http.StatusOK},
+		{"revoked certificate", certificate("cert", 2), http.StatusUnauthorized},
+		{"serial of another collector", certificate("cert", 3), http.StatusUnauthorized},
+		{"common name of another collector", certificate("other", 1), http.StatusUnauthorized},
+		{"unknown serial", certificate("cert", 4), http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/test", nil)
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{tt.cert}}}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d: %s", tt.status, w.Code, w.Body)
+			}
+			if tt.status == http.StatusOK && !strings.Contains(w.Body.String(), `"collector_id":"cert"`) {
+				t.Errorf("Expected the collector of the certificate, got %s", w.Body)
+			}
+		})
+	}
+}
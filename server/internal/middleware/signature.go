@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"Power-Monitor/internal/auth"
+	"Power-Monitor/model"
+	"Power-Monitor/settings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/clause"
+)
+
+// maxNonceLength bounds the length of the nonce of a signed request
+const maxNonceLength = 64
+
+// CollectorSignature returns middleware that verifies signed collector
+// requests. It runs after CollectorAuth and before Decompress, as the
+// signature covers the body as sent. Unsigned requests are rejected for
+// collectors that require signing.
+func CollectorSignature() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		collector := c.MustGet("collector").(*model.Collector)
+
+		signature := c.GetHeader(auth.SignatureHeader)
+		if signature == "" {
+			if collector.RequireSignature {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Request signature required"})
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if collector.SigningSecret == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Request signing is not set up for this collector"})
+			c.Abort()
+			return
+		}
+
+		timestamp, err := strconv.ParseInt(c.GetHeader(auth.SignatureTimestampHeader), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature timestamp"})
+			c.Abort()
+			return
+		}
+		signedAt := time.Unix(timestamp, 0)
+		if skew := time.Since(signedAt); skew > settings.CollectorSettings.SignatureWindow || -skew > settings.CollectorSettings.SignatureWindow {
+			// The server time lets the collector correct its clock
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Signature timestamp outside the allowed window", "server_time": time.Now()})
+			c.Abort()
+			return
+		}
+
+		nonce := c.GetHeader(auth.SignatureNonceHeader)
+		if nonce == "" || len(nonce) > maxNonceLength {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature nonce"})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxDecompressedBody))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		if !auth.VerifyRequestSignature(collector.SigningSecret, c.Request.Method, c.Request.URL.RequestURI(),
+			timestamp, nonce, body, signature) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid request signature"})
+			c.Abort()
+			return
+		}
+
+		// A nonce is accepted once while its timestamp is within the window
+		result := model.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&model.CollectorNonce{
+			CollectorID: collector.CollectorID,
+			Nonce:       nonce,
+			Timestamp:   signedAt,
+		})
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record signature nonce"})
+			c.Abort()
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Replayed request"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"Power-Monitor/internal/auth"
+	"Power-Monitor/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCollectorSignature(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.Create(&model.CollectorNonce{CollectorID: "test", Nonce: "used", Timestamp: time.Now()}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	const secret = "secret"
+	body := []byte(`{"power":100}`)
+
+	tests := []struct {
+		name     string
+		require  bool
+		unsigned bool
+		secret   string        // the request is signed with
+		skew     time.Duration // of the signature timestamp
+		nonce    string
+		status   int
+	}{
+		{"valid signature", true, false, secret, 0, "nonce-1", http.StatusOK},
+		{"valid signature of an optional signing collector", false, false, secret, 0, "nonce-2", http.StatusOK},
+		{"bad MAC", false, false, "other-secret", 0, "nonce-3", http.StatusUnauthorized},
+		{"replayed nonce", false, false, secret, 0, "used", http.StatusUnauthorized},
+		{"stale timestamp", false, false, secret, -10 * time.Minute, "nonce-4", http.StatusUnauthorized},
+		{"timestamp ahead", false, false, secret, 10 * time.Minute, "nonce-5", http.StatusUnauthorized},
+		{"missing nonce", false, false, secret, 0, "", http.StatusUnauthorized},
+		{"unsigned request of a signing collector", true, true, "", 0, "", http.StatusUnauthorized},
+		{"unsigned request", false, true, "", 0, "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := &model.Collector{CollectorID: "test", SigningSecret: secret, RequireSignature: tt.require}
+
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.POST("/test", func(c *gin.Context) {
+				c.Set("collector", collector)
+			}, CollectorSignature(), func(c *gin.Context) {
+				received, err := io.ReadAll(c.Request.Body)
+				if err != nil || !bytes.Equal(received, body) {
+					c.Status(http.StatusBadRequest)
+					return
+				}
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/test?batch=1", bytes.NewReader(body))
+			if !tt.unsigned {
+				timestamp := time.Now().Add(tt.skew).Unix()
+				req.Header.Set(auth.SignatureHeader, auth.SignRequest(tt.secret, http.MethodPost, "/test?batch=1", timestamp, tt.nonce, body))
+				req.Header.Set(auth.SignatureTimestampHeader, strconv.FormatInt(timestamp, 10))
+				req.Header.Set(auth.SignatureNonceHeader, tt.nonce)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d: %s", tt.status, w.Code, w.Body)
+			}
+		})
+	}
+
+	// A valid signature is accepted once
+	var nonces int64
+	if err := db.Model(&model.CollectorNonce{}).Where("nonce LIKE ?", "nonce-%").Count(&nonces).Error; err != nil {
+		t.Fatal(err)
+	}
+	if nonces != 2 {
+		t.Errorf("Expected the nonces of the 2 accepted requests to be recorded, got %d", nonces)
+	}
+}
@@ -0,0 +1,373 @@
+// Package mqtt subscribes to the topics collectors publish their readings,
+// heartbeats and status to when they use the MQTT uplink. The session is
+// persistent, so the broker keeps the messages published while the server
+// is down.
+package mqtt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"Power-Monitor/internal/auth"
+	"Power-Monitor/internal/ingest"
+	"Power-Monitor/internal/realtime"
+	"Power-Monitor/model"
+	"Power-Monitor/settings"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/uozi-tech/cosy/logger"
+	"gorm.io/gorm/clause"
+)
+
+// qos is the quality of service of the subscriptions
+const qos = 1
+
+// maxReconnectInterval bounds the backoff between connection attempts
+const maxReconnectInterval = time.Minute
+
+// collectorIDPlaceholder is the topic level holding the collector ID
+const collectorIDPlaceholder = "{collector_id}"
+
+// signatureMethod takes the place of the request method in the signature
+// of a message
+const signatureMethod = "PUBLISH"
+
+// maxNonceLength bounds the length of the nonce of a signed message, as for
+// signed requests
+const maxNonceLength = 64
+
+var client paho.Client
+
+// Init connects to the broker in the background and subscribes to the
+// collector topics. The connection is retried until the broker is reachable,
+// and the subscriptions are renewed whenever it is re-established.
+func Init() error {
+	s := settings.MQTTSettings
+
+	topics := map[string]paho.MessageHandler{
+		s.DataTopic:      handleData,
+		s.HeartbeatTopic: handleHeartbeat,
+		s.StatusTopic:    handleStatus,
+	}
+	filters := make(map[string]byte)
+	for topic := range topics {
+		if !slices.Contains(strings.Split(topic, "/"), collectorIDPlaceholder) {
+			return fmt.Errorf("topic %s does not contain %s as a topic level", topic, collectorIDPlaceholder)
+		}
+		filters[topicFilter(topic)] = qos
+	}
+
+	opts := paho.NewClientOptions().
+		AddBroker(s.Broker).
+		SetClientID(s.ClientID).
+		SetUsername(s.Username).
+		SetPassword(s.Password).
+		SetCleanSession(false).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetMaxReconnectInterval(maxReconnectInterval).
+		// Messages are acknowledged once stored, so that the broker
+		// delivers them again if storing fails
+		SetAutoAckDisabled(true).
+		// Messages queued by the broker arrive before the subscriptions
+		// are renewed
+		SetDefaultPublishHandler(func(c paho.Client, msg paho.Message) {
+			for topic, handler := range topics {
+				if _, ok := collectorIDFromTopic(topic, msg.Topic()); ok {
+					handler(c, msg)
+					return
+				}
+			}
+			msg.Ack()
+		}).
+		SetOnConnectHandler(func(c paho.Client) {
+			logger.Infof("Connected to MQTT broker %s", s.Broker)
+			// Not waited for, the handler must not block the client
+			c.SubscribeMultiple(filters, nil)
+		}).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			logger.Warnf("Connection to MQTT broker %s lost: %v", s.Broker, err)
+		})
+
+	if s.CACert != "" {
+		caPEM, err := os.ReadFile(s.CACert)
+		if err != nil {
+			return fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return fmt.Errorf("no certificates found in %s", s.CACert)
+		}
+		opts.SetTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool})
+	}
+
+	client = paho.NewClient(opts)
+	client.Connect()
+	return nil
+}
+
+// Close disconnects from the broker
+func Close() {
+	if client != nil {
+		client.Disconnect(250)
+	}
+}
+
+// topicFilter returns the subscription of a topic with a wildcard in place
+// of the collector ID
+func topicFilter(topic string) string {
+	return strings.ReplaceAll(topic, collectorIDPlaceholder, "+")
+}
+
+// collectorIDFromTopic returns the collector ID of a message topic matching
+// a configured topic
+func collectorIDFromTopic(template, topic string) (string, bool) {
+	levels := strings.Split(template, "/")
+	parts := strings.Split(topic, "/")
+	if len(levels) != len(parts) {
+		return "", false
+	}
+
+	collectorID := ""
+	for i, level := range levels {
+		switch {
+		case level == collectorIDPlaceholder:
+			collectorID = parts[i]
+		case level != parts[i]:
+			return "", false
+		}
+	}
+	return collectorID, collectorID != ""
+}
+
+// activeCollector returns the collector of a message, after checking that
+// it is registered and active and that its token has not expired or been
+// revoked. The broker authenticates the collectors and restricts them to
+// their own topics.
+func activeCollector(template string, msg paho.Message) (*model.Collector, bool) {
+	collectorID, ok := collectorIDFromTopic(template, msg.Topic())
+	if !ok {
+		return nil, false
+	}
+
+	var collector model.Collector
+	if err := model.DB.Where("collector_id = ? AND is_active = ?", collectorID, true).
+		First(&collector).Error; err != nil {
+		logger.Warnf("Ignoring MQTT message on %s of unknown or inactive collector %s", msg.Topic(), collectorID)
+		return nil, false
+	}
+	if collector.TokenExpired() {
+		logger.Warnf("Ignoring MQTT message on %s of collector %s with an expired or revoked token", msg.Topic(), collectorID)
+		return nil, false
+	}
+	return &collector, true
+}
+
+// errRejected is wrapped by the errors of messages that are dropped, as
+// opposed to those that could not be processed and are delivered again
+var errRejected = errors.New("message rejected")
+
+// verifyMessage returns the message a collector published, unwrapped from
+// its signature, and the nonce to record once the message is processed.
+// Unsigned messages are accepted unless the collector requires signing.
+// Signed messages are verified like signed requests and share their nonces.
+// Readings are deduplicated by their sequence numbers, so only messages
+// that must be recent, like heartbeats, are rejected once their timestamp
+// is outside the signature window; readings the broker held while the
+// server was down are accepted later.
+func verifyMessage(collector *model.Collector, msg paho.Message, recent bool) ([]byte, *model.CollectorNonce, error) {
+	var signed model.CollectorSignedMessage
+	if err := json.Unmarshal(msg.Payload(), &signed); err != nil || signed.Signature == "" {
+		if collector.RequireSignature {
+			return nil, nil, fmt.Errorf("%w: signature required", errRejected)
+		}
+		return msg.Payload(), nil, nil
+	}
+
+	if collector.SigningSecret == "" {
+		return nil, nil, fmt.Errorf("%w: signing is not set up", errRejected)
+	}
+	signedAt := time.Unix(signed.Timestamp, 0)
+	window := settings.CollectorSettings.SignatureWindow
+	if skew := time.Since(signedAt); -skew > window || (recent && skew > window) {
+		return nil, nil, fmt.Errorf("%w: timestamp outside the signature window", errRejected)
+	}
+	if signed.Nonce == "" || len(signed.Nonce) > maxNonceLength {
+		return nil, nil, fmt.Errorf("%w: invalid nonce", errRejected)
+	}
+	if !auth.VerifyRequestSignature(collector.SigningSecret, signatureMethod, msg.Topic(),
+		signed.Timestamp, signed.Nonce, signed.Message, signed.Signature) {
+		return nil, nil, fmt.Errorf("%w: invalid signature", errRejected)
+	}
+
+	var count int64
+	if err := model.DB.Model(&model.CollectorNonce{}).
+		Where("collector_id = ? AND nonce = ?", collector.CollectorID, signed.Nonce).
+		Count(&count).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to check signature nonce: %w", err)
+	}
+	if count > 0 {
+		return nil, nil, fmt.Errorf("%w: replayed message", errRejected)
+	}
+
+	return signed.Message, &model.CollectorNonce{
+		CollectorID: collector.CollectorID,
+		Nonce:       signed.Nonce,
+		Timestamp:   signedAt,
+	}, nil
+}
+
+// recordNonce records the nonce of a processed signed message, so that the
+// message is not accepted again
+func recordNonce(nonce *model.CollectorNonce) error {
+	if nonce == nil {
+		return nil
+	}
+	return model.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(nonce).Error
+}
+
+// dropOrRetry acknowledges a rejected message, so that it is dropped, and
+// leaves others to be delivered again
+func dropOrRetry(msg paho.Message, what, collectorID string, err error) {
+	if errors.Is(err, errRejected) {
+		logger.Warnf("Ignoring %s of collector %s on %s: %v", what, collectorID, msg.Topic(), err)
+		msg.Ack()
+		return
+	}
+	logger.Errorf("Failed to process %s of collector %s: %v", what, collectorID, err)
+}
+
+// handleData stores the readings published by a collector
+func handleData(_ paho.Client, msg paho.Message) {
+	collector, ok := activeCollector(settings.MQTTSettings.DataTopic, msg)
+	if !ok {
+		msg.Ack()
+		return
+	}
+	collectorID := collector.CollectorID
+
+	payload, nonce, err := verifyMessage(collector, msg, false)
+	if err != nil {
+		dropOrRetry(msg, "readings", collectorID, err)
+		return
+	}
+
+	var req model.PowerDataUploadRequest
+	if err := json.Unmarshal(payload, &req); err != nil || binding.Validator.ValidateStruct(&req) != nil {
+		logger.Warnf("Ignoring invalid readings of collector %s on %s", collectorID, msg.Topic())
+		msg.Ack()
+		return
+	}
+	if req.CollectorID != collectorID {
+		logger.Warnf("Ignoring readings of collector %s published on %s", req.CollectorID, msg.Topic())
+		msg.Ack()
+		return
+	}
+
+	if _, _, err := ingest.PowerData(collectorID, req.Data); err != nil {
+		// Not acknowledged, so that the broker delivers the message again
+		logger.Errorf("Failed to save readings of collector %s: %v", collectorID, err)
+		return
+	}
+	if err := recordNonce(nonce); err != nil {
+		dropOrRetry(msg, "readings", collectorID, err)
+		return
+	}
+
+	updateCollectorLastSeen(collectorID)
+	msg.Ack()
+}
+
+// handleHeartbeat records the heartbeat of a collector with its diagnostics
+func handleHeartbeat(_ paho.Client, msg paho.Message) {
+	collector, ok := activeCollector(settings.MQTTSettings.HeartbeatTopic, msg)
+	if !ok {
+		msg.Ack()
+		return
+	}
+	collectorID := collector.CollectorID
+
+	payload, nonce, err := verifyMessage(collector, msg, true)
+	if err != nil {
+		dropOrRetry(msg, "heartbeat", collectorID, err)
+		return
+	}
+
+	var req model.CollectorHeartbeatRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		logger.Warnf("Ignoring invalid heartbeat of collector %s", collectorID)
+		msg.Ack()
+		return
+	}
+
+	// The broker may have held the heartbeat, so the offset of the collector
+	// clock is not derived from it
+	if err := ingest.Heartbeat(collectorID, req, time.Now()); err != nil {
+		logger.Errorf("Failed to record heartbeat of collector %s: %v", collectorID, err)
+		return
+	}
+	if err := recordNonce(nonce); err != nil {
+		dropOrRetry(msg, "heartbeat", collectorID, err)
+		return
+	}
+
+	updateCollectorLastSeen(collectorID)
+	msg.Ack()
+}
+
+// handleStatus records the online status of a collector and broadcasts
+// changes to connected clients. The retained status of every collector is
+// delivered when subscribing, which is not a change. The status is not
+// signed, as the broker publishes the offline status of the collector.
+func handleStatus(_ paho.Client, msg paho.Message) {
+	collector, ok := activeCollector(settings.MQTTSettings.StatusTopic, msg)
+	if !ok {
+		msg.Ack()
+		return
+	}
+	collectorID := collector.CollectorID
+
+	var req model.CollectorStatusMessage
+	if err := json.Unmarshal(msg.Payload(), &req); err != nil {
+		logger.Warnf("Ignoring invalid status of collector %s", collectorID)
+		msg.Ack()
+		return
+	}
+
+	switch req.Status {
+	case model.CollectorStatusOnline:
+		if !msg.Retained() {
+			logger.Infof("Collector %s connected to the MQTT broker", collectorID)
+			updateCollectorLastSeen(collectorID)
+			realtime.BroadcastCollectorStatus(collectorID, true)
+		}
+		if req.Version != "" {
+			model.DB.Model(&model.Collector{}).
+				Where("collector_id = ?", collectorID).
+				Update("version", req.Version)
+		}
+	case model.CollectorStatusOffline:
+		if !msg.Retained() {
+			logger.Warnf("Collector %s disconnected from the MQTT broker", collectorID)
+			realtime.BroadcastCollectorStatus(collectorID, false)
+		}
+	default:
+		logger.Warnf("Ignoring unknown status %q of collector %s", req.Status, collectorID)
+	}
+	msg.Ack()
+}
+
+// updateCollectorLastSeen updates the collector's last seen time
+func updateCollectorLastSeen(collectorID string) {
+	model.DB.Model(&model.Collector{}).
+		Where("collector_id = ?", collectorID).
+		Update("last_seen_at", time.Now())
+}
@@ -0,0 +1,218 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"Power-Monitor/internal/auth"
+	"Power-Monitor/model"
+	"Power-Monitor/settings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uozi-tech/cosy/logger"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+func TestMain(m *testing.M) {
+	// The handlers log the messages they drop
+	logger.Init(gin.ReleaseMode)
+	os.Exit(m.Run())
+}
+
+// message is a received MQTT message that records its acknowledgement
+type message struct {
+	topic   string
+	payload []byte
+	acked   bool
+}
+
+func (m *message) Duplicate() bool   { return false }
+func (m *message) Qos() byte         { return qos }
+func (m *message) Retained() bool    { return false }
+func (m *message) Topic() string     { return m.topic }
+func (m *message) MessageID() uint16 { return 1 }
+func (m *message) Payload() []byte   { return m.payload }
+func (m *message) Ack()              { m.acked = true }
+
+// newTestDB replaces the global database with an in-memory database
+// holding the collector tables for the duration of the test. The readings
+// table is left out, so that storing readings fails until it is migrated.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger:                                   gormlogger.Default.LogMode(gormlogger.Silent),
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	if err := db.AutoMigrate(&model.Collector{}, &model.CollectorNonce{}, &model.RejectedReading{}); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	prev := model.DB
+	model.DB = db
+	t.Cleanup(func() {
+		model.DB = prev
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// readings returns the payload of a data message holding one reading
+func readings(t *testing.T, collectorID string, seq uint64) []byte {
+	t.Helper()
+
+	payload, err := json.Marshal(model.PowerDataUploadRequest{
+		CollectorID: collectorID,
+		Data:        []model.PowerDataRequest{{Seq: seq, Timestamp: time.Now(), Voltage: 230, Power: 100}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+// countReadings returns the number of stored readings of a collector
+func countReadings(t *testing.T, db *gorm.DB, collectorID string) int64 {
+	t.Helper()
+
+	var count int64
+	if err := db.Model(&model.PowerData{}).Where("collector_id = ?", collectorID).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestCollectorIDFromTopic(t *testing.T) {
+	tests := []struct {
+		template string
+		topic    string
+		want     string
+		ok       bool
+	}{
+		{"power/{collector_id}/data", "power/c1/data", "c1", true},
+		{"{collector_id}/data", "c1/data", "c1", true},
+		{"power/{collector_id}/data", "power/c1/heartbeat", "", false},
+		{"power/{collector_id}/data", "power/c1/data/extra", "", false},
+		{"power/{collector_id}/data", "power/data", "", false},
+		{"power/{collector_id}/data", "power//data", "", false},
+		{"site/power/{collector_id}/data", "other/power/c1/data", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.topic, func(t *testing.T) {
+			got, ok := collectorIDFromTopic(tt.template, tt.topic)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("collectorIDFromTopic(%q, %q) = %q, %v, want %q, %v", tt.template, tt.topic, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestHandleData(t *testing.T) {
+	db := newTestDB(t)
+	collectors := []model.Collector{
+		{CollectorID: "c1", Name: "c1", IsActive: true, Token: "t1", TokenExpiresAt: time.Now().Add(time.Hour)},
+		{CollectorID: "expired", Name: "expired", IsActive: true, Token: "t2", TokenExpiresAt: time.Now().Add(-time.Minute)},
+	}
+	for i := range collectors {
+		if err := db.Create(&collectors[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A failure to store the readings leaves the message to be delivered
+	// again
+	msg := &message{topic: "power/c1/data", payload: readings(t, "c1", 1)}
+	handleData(nil, msg)
+	if msg.acked {
+		t.Fatal("Expected a message whose readings could not be stored not to be acknowledged")
+	}
+
+	if err := db.AutoMigrate(&model.PowerData{}); err != nil {
+		t.Fatal(err)
+	}
+	handleData(nil, msg)
+	if !msg.acked || countReadings(t, db, "c1") != 1 {
+		t.Fatal("Expected the delivered message to be stored and acknowledged")
+	}
+
+	// Readings of another collector are dropped
+	msg = &message{topic: "power/c1/data", payload: readings(t, "c2", 2)}
+	handleData(nil, msg)
+	if !msg.acked || countReadings(t, db, "c2") != 0 || countReadings(t, db, "c1") != 1 {
+		t.Error("Expected readings of another collector than the topic's to be dropped")
+	}
+
+	// Messages of collectors with an expired or revoked token are dropped
+	msg = &message{topic: "power/expired/data", payload: readings(t, "expired", 1)}
+	handleData(nil, msg)
+	if !msg.acked || countReadings(t, db, "expired") != 0 {
+		t.Error("Expected readings of a collector with an expired token to be dropped")
+	}
+}
+
+func TestHandleDataSignature(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.AutoMigrate(&model.PowerData{}); err != nil {
+		t.Fatal(err)
+	}
+	const secret = "secret"
+	collector := model.Collector{CollectorID: "c1", Name: "c1", IsActive: true, Token: "t1",
+		SigningSecret: secret, RequireSignature: true}
+	if err := db.Create(&collector).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	topic := "power/c1/data"
+	// sign wraps a message signed at a time with a nonce and a secret
+	sign := func(payload []byte, at time.Time, nonce, secret string) []byte {
+		signed, err := json.Marshal(model.CollectorSignedMessage{
+			Timestamp: at.Unix(),
+			Nonce:     nonce,
+			Signature: auth.SignRequest(secret, signatureMethod, topic, at.Unix(), nonce, payload),
+			Message:   payload,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	now := time.Now()
+	held := now.Add(-2 * settings.CollectorSettings.SignatureWindow)
+	tests := []struct {
+		name    string
+		payload []byte
+		stored  bool
+	}{
+		{"unsigned", readings(t, "c1", 1), false},
+		{"bad signature", sign(readings(t, "c1", 2), now, "n2", "other"), false},
+		{"signed", sign(readings(t, "c1", 3), now, "n3", secret), true},
+		{"replayed", sign(readings(t, "c1", 4), now, "n3", secret), false},
+		{"held by the broker", sign(readings(t, "c1", 5), held, "n5", secret), true},
+		{"signed ahead of the window", sign(readings(t, "c1", 6), now.Add(time.Hour), "n6", secret), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := countReadings(t, db, "c1")
+			msg := &message{topic: topic, payload: tt.payload}
+			handleData(nil, msg)
+			if !msg.acked {
+				t.Error("Expected the message to be acknowledged")
+			}
+			if stored := countReadings(t, db, "c1") > before; stored != tt.stored {
+				t.Errorf("Expected stored %v, got %v", tt.stored, stored)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package pki
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"Power-Monitor/model"
+	"Power-Monitor/settings"
+
+	cSettings "github.com/uozi-tech/cosy/settings"
+	"gorm.io/gorm"
+)
+
+// ErrRevoked is returned for certificates that were revoked or never issued
+var ErrRevoked = errors.New("certificate revoked or unknown")
+
+// Dir returns the directory of the CA, resolved relative to the config file
+func Dir() string {
+	dir := settings.CollectorSettings.PKIDir
+	if filepath.IsAbs(dir) {
+		return dir
+	}
+	return filepath.Join(filepath.Dir(cSettings.ConfPath), dir)
+}
+
+// IssueCollectorCert issues a client certificate to a collector and records
+// it in db. With a CSR the collector keeps its own key; without one a key
+// pair is generated and returned with the certificate. A certificate whose
+// record is rolled back is rejected like a revoked one.
+func IssueCollectorCert(db *gorm.DB, collectorID string, csrPEM []byte) (certPEM, keyPEM []byte, err error) {
+	ca := Get()
+	if ca == nil {
+		return nil, nil, ErrNotInitialized
+	}
+
+	validity := settings.CollectorSettings.ClientCertExpires
+	var cert *x509.Certificate
+	if len(csrPEM) > 0 {
+		certPEM, cert, err = ca.SignCSR(csrPEM, collectorID, validity)
+	} else {
+		certPEM, keyPEM, cert, err = ca.Issue(collectorID, validity)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	record := model.CollectorCertificate{
+		CollectorID: collectorID,
+		Serial:      Serial(cert),
+		NotAfter:    cert.NotAfter,
+	}
+	if err := db.Create(&record).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to record certificate: %w", err)
+	}
+
+	return certPEM, keyPEM, nil
+}
+
+// VerifyCollectorCert checks that a client certificate, already verified
+// against the CA, was issued to the collector named by its common name and
+// has not been revoked. It returns the collector ID.
+func VerifyCollectorCert(cert *x509.Certificate) (string, error) {
+	var record model.CollectorCertificate
+	err := model.DB.Where("serial = ? AND collector_id = ? AND revoked_at IS NULL",
+		Serial(cert), cert.Subject.CommonName).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", ErrRevoked
+	}
+	if err != nil {
+		return "", err
+	}
+	return record.CollectorID, nil
+}
+
+// RevokeCollectorCerts revokes certificates by serial number, or all
+// certificates of the collector if serial is empty. It returns the number of
+// certificates revoked.
+func RevokeCollectorCerts(collectorID, serial string) (int64, error) {
+	query := model.DB.Model(&model.CollectorCertificate{}).
+		Where("collector_id = ? AND revoked_at IS NULL", collectorID)
+	if serial != "" {
+		query = query.Where("serial = ?", serial)
+	}
+
+	result := query.Update("revoked_at", time.Now())
+	return result.RowsAffected, result.Error
+}
+
+// CollectorCRL returns the PEM encoded CRL of all revoked collector
+// certificates that have not expired yet
+func CollectorCRL() ([]byte, error) {
+	ca := Get()
+	if ca == nil {
+		return nil, ErrNotInitialized
+	}
+
+	var records []model.CollectorCertificate
+	err := model.DB.Where("revoked_at IS NOT NULL AND not_after > ?", time.Now()).
+		Order("revoked_at").
+		Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+
+	revoked := make([]Revoked, 0, len(records))
+	for _, record := range records {
+		revoked = append(revoked, Revoked{Serial: record.Serial, RevokedAt: *record.RevokedAt})
+	}
+	return ca.CRL(revoked)
+}
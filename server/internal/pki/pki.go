@@ -0,0 +1,301 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	caCertFile = "ca.crt"
+	caKeyFile  = "ca.key"
+
+	// caValidity is the lifetime of a CA created on first start
+	caValidity = 10 * 365 * 24 * time.Hour
+
+	// crlValidity is how long a published CRL is valid before clients
+	// should fetch a new one
+	crlValidity = 7 * 24 * time.Hour
+)
+
+// ErrNotInitialized is returned when the CA has not been loaded
+var ErrNotInitialized = errors.New("certificate authority not initialized")
+
+// CA is the certificate authority that signs collector client certificates
+type CA struct {
+	cert    *x509.Certificate
+	certPEM []byte
+	key     crypto.Signer
+	pool    *x509.CertPool
+}
+
+// Revoked is a revoked certificate listed in the CRL
+type Revoked struct {
+	Serial    string
+	RevokedAt time.Time
+}
+
+var (
+	globalCA *CA
+	mu       sync.RWMutex
+)
+
+// Init loads the CA from dir, creating a new one if it does not exist yet
+func Init(dir string) error {
+	ca, err := load(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		ca, err = create(dir)
+	}
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	globalCA = ca
+	mu.Unlock()
+	return nil
+}
+
+// Get returns the loaded CA, or nil if Init has not been called
+func Get() *CA {
+	mu.RLock()
+	defer mu.RUnlock()
+	return globalCA
+}
+
+// load reads the CA certificate and key from dir
+func load(dir string) (*CA, error) {
+	certPEM, err := os.ReadFile(filepath.Join(dir, caCertFile))
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(filepath.Join(dir, caKeyFile))
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := ParseCertificate(certPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode CA key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported CA key type %T", key)
+	}
+
+	return newCA(cert, certPEM, signer), nil
+}
+
+// create generates a self-signed CA and writes it to dir
+func create(dir string) (*CA, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create CA directory: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate CA key: %w", err)
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "Power Monitor Collector CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+
+	if err := os.WriteFile(filepath.Join(dir, caKeyFile), keyPEM, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write CA key: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, caCertFile), certPEM, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write CA certificate: %w", err)
+	}
+
+	return newCA(cert, certPEM, key), nil
+}
+
+func newCA(cert *x509.Certificate, certPEM []byte, key crypto.Signer) *CA {
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &CA{cert: cert, certPEM: certPEM, key: key, pool: pool}
+}
+
+// CertPEM returns the PEM encoded CA certificate
+func (ca *CA) CertPEM() []byte {
+	return ca.certPEM
+}
+
+// Pool returns a pool holding the CA certificate, used to verify client
+// certificates
+func (ca *CA) Pool() *x509.CertPool {
+	return ca.pool
+}
+
+// SignCSR issues a client certificate for the collector from a PEM encoded
+// certificate signing request. The CSR must name the collector ID as its
+// common name. It returns the PEM encoded certificate and its serial number.
+func (ca *CA) SignCSR(csrPEM []byte, collectorID string, validity time.Duration) ([]byte, *x509.Certificate, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, nil, fmt.Errorf("invalid certificate signing request")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid certificate signing request: %w", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, nil, fmt.Errorf("invalid certificate signing request signature: %w", err)
+	}
+	if csr.Subject.CommonName != collectorID {
+		return nil, nil, fmt.Errorf("certificate signing request is for %q, not collector %q", csr.Subject.CommonName, collectorID)
+	}
+
+	return ca.issue(csr.PublicKey, collectorID, validity)
+}
+
+// Issue generates a key pair for the collector and signs a client
+// certificate for it. It returns the PEM encoded certificate and key.
+func (ca *CA) Issue(collectorID string, validity time.Duration) ([]byte, []byte, *x509.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	certPEM, cert, err := ca.issue(&key.PublicKey, collectorID, validity)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return certPEM, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), cert, nil
+}
+
+// issue signs a client certificate for the public key of a collector
+func (ca *CA) issue(pub any, collectorID string, validity time.Duration) ([]byte, *x509.Certificate, error) {
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	notAfter := now.Add(validity)
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: collectorID},
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, pub, ca.key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to sign certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), cert, nil
+}
+
+// CRL returns a PEM encoded certificate revocation list of the revoked
+// certificates, numbered by the time it is generated
+func (ca *CA) CRL(revoked []Revoked) ([]byte, error) {
+	var entries []x509.RevocationListEntry
+	for _, r := range revoked {
+		serial, ok := new(big.Int).SetString(r.Serial, 16)
+		if !ok {
+			return nil, fmt.Errorf("invalid serial number %q", r.Serial)
+		}
+		entries = append(entries, x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: r.RevokedAt,
+		})
+	}
+
+	now := time.Now()
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(now.Unix()),
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(crlValidity),
+		RevokedCertificateEntries: entries,
+	}, ca.cert, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CRL: %w", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), nil
+}
+
+// Serial formats the serial number of a certificate as stored in the database
+func Serial(cert *x509.Certificate) string {
+	return cert.SerialNumber.Text(16)
+}
+
+// ParseCertificate parses a PEM encoded certificate
+func ParseCertificate(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("invalid certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// randomSerial returns a random 128-bit certificate serial number
+func randomSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	return serial, nil
+}
@@ -0,0 +1,223 @@
+package realtime
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/uozi-tech/cosy/logger"
+)
+
+// Command channel message types
+const (
+	CommandMessageCommand = "command" // server to collector: run a command
+	CommandMessageAck     = "ack"     // collector to server: command received
+	CommandMessageResult  = "result"  // collector to server: command finished
+)
+
+// collectorReadLimit bounds the size of a message from a collector, which
+// may carry log output
+const collectorReadLimit = 1 << 20
+
+// ErrCollectorOffline is returned when a collector has no open command channel
+var ErrCollectorOffline = errors.New("collector is not connected")
+
+// CommandMessage represents a message on the command channel of a collector
+type CommandMessage struct {
+	Type    string            `json:"type"`
+	ID      uint              `json:"id"`
+	Command string            `json:"command,omitempty"`
+	Args    map[string]string `json:"args,omitempty"`
+	Success bool              `json:"success,omitempty"`
+	Result  string            `json:"result,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// CollectorHandler receives the events of collector command channels
+type CollectorHandler interface {
+	// OnConnect is called when a collector opens its command channel
+	OnConnect(collectorID string)
+	// OnMessage is called for every message a collector sends
+	OnMessage(collectorID string, msg CommandMessage)
+}
+
+// collectorConn represents the command channel of a connected collector
+type collectorConn struct {
+	conn        *websocket.Conn
+	send        chan []byte
+	collectorID string
+}
+
+var (
+	collectorsMu     sync.RWMutex
+	collectors       = make(map[string]*collectorConn)
+	collectorHandler CollectorHandler
+)
+
+// SetCollectorHandler sets the handler of collector command channel events
+func SetCollectorHandler(handler CollectorHandler) {
+	collectorsMu.Lock()
+	defer collectorsMu.Unlock()
+
+	collectorHandler = handler
+}
+
+// HandleCollectorWebSocket handles the command channel connection of a
+// collector authenticated by the collector middleware
+func HandleCollectorWebSocket(c *gin.Context) {
+	collectorID := c.GetString("collector_id")
+	if collectorID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid collector token"})
+		return
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to upgrade connection"})
+		return
+	}
+
+	cc := &collectorConn{
+		conn:        conn,
+		send:        make(chan []byte, 64),
+		collectorID: collectorID,
+	}
+
+	// A reconnecting collector replaces its previous channel
+	collectorsMu.Lock()
+	if previous, ok := collectors[collectorID]; ok {
+		close(previous.send)
+		previous.conn.Close()
+	}
+	collectors[collectorID] = cc
+	handler := collectorHandler
+	collectorsMu.Unlock()
+
+	BroadcastCollectorStatus(collectorID, true)
+
+	go cc.writePump()
+	go cc.readPump()
+
+	if handler != nil {
+		handler.OnConnect(collectorID)
+	}
+}
+
+// IsCollectorConnected reports whether a collector has an open command channel
+func IsCollectorConnected(collectorID string) bool {
+	collectorsMu.RLock()
+	defer collectorsMu.RUnlock()
+
+	_, ok := collectors[collectorID]
+	return ok
+}
+
+// SendToCollector sends a message over the command channel of a collector
+func SendToCollector(collectorID string, msg CommandMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	collectorsMu.RLock()
+	defer collectorsMu.RUnlock()
+
+	cc, ok := collectors[collectorID]
+	if !ok {
+		return ErrCollectorOffline
+	}
+
+	select {
+	case cc.send <- data:
+		return nil
+	default:
+		return errors.New("collector send queue is full")
+	}
+}
+
+// remove unregisters the connection unless it was already replaced
+func (cc *collectorConn) remove() {
+	collectorsMu.Lock()
+	current, ok := collectors[cc.collectorID]
+	if ok && current == cc {
+		delete(collectors, cc.collectorID)
+		close(cc.send)
+	}
+	collectorsMu.Unlock()
+
+	if ok && current == cc {
+		BroadcastCollectorStatus(cc.collectorID, false)
+	}
+}
+
+// readPump handles messages from the collector
+func (cc *collectorConn) readPump() {
+	defer func() {
+		cc.remove()
+		cc.conn.Close()
+	}()
+
+	cc.conn.SetReadLimit(collectorReadLimit)
+	cc.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	cc.conn.SetPongHandler(func(string) error {
+		cc.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		return nil
+	})
+
+	for {
+		_, data, err := cc.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				logger.Warnf("Collector %s command channel error: %v", cc.collectorID, err)
+			}
+			return
+		}
+
+		var msg CommandMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			logger.Warnf("Invalid message from collector %s: %v", cc.collectorID, err)
+			continue
+		}
+
+		collectorsMu.RLock()
+		handler := collectorHandler
+		collectorsMu.RUnlock()
+		if handler != nil {
+			handler.OnMessage(cc.collectorID, msg)
+		}
+	}
+}
+
+// writePump sends queued messages and keepalive pings to the collector
+func (cc *collectorConn) writePump() {
+	ticker := time.NewTicker(54 * time.Second)
+	defer func() {
+		ticker.Stop()
+		cc.conn.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-cc.send:
+			cc.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if !ok {
+				cc.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+
+			if err := cc.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			cc.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := cc.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
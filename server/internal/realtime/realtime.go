@@ -19,6 +19,11 @@ type Config struct {
 	MaxConnections  int
 }
 
+// hubStallTimeout is how long the hub may be busy with one message before
+// it is reported as stalled. Sending to clients never blocks, so handling a
+// message takes far less.
+const hubStallTimeout = 30 * time.Second
+
 // Hub maintains active connections and broadcasts messages
 type Hub struct {
 	clients    map[*Client]bool
@@ -26,6 +31,11 @@ type Hub struct {
 	register   chan *Client
 	unregister chan *Client
 	mutex      sync.RWMutex
+
+	// busySince is when the hub started handling the current message, zero
+	// while it is waiting for one
+	busySince   time.Time
+	progressMux sync.Mutex
 }
 
 // Client represents a connected client
@@ -86,13 +96,49 @@ func GetHub() *Hub {
 	return globalHub
 }
 
+// Stalled returns an error if the hub has been busy with one message for
+// longer than it may take, e.g. waiting for a lock that is never released
+func Stalled() error {
+	if globalHub == nil {
+		return nil
+	}
+	if busy := globalHub.busy(); busy > hubStallTimeout {
+		return fmt.Errorf("realtime hub stalled for %v", busy.Truncate(time.Second))
+	}
+	return nil
+}
+
+// setBusy records whether the hub is handling a message
+func (h *Hub) setBusy(busy bool) {
+	h.progressMux.Lock()
+	defer h.progressMux.Unlock()
+	if busy {
+		h.busySince = time.Now()
+	} else {
+		h.busySince = time.Time{}
+	}
+}
+
+// busy returns how long the hub has been handling the current message, 0
+// while it is waiting for one
+func (h *Hub) busy() time.Duration {
+	h.progressMux.Lock()
+	defer h.progressMux.Unlock()
+	if h.busySince.IsZero() {
+		return 0
+	}
+	return time.Since(h.busySince)
+}
+
 // run starts the hub's main loop
 func (h *Hub) run(ctx context.Context) {
 	for {
+		h.setBusy(false)
 		select {
 		case <-ctx.Done():
 			return
 		case client := <-h.register:
+			h.setBusy(true)
 			h.mutex.Lock()
 			h.clients[client] = true
 			h.mutex.Unlock()
@@ -117,6 +163,7 @@ func (h *Hub) run(ctx context.Context) {
 			}
 
 		case client := <-h.unregister:
+			h.setBusy(true)
 			h.mutex.Lock()
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
@@ -125,6 +172,7 @@ func (h *Hub) run(ctx context.Context) {
 			h.mutex.Unlock()
 
 		case message := <-h.broadcast:
+			h.setBusy(true)
 			h.mutex.RLock()
 			for client := range h.clients {
 				select {
@@ -0,0 +1,73 @@
+// Package systemd reports the state of the server to systemd when it runs as
+// a Type=notify service, and pings the service watchdog while the server is
+// making progress. Nothing is sent when the server is not started by systemd.
+package systemd
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+	"github.com/uozi-tech/cosy/logger"
+)
+
+// Ready tells systemd that the server has started
+func Ready(status string) {
+	notify(daemon.SdNotifyReady, "STATUS="+status)
+}
+
+// Stopping tells systemd that the server is shutting down
+func Stopping() {
+	notify(daemon.SdNotifyStopping, "STATUS=Stopping")
+}
+
+// Watchdog pings the systemd watchdog at half its interval until ctx is
+// done, as long as check returns nil. While check fails, the ping is
+// withheld and the failure reported as the status, so that systemd restarts
+// the server once WatchdogSec has passed. It returns at once if the watchdog
+// is not enabled for the process.
+func Watchdog(ctx context.Context, status string, check func() error) {
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		logger.Warnf("Failed to read systemd watchdog settings: %v", err)
+		return
+	}
+	if interval == 0 {
+		return
+	}
+	logger.Infof("systemd watchdog enabled (interval: %v)", interval)
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	stalled := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := check(); err != nil {
+				if !stalled {
+					logger.Errorf("Withholding systemd watchdog ping: %v", err)
+					notify("STATUS=" + err.Error())
+					stalled = true
+				}
+				continue
+			}
+			if stalled {
+				logger.Info("Server is making progress again, resuming systemd watchdog pings")
+				notify("STATUS=" + status)
+				stalled = false
+			}
+			notify(daemon.SdNotifyWatchdog)
+		}
+	}
+}
+
+// notify sends the states to systemd
+func notify(states ...string) {
+	if _, err := daemon.SdNotify(false, strings.Join(states, "\n")); err != nil {
+		logger.Warnf("Failed to notify systemd: %v", err)
+	}
+}
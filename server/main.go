@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -13,6 +14,10 @@ import (
 
 	"Power-Monitor/internal/cmd"
 	"Power-Monitor/internal/kernel"
+	"Power-Monitor/internal/mqtt"
+	"Power-Monitor/internal/pki"
+	"Power-Monitor/internal/realtime"
+	"Power-Monitor/internal/systemd"
 	"Power-Monitor/model"
 	"Power-Monitor/router"
 	"Power-Monitor/settings"
@@ -71,6 +76,12 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
+	// Listen before reporting the server as ready to systemd
+	listener, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		logger.Fatalf("Server failed to start: %v", err)
+	}
+
 	// Start server in a goroutine
 	go func() {
 		logger.Infof("Starting Power Monitor server on %s", srv.Addr)
@@ -81,11 +92,20 @@ func main() {
 			srv.TLSConfig = &tls.Config{
 				MinVersion: tls.VersionTLS12,
 			}
+			// Collectors may authenticate with a client certificate
+			// instead of a token
+			if ca := pki.Get(); ca != nil {
+				srv.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+				srv.TLSConfig.ClientCAs = ca.Pool()
+			}
 			logger.Info("Starting Power Monitor HTTPS server")
-			err = srv.ListenAndServeTLS("", "")
+			err = srv.ServeTLS(listener, cSettings.ServerSettings.SSLCert, cSettings.ServerSettings.SSLKey)
 		} else {
+			if settings.CollectorSettings.EnableClientCert {
+				logger.Warn("Client certificates are enabled but HTTPS is not, collectors can only authenticate with tokens")
+			}
 			logger.Info("Starting Power Monitor HTTP server")
-			err = srv.ListenAndServe()
+			err = srv.Serve(listener)
 		}
 
 		if err != nil && !errors.Is(err, http.ErrServerClosed) {
@@ -93,11 +113,17 @@ func main() {
 		}
 	}()
 
+	// Ping the systemd watchdog while the realtime hub makes progress
+	status := fmt.Sprintf("Serving on %s", srv.Addr)
+	systemd.Ready(status)
+	go systemd.Watchdog(ctx, status, realtime.Stalled)
+
 	// Wait for interrupt signal
 	<-ctx.Done()
 
 	// Graceful shutdown
 	logger.Info("Shutting down server...")
+	systemd.Stopping()
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownCancel()
 
@@ -105,6 +131,7 @@ func main() {
 		logger.Errorf("Server forced to shutdown: %v", err)
 		os.Exit(1)
 	}
+	mqtt.Close()
 
 	logger.Info("Server exited")
 }
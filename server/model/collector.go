@@ -1,6 +1,7 @@
 package model
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -14,10 +15,26 @@ type Collector struct {
 	IsActive    bool      `gorm:"default:true" json:"is_active"`
 	LastSeenAt  time.Time `json:"last_seen_at"`
 	Token       string    `gorm:"uniqueIndex" json:"-"`
-	Version     string    `json:"version"`
-	IPAddress   string    `json:"ip_address"`
-	UserID      uint      `json:"user_id"`
-	User        User      `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	// TokenExpiresAt is when Token stops being accepted, zero for tokens
+	// issued before expiry was enforced
+	TokenExpiresAt time.Time `json:"token_expires_at"`
+	// PreviousToken is the token replaced by the last rotation, accepted
+	// until PreviousTokenExpiresAt so the collector can switch over
+	PreviousToken          string    `gorm:"index" json:"-"`
+	PreviousTokenExpiresAt time.Time `json:"-"`
+	// SigningSecret is the HMAC key of signed requests. RequireSignature
+	// rejects requests of the collector that are not signed with it.
+	SigningSecret    string `json:"-"`
+	RequireSignature bool   `gorm:"default:false" json:"require_signature"`
+	Version          string `json:"version"`
+	IPAddress        string `json:"ip_address"`
+	// ClockOffset is how far the clock of the collector was ahead of the
+	// server clock in seconds, observed from its last heartbeat at
+	// ClockCheckedAt
+	ClockOffset    float64    `json:"clock_offset"`
+	ClockCheckedAt *time.Time `json:"clock_checked_at"`
+	UserID         uint       `json:"user_id"`
+	User           User       `gorm:"foreignKey:UserID" json:"user,omitempty"`
 }
 
 // CollectorConfig represents configuration for a collector
@@ -27,9 +44,31 @@ type CollectorConfig struct {
 	SampleInterval   int       `gorm:"default:15" json:"sample_interval"`  // seconds
 	UploadInterval   int       `gorm:"default:60" json:"upload_interval"`  // seconds
 	MaxCacheSize     int       `gorm:"default:1000" json:"max_cache_size"` // number of records
+	BatchSize        int       `gorm:"default:100" json:"batch_size"`      // records per batch upload
 	AutoUpload       bool      `gorm:"default:true" json:"auto_upload"`
 	CompressionLevel int       `gorm:"default:6" json:"compression_level"`
+	Version          int       `gorm:"default:1" json:"version"` // incremented on every change
+	AppliedVersion   int       `json:"applied_version"`          // last version the collector reported as applied
+	AppliedAt        time.Time `json:"applied_at"`
 	Collector        Collector `gorm:"foreignKey:CollectorID;references:CollectorID" json:"collector,omitempty"`
+
+	// Validation settings of the readings, those of the collector apply
+	// where nil
+	VoltageMin     *float64 `json:"voltage_min"`     // V
+	VoltageMax     *float64 `json:"voltage_max"`     // V
+	CurrentMax     *float64 `json:"current_max"`     // A
+	PowerMax       *float64 `json:"power_max"`       // W
+	FrequencyMin   *float64 `json:"frequency_min"`   // Hz
+	FrequencyMax   *float64 `json:"frequency_max"`   // Hz
+	SpikeFilter    *bool    `json:"spike_filter"`    // reject implausible jumps
+	SpikeThreshold *float64 `json:"spike_threshold"` // spreads a reading may deviate from the recent ones
+	UploadRejected *bool    `json:"upload_rejected"` // upload rejected readings flagged instead of dropping them
+}
+
+// CollectorConfigAppliedRequest represents the config version a collector
+// reports after applying it
+type CollectorConfigAppliedRequest struct {
+	Version int `json:"version" binding:"required"`
 }
 
 // RegistrationCode represents a code for registering new collectors
@@ -47,15 +86,231 @@ type RegistrationCode struct {
 // PowerData represents power measurement data (cached in database for quick access)
 type PowerData struct {
 	BaseModel
-	CollectorID string    `gorm:"index;not null" json:"collector_id"`
+	CollectorID string    `gorm:"index;uniqueIndex:idx_power_data_collector_seq;not null" json:"collector_id"`
+	Seq         *uint64   `gorm:"uniqueIndex:idx_power_data_collector_seq" json:"seq,omitempty"` // assigned by the collector, nil if it sends none
 	Timestamp   time.Time `gorm:"index;not null" json:"timestamp"`
-	Voltage     float64   `json:"voltage"`   // Volts
-	Current     float64   `json:"current"`   // Amperes
-	Power       float64   `json:"power"`     // Watts
-	Energy      float64   `json:"energy"`    // kWh
-	Frequency   float64   `json:"frequency"` // Hz
+	Voltage     float64   `json:"voltage"`                                // Volts
+	Current     float64   `json:"current"`                                // Amperes
+	Power       float64   `json:"power"`                                  // Watts
+	Energy      float64   `json:"energy"`                                 // Wh, cumulative meter counter
+	EnergyDelta float64   `gorm:"not null;default:0" json:"energy_delta"` // Wh consumed since the previous reading
+	Frequency   float64   `json:"frequency"`                              // Hz
 	PowerFactor float64   `json:"power_factor"`
-	Collector   Collector `gorm:"foreignKey:CollectorID;references:CollectorID" json:"collector,omitempty"`
+	// TimeUnreliable is set if the collector could not correct the timestamp
+	// with the server clock
+	TimeUnreliable bool `gorm:"not null;default:false" json:"time_unreliable,omitempty"`
+	// Set for the summaries of aggregation windows, whose Voltage, Current,
+	// Power and PowerFactor are the averages of the samples
+	WindowSeconds  int       `gorm:"not null;default:0" json:"window_seconds,omitempty"` // 0 for single readings
+	Samples        int       `gorm:"not null;default:0" json:"samples,omitempty"`
+	VoltageMin     *float64  `json:"voltage_min,omitempty"`
+	VoltageMax     *float64  `json:"voltage_max,omitempty"`
+	CurrentMin     *float64  `json:"current_min,omitempty"`
+	CurrentMax     *float64  `json:"current_max,omitempty"`
+	PowerMin       *float64  `json:"power_min,omitempty"`
+	PowerMax       *float64  `json:"power_max,omitempty"`
+	PowerFactorMin *float64  `json:"power_factor_min,omitempty"`
+	PowerFactorMax *float64  `json:"power_factor_max,omitempty"`
+	WindowEnergy   *float64  `json:"window_energy,omitempty"` // Wh the collector counted since the previous window
+	Collector      Collector `gorm:"foreignKey:CollectorID;references:CollectorID" json:"collector,omitempty"`
+}
+
+// Qualities of the readings a collector rejected as implausible
+const (
+	QualityOutOfRange = "out_of_range" // a measurement is outside the limits of the collector
+	QualitySpike      = "spike"        // an implausible jump from the previous readings
+)
+
+// RejectedReading represents a reading a collector rejected as implausible
+// and uploaded with its quality. It is kept apart from the power data, so
+// that it is not accounted or charted.
+type RejectedReading struct {
+	BaseModel
+	CollectorID    string    `gorm:"index;uniqueIndex:idx_rejected_reading_collector_seq;not null" json:"collector_id"`
+	Seq            *uint64   `gorm:"uniqueIndex:idx_rejected_reading_collector_seq" json:"seq,omitempty"`
+	Timestamp      time.Time `gorm:"index;not null" json:"timestamp"`
+	Quality        string    `gorm:"not null" json:"quality"`
+	Voltage        float64   `json:"voltage"`
+	Current        float64   `json:"current"`
+	Power          float64   `json:"power"`
+	Energy         float64   `json:"energy"`
+	Frequency      float64   `json:"frequency"`
+	PowerFactor    float64   `json:"power_factor"`
+	TimeUnreliable bool      `gorm:"not null;default:false" json:"time_unreliable,omitempty"`
+}
+
+// Data loss policies reported by collectors whose offline cache overflowed
+const (
+	DataLossDropped     = "dropped"     // readings deleted
+	DataLossDownsampled = "downsampled" // readings merged into per-minute averages
+)
+
+// CollectorDataLoss represents readings a collector discarded or merged
+// while its offline cache was full
+type CollectorDataLoss struct {
+	BaseModel
+	CollectorID string    `gorm:"index;not null" json:"collector_id"`
+	Policy      string    `gorm:"not null" json:"policy"`
+	Records     int       `json:"records"` // number of readings lost
+	From        time.Time `json:"from"`    // timestamp of the first reading affected
+	To          time.Time `json:"to"`      // timestamp of the last reading affected
+}
+
+// DataLossReportRequest represents the cache losses reported by a collector
+type DataLossReportRequest struct {
+	Losses []DataLossReport `json:"losses" binding:"required"`
+}
+
+// DataLossReport represents a single cache loss reported by a collector
+type DataLossReport struct {
+	Policy  string    `json:"policy" binding:"required"`
+	Records int       `json:"records"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+}
+
+// States of the alarm events reported by collectors
+const (
+	AlarmRaised  = "raised"
+	AlarmCleared = "cleared"
+)
+
+// CollectorAlarmEvent represents a local alarm rule of a collector being
+// raised or cleared
+type CollectorAlarmEvent struct {
+	BaseModel
+	CollectorID string    `gorm:"index;not null" json:"collector_id"`
+	Rule        string    `gorm:"not null" json:"rule"` // name of the rule in the collector configuration
+	Metric      string    `json:"metric"`
+	State       string    `gorm:"not null" json:"state"`
+	Value       float64   `json:"value"`     // value of the metric when the state changed
+	Threshold   float64   `json:"threshold"` // limit the value crossed
+	Timestamp   time.Time `gorm:"index" json:"timestamp"`
+}
+
+// CollectorHeartbeat represents a heartbeat of a collector with the
+// diagnostics it reported. The diagnostics are zero for collectors that send
+// none.
+type CollectorHeartbeat struct {
+	BaseModel
+	CollectorID   string     `gorm:"index:idx_collector_heartbeat_time;not null" json:"collector_id"`
+	Timestamp     time.Time  `gorm:"index:idx_collector_heartbeat_time" json:"timestamp"` // server time of receipt
+	Status        string     `json:"status"`
+	Version       string     `json:"version"`
+	IsOnline      bool       `json:"is_online"` // whether its last upload reached the server
+	ErrorCount    int        `json:"error_count"`
+	LastError     string     `json:"last_error"`
+	LastErrorAt   *time.Time `json:"last_error_at"`
+	ConfigVersion int        `json:"config_version"`
+	Uptime        float64    `json:"uptime"` // seconds
+	// Offline cache of the collector
+	CacheTotal            int64      `json:"cache_total"`
+	CacheUnuploaded       int64      `json:"cache_unuploaded"`
+	CacheOldestUnuploaded *time.Time `json:"cache_oldest_unuploaded"`
+	// Meter of the collector
+	LastDataTime     *time.Time `json:"last_data_time"`
+	LastReadError    string     `json:"last_read_error"`
+	LastReadErrorAt  *time.Time `json:"last_read_error_at"`
+	ModbusRequests   uint64     `json:"modbus_requests"`
+	ModbusCRCErrors  uint64     `json:"modbus_crc_errors"`
+	ModbusTimeouts   uint64     `json:"modbus_timeouts"`
+	ModbusExceptions uint64     `json:"modbus_exceptions"`
+	// Device the collector runs on, sizes in bytes
+	OS              string `json:"os"`
+	Arch            string `json:"arch"`
+	DiskFree        uint64 `json:"disk_free"`
+	DiskTotal       uint64 `json:"disk_total"`
+	MemoryAlloc     uint64 `json:"memory_alloc"`
+	MemorySys       uint64 `json:"memory_sys"`
+	MemoryAvailable uint64 `json:"memory_available"`
+	MemoryTotal     uint64 `json:"memory_total"`
+}
+
+// CollectorDiagnostics represents the status a collector sends with its
+// heartbeat. It covers all meter channels of the collector process.
+type CollectorDiagnostics struct {
+	IsOnline      bool                          `json:"is_online"`
+	ErrorCount    int                           `json:"error_count"`
+	LastError     string                        `json:"last_error"`
+	LastErrorAt   *time.Time                    `json:"last_error_at"`
+	ConfigVersion int                           `json:"config_version"`
+	CacheStats    map[string]int64              `json:"cache_stats"`
+	Channels      []CollectorChannelDiagnostics `json:"channels"`
+	Uptime        float64                       `json:"uptime"`
+	System        struct {
+		OS              string `json:"os"`
+		Arch            string `json:"arch"`
+		DiskFree        uint64 `json:"disk_free"`
+		DiskTotal       uint64 `json:"disk_total"`
+		MemoryAlloc     uint64 `json:"memory_alloc"`
+		MemorySys       uint64 `json:"memory_sys"`
+		MemoryAvailable uint64 `json:"memory_available"`
+		MemoryTotal     uint64 `json:"memory_total"`
+	} `json:"system"`
+}
+
+// CollectorChannelDiagnostics represents the status of a meter channel of a
+// collector process
+type CollectorChannelDiagnostics struct {
+	CollectorID     string     `json:"collector_id"`
+	LastDataTime    time.Time  `json:"last_data_time"`
+	LastReadError   string     `json:"last_read_error"`
+	LastReadErrorAt *time.Time `json:"last_read_error_at"`
+	Errors          *struct {
+		Requests   uint64 `json:"requests"`
+		CRCErrors  uint64 `json:"crc_errors"`
+		Timeouts   uint64 `json:"timeouts"`
+		Exceptions uint64 `json:"exceptions"`
+	} `json:"errors"`
+}
+
+// AlarmEventReportRequest represents the alarm events reported by a
+// collector
+type AlarmEventReportRequest struct {
+	Events []AlarmEventReport `json:"events" binding:"required"`
+}
+
+// AlarmEventReport represents a single alarm event reported by a collector
+type AlarmEventReport struct {
+	Rule      string    `json:"rule" binding:"required"`
+	Metric    string    `json:"metric"`
+	State     string    `json:"state" binding:"required"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Timestamp time.Time `json:"timestamp" binding:"required"`
+}
+
+// CollectorCertificate represents a client certificate issued to a
+// collector by the server CA
+type CollectorCertificate struct {
+	BaseModel
+	CollectorID string     `gorm:"index;not null" json:"collector_id"`
+	Serial      string     `gorm:"uniqueIndex;not null" json:"serial"` // hexadecimal
+	NotAfter    time.Time  `json:"not_after"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+}
+
+// CollectorNonce records the nonce of a signed collector request, so the
+// request cannot be replayed while its timestamp is within the window
+type CollectorNonce struct {
+	ID          uint      `gorm:"primarykey" json:"id"`
+	CollectorID string    `gorm:"uniqueIndex:idx_collector_nonce;not null" json:"collector_id"`
+	Nonce       string    `gorm:"uniqueIndex:idx_collector_nonce;not null" json:"nonce"`
+	Timestamp   time.Time `gorm:"index" json:"timestamp"`
+}
+
+// CollectorSigningRequest enables or disables mandatory request signing
+type CollectorSigningRequest struct {
+	RequireSignature bool `json:"require_signature"`
+	// RotateSecret replaces the signing secret of the collector
+	RotateSecret bool `json:"rotate_secret"`
+}
+
+// CollectorSigningResponse represents the signing settings of a collector.
+// The secret is only returned when it was generated.
+type CollectorSigningResponse struct {
+	RequireSignature bool   `json:"require_signature"`
+	SigningSecret    string `json:"signing_secret,omitempty"`
 }
 
 // CollectorCreateRequest represents request for creating a collector
@@ -74,6 +329,41 @@ type CollectorUpdateRequest struct {
 	IsActive    bool   `json:"is_active"`
 }
 
+// CollectorHeartbeatRequest represents the heartbeat of a collector
+type CollectorHeartbeatRequest struct {
+	Status  string `json:"status"`
+	Version string `json:"version"`
+	// Timestamp is the time the collector sent the heartbeat by its own
+	// clock, nil for collectors that do not send it
+	Timestamp *time.Time `json:"timestamp"`
+	// Diagnostics is nil for collectors that do not send it
+	Diagnostics *CollectorDiagnostics `json:"diagnostics"`
+}
+
+// Online status a collector publishes over MQTT
+const (
+	CollectorStatusOnline  = "online"
+	CollectorStatusOffline = "offline"
+)
+
+// CollectorStatusMessage represents the online status a collector publishes
+// over MQTT. The broker publishes the offline status when the connection of
+// the collector is lost.
+type CollectorStatusMessage struct {
+	Status  string `json:"status"`
+	Version string `json:"version"`
+}
+
+// CollectorSignedMessage wraps a message a collector published over MQTT,
+// signed like a request with PUBLISH as the method, the topic as the path
+// and the message as the body
+type CollectorSignedMessage struct {
+	Timestamp int64           `json:"timestamp"` // Unix seconds
+	Nonce     string          `json:"nonce"`
+	Signature string          `json:"signature"`
+	Message   json.RawMessage `json:"message"`
+}
+
 // CollectorRegisterRequest represents request for registering a collector
 type CollectorRegisterRequest struct {
 	RegistrationCode string `json:"registration_code" binding:"required"`
@@ -82,12 +372,25 @@ type CollectorRegisterRequest struct {
 	Description      string `json:"description"`
 	Location         string `json:"location"`
 	Version          string `json:"version"`
+	// CSR is a PEM encoded certificate signing request for a client
+	// certificate, with the collector ID as common name
+	CSR string `json:"csr"`
 }
 
 // CollectorRegisterResponse represents response for collector registration
 type CollectorRegisterResponse struct {
-	Token  string          `json:"token"`
-	Config CollectorConfig `json:"config"`
+	Token        string          `json:"token"`
+	TokenExpires time.Time       `json:"token_expires"`
+	Config       CollectorConfig `json:"config"`
+	// Certificate is the client certificate signed from the CSR, if any
+	Certificate   string `json:"certificate,omitempty"`
+	SigningSecret string `json:"signing_secret"`
+}
+
+// CollectorTokenResponse represents a newly issued collector token
+type CollectorTokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
 // CollectorStatusResponse represents collector status
@@ -96,6 +399,10 @@ type CollectorStatusResponse struct {
 	IsOnline     bool      `json:"is_online"`
 	LastDataTime time.Time `json:"last_data_time"`
 	DataCount    int64     `json:"data_count"`
+	// Diagnostics is the last heartbeat, nil if none was received
+	Diagnostics *CollectorHeartbeat `json:"diagnostics"`
+	// Trend lists the heartbeats of the requested period, oldest first
+	Trend []CollectorHeartbeat `json:"trend"`
 }
 
 // PowerDataUploadRequest represents bulk power data upload
@@ -104,8 +411,19 @@ type PowerDataUploadRequest struct {
 	Data        []PowerDataRequest `json:"data" binding:"required"`
 }
 
+// PowerDataBatchResult lists the sequence numbers of a batch upload that
+// were stored and those that had been stored before
+type PowerDataBatchResult struct {
+	Accepted   []uint64 `json:"accepted"`
+	Duplicates []uint64 `json:"duplicates"`
+}
+
 // PowerDataRequest represents single power data measurement
 type PowerDataRequest struct {
+	// Seq is a number the collector assigns to every reading, increasing per
+	// collector. Readings whose seq was already stored are ignored, so that
+	// uploads can be retried safely. 0 disables deduplication.
+	Seq         uint64    `json:"seq"`
 	Timestamp   time.Time `json:"timestamp" binding:"required"`
 	Voltage     float64   `json:"voltage"`
 	Current     float64   `json:"current"`
@@ -113,9 +431,63 @@ type PowerDataRequest struct {
 	Energy      float64   `json:"energy"`
 	Frequency   float64   `json:"frequency"`
 	PowerFactor float64   `json:"power_factor"`
+	// TimeUnreliable is set if the reading was taken before the clock of
+	// the collector was synchronized with the server
+	TimeUnreliable bool `json:"time_unreliable,omitempty"`
+	// Quality is set if the collector rejected the reading as implausible
+	Quality string `json:"quality,omitempty" binding:"omitempty,oneof=out_of_range spike"`
+	// Aggregate is set if the reading summarizes the samples of a window
+	// whose last sample was taken at Timestamp
+	Aggregate *PowerAggregate `json:"aggregate,omitempty"`
+}
+
+// PowerAggregate summarizes the samples of an aggregation window. The
+// reading it belongs to holds the averages of the samples and the energy
+// counter of the last one.
+type PowerAggregate struct {
+	Window         int     `json:"window"` // seconds
+	Samples        int     `json:"samples"`
+	VoltageMin     float64 `json:"voltage_min"`
+	VoltageMax     float64 `json:"voltage_max"`
+	CurrentMin     float64 `json:"current_min"`
+	CurrentMax     float64 `json:"current_max"`
+	PowerMin       float64 `json:"power_min"`
+	PowerMax       float64 `json:"power_max"`
+	PowerFactorMin float64 `json:"power_factor_min"`
+	PowerFactorMax float64 `json:"power_factor_max"`
+	EnergyDelta    float64 `json:"energy_delta"` // Wh consumed since the previous window
 }
 
 // IsOnline checks if collector is online (last seen within 5 minutes)
 func (c *Collector) IsOnline() bool {
 	return time.Since(c.LastSeenAt) < 5*time.Minute
 }
+
+// RotateToken replaces the token of the collector with token, valid for
+// expires. The replaced token keeps working for grace, or stops at once if
+// grace is zero.
+func (c *Collector) RotateToken(token string, expires, grace time.Duration) {
+	now := time.Now()
+	if grace > 0 && c.Token != "" {
+		c.PreviousToken = c.Token
+		c.PreviousTokenExpiresAt = now.Add(grace)
+	} else {
+		c.PreviousToken = ""
+		c.PreviousTokenExpiresAt = time.Time{}
+	}
+	c.Token = token
+	c.TokenExpiresAt = now.Add(expires)
+}
+
+// RevokeToken makes the current and previous tokens of the collector
+// invalid until a new one is issued
+func (c *Collector) RevokeToken() {
+	c.TokenExpiresAt = time.Now()
+	c.PreviousToken = ""
+	c.PreviousTokenExpiresAt = time.Time{}
+}
+
+// TokenExpired reports whether the current token of the collector has expired
+func (c *Collector) TokenExpired() bool {
+	return !c.TokenExpiresAt.IsZero() && !time.Now().Before(c.TokenExpiresAt)
+}
@@ -0,0 +1,54 @@
+package model
+
+import (
+	"time"
+)
+
+// Commands a collector accepts over its command channel
+const (
+	CommandReadNow      = "read_now"      // read the meters and upload the readings immediately
+	CommandFlushCache   = "flush_cache"   // upload all cached readings
+	CommandResetEnergy  = "reset_energy"  // reset the energy counter of the meter
+	CommandReloadConfig = "reload_config" // fetch and apply the server-side configuration
+	CommandRestart      = "restart"       // restart the collector service
+	CommandUploadLogs   = "upload_logs"   // return the tail of the collector log
+)
+
+// Collector command statuses
+const (
+	CommandStatusPending = "pending" // waiting for the collector to connect
+	CommandStatusAcked   = "acked"   // received by the collector
+	CommandStatusDone    = "done"    // completed successfully
+	CommandStatusFailed  = "failed"  // completed with an error
+	CommandStatusExpired = "expired" // not delivered before it expired
+)
+
+// CollectorCommand represents a command sent to a collector
+type CollectorCommand struct {
+	BaseModel
+	CollectorID string     `gorm:"index;not null" json:"collector_id"`
+	Command     string     `gorm:"not null" json:"command"`
+	Args        string     `json:"args"` // JSON object of command arguments
+	Status      string     `gorm:"index;default:pending" json:"status"`
+	Result      string     `json:"result"`
+	Error       string     `json:"error"`
+	UserID      uint       `json:"user_id"`
+	AckedAt     *time.Time `json:"acked_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+// CollectorCommandRequest represents request for sending a command to a collector
+type CollectorCommandRequest struct {
+	Command string            `json:"command" binding:"required"`
+	Args    map[string]string `json:"args"`
+}
+
+// IsValidCommand checks if the command is one a collector accepts
+func IsValidCommand(command string) bool {
+	switch command {
+	case CommandReadNow, CommandFlushCache, CommandResetEnergy,
+		CommandReloadConfig, CommandRestart, CommandUploadLogs:
+		return true
+	}
+	return false
+}
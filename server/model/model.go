@@ -15,6 +15,13 @@ func GenerateAllModel() []interface{} {
 		PowerData{},
 		CollectorConfig{},
 		AuthToken{},
+		CollectorCommand{},
+		CollectorDataLoss{},
+		CollectorAlarmEvent{},
+		CollectorHeartbeat{},
+		RejectedReading{},
+		CollectorCertificate{},
+		CollectorNonce{},
 	}
 }
 
@@ -22,7 +22,7 @@ func InitRouter() {
 	{
 		// Collector routes (for data collection devices)
 		collectorGroup := root.Group("/collector")
-		collectorGroup.Use(middleware.CollectorAuth())
+		collectorGroup.Use(middleware.CollectorAuth(), middleware.CollectorSignature(), middleware.Decompress())
 		{
 			collector.RegisterRoutes(collectorGroup)
 		}
@@ -4,10 +4,35 @@ import "time"
 
 type Collector struct {
 	TokenExpires            time.Duration `ini:"TokenExpires"`
+	TokenRotationGrace      time.Duration `ini:"TokenRotationGrace"`
 	RegistrationCodeExpires time.Duration `ini:"RegistrationCodeExpires"`
+	// EnableClientCert lets collectors authenticate with client certificates
+	// signed by the CA in PKIDir, which is relative to the config file
+	EnableClientCert  bool          `ini:"EnableClientCert"`
+	PKIDir            string        `ini:"PKIDir"`
+	ClientCertExpires time.Duration `ini:"ClientCertExpires"`
+	// SignatureWindow is how far the timestamp of a signed request may be
+	// from the server time
+	SignatureWindow time.Duration `ini:"SignatureWindow"`
+	// HeartbeatRetention is how long the heartbeats of collectors are kept,
+	// or forever when it is not positive
+	HeartbeatRetention time.Duration `ini:"HeartbeatRetention"`
+	// CommandTimeout is how long an acknowledged command may wait for its
+	// result before it is marked as failed
+	CommandTimeout time.Duration `ini:"CommandTimeout"`
+	// CommandExpires is how long a command may wait for its collector to
+	// connect before it is marked as expired
+	CommandExpires time.Duration `ini:"CommandExpires"`
 }
 
 var CollectorSettings = &Collector{
 	TokenExpires:            30 * 24 * time.Hour,
+	TokenRotationGrace:      24 * time.Hour,
 	RegistrationCodeExpires: 7 * 24 * time.Hour,
+	PKIDir:                  "pki",
+	ClientCertExpires:       365 * 24 * time.Hour,
+	SignatureWindow:         5 * time.Minute,
+	HeartbeatRetention:      30 * 24 * time.Hour,
+	CommandTimeout:          time.Hour,
+	CommandExpires:          7 * 24 * time.Hour,
 }
@@ -0,0 +1,27 @@
+package settings
+
+// MQTT represents the subscription to the topics collectors publish their
+// readings, heartbeats and status to. Topics contain {collector_id} as a
+// whole level, matching the topics configured on the collectors.
+type MQTT struct {
+	Enabled  bool   `ini:"Enabled"`
+	Broker   string `ini:"Broker"`
+	ClientID string `ini:"ClientID"`
+	Username string `ini:"Username"`
+	Password string `ini:"Password"`
+	// CACert holds the CA certificates of ssl:// brokers, the system pool is
+	// used if empty
+	CACert         string `ini:"CACert"`
+	DataTopic      string `ini:"DataTopic"`
+	HeartbeatTopic string `ini:"HeartbeatTopic"`
+	StatusTopic    string `ini:"StatusTopic"`
+}
+
+var MQTTSettings = &MQTT{
+	Enabled:        false,
+	Broker:         "tcp://localhost:1883",
+	ClientID:       "power-monitor",
+	DataTopic:      "power/{collector_id}/data",
+	HeartbeatTopic: "power/{collector_id}/heartbeat",
+	StatusTopic:    "power/{collector_id}/status",
+}
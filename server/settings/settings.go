@@ -11,6 +11,7 @@ func init() {
 	sections.Set("collector", CollectorSettings)
 	sections.Set("database", DatabaseSettings)
 	sections.Set("influxdb", InfluxDBSettings)
+	sections.Set("mqtt", MQTTSettings)
 	sections.Set("auth", AuthSettings)
 	sections.Set("frontend", FrontendSettings)
 